@@ -13,6 +13,7 @@ import (
 
 	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/internal/bandwidth"
 	internalblobinfocache "github.com/containers/image/v5/internal/blobinfocache"
 	"github.com/containers/image/v5/internal/imagedestination"
 	"github.com/containers/image/v5/internal/imagesource"
@@ -34,6 +35,7 @@ import (
 	"github.com/vbauerster/mpb/v7"
 	"golang.org/x/sync/semaphore"
 	"golang.org/x/term"
+	"golang.org/x/time/rate"
 )
 
 var (
@@ -75,7 +77,11 @@ type copier struct {
 	ociDecryptConfig              *encconfig.DecryptConfig
 	ociEncryptConfig              *encconfig.EncryptConfig
 	concurrentBlobCopiesSemaphore *semaphore.Weighted // Limits the amount of concurrently copied blobs
+	bandwidthLimiter              *rate.Limiter       // Limits the throughput of copied blobs, or nil
 	downloadForeignLayers         bool
+	referrersSubjects             []referrersSubject // Copied manifests whose referrers should be copied; only used if Options.CopyReferrers
+	copiedReferrers               []ReferrerResult
+	dryRun                        bool // Options.DryRun; dest is a dryRunDestination
 }
 
 // imageCopier tracks state specific to a single image (possibly an item of a manifest list)
@@ -87,6 +93,10 @@ type imageCopier struct {
 	cannotModifyManifestReason string // The reason the manifest cannot be modified, or an empty string if it can
 	canSubstituteBlobs         bool
 	ociEncryptLayers           *[]int
+	layerResults               []LayerResult // Set by copyLayers
+	configBytesTransferred     int64         // Set by copyUpdatedConfigAndManifest
+	plannedLayers              []PlannedBlob // Set by copyLayers in dry runs
+	plannedConfig              *PlannedBlob  // Set by copyUpdatedConfigAndManifest in dry runs, if the image has a config
 }
 
 const (
@@ -126,6 +136,13 @@ type Options struct {
 	SignBy           string          // If non-empty, asks for a signature to be added during the copy, and specifies a key ID, as accepted by signature.NewGPGSigningMechanism().SignDockerManifest(),
 	SignPassphrase   string          // Passphare to use when signing with the key ID from `SignBy`.
 	SignIdentity     reference.Named // Identify to use when signing, defaults to the docker reference of the destination
+
+	// SignBySigstorePrivateKeyFile, if non-empty, asks for a sigstore (cosign-compatible) signature to be added during the copy,
+	// using a private key stored in the file: a PEM-encoded ECDSA P-256 key, possibly encrypted (e.g. as created by "cosign generate-key-pair").
+	// This can be used together with SignBy, to create both kinds of signatures. SignIdentity applies to both.
+	SignBySigstorePrivateKeyFile     string
+	SignSigstorePrivateKeyPassphrase []byte // Passphrase to use when signing with SignBySigstorePrivateKeyFile.
+
 	ReportWriter     io.Writer
 	SourceCtx        *types.SystemContext
 	DestinationCtx   *types.SystemContext
@@ -160,6 +177,10 @@ type Options struct {
 	// MaxParallelDownloads indicates the maximum layers to pull at the same time. Applies to a single copy operation. A reasonable default is used if this is left as 0. Ignored if ConcurrentBlobCopiesSemaphore is set.
 	MaxParallelDownloads uint
 
+	// A token-bucket rate limiter, in bytes per second, limiting the throughput of copying blob data. Applies to all copy operations using the limiter.
+	// The limit applies in addition to any bandwidth limits configured for registries in registries.conf.
+	BandwidthLimiter *rate.Limiter
+
 	// When OptimizeDestinationImageAlreadyExists is set, optimize the copy assuming that the destination image already
 	// exists (and is equivalent). Making the eventual (no-op) copy more performant for this case. Enabling the option
 	// is slightly pessimistic if the destination image doesn't exist, or is not equivalent.
@@ -168,6 +189,23 @@ type Options struct {
 	// Download layer contents with "nondistributable" media types ("foreign" layers) and translate the layer media type
 	// to not indicate "nondistributable".
 	DownloadForeignLayers bool
+
+	// CopyReferrers, if set, asks for referrers of the copied manifests (artifacts like signatures, SBOMs or attestations,
+	// whose "subject" field refers to a copied manifest) to be discovered in the source and copied as well, recursively.
+	// If a copied manifest has been modified (e.g. converted to a different format), the "subject" fields of its referrers
+	// are updated to refer to the modified manifest.
+	// The source must support referrers (currently only the docker transport does); referrers are not evaluated against the policy.
+	CopyReferrers bool
+
+	// DryRun, if set, only determines what the copy would do: the images to copy are selected and checked against the policy,
+	// the manifest conversions to perform are determined, and the destination is probed for blobs it already contains,
+	// but no layers are read from the source, and nothing is written to the destination.
+	// Dry runs are only supported for destination transports which can be probed without being modified
+	// (currently "docker:", "dir:" and "oci:"); other destinations are rejected.
+	// The manifest type is chosen without trying to write the manifest, so a destination might still reject it.
+	// Use ImageWithResult to obtain the plan; see Result.DryRun.
+	// No signatures are created in a dry run, and DryRun can not be combined with CopyReferrers.
+	DryRun bool
 }
 
 // validateImageListSelection returns an error if the passed-in value is not one that we recognize as a valid ImageListSelection value
@@ -184,6 +222,16 @@ func validateImageListSelection(selection ImageListSelection) error {
 // source image admissibility.  It returns the manifest which was written to
 // the new copy of the image.
 func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef, srcRef types.ImageReference, options *Options) (copiedManifest []byte, retErr error) {
+	res, err := ImageWithResult(ctx, policyContext, destRef, srcRef, options)
+	if err != nil {
+		return nil, err
+	}
+	return res.Manifest, nil
+}
+
+// ImageWithResult copies image from srcRef to destRef, using policyContext to validate
+// source image admissibility, like Image.  It returns a Result describing what was copied.
+func ImageWithResult(ctx context.Context, policyContext *signature.PolicyContext, destRef, srcRef types.ImageReference, options *Options) (result *Result, retErr error) {
 	// NOTE this function uses an output parameter for the error return value.
 	// Setting this and returning is the ideal way to return an error.
 	//
@@ -196,6 +244,9 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 	if err := validateImageListSelection(options.ImageListSelection); err != nil {
 		return nil, err
 	}
+	if options.DryRun && options.CopyReferrers {
+		return nil, errors.New("Copying referrers is not supported in a dry run")
+	}
 
 	reportWriter := io.Discard
 
@@ -203,7 +254,17 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 		reportWriter = options.ReportWriter
 	}
 
-	publicDest, err := destRef.NewImageDestination(ctx, options.DestinationCtx)
+	var publicDest types.ImageDestination
+	var err error
+	if options.DryRun {
+		probeRef, ok := destRef.(private.ImageReferenceWithProbe)
+		if !ok {
+			return nil, errors.Errorf("Dry runs are not supported for destination transport %q", destRef.Transport().Name())
+		}
+		publicDest, err = probeRef.NewImageDestinationForProbe(ctx, options.DestinationCtx)
+	} else {
+		publicDest, err = destRef.NewImageDestination(ctx, options.DestinationCtx)
+	}
 	if err != nil {
 		return nil, errors.Wrapf(err, "initializing destination %s", transports.ImageName(destRef))
 	}
@@ -213,6 +274,9 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 			retErr = errors.Wrapf(retErr, " (dest: %v)", err)
 		}
 	}()
+	if options.DryRun {
+		dest = newDryRunDestination(dest)
+	}
 
 	publicRawSource, err := srcRef.NewImageSource(ctx, options.SourceCtx)
 	if err != nil {
@@ -246,7 +310,9 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 		blobInfoCache:         internalblobinfocache.FromBlobInfoCache(blobinfocache.DefaultCache(options.DestinationCtx)),
 		ociDecryptConfig:      options.OciDecryptConfig,
 		ociEncryptConfig:      options.OciEncryptConfig,
+		bandwidthLimiter:      options.BandwidthLimiter,
 		downloadForeignLayers: options.DownloadForeignLayers,
+		dryRun:                options.DryRun,
 	}
 
 	// Set the concurrentBlobCopiesSemaphore if we can copy layers in parallel.
@@ -283,9 +349,16 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 
 	if !multiImage {
 		// The simple case: just copy a single image.
-		if copiedManifest, _, _, err = c.copyOneImage(ctx, policyContext, options, unparsedToplevel, unparsedToplevel, nil); err != nil {
+		copiedManifest, instance, err := c.copyOneImage(ctx, policyContext, options, unparsedToplevel, unparsedToplevel, nil)
+		if err != nil {
 			return nil, err
 		}
+		if options.CopyReferrers {
+			if err := c.noteUnparsedReferrersSubject(ctx, unparsedToplevel, copiedManifest, instance.ManifestMIMEType); err != nil {
+				return nil, err
+			}
+		}
+		result = singleImageResult(copiedManifest, instance)
 	} else if options.ImageListSelection == CopySystemImage {
 		// This is a manifest list, and we weren't asked to copy multiple images.  Choose a single image that
 		// matches the current system to copy, and copy it.
@@ -304,9 +377,16 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 		logrus.Debugf("Source is a manifest list; copying (only) instance %s for current system", instanceDigest)
 		unparsedInstance := image.UnparsedInstance(rawSource, &instanceDigest)
 
-		if copiedManifest, _, _, err = c.copyOneImage(ctx, policyContext, options, unparsedToplevel, unparsedInstance, nil); err != nil {
+		copiedManifest, instance, err := c.copyOneImage(ctx, policyContext, options, unparsedToplevel, unparsedInstance, nil)
+		if err != nil {
 			return nil, errors.Wrap(err, "copying system image from manifest list")
 		}
+		if options.CopyReferrers {
+			if err := c.noteUnparsedReferrersSubject(ctx, unparsedInstance, copiedManifest, instance.ManifestMIMEType); err != nil {
+				return nil, err
+			}
+		}
+		result = singleImageResult(copiedManifest, instance)
 	} else { /* options.ImageListSelection == CopyAllImages or options.ImageListSelection == CopySpecificImages, */
 		// If we were asked to copy multiple images and can't, that's an error.
 		if !supportsMultipleImages(c.dest) {
@@ -319,16 +399,42 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 		case CopySpecificImages:
 			logrus.Debugf("Source is a manifest list; copying some instances")
 		}
-		if copiedManifest, err = c.copyMultipleImages(ctx, policyContext, options, unparsedToplevel); err != nil {
+		if result, err = c.copyMultipleImages(ctx, policyContext, options, unparsedToplevel); err != nil {
 			return nil, err
 		}
 	}
 
+	if options.CopyReferrers {
+		if err := c.copyReferrers(ctx); err != nil {
+			return nil, err
+		}
+		result.Referrers = c.copiedReferrers
+	}
+
 	if err := c.dest.Commit(ctx, unparsedToplevel); err != nil {
 		return nil, errors.Wrap(err, "committing the finished image")
 	}
 
-	return copiedManifest, nil
+	for _, instance := range result.Instances {
+		result.BytesTransferred += instance.BytesTransferred
+	}
+	for _, referrer := range result.Referrers {
+		result.BytesTransferred += referrer.BytesTransferred
+	}
+	result.DryRun = options.DryRun
+	return result, nil
+}
+
+// singleImageResult returns a Result for a copy of a single image, written as manifest and described by instance.
+func singleImageResult(manifest []byte, instance *InstanceResult) *Result {
+	return &Result{
+		Manifest:         manifest,
+		ManifestDigest:   instance.ManifestDigest,
+		ManifestMIMEType: instance.ManifestMIMEType,
+		Instances:        []InstanceResult{*instance},
+		SignaturesCopied: instance.SignaturesCopied,
+		SignaturesAdded:  instance.SignaturesAdded,
+	}
 }
 
 // Checks if the destination supports accepting multiple images by checking if it can support
@@ -388,7 +494,8 @@ func compareImageDestinationManifestEqual(ctx context.Context, options *Options,
 
 // copyMultipleImages copies some or all of an image list's instances, using
 // policyContext to validate source image admissibility.
-func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signature.PolicyContext, options *Options, unparsedToplevel *image.UnparsedImage) (copiedManifest []byte, retErr error) {
+// It returns a Result describing the copied list, except for Referrers and BytesTransferred.
+func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signature.PolicyContext, options *Options, unparsedToplevel *image.UnparsedImage) (copiedList *Result, retErr error) {
 	// Parse the list and get a copy of the original value after it's re-encoded.
 	manifestList, manifestType, err := unparsedToplevel.Manifest(ctx)
 	if err != nil {
@@ -476,6 +583,7 @@ func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signatur
 	c.Printf("Copying %d of %d images in list\n", imagesToCopy, len(instanceDigests))
 	updates := make([]manifest.ListUpdate, len(instanceDigests))
 	instancesCopied := 0
+	instanceResults := []InstanceResult{}
 	for i, instanceDigest := range instanceDigests {
 		if options.ImageListSelection == CopySpecificImages {
 			skip := true
@@ -499,16 +607,22 @@ func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signatur
 		logrus.Debugf("Copying instance %s (%d/%d)", instanceDigest, i+1, len(instanceDigests))
 		c.Printf("Copying image %s (%d/%d)\n", instanceDigest, instancesCopied+1, imagesToCopy)
 		unparsedInstance := image.UnparsedInstance(c.rawSource, &instanceDigest)
-		updatedManifest, updatedManifestType, updatedManifestDigest, err := c.copyOneImage(ctx, policyContext, options, unparsedToplevel, unparsedInstance, &instanceDigest)
+		updatedManifest, instanceResult, err := c.copyOneImage(ctx, policyContext, options, unparsedToplevel, unparsedInstance, &instanceDigest)
 		if err != nil {
 			return nil, errors.Wrapf(err, "copying image %d/%d from manifest list", instancesCopied+1, imagesToCopy)
 		}
 		instancesCopied++
+		instanceResults = append(instanceResults, *instanceResult)
+		if options.CopyReferrers {
+			if err := c.noteUnparsedReferrersSubject(ctx, unparsedInstance, updatedManifest, instanceResult.ManifestMIMEType); err != nil {
+				return nil, err
+			}
+		}
 		// Record the result of a possible conversion here.
 		update := manifest.ListUpdate{
-			Digest:    updatedManifestDigest,
+			Digest:    instanceResult.ManifestDigest,
 			Size:      int64(len(updatedManifest)),
-			MediaType: updatedManifestType,
+			MediaType: instanceResult.ManifestMIMEType,
 		}
 		updates[i] = update
 	}
@@ -521,6 +635,7 @@ func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signatur
 	// Iterate through supported list types, preferred format first.
 	c.Printf("Writing manifest list to image destination\n")
 	var errs []string
+	var manifestListType string
 	for _, thisListType := range append([]string{selectedListType}, otherManifestMIMETypeCandidates...) {
 		attemptedList := updatedList
 
@@ -565,52 +680,68 @@ func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signatur
 		}
 		errs = nil
 		manifestList = attemptedManifestList
+		manifestListType = thisListType
 		break
 	}
 	if errs != nil {
 		return nil, fmt.Errorf("Uploading manifest list failed, attempted the following formats: %s", strings.Join(errs, ", "))
 	}
-
-	// Sign the manifest list.
-	if options.SignBy != "" {
-		newSig, err := c.createSignature(manifestList, options.SignBy, options.SignPassphrase, options.SignIdentity)
-		if err != nil {
+	if options.CopyReferrers {
+		if err := c.noteUnparsedReferrersSubject(ctx, unparsedToplevel, manifestList, manifestListType); err != nil {
 			return nil, err
 		}
-		sigs = append(sigs, newSig)
 	}
 
+	// Sign the manifest list.
+	newSigs, err := c.createSignatures(manifestList, options)
+	if err != nil {
+		return nil, err
+	}
+	signaturesCopied := len(sigs)
+	sigs = append(sigs, newSigs...)
+
 	c.Printf("Storing list signatures\n")
 	if err := c.dest.PutSignatures(ctx, sigs, nil); err != nil {
 		return nil, errors.Wrap(err, "writing signatures")
 	}
 
-	return manifestList, nil
+	manifestListDigest, err := manifest.Digest(manifestList)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{
+		Manifest:         manifestList,
+		ManifestDigest:   manifestListDigest,
+		ManifestMIMEType: manifestListType,
+		Instances:        instanceResults,
+		SignaturesCopied: signaturesCopied,
+		SignaturesAdded:  len(newSigs),
+	}, nil
 }
 
 // copyOneImage copies a single (non-manifest-list) image unparsedImage, using policyContext to validate
 // source image admissibility.
-func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.PolicyContext, options *Options, unparsedToplevel, unparsedImage *image.UnparsedImage, targetInstance *digest.Digest) (retManifest []byte, retManifestType string, retManifestDigest digest.Digest, retErr error) {
+func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.PolicyContext, options *Options, unparsedToplevel, unparsedImage *image.UnparsedImage, targetInstance *digest.Digest) (retManifest []byte, retInstance *InstanceResult, retErr error) {
 	// The caller is handling manifest lists; this could happen only if a manifest list contains a manifest list.
 	// Make sure we fail cleanly in such cases.
 	multiImage, err := isMultiImage(ctx, unparsedImage)
 	if err != nil {
 		// FIXME FIXME: How to name a reference for the sub-image?
-		return nil, "", "", errors.Wrapf(err, "determining manifest MIME type for %s", transports.ImageName(unparsedImage.Reference()))
+		return nil, nil, errors.Wrapf(err, "determining manifest MIME type for %s", transports.ImageName(unparsedImage.Reference()))
 	}
 	if multiImage {
-		return nil, "", "", fmt.Errorf("Unexpectedly received a manifest list instead of a manifest for a single image")
+		return nil, nil, fmt.Errorf("Unexpectedly received a manifest list instead of a manifest for a single image")
 	}
 
 	// Please keep this policy check BEFORE reading any other information about the image.
 	// (The multiImage check above only matches the MIME type, which we have received anyway.
 	// Actual parsing of anything should be deferred.)
 	if allowed, err := policyContext.IsRunningImageAllowed(ctx, unparsedImage); !allowed || err != nil { // Be paranoid and fail if either return value indicates so.
-		return nil, "", "", errors.Wrap(err, "Source image rejected")
+		return nil, nil, errors.Wrap(err, "Source image rejected")
 	}
 	src, err := image.FromUnparsedImage(ctx, options.SourceCtx, unparsedImage)
 	if err != nil {
-		return nil, "", "", errors.Wrapf(err, "initializing image from source %s", transports.ImageName(c.rawSource.Reference()))
+		return nil, nil, errors.Wrapf(err, "initializing image from source %s", transports.ImageName(c.rawSource.Reference()))
 	}
 
 	// If the destination is a digested reference, make a note of that, determine what digest value we're
@@ -622,30 +753,30 @@ func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.Poli
 			destIsDigestedReference = true
 			sourceManifest, _, err := src.Manifest(ctx)
 			if err != nil {
-				return nil, "", "", errors.Wrapf(err, "reading manifest from source image")
+				return nil, nil, errors.Wrapf(err, "reading manifest from source image")
 			}
 			matches, err := manifest.MatchesDigest(sourceManifest, digested.Digest())
 			if err != nil {
-				return nil, "", "", errors.Wrapf(err, "computing digest of source image's manifest")
+				return nil, nil, errors.Wrapf(err, "computing digest of source image's manifest")
 			}
 			if !matches {
 				manifestList, _, err := unparsedToplevel.Manifest(ctx)
 				if err != nil {
-					return nil, "", "", errors.Wrapf(err, "reading manifest from source image")
+					return nil, nil, errors.Wrapf(err, "reading manifest from source image")
 				}
 				matches, err = manifest.MatchesDigest(manifestList, digested.Digest())
 				if err != nil {
-					return nil, "", "", errors.Wrapf(err, "computing digest of source image's manifest")
+					return nil, nil, errors.Wrapf(err, "computing digest of source image's manifest")
 				}
 				if !matches {
-					return nil, "", "", errors.New("Digest of source image's manifest would not match destination reference")
+					return nil, nil, errors.New("Digest of source image's manifest would not match destination reference")
 				}
 			}
 		}
 	}
 
 	if err := checkImageDestinationForCurrentRuntime(ctx, options.DestinationCtx, src, c.dest); err != nil {
-		return nil, "", "", err
+		return nil, nil, err
 	}
 
 	var sigs [][]byte
@@ -655,14 +786,14 @@ func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.Poli
 		c.Printf("Getting image source signatures\n")
 		s, err := src.Signatures(ctx)
 		if err != nil {
-			return nil, "", "", errors.Wrap(err, "reading signatures")
+			return nil, nil, errors.Wrap(err, "reading signatures")
 		}
 		sigs = s
 	}
 	if len(sigs) != 0 {
 		c.Printf("Checking if image destination supports signatures\n")
 		if err := c.dest.SupportsSignatures(ctx); err != nil {
-			return nil, "", "", errors.Wrapf(err, "Can not copy signatures to %s", transports.ImageName(c.dest.Reference()))
+			return nil, nil, errors.Wrapf(err, "Can not copy signatures to %s", transports.ImageName(c.dest.Reference()))
 		}
 	}
 
@@ -694,10 +825,10 @@ func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.Poli
 	// We do intend the RecordDigestUncompressedPair calls to only work with reliable data, but at least there’s a risk
 	// that the compressed version coming from a third party may be designed to attack some other decompressor implementation,
 	// and we would reuse and sign it.
-	ic.canSubstituteBlobs = ic.cannotModifyManifestReason == "" && options.SignBy == ""
+	ic.canSubstituteBlobs = ic.cannotModifyManifestReason == "" && !options.signing()
 
 	if err := ic.updateEmbeddedDockerReference(); err != nil {
-		return nil, "", "", err
+		return nil, nil, err
 	}
 
 	destRequiresOciEncryption := (isEncrypted(src) && ic.c.ociDecryptConfig != nil) || options.OciEncryptLayers != nil
@@ -706,7 +837,7 @@ func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.Poli
 	// Without having to add this context in an error message, we would be happy enough to know only that no conversion is needed.
 	preferredManifestMIMEType, otherManifestMIMETypeCandidates, err := ic.determineManifestConversion(ctx, c.dest.SupportedManifestMIMETypes(), options.ForceManifestMIMEType, destRequiresOciEncryption)
 	if err != nil {
-		return nil, "", "", err
+		return nil, nil, err
 	}
 
 	// If src.UpdatedImageNeedsLayerDiffIDs(ic.manifestUpdates) will be true, it needs to be true by the time we get here.
@@ -714,7 +845,7 @@ func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.Poli
 
 	// If enabled, fetch and compare the destination's manifest. And as an optimization skip updating the destination iff equal
 	if options.OptimizeDestinationImageAlreadyExists {
-		shouldUpdateSigs := len(sigs) > 0 || options.SignBy != "" // TODO: Consider allowing signatures updates only and skipping the image's layers/manifest copy if possible
+		shouldUpdateSigs := len(sigs) > 0 || options.signing() // TODO: Consider allowing signatures updates only and skipping the image's layers/manifest copy if possible
 		noPendingManifestUpdates := ic.noPendingManifestUpdates()
 
 		logrus.Debugf("Checking if we can skip copying: has signatures=%t, OCI encryption=%t, no manifest updates=%t", shouldUpdateSigs, destRequiresOciEncryption, noPendingManifestUpdates)
@@ -722,18 +853,23 @@ func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.Poli
 			isSrcDestManifestEqual, retManifest, retManifestType, retManifestDigest, err := compareImageDestinationManifestEqual(ctx, options, src, targetInstance, c.dest)
 			if err != nil {
 				logrus.Warnf("Failed to compare destination image manifest: %v", err)
-				return nil, "", "", err
+				return nil, nil, err
 			}
 
 			if isSrcDestManifestEqual {
 				c.Printf("Skipping: image already present at destination\n")
-				return retManifest, retManifestType, retManifestDigest, nil
+				return retManifest, &InstanceResult{
+					SourceDigest:     retManifestDigest, // The manifests are equal
+					ManifestDigest:   retManifestDigest,
+					ManifestMIMEType: retManifestType,
+					AlreadyPresent:   true,
+				}, nil
 			}
 		}
 	}
 
 	if err := ic.copyLayers(ctx); err != nil {
-		return nil, "", "", err
+		return nil, nil, err
 	}
 
 	// With docker/distribution registries we do not know whether the registry accepts schema2 or schema1 only;
@@ -742,7 +878,7 @@ func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.Poli
 	// So, try the preferred manifest MIME type with possibly-updated blob digests, media types, and sizes if
 	// we're altering how they're compressed.  If the process succeeds, fine…
 	manifestBytes, retManifestDigest, err := ic.copyUpdatedConfigAndManifest(ctx, targetInstance)
-	retManifestType = preferredManifestMIMEType
+	retManifestType := preferredManifestMIMEType
 	if err != nil {
 		logrus.Debugf("Writing manifest using preferred type %s failed: %v", preferredManifestMIMEType, err)
 		// … if it fails, and the failure is either because the manifest is rejected by the registry, or
@@ -755,14 +891,14 @@ func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.Poli
 			// We don’t have other options.
 			// In principle the code below would handle this as well, but the resulting  error message is fairly ugly.
 			// Don’t bother the user with MIME types if we have no choice.
-			return nil, "", "", err
+			return nil, nil, err
 		}
 		// If the original MIME type is acceptable, determineManifestConversion always uses it as preferredManifestMIMEType.
 		// So if we are here, we will definitely be trying to convert the manifest.
 		// With ic.cannotModifyManifestReason != "", that would just be a string of repeated failures for the same reason,
 		// so let’s bail out early and with a better error message.
 		if ic.cannotModifyManifestReason != "" {
-			return nil, "", "", errors.Wrapf(err, "Writing manifest failed and we cannot try conversions: %q", cannotModifyManifestReason)
+			return nil, nil, errors.Wrapf(err, "Writing manifest failed and we cannot try conversions: %q", cannotModifyManifestReason)
 		}
 
 		// errs is a list of errors when trying various manifest types. Also serves as an "upload succeeded" flag when set to nil.
@@ -785,27 +921,53 @@ func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.Poli
 			break
 		}
 		if errs != nil {
-			return nil, "", "", fmt.Errorf("Uploading manifest failed, attempted the following formats: %s", strings.Join(errs, ", "))
+			return nil, nil, fmt.Errorf("Uploading manifest failed, attempted the following formats: %s", strings.Join(errs, ", "))
 		}
 	}
 	if targetInstance != nil {
 		targetInstance = &retManifestDigest
 	}
 
-	if options.SignBy != "" {
-		newSig, err := c.createSignature(manifestBytes, options.SignBy, options.SignPassphrase, options.SignIdentity)
-		if err != nil {
-			return nil, "", "", err
-		}
-		sigs = append(sigs, newSig)
+	newSigs, err := c.createSignatures(manifestBytes, options)
+	if err != nil {
+		return nil, nil, err
 	}
+	signaturesCopied := len(sigs)
+	sigs = append(sigs, newSigs...)
 
 	c.Printf("Storing signatures\n")
 	if err := c.dest.PutSignatures(ctx, sigs, targetInstance); err != nil {
-		return nil, "", "", errors.Wrap(err, "writing signatures")
+		return nil, nil, errors.Wrap(err, "writing signatures")
 	}
 
-	return manifestBytes, retManifestType, retManifestDigest, nil
+	sourceManifest, _, err := src.Manifest(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "reading manifest from source image")
+	}
+	sourceDigest, err := manifest.Digest(sourceManifest)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "computing digest of source image's manifest")
+	}
+	instance := &InstanceResult{
+		SourceDigest:     sourceDigest,
+		ManifestDigest:   retManifestDigest,
+		ManifestMIMEType: retManifestType,
+		Layers:           ic.layerResults,
+		SignaturesCopied: signaturesCopied,
+		SignaturesAdded:  len(newSigs),
+		BytesTransferred: ic.configBytesTransferred,
+	}
+	for _, l := range ic.layerResults {
+		instance.BytesTransferred += l.BytesTransferred
+	}
+	if c.dryRun {
+		instance.PlannedBlobs = []PlannedBlob{}
+		if ic.plannedConfig != nil {
+			instance.PlannedBlobs = append(instance.PlannedBlobs, *ic.plannedConfig)
+		}
+		instance.PlannedBlobs = append(instance.PlannedBlobs, ic.plannedLayers...)
+	}
+	return manifestBytes, instance, nil
 }
 
 // Printf writes a formatted string to c.reportWriter.
@@ -903,6 +1065,8 @@ func (ic *imageCopier) copyLayers(ctx context.Context) error {
 	type copyLayerData struct {
 		destInfo types.BlobInfo
 		diffID   digest.Digest
+		result   LayerResult  // Only the fields set by copyLayer
+		planned  *PlannedBlob // Only in dry runs, if the layer would be copied
 		err      error
 	}
 
@@ -937,7 +1101,15 @@ func (ic *imageCopier) copyLayers(ctx context.Context) error {
 				logrus.Debugf("Skipping foreign layer %q copy to %s", cld.destInfo.Digest, ic.c.dest.Reference().Transport().Name())
 			}
 		} else {
-			cld.destInfo, cld.diffID, cld.err = ic.copyLayer(ctx, srcLayer, toEncrypt, pool, index, srcRef, manifestLayerInfos[index].EmptyLayer)
+			cld.destInfo, cld.diffID, cld.err = ic.copyLayer(ctx, srcLayer, toEncrypt, pool, index, srcRef, manifestLayerInfos[index].EmptyLayer, &cld.result)
+			if ic.c.dryRun {
+				cld.planned = &PlannedBlob{
+					Digest:         srcLayer.Digest,
+					Size:           srcLayer.Size,
+					MediaType:      srcLayer.MediaType,
+					AlreadyPresent: cld.result.Reused,
+				}
+			}
 		}
 		data[index] = cld
 	}
@@ -985,12 +1157,29 @@ func (ic *imageCopier) copyLayers(ctx context.Context) error {
 
 	destInfos := make([]types.BlobInfo, numLayers)
 	diffIDs := make([]digest.Digest, numLayers)
+	layerResults := make([]LayerResult, numLayers)
+	plannedLayers := []PlannedBlob{}
 	for i, cld := range data {
 		if cld.err != nil {
 			return cld.err
 		}
+		if cld.planned != nil {
+			plannedLayers = append(plannedLayers, *cld.planned)
+		}
 		destInfos[i] = cld.destInfo
 		diffIDs[i] = cld.diffID
+		layerResults[i] = cld.result
+		layerResults[i].SourceDigest = srcInfos[i].Digest
+		layerResults[i].Digest = cld.destInfo.Digest
+		layerResults[i].Size = cld.destInfo.Size
+		layerResults[i].CompressionOperation = cld.destInfo.CompressionOperation
+		if cld.destInfo.CompressionOperation == types.Compress {
+			layerResults[i].CompressionAlgorithm = cld.destInfo.CompressionAlgorithm
+		}
+	}
+	ic.layerResults = layerResults
+	if ic.c.dryRun {
+		ic.plannedLayers = plannedLayers
 	}
 
 	// WARNING: If you are adding new reasons to change ic.manifestUpdates, also update the
@@ -999,7 +1188,8 @@ func (ic *imageCopier) copyLayers(ctx context.Context) error {
 	if ic.diffIDsAreNeeded {
 		ic.manifestUpdates.InformationOnly.LayerDiffIDs = diffIDs
 	}
-	if srcInfosUpdated || layerDigestsDiffer(srcInfos, destInfos) {
+	// In dry runs, the layer digests don’t change, but the MIME types might.
+	if srcInfosUpdated || layerDigestsDiffer(srcInfos, destInfos) || (ic.c.dryRun && plannedLayerCompressionChanges(destInfos)) {
 		ic.manifestUpdates.LayerInfos = destInfos
 	}
 	return nil
@@ -1047,8 +1237,20 @@ func (ic *imageCopier) copyUpdatedConfigAndManifest(ctx context.Context, instanc
 		return nil, "", errors.Wrap(err, "reading manifest")
 	}
 
-	if err := ic.c.copyConfig(ctx, pendingImage); err != nil {
-		return nil, "", err
+	ic.configBytesTransferred = 0
+	if ic.c.dryRun {
+		plannedConfig, err := ic.c.planConfig(ctx, pendingImage)
+		if err != nil {
+			return nil, "", err
+		}
+		ic.plannedConfig = plannedConfig
+	} else {
+		if err := ic.c.copyConfig(ctx, pendingImage); err != nil {
+			return nil, "", err
+		}
+		if configInfo := pendingImage.ConfigInfo(); configInfo.Digest != "" && configInfo.Size > 0 {
+			ic.configBytesTransferred = configInfo.Size
+		}
 	}
 
 	ic.c.Printf("Writing manifest to image destination\n")
@@ -1115,7 +1317,8 @@ type diffIDResult struct {
 // copyLayer copies a layer with srcInfo (with known Digest and Annotations and possibly known Size) in src to dest, perhaps (de/re/)compressing it,
 // and returns a complete blobInfo of the copied layer, and a value for LayerDiffIDs if diffIDIsNeeded
 // srcRef can be used as an additional hint to the destination during checking whether a layer can be reused but srcRef can be nil.
-func (ic *imageCopier) copyLayer(ctx context.Context, srcInfo types.BlobInfo, toEncrypt bool, pool *mpb.Progress, layerIndex int, srcRef reference.Named, emptyLayer bool) (types.BlobInfo, digest.Digest, error) {
+// On success, the Reused, ReusedFrom and BytesTransferred fields of result are set.
+func (ic *imageCopier) copyLayer(ctx context.Context, srcInfo types.BlobInfo, toEncrypt bool, pool *mpb.Progress, layerIndex int, srcRef reference.Named, emptyLayer bool, result *LayerResult) (types.BlobInfo, digest.Digest, error) {
 	// If the srcInfo doesn't contain compression information, try to compute it from the
 	// MediaType, which was either read from a manifest by way of LayerInfos() or constructed
 	// by LayerInfosForCopy(), if it was supplied at all.  If we succeed in copying the blob,
@@ -1152,12 +1355,14 @@ func (ic *imageCopier) copyLayer(ctx context.Context, srcInfo types.BlobInfo, to
 		// a failure when we eventually try to update the manifest with the digest and MIME type of the reused blob.
 		// Fixing that will probably require passing more information to TryReusingBlob() than the current version of
 		// the ImageDestination interface lets us pass in.
+		reusedFrom := ""
 		reused, blobInfo, err := ic.c.dest.TryReusingBlobWithOptions(ctx, srcInfo, private.TryReusingBlobOptions{
-			Cache:         ic.c.blobInfoCache,
-			CanSubstitute: ic.canSubstituteBlobs,
-			EmptyLayer:    emptyLayer,
-			LayerIndex:    &layerIndex,
-			SrcRef:        srcRef,
+			Cache:            ic.c.blobInfoCache,
+			CanSubstitute:    ic.canSubstituteBlobs,
+			EmptyLayer:       emptyLayer,
+			LayerIndex:       &layerIndex,
+			SrcRef:           srcRef,
+			ReportReusedFrom: func(location string) { reusedFrom = location },
 		})
 		if err != nil {
 			return types.BlobInfo{}, "", errors.Wrapf(err, "trying to reuse blob %s at destination", srcInfo.Digest)
@@ -1190,16 +1395,23 @@ func (ic *imageCopier) copyLayer(ctx context.Context, srcInfo types.BlobInfo, to
 				blobInfo.CompressionOperation = srcInfo.CompressionOperation
 				blobInfo.CompressionAlgorithm = srcInfo.CompressionAlgorithm
 			}
+			result.Reused = true
+			result.ReusedFrom = reusedFrom
 			return blobInfo, cachedDiffID, nil
 		}
 	}
 
+	if ic.c.dryRun {
+		logrus.Debugf("Dry run: not copying blob %s", srcInfo.Digest)
+		return ic.plannedLayerBlobInfo(srcInfo), cachedDiffID, nil
+	}
+
 	// A partial pull is managed by the destination storage, that decides what portions
 	// of the source file are not known yet and must be fetched.
 	// Attempt a partial only when the source allows to retrieve a blob partially and
 	// the destination has support for it.
 	if canAvoidProcessingCompleteLayer && ic.c.rawSource.SupportsGetBlobAt() && ic.c.dest.SupportsPutBlobPartial() {
-		if reused, blobInfo := func() (bool, types.BlobInfo) { // A scope for defer
+		if reused, blobInfo, bytesTransferred := func() (bool, types.BlobInfo, int64) { // A scope for defer
 			bar := ic.c.createProgressBar(pool, true, srcInfo, "blob", "done")
 			hideProgressBar := true
 			defer func() { // Note that this is not the same as defer bar.Abort(hideProgressBar); we need hideProgressBar to be evaluated lazily.
@@ -1212,17 +1424,19 @@ func (ic *imageCopier) copyLayer(ctx context.Context, srcInfo types.BlobInfo, to
 			}
 			info, err := ic.c.dest.PutBlobPartial(ctx, &proxy, srcInfo, ic.c.blobInfoCache)
 			if err == nil {
+				bytesTransferred := bar.Current() // Only counts the chunks fetched from the source, not the refill
 				if srcInfo.Size != -1 {
 					bar.SetRefill(srcInfo.Size - bar.Current())
 				}
 				bar.mark100PercentComplete()
 				hideProgressBar = false
 				logrus.Debugf("Retrieved partial blob %v", srcInfo.Digest)
-				return true, info
+				return true, info, bytesTransferred
 			}
 			logrus.Debugf("Failed to retrieve partial blob: %v", err)
-			return false, types.BlobInfo{}
+			return false, types.BlobInfo{}, 0
 		}(); reused {
+			result.BytesTransferred = bytesTransferred
 			return blobInfo, cachedDiffID, nil
 		}
 	}
@@ -1237,8 +1451,9 @@ func (ic *imageCopier) copyLayer(ctx context.Context, srcInfo types.BlobInfo, to
 			return types.BlobInfo{}, "", errors.Wrapf(err, "reading blob %s", srcInfo.Digest)
 		}
 		defer srcStream.Close()
+		countingStream := &byteCountingReader{reader: srcStream}
 
-		blobInfo, diffIDChan, err := ic.copyLayerFromStream(ctx, srcStream, types.BlobInfo{Digest: srcInfo.Digest, Size: srcBlobSize, MediaType: srcInfo.MediaType, Annotations: srcInfo.Annotations}, diffIDIsNeeded, toEncrypt, bar, layerIndex, emptyLayer)
+		blobInfo, diffIDChan, err := ic.copyLayerFromStream(ctx, countingStream, types.BlobInfo{Digest: srcInfo.Digest, Size: srcBlobSize, MediaType: srcInfo.MediaType, Annotations: srcInfo.Annotations}, diffIDIsNeeded, toEncrypt, bar, layerIndex, emptyLayer)
 		if err != nil {
 			return types.BlobInfo{}, "", err
 		}
@@ -1269,6 +1484,7 @@ func (ic *imageCopier) copyLayer(ctx context.Context, srcInfo types.BlobInfo, to
 		}
 
 		bar.mark100PercentComplete()
+		result.BytesTransferred = countingStream.count
 		return blobInfo, diffID, nil
 	}()
 }
@@ -1363,6 +1579,9 @@ func (c *copier) copyBlobFromStream(ctx context.Context, srcStream io.Reader, sr
 	// The copying happens through a pipeline of connected io.Readers.
 	// === Input: srcStream
 
+	// === Limit the throughput, if required.
+	srcStream = bandwidth.NewReader(ctx, srcStream, c.bandwidthLimiter)
+
 	// === Process input through digestingReader to validate against the expected digest.
 	// Be paranoid; in case PutBlob somehow managed to ignore an error from digestingReader,
 	// use a separate validation failure indicator.
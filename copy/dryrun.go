@@ -0,0 +1,138 @@
+package copy
+
+import (
+	"context"
+	"io"
+
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// dryRunDestination wraps a private.ImageDestination for Options.DryRun: it only allows probing the destination for existing blobs,
+// and turns all writes into no-ops (or, for blobs, which a dry run should never even try to write, into errors).
+type dryRunDestination struct {
+	private.ImageDestination
+}
+
+// newDryRunDestination returns a dryRunDestination wrapping dest.
+func newDryRunDestination(dest private.ImageDestination) *dryRunDestination {
+	return &dryRunDestination{ImageDestination: dest}
+}
+
+// PutBlob always fails; a dry run does not read blob contents from the source.
+func (d *dryRunDestination) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, cache types.BlobInfoCache, isConfig bool) (types.BlobInfo, error) {
+	return types.BlobInfo{}, errors.Errorf("Internal error: writing blob %s in a dry run", inputInfo.Digest)
+}
+
+// PutBlobWithOptions always fails; a dry run does not read blob contents from the source.
+func (d *dryRunDestination) PutBlobWithOptions(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, options private.PutBlobOptions) (types.BlobInfo, error) {
+	return types.BlobInfo{}, errors.Errorf("Internal error: writing blob %s in a dry run", inputInfo.Digest)
+}
+
+// SupportsPutBlobPartial returns false; a dry run does not read blob contents from the source.
+func (d *dryRunDestination) SupportsPutBlobPartial() bool {
+	return false
+}
+
+// PutBlobPartial always fails; a dry run does not read blob contents from the source.
+func (d *dryRunDestination) PutBlobPartial(ctx context.Context, chunkAccessor private.BlobChunkAccessor, srcInfo types.BlobInfo, cache types.BlobInfoCache) (types.BlobInfo, error) {
+	return types.BlobInfo{}, errors.Errorf("Internal error: writing blob %s in a dry run", srcInfo.Digest)
+}
+
+// TryReusingBlob checks whether the destination already contains a blob; see TryReusingBlobWithOptions.
+func (d *dryRunDestination) TryReusingBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache, canSubstitute bool) (bool, types.BlobInfo, error) {
+	return d.TryReusingBlobWithOptions(ctx, info, private.TryReusingBlobOptions{Cache: cache, CanSubstitute: canSubstitute})
+}
+
+// TryReusingBlobWithOptions checks whether the destination already contains a blob.
+// Reusing blobs from other locations (e.g. mounting them from other repositories of a registry) would modify
+// the destination, so it is not allowed, the cache is not used, and substitutions are not allowed.
+func (d *dryRunDestination) TryReusingBlobWithOptions(ctx context.Context, info types.BlobInfo, options private.TryReusingBlobOptions) (bool, types.BlobInfo, error) {
+	options.Cache = none.NoCache
+	options.CanSubstitute = false
+	options.ReportReusedFrom = nil
+	options.NoCrossRepositoryReuse = true
+	return d.ImageDestination.TryReusingBlobWithOptions(ctx, info, options)
+}
+
+// PutManifest does nothing.
+func (d *dryRunDestination) PutManifest(ctx context.Context, manifest []byte, instanceDigest *digest.Digest) error {
+	return nil
+}
+
+// PutSignatures does nothing.
+func (d *dryRunDestination) PutSignatures(ctx context.Context, signatures [][]byte, instanceDigest *digest.Digest) error {
+	return nil
+}
+
+// Commit does nothing.
+func (d *dryRunDestination) Commit(ctx context.Context, unparsedToplevel types.UnparsedImage) error {
+	return nil
+}
+
+// plannedLayerBlobInfo returns a BlobInfo for a layer with srcInfo, which a dry run would copy, describing the compression
+// changes copyBlobFromStream would make, as far as they can be determined from srcInfo without reading the layer.
+// The digest and size are not updated.
+func (ic *imageCopier) plannedLayerBlobInfo(srcInfo types.BlobInfo) types.BlobInfo {
+	res := srcInfo
+	res.CompressionOperation = types.PreserveOriginal
+	if ic.cannotModifyManifestReason != "" || isOciEncrypted(srcInfo.MediaType) {
+		return res
+	}
+	isCompressed := srcInfo.CompressionAlgorithm != nil
+	isUncompressed := srcInfo.MediaType == imgspecv1.MediaTypeImageLayer || srcInfo.MediaType == imgspecv1.MediaTypeImageLayerNonDistributable
+	switch ic.c.dest.DesiredLayerCompression() {
+	case types.Compress:
+		if isUncompressed {
+			res.CompressionOperation = types.Compress
+			res.CompressionAlgorithm = defaultCompressionFormat
+			if ic.c.compressionFormat != nil {
+				res.CompressionAlgorithm = ic.c.compressionFormat
+			}
+		} else if isCompressed && ic.c.compressionFormat != nil && ic.c.compressionFormat.Name() != srcInfo.CompressionAlgorithm.Name() {
+			res.CompressionOperation = types.Compress
+			res.CompressionAlgorithm = ic.c.compressionFormat
+		}
+	case types.Decompress:
+		if isCompressed {
+			res.CompressionOperation = types.Decompress
+			res.CompressionAlgorithm = nil
+		}
+	}
+	return res
+}
+
+// plannedLayerCompressionChanges returns true if any of infos, as returned by plannedLayerBlobInfo, would change the layer compression.
+func plannedLayerCompressionChanges(infos []types.BlobInfo) bool {
+	for _, info := range infos {
+		if info.CompressionOperation != types.PreserveOriginal {
+			return true
+		}
+	}
+	return false
+}
+
+// planConfig returns a PlannedBlob for the config of src, if any, checking whether it already exists at the destination.
+func (c *copier) planConfig(ctx context.Context, src types.Image) (*PlannedBlob, error) {
+	srcInfo := src.ConfigInfo()
+	if srcInfo.Digest == "" {
+		return nil, nil
+	}
+	reused, _, err := c.dest.TryReusingBlobWithOptions(ctx, srcInfo, private.TryReusingBlobOptions{
+		Cache:         c.blobInfoCache,
+		CanSubstitute: false,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "trying to reuse config %s at destination", srcInfo.Digest)
+	}
+	return &PlannedBlob{
+		Digest:         srcInfo.Digest,
+		Size:           srcInfo.Size,
+		MediaType:      srcInfo.MediaType,
+		AlreadyPresent: reused,
+	}, nil
+}
@@ -0,0 +1,227 @@
+package copy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/directory"
+	"github.com/containers/image/v5/docker/archive"
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/manifest"
+	ocilayout "github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/pkg/blobinfocache/memory"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/pkg/compression"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageDryRun(t *testing.T) {
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{
+		Default: signature.PolicyRequirements{signature.NewPRInsecureAcceptAnything()},
+	})
+	require.NoError(t, err)
+	defer func() { _ = policyContext.Destroy() }()
+
+	gzipLayer, err := os.ReadFile("fixtures/Hello.gz")
+	require.NoError(t, err)
+	srcRef, srcManifest, config := resultTestSourceImage(t, gzipLayer, imgspecv1.MediaTypeImageLayerGzip)
+	destDir := t.TempDir()
+	destRef, err := ocilayout.NewReference(destDir, "tag")
+	require.NoError(t, err)
+	expectedBlobs := []PlannedBlob{
+		{Digest: digest.FromBytes(config), Size: int64(len(config)), MediaType: imgspecv1.MediaTypeImageConfig},
+		{Digest: digest.FromBytes(gzipLayer), Size: int64(len(gzipLayer)), MediaType: imgspecv1.MediaTypeImageLayerGzip},
+	}
+
+	// Nothing is present at the destination
+	res, err := ImageWithResult(context.Background(), policyContext, destRef, srcRef, &Options{DryRun: true})
+	require.NoError(t, err)
+	assert.True(t, res.DryRun)
+	assert.Equal(t, srcManifest, res.Manifest)
+	assert.Equal(t, imgspecv1.MediaTypeImageManifest, res.ManifestMIMEType)
+	assert.Equal(t, int64(0), res.BytesTransferred)
+	require.Len(t, res.Instances, 1)
+	assert.Equal(t, expectedBlobs, res.Instances[0].PlannedBlobs)
+	toTransfer, size := res.BlobsToTransfer()
+	assert.Equal(t, expectedBlobs, toTransfer)
+	assert.Equal(t, int64(len(config)+len(gzipLayer)), size)
+	for _, blob := range expectedBlobs {
+		_, err := os.Stat(filepath.Join(destDir, "blobs", blob.Digest.Algorithm().String(), blob.Digest.Encoded()))
+		assert.True(t, os.IsNotExist(err))
+	}
+	_, err = os.Stat(filepath.Join(destDir, "blobs", "sha256", digest.FromBytes(srcManifest).Encoded()))
+	assert.True(t, os.IsNotExist(err))
+
+	// After a real copy, all blobs are present
+	_, err = Image(context.Background(), policyContext, destRef, srcRef, &Options{})
+	require.NoError(t, err)
+	res, err = ImageWithResult(context.Background(), policyContext, destRef, srcRef, &Options{DryRun: true})
+	require.NoError(t, err)
+	require.Len(t, res.Instances, 1)
+	require.Len(t, res.Instances[0].PlannedBlobs, 2)
+	for _, blob := range res.Instances[0].PlannedBlobs {
+		assert.True(t, blob.AlreadyPresent, blob.Digest.String())
+	}
+	toTransfer, size = res.BlobsToTransfer()
+	assert.Empty(t, toTransfer)
+	assert.Equal(t, int64(0), size)
+
+	// Compression changes are reflected in the manifest
+	uncompressedLayer, err := os.ReadFile("fixtures/Hello.uncompressed")
+	require.NoError(t, err)
+	srcRef, _, _ = resultTestSourceImage(t, uncompressedLayer, imgspecv1.MediaTypeImageLayer)
+	destRef, err = ocilayout.NewReference(t.TempDir(), "tag")
+	require.NoError(t, err)
+	res, err = ImageWithResult(context.Background(), policyContext, destRef, srcRef, &Options{
+		DryRun:         true,
+		DestinationCtx: &types.SystemContext{CompressionFormat: &compression.Zstd},
+	})
+	require.NoError(t, err)
+	require.Len(t, res.Instances, 1)
+	require.Len(t, res.Instances[0].Layers, 1)
+	layer := res.Instances[0].Layers[0]
+	assert.Equal(t, types.Compress, layer.CompressionOperation)
+	require.NotNil(t, layer.CompressionAlgorithm)
+	assert.Equal(t, compression.Zstd.Name(), layer.CompressionAlgorithm.Name())
+	m, err := manifest.OCI1FromManifest(res.Manifest)
+	require.NoError(t, err)
+	require.Len(t, m.Layers, 1)
+	assert.Equal(t, imgspecv1.MediaTypeImageLayerZstd, m.Layers[0].MediaType)
+
+	// Incompatible conversions are detected
+	_, err = ImageWithResult(context.Background(), policyContext, destRef, srcRef, &Options{
+		DryRun:                true,
+		DestinationCtx:        &types.SystemContext{CompressionFormat: &compression.Zstd},
+		ForceManifestMIMEType: manifest.DockerV2Schema2MediaType,
+	})
+	assert.Error(t, err)
+
+	// Referrers can’t be copied in a dry run
+	_, err = ImageWithResult(context.Background(), policyContext, destRef, srcRef, &Options{DryRun: true, CopyReferrers: true})
+	assert.Error(t, err)
+}
+
+func TestImageDryRunDoesNotModifyDestination(t *testing.T) {
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{
+		Default: signature.PolicyRequirements{signature.NewPRInsecureAcceptAnything()},
+	})
+	require.NoError(t, err)
+	defer func() { _ = policyContext.Destroy() }()
+
+	gzipLayer, err := os.ReadFile("fixtures/Hello.gz")
+	require.NoError(t, err)
+	srcRef, _, _ := resultTestSourceImage(t, gzipLayer, imgspecv1.MediaTypeImageLayerGzip)
+
+	// A populated dir: destination is left unchanged
+	destDir := t.TempDir()
+	destRef, err := directory.NewReference(destDir)
+	require.NoError(t, err)
+	_, err = Image(context.Background(), policyContext, destRef, srcRef, &Options{})
+	require.NoError(t, err)
+	readDir := func() map[string][]byte {
+		contents := map[string][]byte{}
+		entries, err := os.ReadDir(destDir)
+		require.NoError(t, err)
+		for _, e := range entries {
+			data, err := os.ReadFile(filepath.Join(destDir, e.Name()))
+			require.NoError(t, err)
+			contents[e.Name()] = data
+		}
+		return contents
+	}
+	before := readDir()
+	require.NotEmpty(t, before)
+	res, err := ImageWithResult(context.Background(), policyContext, destRef, srcRef, &Options{DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, before, readDir())
+	// A real copy would empty the directory first, so nothing is reported as already present.
+	require.Len(t, res.Instances, 1)
+	require.Len(t, res.Instances[0].PlannedBlobs, 2)
+	for _, blob := range res.Instances[0].PlannedBlobs {
+		assert.False(t, blob.AlreadyPresent, blob.Digest.String())
+	}
+
+	// A non-image directory is rejected without being modified
+	otherDir := t.TempDir()
+	err = os.WriteFile(filepath.Join(otherDir, "data"), []byte("important"), 0644)
+	require.NoError(t, err)
+	destRef, err = directory.NewReference(otherDir)
+	require.NoError(t, err)
+	_, err = ImageWithResult(context.Background(), policyContext, destRef, srcRef, &Options{DryRun: true})
+	assert.ErrorIs(t, err, directory.ErrNotContainerImageDir)
+	data, err := os.ReadFile(filepath.Join(otherDir, "data"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("important"), data)
+
+	// An oci: destination is not created
+	ociDir := filepath.Join(t.TempDir(), "oci")
+	destRef, err = ocilayout.NewReference(ociDir, "tag")
+	require.NoError(t, err)
+	_, err = ImageWithResult(context.Background(), policyContext, destRef, srcRef, &Options{DryRun: true})
+	require.NoError(t, err)
+	_, err = os.Stat(ociDir)
+	assert.True(t, os.IsNotExist(err))
+
+	// Transports which can't be probed without modifying them are rejected
+	archivePath := filepath.Join(t.TempDir(), "archive.tar")
+	destRef, err = archive.ParseReference(archivePath)
+	require.NoError(t, err)
+	_, err = ImageWithResult(context.Background(), policyContext, destRef, srcRef, &Options{DryRun: true})
+	assert.Error(t, err)
+	_, err = os.Stat(archivePath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+// optionsRecordingDestination is a private.ImageDestination which records the options passed to TryReusingBlobWithOptions.
+type optionsRecordingDestination struct {
+	private.ImageDestination // nil, only to satisfy the interface
+	options                  []private.TryReusingBlobOptions
+}
+
+func (d *optionsRecordingDestination) TryReusingBlobWithOptions(ctx context.Context, info types.BlobInfo, options private.TryReusingBlobOptions) (bool, types.BlobInfo, error) {
+	d.options = append(d.options, options)
+	return false, types.BlobInfo{}, nil
+}
+
+func TestDryRunDestinationTryReusingBlob(t *testing.T) {
+	inner := &optionsRecordingDestination{}
+	dest := newDryRunDestination(inner)
+	info := types.BlobInfo{Digest: digest.FromString("blob")}
+	_, _, err := dest.TryReusingBlobWithOptions(context.Background(), info, private.TryReusingBlobOptions{
+		Cache:            memory.New(),
+		CanSubstitute:    true,
+		ReportReusedFrom: func(string) {},
+	})
+	require.NoError(t, err)
+	_, _, err = dest.TryReusingBlob(context.Background(), info, memory.New(), true)
+	require.NoError(t, err)
+	require.Len(t, inner.options, 2)
+	for _, options := range inner.options {
+		assert.True(t, options.NoCrossRepositoryReuse)
+		assert.False(t, options.CanSubstitute)
+		assert.Nil(t, options.ReportReusedFrom)
+		assert.Equal(t, none.NoCache, options.Cache)
+	}
+}
+
+func TestResultBlobsToTransfer(t *testing.T) {
+	shared := PlannedBlob{Digest: digest.FromString("shared"), Size: 1}
+	present := PlannedBlob{Digest: digest.FromString("present"), Size: 10, AlreadyPresent: true}
+	unknownSize := PlannedBlob{Digest: digest.FromString("unknown size"), Size: -1}
+	other := PlannedBlob{Digest: digest.FromString("other"), Size: 100}
+	res := Result{Instances: []InstanceResult{
+		{PlannedBlobs: []PlannedBlob{shared, present, unknownSize}},
+		{PlannedBlobs: []PlannedBlob{shared, other}},
+		{AlreadyPresent: true},
+	}}
+	blobs, size := res.BlobsToTransfer()
+	assert.Equal(t, []PlannedBlob{shared, unknownSize, other}, blobs)
+	assert.Equal(t, int64(101), size)
+}
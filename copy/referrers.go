@@ -0,0 +1,239 @@
+package copy
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/internal/bandwidth"
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// referrersSubject is a manifest which has been copied, and whose referrers should be copied as well.
+type referrersSubject struct {
+	source digest.Digest        // Digest of the manifest in the source
+	dest   imgspecv1.Descriptor // The manifest as written to the destination
+}
+
+// referrerManifest contains the fields of a referrer manifest (an image manifest, an index, or an artifact manifest)
+// relevant for copying it.
+type referrerManifest struct {
+	Config    *imgspecv1.Descriptor  `json:"config,omitempty"`
+	Layers    []imgspecv1.Descriptor `json:"layers,omitempty"`
+	Blobs     []imgspecv1.Descriptor `json:"blobs,omitempty"` // Used by the OCI artifact manifest
+	Manifests []imgspecv1.Descriptor `json:"manifests,omitempty"`
+	Subject   *imgspecv1.Descriptor  `json:"subject,omitempty"`
+}
+
+// noteReferrersSubject records that sourceManifest has been copied as destManifest (of destMIMEType),
+// so that copyReferrers copies its referrers.
+func (c *copier) noteReferrersSubject(sourceManifest, destManifest []byte, destMIMEType string) error {
+	sourceDigest, err := manifest.Digest(sourceManifest)
+	if err != nil {
+		return err
+	}
+	destDigest, err := manifest.Digest(destManifest)
+	if err != nil {
+		return err
+	}
+	c.referrersSubjects = append(c.referrersSubjects, referrersSubject{
+		source: sourceDigest,
+		dest: imgspecv1.Descriptor{
+			MediaType: destMIMEType,
+			Digest:    destDigest,
+			Size:      int64(len(destManifest)),
+		},
+	})
+	return nil
+}
+
+// noteUnparsedReferrersSubject is noteReferrersSubject for a source manifest of unparsedSource.
+func (c *copier) noteUnparsedReferrersSubject(ctx context.Context, unparsedSource *image.UnparsedImage, destManifest []byte, destMIMEType string) error {
+	sourceManifest, _, err := unparsedSource.Manifest(ctx)
+	if err != nil {
+		return errors.Wrap(err, "reading source manifest")
+	}
+	return c.noteReferrersSubject(sourceManifest, destManifest, destMIMEType)
+}
+
+// copyReferrers copies all referrers of c.referrersSubjects from the source, recursively (i.e. including referrers
+// of the referrers), rewriting their "subject" fields if the referred-to manifest was modified when copying.
+func (c *copier) copyReferrers(ctx context.Context) error {
+	if !c.rawSource.SupportsReferrers() {
+		return errors.Errorf("copying referrers: source %s does not support referrers", transports.ImageName(c.rawSource.Reference()))
+	}
+	seen := map[digest.Digest]struct{}{}
+	queue := append([]referrersSubject{}, c.referrersSubjects...)
+	for len(queue) > 0 {
+		subject := queue[0]
+		queue = queue[1:]
+
+		referrers, err := c.rawSource.GetReferrers(ctx, subject.source, "")
+		if err != nil {
+			return errors.Wrapf(err, "listing referrers of %s", subject.source)
+		}
+		for _, r := range referrers {
+			if _, ok := seen[r.Digest]; ok {
+				continue
+			}
+			seen[r.Digest] = struct{}{}
+
+			c.Printf("Copying referrer %s\n", r.Digest)
+			dest, bytesTransferred, err := c.copyReferrerManifest(ctx, r.Digest, &subject)
+			if err != nil {
+				return errors.Wrapf(err, "copying referrer %s of %s", r.Digest, subject.source)
+			}
+			c.copiedReferrers = append(c.copiedReferrers, ReferrerResult{
+				SubjectDigest: subject.dest.Digest,
+				SourceDigest:  r.Digest,
+				Digest:        dest.Digest,
+				ArtifactType:  r.ArtifactType,
+
+				BytesTransferred: bytesTransferred,
+			})
+			queue = append(queue, referrersSubject{source: r.Digest, dest: dest})
+		}
+	}
+	return nil
+}
+
+// copyReferrerManifest copies the manifest with manifestDigest, and all blobs and manifests it refers to, from the source
+// to the destination, without any modifications, except that if subject is not nil and the manifest refers to subject.source,
+// its "subject" field is updated to refer to subject.dest.
+// It returns a descriptor of the manifest as written to the destination, and the number of bytes of blobs read from the source.
+func (c *copier) copyReferrerManifest(ctx context.Context, manifestDigest digest.Digest, subject *referrersSubject) (imgspecv1.Descriptor, int64, error) {
+	m, mimeType, err := c.rawSource.GetManifest(ctx, &manifestDigest)
+	if err != nil {
+		return imgspecv1.Descriptor{}, 0, errors.Wrapf(err, "reading manifest %s", manifestDigest)
+	}
+	matches, err := manifest.MatchesDigest(m, manifestDigest)
+	if err != nil {
+		return imgspecv1.Descriptor{}, 0, errors.Wrapf(err, "computing digest of manifest %s", manifestDigest)
+	}
+	if !matches {
+		return imgspecv1.Descriptor{}, 0, errors.Errorf("manifest %s does not match its digest", manifestDigest)
+	}
+	if mimeType == "" {
+		mimeType = manifest.GuessMIMEType(m)
+	}
+
+	var parsed referrerManifest
+	if err := json.Unmarshal(m, &parsed); err != nil {
+		return imgspecv1.Descriptor{}, 0, errors.Wrapf(err, "parsing manifest %s", manifestDigest)
+	}
+	bytesTransferred := int64(0)
+	for _, child := range parsed.Manifests {
+		childDest, childBytesTransferred, err := c.copyReferrerManifest(ctx, child.Digest, nil)
+		if err != nil {
+			return imgspecv1.Descriptor{}, 0, err
+		}
+		bytesTransferred += childBytesTransferred
+		if childDest.Digest != child.Digest { // Coverage: This should never happen, we only modify manifests with a subject.
+			return imgspecv1.Descriptor{}, 0, errors.Errorf("Internal error: manifest %s was unexpectedly modified to %s", child.Digest, childDest.Digest)
+		}
+	}
+	if parsed.Config != nil {
+		n, err := c.copyReferrerBlob(ctx, *parsed.Config, true)
+		if err != nil {
+			return imgspecv1.Descriptor{}, 0, err
+		}
+		bytesTransferred += n
+	}
+	for _, blob := range append(append([]imgspecv1.Descriptor{}, parsed.Layers...), parsed.Blobs...) {
+		n, err := c.copyReferrerBlob(ctx, blob, false)
+		if err != nil {
+			return imgspecv1.Descriptor{}, 0, err
+		}
+		bytesTransferred += n
+	}
+
+	destDigest := manifestDigest
+	if subject != nil && parsed.Subject != nil && parsed.Subject.Digest == subject.source && subject.dest.Digest != subject.source {
+		logrus.Debugf("Updating subject of %s from %s to %s", manifestDigest, subject.source, subject.dest.Digest)
+		m, err = rewriteReferrerSubject(m, *parsed.Subject, subject.dest)
+		if err != nil {
+			return imgspecv1.Descriptor{}, 0, errors.Wrapf(err, "updating subject of manifest %s", manifestDigest)
+		}
+		destDigest, err = manifest.Digest(m)
+		if err != nil {
+			return imgspecv1.Descriptor{}, 0, err
+		}
+	}
+	if err := c.dest.PutManifest(ctx, m, &destDigest); err != nil {
+		return imgspecv1.Descriptor{}, 0, errors.Wrapf(err, "writing manifest %s", destDigest)
+	}
+	return imgspecv1.Descriptor{
+		MediaType: mimeType,
+		Digest:    destDigest,
+		Size:      int64(len(m)),
+	}, bytesTransferred, nil
+}
+
+// rewriteReferrerSubject returns a version of m, a manifest with a "subject" field containing original,
+// updated to refer to dest instead. Other fields of m are preserved.
+func rewriteReferrerSubject(m []byte, original, dest imgspecv1.Descriptor) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(m, &fields); err != nil {
+		return nil, err
+	}
+	updated := original
+	updated.MediaType = dest.MediaType
+	updated.Digest = dest.Digest
+	updated.Size = dest.Size
+	subject, err := json.Marshal(updated)
+	if err != nil {
+		return nil, err
+	}
+	fields["subject"] = subject
+	return json.Marshal(fields)
+}
+
+// copyReferrerBlob copies a blob described by desc from the source to the destination, without any modifications.
+// It returns the number of bytes read from the source.
+func (c *copier) copyReferrerBlob(ctx context.Context, desc imgspecv1.Descriptor, isConfig bool) (int64, error) {
+	srcInfo := types.BlobInfo{Digest: desc.Digest, Size: desc.Size, MediaType: desc.MediaType}
+	reused, _, err := c.dest.TryReusingBlobWithOptions(ctx, srcInfo, private.TryReusingBlobOptions{
+		Cache:         c.blobInfoCache,
+		CanSubstitute: false,
+		SrcRef:        c.rawSource.Reference().DockerReference(),
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "trying to reuse blob %s at destination", desc.Digest)
+	}
+	if reused {
+		logrus.Debugf("Skipping blob %s (already present)", desc.Digest)
+		return 0, nil
+	}
+
+	srcStream, _, err := c.rawSource.GetBlob(ctx, srcInfo, c.blobInfoCache)
+	if err != nil {
+		return 0, errors.Wrapf(err, "reading blob %s", desc.Digest)
+	}
+	defer srcStream.Close()
+	countingStream := &byteCountingReader{reader: bandwidth.NewReader(ctx, srcStream, c.bandwidthLimiter)}
+	digestingReader, err := newDigestingReader(countingStream, desc.Digest)
+	if err != nil {
+		return 0, errors.Wrapf(err, "preparing to verify blob %s", desc.Digest)
+	}
+	uploadedInfo, err := c.dest.PutBlobWithOptions(ctx, &errorAnnotationReader{digestingReader}, srcInfo, private.PutBlobOptions{
+		Cache:    c.blobInfoCache,
+		IsConfig: isConfig,
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "writing blob")
+	}
+	if digestingReader.validationFailed { // Coverage: This should never happen.
+		return 0, errors.Errorf("Internal error writing blob %s, digest verification failed but was ignored", desc.Digest)
+	}
+	if uploadedInfo.Digest != desc.Digest {
+		return 0, errors.Errorf("Internal error writing blob %s, blob saved with digest %s", desc.Digest, uploadedInfo.Digest)
+	}
+	return countingStream.count, nil
+}
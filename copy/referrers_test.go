@@ -0,0 +1,263 @@
+package copy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/directory"
+	"github.com/containers/image/v5/internal/bandwidth"
+	internalblobinfocache "github.com/containers/image/v5/internal/blobinfocache"
+	"github.com/containers/image/v5/internal/imagedestination"
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// referrersTestSource is a private.ImageSource serving manifests, blobs and referrers from memory.
+// Only the methods used by copyReferrers are implemented.
+type referrersTestSource struct {
+	private.ImageSource
+	ref               types.ImageReference
+	supportsReferrers bool
+	manifests         map[digest.Digest][]byte
+	blobs             map[digest.Digest][]byte
+	referrers         map[digest.Digest][]private.Referrer
+}
+
+func (s *referrersTestSource) Reference() types.ImageReference {
+	return s.ref
+}
+
+func (s *referrersTestSource) SupportsReferrers() bool {
+	return s.supportsReferrers
+}
+
+func (s *referrersTestSource) GetReferrers(ctx context.Context, manifestDigest digest.Digest, artifactType string) ([]private.Referrer, error) {
+	return s.referrers[manifestDigest], nil
+}
+
+func (s *referrersTestSource) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	m, ok := s.manifests[*instanceDigest]
+	if !ok {
+		return nil, "", fmt.Errorf("manifest %s not found", instanceDigest)
+	}
+	return m, imgspecv1.MediaTypeImageManifest, nil
+}
+
+func (s *referrersTestSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	b, ok := s.blobs[info.Digest]
+	if !ok {
+		return nil, -1, fmt.Errorf("blob %s not found", info.Digest)
+	}
+	return io.NopCloser(bytes.NewReader(b)), int64(len(b)), nil
+}
+
+// addBlob adds contents to s.blobs, and returns its descriptor.
+func (s *referrersTestSource) addBlob(mediaType string, contents []byte) imgspecv1.Descriptor {
+	d := digest.FromBytes(contents)
+	s.blobs[d] = contents
+	return imgspecv1.Descriptor{MediaType: mediaType, Digest: d, Size: int64(len(contents))}
+}
+
+// addReferrer adds an artifact manifest referring to subject, and returns its digest.
+func (s *referrersTestSource) addReferrer(t *testing.T, subject imgspecv1.Descriptor, artifactType string, layerContents []byte) digest.Digest {
+	m, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     imgspecv1.MediaTypeImageManifest,
+		"config":        s.addBlob(artifactType, []byte("{}")),
+		"layers":        []imgspecv1.Descriptor{s.addBlob("application/octet-stream", layerContents)},
+		"subject":       subject,
+		"annotations":   map[string]string{"org.example.test": artifactType},
+	})
+	require.NoError(t, err)
+	d := digest.FromBytes(m)
+	s.manifests[d] = m
+	s.referrers[subject.Digest] = append(s.referrers[subject.Digest], private.Referrer{
+		MediaType:    imgspecv1.MediaTypeImageManifest,
+		Digest:       d,
+		Size:         int64(len(m)),
+		ArtifactType: artifactType,
+	})
+	return d
+}
+
+func TestCopyReferrers(t *testing.T) {
+	srcDir := t.TempDir()
+	srcRef, err := directory.NewReference(srcDir)
+	require.NoError(t, err)
+	destDir := t.TempDir()
+	destRef, err := directory.NewReference(destDir)
+	require.NoError(t, err)
+
+	newCopier := func(src private.ImageSource) (*copier, func()) {
+		dest, err := destRef.NewImageDestination(context.Background(), nil)
+		require.NoError(t, err)
+		return &copier{
+			dest:          imagedestination.FromPublic(dest),
+			rawSource:     src,
+			reportWriter:  io.Discard,
+			blobInfoCache: internalblobinfocache.FromBlobInfoCache(none.NoCache),
+		}, func() { dest.Close() }
+	}
+	readDestManifest := func(d digest.Digest) map[string]interface{} {
+		m, err := os.ReadFile(filepath.Join(destDir, d.Encoded()+".manifest.json"))
+		require.NoError(t, err)
+		assert.Equal(t, d, digest.FromBytes(m))
+		var res map[string]interface{}
+		err = json.Unmarshal(m, &res)
+		require.NoError(t, err)
+		return res
+	}
+
+	sourceImage := imgspecv1.Descriptor{MediaType: manifest.DockerV2Schema2MediaType, Digest: digest.FromString("source manifest"), Size: 15}
+	for _, c := range []struct {
+		name string
+		dest imgspecv1.Descriptor
+	}{
+		{"unmodified", sourceImage},
+		{"converted", imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageManifest, Digest: digest.FromString("converted manifest"), Size: 18}},
+	} {
+		src := &referrersTestSource{
+			ref:               srcRef,
+			supportsReferrers: true,
+			manifests:         map[digest.Digest][]byte{},
+			blobs:             map[digest.Digest][]byte{},
+			referrers:         map[digest.Digest][]private.Referrer{},
+		}
+		sbom := src.addReferrer(t, sourceImage, "application/vnd.example.sbom", []byte("sbom"))
+		sbomSignature := src.addReferrer(t, imgspecv1.Descriptor{
+			MediaType: imgspecv1.MediaTypeImageManifest,
+			Digest:    sbom,
+			Size:      int64(len(src.manifests[sbom])),
+		}, "application/vnd.example.signature", []byte("signature"))
+
+		c2, closeDest := newCopier(src)
+		c2.referrersSubjects = []referrersSubject{{source: sourceImage.Digest, dest: c.dest}}
+		err = c2.copyReferrers(context.Background())
+		closeDest()
+		require.NoError(t, err, c.name)
+
+		require.Len(t, c2.copiedReferrers, 2, c.name)
+		destSBOM := c2.copiedReferrers[0]
+		assert.Equal(t, c.dest.Digest, destSBOM.SubjectDigest, c.name)
+		assert.Equal(t, sbom, destSBOM.SourceDigest, c.name)
+		assert.Equal(t, "application/vnd.example.sbom", destSBOM.ArtifactType, c.name)
+		destSignature := c2.copiedReferrers[1]
+		assert.Equal(t, destSBOM.Digest, destSignature.SubjectDigest, c.name)
+		assert.Equal(t, sbomSignature, destSignature.SourceDigest, c.name)
+		assert.Equal(t, int64(len("{}")+len("sbom")), destSBOM.BytesTransferred, c.name)
+		assert.Equal(t, int64(len("signature")), destSignature.BytesTransferred, c.name) // The config has already been copied
+		if c.dest.Digest == sourceImage.Digest {
+			assert.Equal(t, sbom, destSBOM.Digest, c.name)
+			assert.Equal(t, sbomSignature, destSignature.Digest, c.name)
+		} else {
+			assert.NotEqual(t, sbom, destSBOM.Digest, c.name)
+			assert.NotEqual(t, sbomSignature, destSignature.Digest, c.name)
+		}
+
+		m := readDestManifest(destSBOM.Digest)
+		subject := m["subject"].(map[string]interface{})
+		assert.Equal(t, c.dest.Digest.String(), subject["digest"], c.name)
+		assert.Equal(t, c.dest.MediaType, subject["mediaType"], c.name)
+		assert.Equal(t, float64(c.dest.Size), subject["size"], c.name)
+		assert.Equal(t, map[string]interface{}{"org.example.test": "application/vnd.example.sbom"}, m["annotations"], c.name)
+		m = readDestManifest(destSignature.Digest)
+		subject = m["subject"].(map[string]interface{})
+		assert.Equal(t, destSBOM.Digest.String(), subject["digest"], c.name)
+
+		for d, contents := range src.blobs {
+			blob, err := os.ReadFile(filepath.Join(destDir, d.Encoded()))
+			require.NoError(t, err, c.name)
+			assert.Equal(t, contents, blob, c.name)
+		}
+	}
+
+	// The bandwidth limit applies to referrer blobs
+	src := &referrersTestSource{
+		ref:               srcRef,
+		supportsReferrers: true,
+		manifests:         map[digest.Digest][]byte{},
+		blobs:             map[digest.Digest][]byte{},
+		referrers:         map[digest.Digest][]private.Referrer{},
+	}
+	src.addReferrer(t, sourceImage, "application/vnd.example.sbom", []byte("sbom"))
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c, closeDest := newCopier(src)
+	c.referrersSubjects = []referrersSubject{{source: sourceImage.Digest, dest: sourceImage}}
+	c.bandwidthLimiter = bandwidth.NewLimiter(1)
+	err = c.copyReferrers(canceledCtx) // Waiting for the limiter fails immediately
+	closeDest()
+	assert.ErrorIs(t, err, context.Canceled)
+	c, closeDest = newCopier(src)
+	c.referrersSubjects = []referrersSubject{{source: sourceImage.Digest, dest: sourceImage}}
+	err = c.copyReferrers(canceledCtx) // Nothing else in this test depends on ctx
+	closeDest()
+	require.NoError(t, err)
+
+	// No referrers
+	src = &referrersTestSource{ref: srcRef, supportsReferrers: true}
+	c, closeDest = newCopier(src)
+	c.referrersSubjects = []referrersSubject{{source: sourceImage.Digest, dest: sourceImage}}
+	err = c.copyReferrers(context.Background())
+	closeDest()
+	require.NoError(t, err)
+	assert.Empty(t, c.copiedReferrers)
+
+	// Source does not support referrers
+	src = &referrersTestSource{ref: srcRef, supportsReferrers: false}
+	c, closeDest = newCopier(src)
+	c.referrersSubjects = []referrersSubject{{source: sourceImage.Digest, dest: sourceImage}}
+	err = c.copyReferrers(context.Background())
+	closeDest()
+	assert.Error(t, err)
+}
+
+func TestRewriteReferrerSubject(t *testing.T) {
+	original := imgspecv1.Descriptor{
+		MediaType:   imgspecv1.MediaTypeImageManifest,
+		Digest:      digest.FromString("original"),
+		Size:        8,
+		Annotations: map[string]string{"a": "b"},
+	}
+	m, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"artifactType":  "application/vnd.example",
+		"subject":       original,
+		"unknownField":  []int{1, 2, 3},
+	})
+	require.NoError(t, err)
+
+	dest := imgspecv1.Descriptor{MediaType: manifest.DockerV2Schema2MediaType, Digest: digest.FromString("updated"), Size: 7}
+	res, err := rewriteReferrerSubject(m, original, dest)
+	require.NoError(t, err)
+	var parsed map[string]json.RawMessage
+	err = json.Unmarshal(res, &parsed)
+	require.NoError(t, err)
+	assert.JSONEq(t, `2`, string(parsed["schemaVersion"]))
+	assert.JSONEq(t, `"application/vnd.example"`, string(parsed["artifactType"]))
+	assert.JSONEq(t, `[1,2,3]`, string(parsed["unknownField"]))
+	var subject imgspecv1.Descriptor
+	err = json.Unmarshal(parsed["subject"], &subject)
+	require.NoError(t, err)
+	assert.Equal(t, imgspecv1.Descriptor{
+		MediaType:   manifest.DockerV2Schema2MediaType,
+		Digest:      dest.Digest,
+		Size:        7,
+		Annotations: map[string]string{"a": "b"},
+	}, subject)
+
+	_, err = rewriteReferrerSubject([]byte("not JSON"), original, dest)
+	assert.Error(t, err)
+}
@@ -0,0 +1,131 @@
+package copy
+
+import (
+	"io"
+
+	compressiontypes "github.com/containers/image/v5/pkg/compression/types"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// Result describes the outcome of ImageWithResult.
+type Result struct {
+	Manifest         []byte        // The manifest, or manifest list, written to the destination
+	ManifestDigest   digest.Digest // The digest of Manifest
+	ManifestMIMEType string        // The MIME type of Manifest
+
+	// Instances describes the copied single-platform images. If the source is not a manifest list, or only a single image
+	// has been selected from it (CopySystemImage), this contains exactly one entry, describing Manifest.
+	// Otherwise it contains entries for the copied instances of the list, in the order they appear in the source list.
+	Instances []InstanceResult
+
+	SignaturesCopied int // The number of signatures of Manifest copied from the source
+	SignaturesAdded  int // The number of signatures of Manifest created during the copy
+
+	// BytesTransferred is the number of bytes of layers and configs read from the source, for all instances and referrers.
+	BytesTransferred int64
+
+	// Referrers describes the copied referrers, if Options.CopyReferrers; in the order they were copied.
+	Referrers []ReferrerResult
+
+	// DryRun is true if the result describes a dry run (Options.DryRun). In that case nothing was read from the source
+	// except for manifests and configs, nothing was written to the destination, and BytesTransferred is 0.
+	// Manifest and ManifestDigest only approximate what would be written: layers which would be (de/re/)compressed
+	// are still referred to by their source digests and sizes (but with updated MIME types).
+	// The blobs which would be copied are listed in InstanceResult.PlannedBlobs; see also BlobsToTransfer.
+	DryRun bool
+}
+
+// InstanceResult describes a single-platform image copied by ImageWithResult.
+type InstanceResult struct {
+	SourceDigest     digest.Digest // The digest of the manifest in the source
+	ManifestDigest   digest.Digest // The digest of the manifest written to the destination; differs from SourceDigest if the manifest was modified
+	ManifestMIMEType string        // The MIME type of the manifest written to the destination
+
+	// AlreadyPresent is true if copying the image was skipped because the destination already contained an equivalent image
+	// (see Options.OptimizeDestinationImageAlreadyExists). In that case, Layers is empty.
+	AlreadyPresent bool
+	Layers         []LayerResult // In the order of the layers in the manifest
+
+	SignaturesCopied int   // The number of signatures copied from the source
+	SignaturesAdded  int   // The number of signatures created during the copy; always 0 in dry runs
+	BytesTransferred int64 // The number of bytes of layers and the config read from the source
+
+	// PlannedBlobs, only set in dry runs, lists the config and the layers (in this order) which would be copied;
+	// foreign layers which would not be copied are not included.
+	PlannedBlobs []PlannedBlob
+}
+
+// LayerResult describes a layer copied by ImageWithResult.
+type LayerResult struct {
+	SourceDigest digest.Digest // The digest of the layer in the source
+	Digest       digest.Digest // The digest of the layer in the destination; differs from SourceDigest if the layer was (de/re/)compressed or substituted
+	Size         int64         // The size of the layer in the destination, or -1 if unknown
+
+	// CompressionOperation and CompressionAlgorithm describe how the compression of the layer was changed, if at all;
+	// CompressionAlgorithm is only set if CompressionOperation is types.Compress.
+	CompressionOperation types.LayerCompression
+	CompressionAlgorithm *compressiontypes.Algorithm
+
+	// Reused is true if the layer was not uploaded, because the destination already contained it,
+	// or because it could be reused from a different location (typically found via the blob info cache).
+	Reused bool
+	// ReusedFrom is a human-readable description of the location the layer was reused from, if Reused and the layer did not already
+	// exist in the destination; it may be empty if the destination does not report it.
+	ReusedFrom string
+	// BytesTransferred is the number of bytes read from the source.
+	BytesTransferred int64
+}
+
+// PlannedBlob describes a blob which a dry run (Options.DryRun) would copy.
+type PlannedBlob struct {
+	Digest    digest.Digest // The digest of the blob in the source
+	Size      int64         // The size of the blob in the source, or -1 if unknown
+	MediaType string
+	// AlreadyPresent is true if the destination already contains the blob, so it would not be transferred.
+	AlreadyPresent bool
+}
+
+// ReferrerResult describes a referrer copied by ImageWithResult.
+type ReferrerResult struct {
+	SubjectDigest digest.Digest // The digest of the destination manifest the referrer refers to
+	SourceDigest  digest.Digest // The digest of the referrer in the source
+	Digest        digest.Digest // The digest of the referrer in the destination; differs from SourceDigest if its subject was updated
+	ArtifactType  string
+
+	// BytesTransferred is the number of bytes of blobs of the referrer (and of any manifests it includes) read from the source.
+	BytesTransferred int64
+}
+
+// BlobsToTransfer returns the blobs a dry run would transfer, i.e. the PlannedBlobs of all instances which are not
+// already present at the destination, each listed only once, and their total size (not counting blobs of unknown size).
+func (r *Result) BlobsToTransfer() ([]PlannedBlob, int64) {
+	res := []PlannedBlob{}
+	size := int64(0)
+	seen := map[digest.Digest]struct{}{}
+	for _, instance := range r.Instances {
+		for _, blob := range instance.PlannedBlobs {
+			if _, ok := seen[blob.Digest]; ok || blob.AlreadyPresent {
+				continue
+			}
+			seen[blob.Digest] = struct{}{}
+			res = append(res, blob)
+			if blob.Size != -1 {
+				size += blob.Size
+			}
+		}
+	}
+	return res, size
+}
+
+// byteCountingReader is an io.Reader which counts the bytes read from the underlying reader.
+type byteCountingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *byteCountingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
@@ -0,0 +1,135 @@
+package copy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/directory"
+	ocilayout "github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/pkg/compression"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecs "github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// resultTestSourceImage creates a single-layer OCI image, using layer, in a new "dir:" directory, and returns a reference to it
+// along with the image manifest and the config.
+func resultTestSourceImage(t *testing.T, layer []byte, layerMediaType string) (types.ImageReference, []byte, []byte) {
+	ref, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+
+	config := []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":["sha256:185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969"]}}`)
+	for _, blob := range [][]byte{config, layer} {
+		_, err := dest.PutBlob(context.Background(), bytes.NewReader(blob), types.BlobInfo{Digest: digest.FromBytes(blob), Size: int64(len(blob))}, none.NoCache, false)
+		require.NoError(t, err)
+	}
+	m, err := json.Marshal(imgspecv1.Manifest{
+		Versioned: imgspecs.Versioned{SchemaVersion: 2},
+		MediaType: imgspecv1.MediaTypeImageManifest,
+		Config:    imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageConfig, Digest: digest.FromBytes(config), Size: int64(len(config))},
+		Layers:    []imgspecv1.Descriptor{{MediaType: layerMediaType, Digest: digest.FromBytes(layer), Size: int64(len(layer))}},
+	})
+	require.NoError(t, err)
+	err = dest.PutManifest(context.Background(), m, nil)
+	require.NoError(t, err)
+	err = dest.Commit(context.Background(), nil)
+	require.NoError(t, err)
+	return ref, m, config
+}
+
+func TestImageWithResult(t *testing.T) {
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{
+		Default: signature.PolicyRequirements{signature.NewPRInsecureAcceptAnything()},
+	})
+	require.NoError(t, err)
+	defer func() { _ = policyContext.Destroy() }()
+
+	gzipLayer, err := os.ReadFile("fixtures/Hello.gz")
+	require.NoError(t, err)
+	srcRef, srcManifest, config := resultTestSourceImage(t, gzipLayer, imgspecv1.MediaTypeImageLayerGzip)
+	srcDigest := digest.FromBytes(srcManifest)
+	destDir := t.TempDir()
+	destRef, err := directory.NewReference(destDir)
+	require.NoError(t, err)
+
+	// A copy without any modifications
+	res, err := ImageWithResult(context.Background(), policyContext, destRef, srcRef, &Options{})
+	require.NoError(t, err)
+	destManifest, err := os.ReadFile(filepath.Join(destDir, "manifest.json"))
+	require.NoError(t, err)
+	assert.Equal(t, destManifest, res.Manifest)
+	assert.Equal(t, srcDigest, res.ManifestDigest)
+	assert.Equal(t, imgspecv1.MediaTypeImageManifest, res.ManifestMIMEType)
+	assert.Equal(t, 0, res.SignaturesCopied)
+	assert.Equal(t, 0, res.SignaturesAdded)
+	assert.Empty(t, res.Referrers)
+	assert.Equal(t, int64(len(gzipLayer)+len(config)), res.BytesTransferred)
+	assert.Equal(t, []InstanceResult{{
+		SourceDigest:     srcDigest,
+		ManifestDigest:   srcDigest,
+		ManifestMIMEType: imgspecv1.MediaTypeImageManifest,
+		Layers: []LayerResult{{
+			SourceDigest:     digest.FromBytes(gzipLayer),
+			Digest:           digest.FromBytes(gzipLayer),
+			Size:             int64(len(gzipLayer)),
+			BytesTransferred: int64(len(gzipLayer)),
+		}},
+		BytesTransferred: int64(len(gzipLayer) + len(config)),
+	}}, res.Instances)
+
+	// Image also returns the manifest
+	copiedManifest, err := Image(context.Background(), policyContext, destRef, srcRef, &Options{})
+	require.NoError(t, err)
+	assert.Equal(t, destManifest, copiedManifest)
+
+	// Copying again reuses the layer. (dir: destinations are always emptied first, so use an OCI layout.)
+	ociRef, err := ocilayout.NewReference(t.TempDir(), "tag")
+	require.NoError(t, err)
+	_, err = ImageWithResult(context.Background(), policyContext, ociRef, srcRef, &Options{})
+	require.NoError(t, err)
+	res, err = ImageWithResult(context.Background(), policyContext, ociRef, srcRef, &Options{})
+	require.NoError(t, err)
+	require.Len(t, res.Instances, 1)
+	require.Len(t, res.Instances[0].Layers, 1)
+	assert.True(t, res.Instances[0].Layers[0].Reused)
+	assert.Equal(t, "", res.Instances[0].Layers[0].ReusedFrom)
+	assert.Equal(t, int64(0), res.Instances[0].Layers[0].BytesTransferred)
+	assert.Equal(t, int64(len(config)), res.BytesTransferred)
+
+	// Compression changes are reported
+	uncompressedLayer, err := os.ReadFile("fixtures/Hello.uncompressed")
+	require.NoError(t, err)
+	srcRef, srcManifest, _ = resultTestSourceImage(t, uncompressedLayer, imgspecv1.MediaTypeImageLayer)
+	destRef, err = directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	res, err = ImageWithResult(context.Background(), policyContext, destRef, srcRef, &Options{
+		DestinationCtx: &types.SystemContext{DirForceCompress: true},
+	})
+	require.NoError(t, err)
+	require.Len(t, res.Instances, 1)
+	instance := res.Instances[0]
+	assert.Equal(t, digest.FromBytes(srcManifest), instance.SourceDigest)
+	assert.NotEqual(t, instance.SourceDigest, instance.ManifestDigest)
+	assert.Equal(t, res.ManifestDigest, instance.ManifestDigest)
+	require.Len(t, instance.Layers, 1)
+	layer := instance.Layers[0]
+	assert.Equal(t, digest.FromBytes(uncompressedLayer), layer.SourceDigest)
+	assert.NotEqual(t, layer.SourceDigest, layer.Digest)
+	assert.Equal(t, types.Compress, layer.CompressionOperation)
+	require.NotNil(t, layer.CompressionAlgorithm)
+	assert.Equal(t, compression.Gzip.Name(), layer.CompressionAlgorithm.Name())
+	assert.False(t, layer.Reused)
+	assert.Equal(t, int64(len(uncompressedLayer)), layer.BytesTransferred)
+}
@@ -1,12 +1,58 @@
 package copy
 
 import (
+	"os"
+
 	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/signature"
 	"github.com/containers/image/v5/transports"
 	"github.com/pkg/errors"
 )
 
+// signing returns true if options ask for new signatures to be created.
+func (options *Options) signing() bool {
+	return options.SignBy != "" || options.SignBySigstorePrivateKeyFile != ""
+}
+
+// createSignatures creates the signatures of manifest requested by options.
+// In dry runs, no signatures are created.
+func (c *copier) createSignatures(manifest []byte, options *Options) ([][]byte, error) {
+	res := [][]byte{}
+	if c.dryRun {
+		return res, nil
+	}
+	if options.SignBy != "" {
+		newSig, err := c.createSignature(manifest, options.SignBy, options.SignPassphrase, options.SignIdentity)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, newSig)
+	}
+	if options.SignBySigstorePrivateKeyFile != "" {
+		newSig, err := c.createSigstoreSignature(manifest, options.SignBySigstorePrivateKeyFile, options.SignSigstorePrivateKeyPassphrase, options.SignIdentity)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, newSig)
+	}
+	return res, nil
+}
+
+// signatureIdentity returns the identity to use in new signatures: identity if not nil, or the identity of the destination.
+func (c *copier) signatureIdentity(identity reference.Named) (reference.Named, error) {
+	if identity != nil {
+		if reference.IsNameOnly(identity) {
+			return nil, errors.Errorf("Sign identity must be a fully specified reference %s", identity)
+		}
+		return identity, nil
+	}
+	identity = c.dest.Reference().DockerReference()
+	if identity == nil {
+		return nil, errors.Errorf("Cannot determine canonical Docker reference for destination %s", transports.ImageName(c.dest.Reference()))
+	}
+	return identity, nil
+}
+
 // createSignature creates a new signature of manifest using keyIdentity.
 func (c *copier) createSignature(manifest []byte, keyIdentity string, passphrase string, identity reference.Named) ([]byte, error) {
 	mech, err := signature.NewGPGSigningMechanism()
@@ -18,15 +64,9 @@ func (c *copier) createSignature(manifest []byte, keyIdentity string, passphrase
 		return nil, errors.Wrap(err, "Signing not supported")
 	}
 
-	if identity != nil {
-		if reference.IsNameOnly(identity) {
-			return nil, errors.Errorf("Sign identity must be a fully specified reference %s", identity)
-		}
-	} else {
-		identity = c.dest.Reference().DockerReference()
-		if identity == nil {
-			return nil, errors.Errorf("Cannot determine canonical Docker reference for destination %s", transports.ImageName(c.dest.Reference()))
-		}
+	identity, err = c.signatureIdentity(identity)
+	if err != nil {
+		return nil, err
 	}
 
 	c.Printf("Signing manifest\n")
@@ -36,3 +76,22 @@ func (c *copier) createSignature(manifest []byte, keyIdentity string, passphrase
 	}
 	return newSig, nil
 }
+
+// createSigstoreSignature creates a new sigstore signature of manifest using the private key in privateKeyFile.
+func (c *copier) createSigstoreSignature(manifest []byte, privateKeyFile string, passphrase []byte, identity reference.Named) ([]byte, error) {
+	identity, err := c.signatureIdentity(identity)
+	if err != nil {
+		return nil, err
+	}
+	privateKeyPEM, err := os.ReadFile(privateKeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading private key")
+	}
+
+	c.Printf("Signing manifest using a sigstore signature\n")
+	newSig, err := signature.SignDockerManifestWithSigstorePrivateKey(manifest, identity.String(), privateKeyPEM, passphrase)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating sigstore signature")
+	}
+	return newSig, nil
+}
@@ -2,17 +2,28 @@ package copy
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
 	"io"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/containers/image/v5/directory"
 	"github.com/containers/image/v5/docker"
 	"github.com/containers/image/v5/internal/imagedestination"
+	internalSig "github.com/containers/image/v5/internal/signature"
 	"github.com/containers/image/v5/manifest"
 	"github.com/containers/image/v5/signature"
 	"github.com/containers/image/v5/types"
 	"github.com/docker/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -98,3 +109,113 @@ func TestCreateSignature(t *testing.T) {
 	assert.Equal(t, "myregistry.io/myrepo:mytag", verified.DockerReference)
 	assert.Equal(t, manifestDigest, verified.DockerManifestDigest)
 }
+
+// verifySigstoreSignatureBlob verifies that sigBlob is a sigstore signature by publicKey, and returns its payload.
+func verifySigstoreSignatureBlob(t *testing.T, publicKey *ecdsa.PublicKey, sigBlob []byte) sigstorePayloadForTesting {
+	sig, err := internalSig.FromBlob(sigBlob)
+	require.NoError(t, err)
+	sigstoreSig, ok := sig.(internalSig.Sigstore)
+	require.True(t, ok)
+	assert.Equal(t, internalSig.SigstoreSignatureMIMEType, sigstoreSig.UntrustedMIMEType())
+
+	payload := sigstoreSig.UntrustedPayload()
+	rawSig, err := base64.StdEncoding.DecodeString(sigstoreSig.UntrustedAnnotations()[internalSig.SigstoreSignatureAnnotationKey])
+	require.NoError(t, err)
+	payloadDigest := sha256.Sum256(payload)
+	assert.True(t, ecdsa.VerifyASN1(publicKey, payloadDigest[:], rawSig))
+
+	var res sigstorePayloadForTesting
+	err = json.Unmarshal(payload, &res)
+	require.NoError(t, err)
+	assert.Equal(t, "cosign container image signature", res.Critical.Type)
+	return res
+}
+
+// sigstorePayloadForTesting is a subset of the sigstore signature payload format.
+type sigstorePayloadForTesting struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest digest.Digest `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+func TestCreateSigstoreSignature(t *testing.T) {
+	manifestBlob := []byte("Something")
+	manifestDigest, err := manifest.Digest(manifestBlob)
+	require.NoError(t, err)
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+	privateKeyFile := filepath.Join(t.TempDir(), "cosign.key")
+	err = os.WriteFile(privateKeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	require.NoError(t, err)
+
+	// Signing a directory: reference, which does not have a DockerReference(), fails.
+	dirRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	dirDest, err := dirRef.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dirDest.Close()
+	c := &copier{
+		dest:         imagedestination.FromPublic(dirDest),
+		reportWriter: io.Discard,
+	}
+	_, err = c.createSigstoreSignature(manifestBlob, privateKeyFile, nil, nil)
+	assert.Error(t, err)
+
+	// Set up a docker: reference
+	dockerRef, err := docker.ParseReference("//busybox")
+	require.NoError(t, err)
+	dockerDest, err := dockerRef.NewImageDestination(context.Background(),
+		&types.SystemContext{RegistriesDirPath: "/this/does/not/exist", DockerPerHostCertDirPath: "/this/does/not/exist"})
+	require.NoError(t, err)
+	defer dockerDest.Close()
+	c = &copier{
+		dest:         imagedestination.FromPublic(dockerDest),
+		reportWriter: io.Discard,
+	}
+
+	// Signing with a nonexistent or invalid key file fails
+	_, err = c.createSigstoreSignature(manifestBlob, "/this/does/not/exist", nil, nil)
+	assert.Error(t, err)
+	_, err = c.createSigstoreSignature(manifestBlob, "fixtures/Hello.uncompressed", nil, nil)
+	assert.Error(t, err)
+
+	// Can't sign without a full reference
+	ref, err := reference.ParseNamed("myregistry.io/myrepo")
+	require.NoError(t, err)
+	_, err = c.createSigstoreSignature(manifestBlob, privateKeyFile, nil, ref)
+	assert.Error(t, err)
+
+	// Signing without overriding the identity uses the docker reference
+	sig, err := c.createSigstoreSignature(manifestBlob, privateKeyFile, nil, nil)
+	require.NoError(t, err)
+	payload := verifySigstoreSignatureBlob(t, &privateKey.PublicKey, sig)
+	assert.Equal(t, "docker.io/library/busybox:latest", payload.Critical.Identity.DockerReference)
+	assert.Equal(t, manifestDigest, payload.Critical.Image.DockerManifestDigest)
+
+	// Can override the identity with own
+	ref, err = reference.ParseNamed("myregistry.io/myrepo:mytag")
+	require.NoError(t, err)
+	sig, err = c.createSigstoreSignature(manifestBlob, privateKeyFile, nil, ref)
+	require.NoError(t, err)
+	payload = verifySigstoreSignatureBlob(t, &privateKey.PublicKey, sig)
+	assert.Equal(t, "myregistry.io/myrepo:mytag", payload.Critical.Identity.DockerReference)
+	assert.Equal(t, manifestDigest, payload.Critical.Image.DockerManifestDigest)
+
+	// createSignatures creates only the requested signatures
+	sigs, err := c.createSignatures(manifestBlob, &Options{})
+	require.NoError(t, err)
+	assert.Len(t, sigs, 0)
+	sigs, err = c.createSignatures(manifestBlob, &Options{SignBySigstorePrivateKeyFile: privateKeyFile})
+	require.NoError(t, err)
+	require.Len(t, sigs, 1)
+	verifySigstoreSignatureBlob(t, &privateKey.PublicKey, sigs[0])
+}
@@ -23,55 +23,23 @@ var ErrNotContainerImageDir = errors.New("not a containers image directory, don'
 type dirImageDestination struct {
 	ref                     dirReference
 	desiredLayerCompression types.LayerCompression
+	probeOnly               bool // Created by newImageDestinationForProbe; the directory has not been emptied.
 }
 
 // newImageDestination returns an ImageDestination for writing to a directory.
 func newImageDestination(sys *types.SystemContext, ref dirReference) (types.ImageDestination, error) {
-	desiredLayerCompression := types.PreserveOriginal
-	if sys != nil {
-		if sys.DirForceCompress {
-			desiredLayerCompression = types.Compress
-
-			if sys.DirForceDecompress {
-				return nil, errors.Errorf("Cannot compress and decompress at the same time")
-			}
-		}
-		if sys.DirForceDecompress {
-			desiredLayerCompression = types.Decompress
-		}
+	desiredLayerCompression, err := desiredLayerCompressionFromSystemContext(sys)
+	if err != nil {
+		return nil, err
 	}
 	d := &dirImageDestination{ref: ref, desiredLayerCompression: desiredLayerCompression}
 
-	// If directory exists check if it is empty
-	// if not empty, check whether the contents match that of a container image directory and overwrite the contents
-	// if the contents don't match throw an error
-	dirExists, err := pathExists(d.ref.resolvedPath)
+	dirExists, isEmpty, err := checkExistingDir(d.ref)
 	if err != nil {
-		return nil, errors.Wrapf(err, "checking for path %q", d.ref.resolvedPath)
+		return nil, err
 	}
 	if dirExists {
-		isEmpty, err := isDirEmpty(d.ref.resolvedPath)
-		if err != nil {
-			return nil, err
-		}
-
 		if !isEmpty {
-			versionExists, err := pathExists(d.ref.versionPath())
-			if err != nil {
-				return nil, errors.Wrapf(err, "checking if path exists %q", d.ref.versionPath())
-			}
-			if versionExists {
-				contents, err := os.ReadFile(d.ref.versionPath())
-				if err != nil {
-					return nil, err
-				}
-				// check if contents of version file is what we expect it to be
-				if string(contents) != version {
-					return nil, ErrNotContainerImageDir
-				}
-			} else {
-				return nil, ErrNotContainerImageDir
-			}
 			// delete directory contents so that only one image is in the directory at a time
 			if err = removeDirContents(d.ref.resolvedPath); err != nil {
 				return nil, errors.Wrapf(err, "erasing contents in %q", d.ref.resolvedPath)
@@ -92,6 +60,73 @@ func newImageDestination(sys *types.SystemContext, ref dirReference) (types.Imag
 	return d, nil
 }
 
+// newImageDestinationForProbe returns a dirImageDestination which can only be used to read destination properties
+// and to check for existing blobs. Unlike newImageDestination, it does not modify the directory, but it fails the same way
+// if the directory exists and does not contain an image.
+func newImageDestinationForProbe(sys *types.SystemContext, ref dirReference) (types.ImageDestination, error) {
+	desiredLayerCompression, err := desiredLayerCompressionFromSystemContext(sys)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := checkExistingDir(ref); err != nil {
+		return nil, err
+	}
+	return &dirImageDestination{ref: ref, desiredLayerCompression: desiredLayerCompression, probeOnly: true}, nil
+}
+
+// desiredLayerCompressionFromSystemContext returns the layer compression requested by sys.
+func desiredLayerCompressionFromSystemContext(sys *types.SystemContext) (types.LayerCompression, error) {
+	desiredLayerCompression := types.PreserveOriginal
+	if sys != nil {
+		if sys.DirForceCompress {
+			desiredLayerCompression = types.Compress
+
+			if sys.DirForceDecompress {
+				return types.PreserveOriginal, errors.Errorf("Cannot compress and decompress at the same time")
+			}
+		}
+		if sys.DirForceDecompress {
+			desiredLayerCompression = types.Decompress
+		}
+	}
+	return desiredLayerCompression, nil
+}
+
+// checkExistingDir returns whether the directory of ref exists, and if so, whether it is empty.
+// If the directory is not empty, it checks whether the contents match that of a container image directory
+// (which can be overwritten), and fails with ErrNotContainerImageDir if they don't.
+func checkExistingDir(ref dirReference) (dirExists bool, isEmpty bool, err error) {
+	dirExists, err = pathExists(ref.resolvedPath)
+	if err != nil {
+		return false, false, errors.Wrapf(err, "checking for path %q", ref.resolvedPath)
+	}
+	if !dirExists {
+		return false, false, nil
+	}
+	isEmpty, err = isDirEmpty(ref.resolvedPath)
+	if err != nil {
+		return false, false, err
+	}
+	if !isEmpty {
+		versionExists, err := pathExists(ref.versionPath())
+		if err != nil {
+			return false, false, errors.Wrapf(err, "checking if path exists %q", ref.versionPath())
+		}
+		if !versionExists {
+			return false, false, ErrNotContainerImageDir
+		}
+		contents, err := os.ReadFile(ref.versionPath())
+		if err != nil {
+			return false, false, err
+		}
+		// check if contents of version file is what we expect it to be
+		if string(contents) != version {
+			return false, false, ErrNotContainerImageDir
+		}
+	}
+	return true, isEmpty, nil
+}
+
 // Reference returns the reference used to set up this destination.  Note that this should directly correspond to user's intent,
 // e.g. it should use the public hostname instead of the result of resolving CNAMEs or following redirects.
 func (d *dirImageDestination) Reference() types.ImageReference {
@@ -211,6 +246,10 @@ func (d *dirImageDestination) TryReusingBlob(ctx context.Context, info types.Blo
 	if info.Digest == "" {
 		return false, types.BlobInfo{}, errors.Errorf(`"Can not check for a blob with unknown digest`)
 	}
+	if d.probeOnly {
+		// A real copy empties the directory first, so nothing present now would be reused.
+		return false, types.BlobInfo{}, nil
+	}
 	blobPath := d.ref.layerPath(info.Digest)
 	finfo, err := os.Stat(blobPath)
 	if err != nil && os.IsNotExist(err) {
@@ -0,0 +1,34 @@
+package docker
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/containers/image/v5/internal/bandwidth"
+	"golang.org/x/time/rate"
+)
+
+// registryBandwidthLimiters contains the limiters returned by registryBandwidthLimiter, shared by all dockerClient instances in this process.
+var registryBandwidthLimiters = struct {
+	mutex    sync.Mutex
+	limiters map[string]*rate.Limiter // Keyed by registry and limit
+}{limiters: map[string]*rate.Limiter{}}
+
+// registryBandwidthLimiter returns a limiter for blob transfers to/from registry, limited to bytesPerSecond,
+// or nil if bytesPerSecond is 0.
+// The limiter is shared by all users of the same registry and limit in this process.
+func registryBandwidthLimiter(registry string, bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	key := fmt.Sprintf("%s\x00%d", registry, bytesPerSecond)
+
+	registryBandwidthLimiters.mutex.Lock()
+	defer registryBandwidthLimiters.mutex.Unlock()
+	limiter, ok := registryBandwidthLimiters.limiters[key]
+	if !ok {
+		limiter = bandwidth.NewLimiter(bytesPerSecond)
+		registryBandwidthLimiters.limiters[key] = limiter
+	}
+	return limiter
+}
@@ -0,0 +1,60 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/pkg/blobinfocache/memory"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryBandwidthLimiter(t *testing.T) {
+	assert.Nil(t, registryBandwidthLimiter("registry.example", 0))
+
+	limiter := registryBandwidthLimiter("registry.example", 1000)
+	require.NotNil(t, limiter)
+	assert.Equal(t, float64(1000), float64(limiter.Limit()))
+	assert.Same(t, limiter, registryBandwidthLimiter("registry.example", 1000))
+	assert.NotSame(t, limiter, registryBandwidthLimiter("registry.example", 2000))
+	assert.NotSame(t, limiter, registryBandwidthLimiter("other.example", 1000))
+}
+
+func TestGetBlobBandwidthLimit(t *testing.T) {
+	registry := newFakeRegistryWithRepos(t, "repo")
+	blob := bytes.Repeat([]byte{'x'}, 15000)
+	blobDigest := digest.FromBytes(blob)
+	registry.blobs["repo@"+blobDigest.String()] = blob
+
+	for _, c := range []struct {
+		name        string
+		conf        string
+		minDuration time.Duration
+	}{
+		{"unlimited", "", 0},
+		{"limited", "bandwidth-limit = 10000\n", 400 * time.Millisecond},
+	} {
+		sys := sigstoreAttachmentsTestSystemContext(t, registry.host(), false)
+		err := os.WriteFile(sys.SystemRegistriesConfPath, []byte(fmt.Sprintf("[[registry]]\nlocation = %q\n%s", registry.host(), c.conf)), 0644)
+		require.NoError(t, err, c.name)
+		src, err := newImageSource(context.Background(), sys, dockerRefFromString(t, "//"+registry.host()+"/repo:latest"))
+		require.NoError(t, err, c.name)
+		defer src.Close()
+
+		start := time.Now()
+		reader, _, err := src.GetBlob(context.Background(), types.BlobInfo{Digest: blobDigest, Size: -1}, memory.New())
+		require.NoError(t, err, c.name)
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err, c.name)
+		reader.Close()
+		assert.Equal(t, blob, data, c.name)
+		assert.GreaterOrEqual(t, time.Since(start), c.minDuration, c.name)
+	}
+}
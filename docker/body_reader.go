@@ -0,0 +1,154 @@
+package docker
+
+import (
+	"context"
+	"io"
+
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// maxBodyReaderResumeAttempts is the number of times bodyReader tries to resume reading a blob without making any progress.
+const maxBodyReaderResumeAttempts = 5
+
+// bodyReader is an io.ReadCloser returned by dockerImageSource.GetBlob,
+// which can transparently resume reading the blob using a HTTP range request (via dockerImageSource.GetBlobAt)
+// if the original connection fails.
+type bodyReader struct {
+	ctx  context.Context
+	src  *dockerImageSource
+	info types.BlobInfo
+	size int64 // The total size of the blob
+
+	body          io.ReadCloser // nil after a failed attempt to resume
+	bodyErrs      chan error    // Errors reported by GetBlobAt for body, if any
+	offset        int64         // The number of bytes already returned to the caller
+	failedResumes int           // The number of attempts to resume the download since the last successful read
+}
+
+// newBodyReader returns a bodyReader for a blob described by info, with total size, initially reading from body.
+// If size is -1, the download can’t be resumed, and body is returned directly.
+func newBodyReader(ctx context.Context, src *dockerImageSource, info types.BlobInfo, size int64, body io.ReadCloser) io.ReadCloser {
+	if size == -1 {
+		return body
+	}
+	return &bodyReader{
+		ctx:  ctx,
+		src:  src,
+		info: info,
+		size: size,
+		body: body,
+	}
+}
+
+// Read implements io.Reader.
+func (br *bodyReader) Read(p []byte) (int, error) {
+	for {
+		if br.body == nil {
+			return 0, errors.Errorf("Internal error: reading blob %s after a failure", br.info.Digest)
+		}
+		n, err := br.body.Read(p)
+		br.offset += int64(n)
+		if n > 0 {
+			br.failedResumes = 0
+		}
+		if err == nil || (err == io.EOF && br.offset >= br.size) {
+			return n, err
+		}
+		if br.offset > br.size {
+			return n, errors.Errorf("reading blob %s: more data than the expected %d bytes", br.info.Digest, br.size)
+		}
+		if br.ctx.Err() != nil {
+			return n, err
+		}
+		if resumeErr := br.resume(err); resumeErr != nil {
+			return n, resumeErr
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// resume replaces br.body with a stream of the rest of the blob, after the original stream failed with originalErr.
+func (br *bodyReader) resume(originalErr error) error {
+	if originalErr == io.EOF {
+		originalErr = io.ErrUnexpectedEOF
+	}
+	if br.failedResumes >= maxBodyReaderResumeAttempts {
+		return errors.Wrapf(originalErr, "reading blob %s (giving up after %d attempts to resume)", br.info.Digest, br.failedResumes)
+	}
+	br.failedResumes++
+	logrus.Debugf("Reading blob %s failed at offset %d: %v; resuming (attempt %d)", br.info.Digest, br.offset, originalErr, br.failedResumes)
+
+	if err := br.closeBody(); err != nil {
+		logrus.Debugf("Error closing blob %s body: %v", br.info.Digest, err)
+	}
+	streams, errs, err := br.src.GetBlobAt(br.ctx, br.info, []private.ImageSourceChunk{{
+		Offset: uint64(br.offset),
+		Length: uint64(br.size - br.offset),
+	}})
+	if err != nil {
+		return errors.Wrapf(err, "resuming download of blob %s after %v", br.info.Digest, originalErr)
+	}
+	select {
+	case body, ok := <-streams:
+		if !ok {
+			err := <-errs // Either an error, or nil if errs is closed as well.
+			if err == nil {
+				err = errors.New("no data returned")
+			}
+			return errors.Wrapf(err, "resuming download of blob %s", br.info.Digest)
+		}
+		br.body = body
+		br.bodyErrs = errs
+		return nil
+	case err := <-errs:
+		if err == nil { // errs is closed before streams; if both are empty, there is no data.
+			err = errors.New("no data returned")
+		}
+		abandonGetBlobAt(streams, errs)
+		return errors.Wrapf(err, "resuming download of blob %s", br.info.Digest)
+	case <-br.ctx.Done():
+		abandonGetBlobAt(streams, errs)
+		return br.ctx.Err()
+	}
+}
+
+// closeBody closes br.body, and makes sure the goroutine started by GetBlobAt, if any, can terminate.
+func (br *bodyReader) closeBody() error {
+	if br.body == nil {
+		return nil
+	}
+	err := br.body.Close()
+	br.body = nil
+	if br.bodyErrs != nil {
+		go drainErrs(br.bodyErrs)
+		br.bodyErrs = nil
+	}
+	return err
+}
+
+// abandonGetBlobAt consumes all values returned by GetBlobAt, so that the goroutine writing them can terminate.
+func abandonGetBlobAt(streams chan io.ReadCloser, errs chan error) {
+	go func() {
+		for s := range streams {
+			s.Close()
+		}
+	}()
+	go drainErrs(errs)
+}
+
+// drainErrs reads all values from errs, so that the goroutine writing to it can terminate.
+func drainErrs(errs chan error) {
+	for err := range errs {
+		logrus.Debugf("Ignoring error after resuming a blob download: %v", err)
+	}
+}
+
+// Close implements io.Closer.
+func (br *bodyReader) Close() error {
+	return br.closeBody()
+}
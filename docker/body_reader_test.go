@@ -0,0 +1,78 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerImageSourceGetBlobResume(t *testing.T) {
+	blob := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	blobDigest := digest.FromBytes(blob)
+	manifestBlob := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:0000000000000000000000000000000000000000000000000000000000000000","size":1},"layers":[]}`)
+
+	blobGETs := func(registry *fakeRegistry) int {
+		res := 0
+		for _, r := range registry.recordedRequests() {
+			if r == "GET /v2/repo/blobs/"+blobDigest.String() {
+				res++
+			}
+		}
+		return res
+	}
+
+	for _, c := range []struct {
+		name     string
+		size     int64 // BlobInfo.Size
+		failures []int
+		gets     int
+		success  bool
+	}{
+		{"no failures", -1, nil, 1, true},
+		{"failures with progress", -1, []int{1000, 0, 100000, 1}, 5, true},
+		{"known size", int64(len(blob)), []int{1000}, 2, true},
+		{"too many failures without progress", -1, []int{1000, 0, 0, 0, 0, 0, 0}, 1 + maxBodyReaderResumeAttempts, false},
+	} {
+		registry := newFakeRegistry(t)
+		registry.manifests[manifestKey("repo", "tag")] = fakeManifest{mimeType: imgspecv1.MediaTypeImageManifest, contents: manifestBlob}
+		registry.blobs["repo@"+blobDigest.String()] = blob
+		registry.blobReadFailures = c.failures
+		sys := sigstoreAttachmentsTestSystemContext(t, registry.host(), false)
+		src, err := newImageSource(context.Background(), sys, dockerRefFromString(t, "//"+registry.host()+"/repo:tag"))
+		require.NoError(t, err, c.name)
+		defer src.Close()
+
+		reader, size, err := src.GetBlob(context.Background(), types.BlobInfo{Digest: blobDigest, Size: c.size}, none.NoCache)
+		require.NoError(t, err, c.name)
+		assert.Equal(t, int64(len(blob)), size, c.name)
+		data, err := io.ReadAll(reader)
+		if c.success {
+			require.NoError(t, err, c.name)
+			assert.Equal(t, blob, data, c.name)
+		} else {
+			assert.Error(t, err, c.name)
+		}
+		err = reader.Close()
+		assert.NoError(t, err, c.name)
+		assert.Equal(t, c.gets, blobGETs(registry), c.name)
+	}
+
+	// A response without a Content-Length, and an unknown size: no resuming is possible.
+	registry := newFakeRegistry(t)
+	registry.manifests[manifestKey("repo", "tag")] = fakeManifest{mimeType: imgspecv1.MediaTypeImageManifest, contents: manifestBlob}
+	sys := sigstoreAttachmentsTestSystemContext(t, registry.host(), false)
+	src, err := newImageSource(context.Background(), sys, dockerRefFromString(t, "//"+registry.host()+"/repo:tag"))
+	require.NoError(t, err)
+	defer src.Close()
+	body := io.NopCloser(strings.NewReader("contents"))
+	assert.Equal(t, body, newBodyReader(context.Background(), src, types.BlobInfo{Digest: blobDigest, Size: -1}, -1, body))
+}
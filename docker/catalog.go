@@ -0,0 +1,228 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/containers/image/v5/pkg/docker/config"
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const catalogPath = "/v2/_catalog"
+
+// CatalogOptions are options for NewCatalogLister.
+type CatalogOptions struct {
+	// If > 0, the number of repositories to ask the registry for in each request.
+	// Registries may return fewer repositories per request.
+	PageSize int
+	// If not "", only repositories sorted after Last are returned.
+	Last string
+}
+
+// CatalogLister enumerates the repositories of a registry using the /v2/_catalog API, one page at a time.
+//
+// Mirrors of the registry configured in registries.conf are tried first, in order, if they are
+// not restricted to digest pulls and mirror the whole registry without a namespace; the first endpoint
+// which successfully returns the first page is used for all following pages.
+// If registries.conf remaps the registry to a location with a namespace, NewCatalogLister fails.
+type CatalogLister struct {
+	sys       *types.SystemContext
+	endpoints []sysregistriesv2.Endpoint // Candidate endpoints to use, if client is not set yet.
+	client    *dockerClient              // The endpoint used, set after the first page was read.
+	path      string                     // The path of the next page, or "" if all pages have been read.
+}
+
+// NewCatalogLister returns a CatalogLister for registry (a host[:port] value, e.g. "registry.example.com:5000").
+// options may be nil.
+func NewCatalogLister(sys *types.SystemContext, registry string, options *CatalogOptions) (*CatalogLister, error) {
+	if registry == "" || strings.Contains(registry, "/") {
+		return nil, errors.Errorf("invalid registry %q", registry)
+	}
+	reg, err := sysregistriesv2.FindRegistry(sys, registry)
+	if err != nil {
+		return nil, errors.Wrapf(err, "loading registries configuration")
+	}
+
+	l := &CatalogLister{
+		sys:  sys,
+		path: catalogPath,
+	}
+	primary := sysregistriesv2.Endpoint{Location: registry}
+	if reg != nil {
+		if reg.Blocked {
+			return nil, errors.Errorf("registry %s is blocked in %s or %s", reg.Prefix, sysregistriesv2.ConfigPath(sys), sysregistriesv2.ConfigDirPath(sys))
+		}
+		// The catalog of a namespace within a registry can't be listed; using the original host instead would
+		// contact a server the configuration redirects away from.
+		if strings.Contains(reg.Location, "/") {
+			return nil, errors.Errorf("registry %s is remapped to %s, which is not a whole registry; listing its catalog is not supported", registry, reg.Location)
+		}
+		primary = reg.Endpoint
+		primary.Location = registry
+		if reg.Prefix == registry && !reg.MirrorByDigestOnly {
+			for _, mirror := range reg.Mirrors {
+				if mirror.PullFromMirror != sysregistriesv2.MirrorByDigestOnly && mirror.Location != "" && !strings.Contains(mirror.Location, "/") {
+					l.endpoints = append(l.endpoints, mirror)
+				}
+			}
+		}
+		if reg.Location != "" {
+			primary.Location = reg.Location
+		}
+	}
+	l.endpoints = append(l.endpoints, primary)
+
+	query := url.Values{}
+	if options != nil && options.PageSize > 0 {
+		query.Set("n", strconv.Itoa(options.PageSize))
+	}
+	if options != nil && options.Last != "" {
+		query.Set("last", options.Last)
+	}
+	if len(query) != 0 {
+		l.path += "?" + query.Encode()
+	}
+	return l, nil
+}
+
+// NextPage returns the next non-empty page of repository names, in the order returned by the registry.
+// It returns io.EOF after all repositories have been returned.
+func (l *CatalogLister) NextPage(ctx context.Context) ([]string, error) {
+	for l.path != "" {
+		var (
+			repos []string
+			err   error
+		)
+		if l.client == nil {
+			repos, err = l.fetchFirstPage(ctx)
+		} else {
+			repos, err = l.fetchPage(ctx, l.client)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(repos) != 0 {
+			return repos, nil
+		}
+	}
+	return nil, io.EOF
+}
+
+// fetchFirstPage reads the first page from the first of l.endpoints which works, and sets l.client to use that endpoint.
+func (l *CatalogLister) fetchFirstPage(ctx context.Context) ([]string, error) {
+	var err error
+	for i, endpoint := range l.endpoints {
+		if l.sys != nil && l.sys.DockerLogMirrorChoice {
+			logrus.Infof("Trying to access the catalog of %q", endpoint.Location)
+		} else {
+			logrus.Debugf("Trying to access the catalog of %q", endpoint.Location)
+		}
+		var client *dockerClient
+		client, err = l.newEndpointClient(endpoint, i == len(l.endpoints)-1)
+		if err == nil {
+			var repos []string
+			repos, err = l.fetchPage(ctx, client)
+			if err == nil {
+				l.client = client
+				return repos, nil
+			}
+		}
+		logrus.Debugf("Accessing the catalog of %q failed: %v", endpoint.Location, err)
+	}
+	// The primary endpoint is tried last, so err is the error encountered when accessing it.
+	return nil, err
+}
+
+// newEndpointClient returns a dockerClient for reading the catalog of endpoint.
+func (l *CatalogLister) newEndpointClient(endpoint sysregistriesv2.Endpoint, isPrimary bool) (*dockerClient, error) {
+	endpointSys := l.sys
+	// sys.DockerAuthConfig does not explicitly specify a registry; we must not blindly send the credentials intended for the primary endpoint to mirrors.
+	if !isPrimary && endpointSys != nil && endpointSys.DockerAuthConfig != nil {
+		copy := *endpointSys
+		copy.DockerAuthConfig = nil
+		copy.DockerBearerRegistryToken = ""
+		endpointSys = &copy
+	}
+	// Get credentials from authfile for the underlying hostname
+	// We can't use GetCredentialsForRef here because we want to access the whole registry.
+	auth, err := config.GetCredentials(endpointSys, endpoint.Location)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting username and password")
+	}
+	client, err := newDockerClient(endpointSys, endpoint.Location, endpoint.Location)
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating new docker client")
+	}
+	client.auth = auth
+	if endpointSys != nil {
+		client.registryToken = endpointSys.DockerBearerRegistryToken
+	}
+	client.tlsClientConfig.InsecureSkipVerify = endpoint.Insecure
+	client.proxy, err = endpoint.ProxyFunc()
+	if err != nil {
+		return nil, err
+	}
+	if err := endpoint.ConfigureTLS(client.tlsClientConfig); err != nil {
+		return nil, err
+	}
+	client.scope = authScope{
+		resourceType: "registry",
+		remoteName:   "catalog",
+		actions:      "*",
+	}
+	return client, nil
+}
+
+// fetchPage reads the page at l.path using client, and updates l.path to point to the next page.
+func (l *CatalogLister) fetchPage(ctx context.Context, client *dockerClient) ([]string, error) {
+	res, err := client.makeRequest(ctx, http.MethodGet, l.path, nil, nil, v2Auth, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if err := httpResponseToError(res, "fetching catalog"); err != nil {
+		return nil, err
+	}
+
+	var catalog struct {
+		Repositories []string `json:"repositories"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&catalog); err != nil {
+		return nil, err
+	}
+	l.path, err = nextPagePath(res)
+	if err != nil {
+		return nil, err
+	}
+	return catalog.Repositories, nil
+}
+
+// GetCatalog returns the names of all repositories in registry (a host[:port] value).
+// For large registries, NewCatalogLister should be preferred.
+func GetCatalog(ctx context.Context, sys *types.SystemContext, registry string) ([]string, error) {
+	lister, err := NewCatalogLister(sys, registry, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	repos := []string{}
+	for {
+		page, err := lister.NextPage(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, page...)
+	}
+	return repos, nil
+}
@@ -0,0 +1,108 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeRegistryWithRepos returns a fakeRegistry containing a manifest tagged "latest" in each of repos.
+func newFakeRegistryWithRepos(t *testing.T, repos ...string) *fakeRegistry {
+	r := newFakeRegistry(t)
+	for _, repo := range repos {
+		r.manifests[manifestKey(repo, "latest")] = fakeManifest{mimeType: imgspecv1.MediaTypeImageManifest, contents: []byte("{}")}
+	}
+	return r
+}
+
+func TestCatalogLister(t *testing.T) {
+	primary := newFakeRegistryWithRepos(t, "a", "b/c", "d", "e/f/g", "h")
+	sys := sigstoreAttachmentsTestSystemContext(t, primary.host(), false)
+
+	for _, c := range []struct {
+		name    string
+		options *CatalogOptions
+		pages   [][]string
+	}{
+		{"default", nil, [][]string{{"a", "b/c", "d", "e/f/g", "h"}}},
+		{"paged", &CatalogOptions{PageSize: 2}, [][]string{{"a", "b/c"}, {"d", "e/f/g"}, {"h"}}},
+		{"last", &CatalogOptions{PageSize: 2, Last: "b/c"}, [][]string{{"d", "e/f/g"}, {"h"}}},
+	} {
+		lister, err := NewCatalogLister(sys, primary.host(), c.options)
+		require.NoError(t, err, c.name)
+		pages := [][]string{}
+		for {
+			page, err := lister.NextPage(context.Background())
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err, c.name)
+			pages = append(pages, page)
+		}
+		assert.Equal(t, c.pages, pages, c.name)
+	}
+
+	repos, err := GetCatalog(context.Background(), sys, primary.host())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b/c", "d", "e/f/g", "h"}, repos)
+
+	_, err = NewCatalogLister(sys, primary.host()+"/a", nil)
+	assert.Error(t, err)
+}
+
+func TestCatalogListerMirrors(t *testing.T) {
+	primary := newFakeRegistryWithRepos(t, "primary")
+	mirror := newFakeRegistryWithRepos(t, "mirror")
+	unavailable := httptest.NewServer(http.NotFoundHandler())
+	unavailable.Close()
+	unavailableHost := strings.TrimPrefix(unavailable.URL, "http://")
+
+	for _, c := range []struct {
+		name    string
+		mirrors string
+		repos   []string
+	}{
+		{"no mirrors", "", []string{"primary"}},
+		{"mirror", fmt.Sprintf("[[registry.mirror]]\nlocation = %q\n", mirror.host()), []string{"mirror"}},
+		{"unavailable mirror", fmt.Sprintf("[[registry.mirror]]\nlocation = %q\n[[registry.mirror]]\nlocation = %q\n", unavailableHost, mirror.host()), []string{"mirror"}},
+		{"all mirrors unavailable", fmt.Sprintf("[[registry.mirror]]\nlocation = %q\n", unavailableHost), []string{"primary"}},
+		{"digest-only mirror", fmt.Sprintf("[[registry.mirror]]\nlocation = %q\npull-from-mirror = \"digest-only\"\n", mirror.host()), []string{"primary"}},
+		{"namespaced mirror", fmt.Sprintf("[[registry.mirror]]\nlocation = %q\n", mirror.host()+"/ns"), []string{"primary"}},
+	} {
+		sys := sigstoreAttachmentsTestSystemContext(t, primary.host(), false)
+		err := os.WriteFile(sys.SystemRegistriesConfPath, []byte(fmt.Sprintf("[[registry]]\nlocation = %q\n%s", primary.host(), c.mirrors)), 0644)
+		require.NoError(t, err, c.name)
+
+		repos, err := GetCatalog(context.Background(), sys, primary.host())
+		require.NoError(t, err, c.name)
+		assert.Equal(t, c.repos, repos, c.name)
+	}
+}
+
+func TestCatalogListerRemappedLocation(t *testing.T) {
+	primary := newFakeRegistryWithRepos(t, "primary")
+	relocated := newFakeRegistryWithRepos(t, "relocated")
+	sys := sigstoreAttachmentsTestSystemContext(t, primary.host(), false)
+
+	// A registry remapped to another host uses that host
+	err := os.WriteFile(sys.SystemRegistriesConfPath, []byte(fmt.Sprintf("[[registry]]\nprefix = %q\nlocation = %q\n", primary.host(), relocated.host())), 0644)
+	require.NoError(t, err)
+	repos, err := GetCatalog(context.Background(), sys, primary.host())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"relocated"}, repos)
+
+	// A registry remapped to a namespace is rejected, neither host is contacted
+	err = os.WriteFile(sys.SystemRegistriesConfPath, []byte(fmt.Sprintf("[[registry]]\nprefix = %q\nlocation = %q\n", primary.host(), relocated.host()+"/ns")), 0644)
+	require.NoError(t, err)
+	_, err = NewCatalogLister(sys, primary.host(), nil)
+	assert.Error(t, err)
+}
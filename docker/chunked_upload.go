@@ -0,0 +1,148 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// maxChunkUploadAttempts is the number of times uploadChunk tries to upload a chunk without making any progress.
+const maxChunkUploadAttempts = 5
+
+// uploadBlobChunked uploads all of stream to uploadLocation in chunks of chunkSize, resuming the upload if uploading a chunk fails,
+// and returns the location to use for finishing the upload.
+func (d *dockerImageDestination) uploadBlobChunked(ctx context.Context, uploadLocation *url.URL, stream io.Reader, chunkSize int64) (*url.URL, error) {
+	buf := make([]byte, chunkSize)
+	offset := int64(0)
+	for {
+		n, err := io.ReadFull(stream, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		if n == 0 {
+			return uploadLocation, nil
+		}
+		uploadLocation, err = d.uploadChunk(ctx, uploadLocation, buf[:n], offset)
+		if err != nil {
+			return nil, err
+		}
+		offset += int64(n)
+		if n < len(buf) {
+			return uploadLocation, nil
+		}
+	}
+}
+
+// uploadChunk uploads chunk, starting at offset within the blob, to uploadLocation, and returns the location to use for continuing the upload.
+// If the upload fails in a way that might be transient, uploadChunk asks the registry how much data it has received, and resumes the upload from there.
+func (d *dockerImageDestination) uploadChunk(ctx context.Context, uploadLocation *url.URL, chunk []byte, offset int64) (*url.URL, error) {
+	uploaded := int64(0) // The number of bytes of chunk the registry has received
+	failedAttempts := 0  // The number of failed attempts since the last progress
+	for {
+		start := offset + uploaded
+		end := offset + int64(len(chunk)) - 1
+		logrus.Debugf("Uploading blob chunk %d-%d", start, end)
+		res, err := d.c.makeRequestToResolvedURL(ctx, http.MethodPatch, uploadLocation, map[string][]string{
+			"Content-Type":  {"application/octet-stream"},
+			"Content-Range": {fmt.Sprintf("%d-%d", start, end)},
+		}, bytes.NewReader(chunk[uploaded:]), int64(len(chunk))-uploaded, v2Auth, nil)
+		if err == nil {
+			if successStatus(res.StatusCode) {
+				defer res.Body.Close()
+				newLocation, err := res.Location()
+				if err != nil {
+					return nil, errors.Wrap(err, "determining upload URL")
+				}
+				return newLocation, nil
+			}
+			err = errors.Wrapf(registryHTTPResponseToError(res), "uploading blob chunk %d-%d", start, end)
+			transient := res.StatusCode >= http.StatusInternalServerError || res.StatusCode == http.StatusRequestedRangeNotSatisfiable
+			res.Body.Close()
+			if !transient {
+				return nil, err
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		logrus.Debugf("Uploading blob chunk %d-%d failed: %v; resuming", start, end, err)
+
+		newLocation, lastByte, statusErr := d.uploadStatus(ctx, uploadLocation)
+		if statusErr != nil {
+			return nil, errors.Wrapf(statusErr, "resuming blob upload after %v", err)
+		}
+		received := lastByte + 1
+		if lastByte == 0 && offset == 0 {
+			// Registries report "0-0" both for an empty upload and an upload with a single byte; assume nothing was received
+			// (if the registry has actually received a byte, it rejects the retried chunk, and the upload eventually fails).
+			received = 0
+		}
+		if received < offset || received > offset+int64(len(chunk)) {
+			return nil, errors.Errorf("resuming blob upload after %v: registry has received %d bytes, expected %d to %d", err, received, offset, offset+int64(len(chunk)))
+		}
+		if received-offset > uploaded {
+			failedAttempts = 0
+		} else {
+			failedAttempts++
+		}
+		uploaded = received - offset
+		uploadLocation = newLocation
+		if uploaded == int64(len(chunk)) {
+			return uploadLocation, nil
+		}
+		if failedAttempts >= maxChunkUploadAttempts {
+			return nil, errors.Wrapf(err, "uploading blob chunk (giving up after %d attempts)", failedAttempts)
+		}
+	}
+}
+
+// uploadStatus asks the registry about the status of an upload at uploadLocation,
+// and returns the location to use for continuing the upload, and the offset of the last byte the registry has received (or -1 if none).
+func (d *dockerImageDestination) uploadStatus(ctx context.Context, uploadLocation *url.URL) (*url.URL, int64, error) {
+	res, err := d.c.makeRequestToResolvedURL(ctx, http.MethodGet, uploadLocation, nil, nil, -1, v2Auth, nil)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		return nil, -1, errors.Wrap(registryHTTPResponseToError(res), "querying blob upload status")
+	}
+	newLocation, err := res.Location()
+	if err != nil {
+		if err != http.ErrNoLocation {
+			return nil, -1, errors.Wrap(err, "determining upload URL")
+		}
+		newLocation = uploadLocation
+	}
+	lastByte, err := parseUploadRange(res.Header.Get("Range"))
+	if err != nil {
+		return nil, -1, err
+	}
+	return newLocation, lastByte, nil
+}
+
+// parseUploadRange parses a Range header value returned by the registry for an upload, and returns the offset of the last byte received,
+// or -1 if value is empty.
+func parseUploadRange(value string) (int64, error) {
+	if value == "" {
+		return -1, nil
+	}
+	rangeValue := strings.TrimPrefix(value, "bytes=")
+	parts := strings.SplitN(rangeValue, "-", 2)
+	if len(parts) != 2 || parts[0] != "0" {
+		return -1, errors.Errorf("invalid upload range %q", value)
+	}
+	end, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || end < 0 {
+		return -1, errors.Errorf("invalid upload range %q", value)
+	}
+	return end, nil
+}
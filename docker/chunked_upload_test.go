@@ -0,0 +1,87 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPutBlobChunked(t *testing.T) {
+	blob := bytes.Repeat([]byte("0123456789"), 250)
+	for _, c := range []struct {
+		name           string
+		blob           []byte
+		chunkSize      int64
+		chunkMinLength int
+		failures       []int
+		chunkSizes     []int
+		success        bool
+	}{
+		{"monolithic", blob, 0, 0, nil, []int{2500}, true},
+		{"chunked", blob, 1000, 0, nil, []int{1000, 1000, 500}, true},
+		{"exact multiple of the chunk size", blob, 500, 0, nil, []int{500, 500, 500, 500, 500}, true},
+		{"registry minimum chunk size", blob, 1000, 2000, nil, []int{2000, 500}, true},
+		{"registry minimum smaller than the chunk size", blob, 1000, 10, nil, []int{1000, 1000, 500}, true},
+		{"empty blob", []byte{}, 1000, 0, nil, nil, true},
+		{"resumed", blob, 1000, 0, []int{300, 0}, []int{1000, 700, 700, 1000, 500}, true},
+		{"too many failures", blob, 1000, 0, []int{0, 0, 0, 0, 0}, []int{1000, 1000, 1000, 1000, 1000}, false},
+		{"progress resets the failure count", blob, 1000, 0, []int{0, 0, 0, 0, 100, 0, 0, 0}, []int{1000, 1000, 1000, 1000, 1000, 900, 900, 900, 900, 1000, 500}, true},
+	} {
+		registry := newFakeRegistry(t)
+		registry.chunkMinLength = c.chunkMinLength
+		registry.uploadFailures = c.failures
+		sys := sigstoreAttachmentsTestSystemContext(t, registry.host(), false)
+		sys.DockerRegistryPushChunkSize = c.chunkSize
+		dest, err := newImageDestination(sys, dockerRefFromString(t, "//"+registry.host()+"/repo:tag"))
+		require.NoError(t, err, c.name)
+		defer dest.Close()
+
+		blobDigest := digest.FromBytes(c.blob)
+		info, err := dest.(private.ImageDestination).PutBlobWithOptions(context.Background(), bytes.NewReader(c.blob), types.BlobInfo{Digest: blobDigest, Size: -1},
+			private.PutBlobOptions{Cache: none.NoCache})
+		if c.success {
+			require.NoError(t, err, c.name)
+			assert.Equal(t, types.BlobInfo{Digest: blobDigest, Size: int64(len(c.blob))}, info, c.name)
+			assert.Equal(t, c.blob, registry.blobs["repo@"+blobDigest.String()], c.name)
+		} else {
+			assert.Error(t, err, c.name)
+			_, ok := registry.blobs["repo@"+blobDigest.String()]
+			assert.False(t, ok, c.name)
+		}
+		assert.Equal(t, c.chunkSizes, registry.uploadChunkSizes, c.name)
+	}
+}
+
+func TestParseUploadRange(t *testing.T) {
+	for _, c := range []struct {
+		input    string
+		expected int64
+	}{
+		{"", -1},
+		{"0-0", 0},
+		{"0-1023", 1023},
+		{"bytes=0-99", 99},
+	} {
+		res, err := parseUploadRange(c.input)
+		require.NoError(t, err, c.input)
+		assert.Equal(t, c.expected, res, c.input)
+	}
+
+	for _, input := range []string{
+		"0",
+		"1-100",
+		"0-x",
+		"0--1",
+		"bytes 0-100",
+	} {
+		_, err := parseUploadRange(input)
+		assert.Error(t, err, input)
+	}
+}
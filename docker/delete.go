@@ -0,0 +1,123 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/types"
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// DeleteImageOptions are options for DeleteImageWithOptions.
+type DeleteImageOptions struct {
+	// If true and the image is a manifest list, the manifests it refers to, recursively, and their lookaside signatures,
+	// are deleted as well, before the manifest list itself.  Note that they may be shared by other manifest lists,
+	// which will then become incomplete.
+	DeleteInstances bool
+}
+
+// DeleteImageWithOptions deletes the manifest ref refers to, and its lookaside signatures, from the registry, if supported.
+// Unlike ref.DeleteImage, options can be used to delete the per-platform manifests of a manifest list as well.
+// options may be nil.
+func DeleteImageWithOptions(ctx context.Context, sys *types.SystemContext, ref types.ImageReference, options *DeleteImageOptions) error {
+	dr, ok := ref.(dockerReference)
+	if !ok {
+		return errors.Errorf("ref must be a dockerReference")
+	}
+	return deleteImage(ctx, sys, dr, options)
+}
+
+// DeleteTag removes the tag in ref from the registry, without deleting the manifest it refers to.
+// It returns ErrTagDeletionUnsupported if the registry does not support deleting tags.
+func DeleteTag(ctx context.Context, sys *types.SystemContext, ref types.ImageReference) error {
+	dr, ok := ref.(dockerReference)
+	if !ok {
+		return errors.Errorf("ref must be a dockerReference")
+	}
+	tagged, ok := dr.ref.(reference.NamedTagged)
+	if !ok {
+		return errors.Errorf("%s does not contain a tag", reference.FamiliarString(dr.ref))
+	}
+	if _, ok := dr.ref.(reference.Canonical); ok {
+		return errors.Errorf("%s contains a digest, expected only a tag", reference.FamiliarString(dr.ref))
+	}
+
+	// See deleteImage about the choice of the actions.
+	c, err := newDockerClientFromRef(sys, dr, true, "*")
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf(manifestPath, reference.Path(dr.ref), tagged.Tag())
+	res, err := c.makeRequest(ctx, http.MethodDelete, path, nil, nil, v2Auth, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusAccepted:
+		return nil
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return ErrTagDeletionUnsupported
+	default:
+		err := registryHTTPResponseToError(res)
+		if res.StatusCode == http.StatusBadRequest && isTagDeletionUnsupportedError(err) {
+			logrus.Debugf("Deleting tag %s failed: %v", tagged.Tag(), err)
+			return ErrTagDeletionUnsupported
+		}
+		return errors.Wrapf(err, "deleting tag %s in %s", tagged.Tag(), dr.ref.Name())
+	}
+}
+
+// isTagDeletionUnsupportedError returns true iff err from registryHTTPResponseToError reports that
+// manifests can only be deleted by digest.
+func isTagDeletionUnsupportedError(err error) bool {
+	errors, ok := err.(errcode.Errors)
+	if !ok || len(errors) == 0 {
+		return false
+	}
+	ec, ok := errors[0].(errcode.ErrorCoder)
+	if !ok {
+		return false
+	}
+	switch ec.ErrorCode() {
+	// docker/distribution rejects tags where it expects a digest with ErrorCodeDigestInvalid.
+	case v2.ErrorCodeDigestInvalid, errcode.ErrorCodeUnsupported:
+		return true
+	default:
+		return false
+	}
+}
+
+// DeleteBlob deletes the blob with blobDigest from the repository of ref; the tag or digest in ref is ignored.
+// Note that the blob may still be referenced by manifests in the repository.
+func DeleteBlob(ctx context.Context, sys *types.SystemContext, ref types.ImageReference, blobDigest digest.Digest) error {
+	dr, ok := ref.(dockerReference)
+	if !ok {
+		return errors.Errorf("ref must be a dockerReference")
+	}
+	if err := blobDigest.Validate(); err != nil {
+		return err
+	}
+
+	// See deleteImage about the choice of the actions.
+	c, err := newDockerClientFromRef(sys, dr, true, "*")
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf(blobsPath, reference.Path(dr.ref), blobDigest.String())
+	res, err := c.makeRequest(ctx, http.MethodDelete, path, nil, nil, v2Auth, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusAccepted {
+		return errors.Wrapf(registryHTTPResponseToError(res), "deleting blob %s in %s", blobDigest.String(), dr.ref.Name())
+	}
+	return nil
+}
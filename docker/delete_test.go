@@ -0,0 +1,206 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecs "github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deleteTestSetup returns a fakeRegistry containing a manifest list tagged "list" with two instances,
+// the first of which is also tagged "instance", and a SystemContext using a lookaside directory
+// containing a signature for each of the manifests.
+func deleteTestSetup(t *testing.T) (*fakeRegistry, *types.SystemContext, string, digest.Digest, []digest.Digest) {
+	registry := newFakeRegistry(t)
+	index := imgspecv1.Index{
+		Versioned: imgspecs.Versioned{SchemaVersion: 2},
+		MediaType: imgspecv1.MediaTypeImageIndex,
+	}
+	instanceDigests := []digest.Digest{}
+	for i := 0; i < 2; i++ {
+		contents := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:0000000000000000000000000000000000000000000000000000000000000000","size":%d},"layers":[]}`, i))
+		d := digest.FromBytes(contents)
+		m := fakeManifest{mimeType: imgspecv1.MediaTypeImageManifest, contents: contents}
+		registry.manifests[manifestKey("repo", d.String())] = m
+		if i == 0 {
+			registry.manifests[manifestKey("repo", "instance")] = m
+		}
+		index.Manifests = append(index.Manifests, imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageManifest, Digest: d, Size: int64(len(contents))})
+		instanceDigests = append(instanceDigests, d)
+	}
+	indexBlob, err := json.Marshal(index)
+	require.NoError(t, err)
+	indexDigest := digest.FromBytes(indexBlob)
+	m := fakeManifest{mimeType: imgspecv1.MediaTypeImageIndex, contents: indexBlob}
+	registry.manifests[manifestKey("repo", "list")] = m
+	registry.manifests[manifestKey("repo", indexDigest.String())] = m
+
+	lookasideDir := t.TempDir()
+	sys := sigstoreAttachmentsTestSystemContext(t, registry.host(), false)
+	err = os.WriteFile(filepath.Join(sys.RegistriesDirPath, "test.yaml"),
+		[]byte(fmt.Sprintf("docker:\n  %s:\n    sigstore-staging: file://%s\n", registry.host(), lookasideDir)), 0644)
+	require.NoError(t, err)
+	for _, d := range append([]digest.Digest{indexDigest}, instanceDigests...) {
+		path := deleteTestSignaturePath(lookasideDir, d)
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		require.NoError(t, err)
+		err = os.WriteFile(path, []byte("signature"), 0644)
+		require.NoError(t, err)
+	}
+	return registry, sys, lookasideDir, indexDigest, instanceDigests
+}
+
+// deleteTestSignaturePath returns the path of the first lookaside signature of d in deleteTestSetup.
+func deleteTestSignaturePath(lookasideDir string, d digest.Digest) string {
+	return filepath.Join(lookasideDir, "repo@"+d.Algorithm().String()+"="+d.Hex(), "signature-1")
+}
+
+func TestDeleteImageWithOptions(t *testing.T) {
+	for _, deleteInstances := range []bool{false, true} {
+		registry, sys, lookasideDir, indexDigest, instanceDigests := deleteTestSetup(t)
+		ref := dockerRefFromString(t, "//"+registry.host()+"/repo:list")
+
+		err := DeleteImageWithOptions(context.Background(), sys, ref, &DeleteImageOptions{DeleteInstances: deleteInstances})
+		require.NoError(t, err)
+		_, ok := registry.manifest("repo", "list")
+		assert.False(t, ok)
+		_, ok = registry.manifest("repo", indexDigest.String())
+		assert.False(t, ok)
+		assert.NoFileExists(t, deleteTestSignaturePath(lookasideDir, indexDigest))
+		for _, d := range instanceDigests {
+			_, ok := registry.manifest("repo", d.String())
+			assert.Equal(t, !deleteInstances, ok, d.String())
+			if deleteInstances {
+				assert.NoFileExists(t, deleteTestSignaturePath(lookasideDir, d))
+			} else {
+				assert.FileExists(t, deleteTestSignaturePath(lookasideDir, d))
+			}
+		}
+		_, ok = registry.manifest("repo", "instance")
+		assert.Equal(t, !deleteInstances, ok)
+
+		// Missing instances are skipped.
+		registry, sys, _, _, instanceDigests = deleteTestSetup(t)
+		delete(registry.manifests, manifestKey("repo", instanceDigests[0].String()))
+		err = DeleteImageWithOptions(context.Background(), sys, ref, &DeleteImageOptions{DeleteInstances: deleteInstances})
+		require.NoError(t, err)
+		_, ok = registry.manifest("repo", instanceDigests[1].String())
+		assert.Equal(t, !deleteInstances, ok)
+	}
+
+	// A missing image fails.
+	registry, sys, _, _, _ := deleteTestSetup(t)
+	err := DeleteImageWithOptions(context.Background(), sys, dockerRefFromString(t, "//"+registry.host()+"/repo:missing"), nil)
+	assert.Error(t, err)
+}
+
+func TestDeleteImageWithOptionsNestedIndex(t *testing.T) {
+	registry, sys, lookasideDir, indexDigest, instanceDigests := deleteTestSetup(t)
+	extraContents := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:0000000000000000000000000000000000000000000000000000000000000000","size":2},"layers":[]}`)
+	extraDigest := digest.FromBytes(extraContents)
+	registry.manifests[manifestKey("repo", extraDigest.String())] = fakeManifest{mimeType: imgspecv1.MediaTypeImageManifest, contents: extraContents}
+	indexManifest, ok := registry.manifest("repo", indexDigest.String())
+	require.True(t, ok)
+	top := imgspecv1.Index{
+		Versioned: imgspecs.Versioned{SchemaVersion: 2},
+		MediaType: imgspecv1.MediaTypeImageIndex,
+		Manifests: []imgspecv1.Descriptor{
+			{MediaType: imgspecv1.MediaTypeImageIndex, Digest: indexDigest, Size: int64(len(indexManifest.contents))},
+			{MediaType: imgspecv1.MediaTypeImageManifest, Digest: instanceDigests[0], Size: 1}, // Also included in the nested index
+			{MediaType: imgspecv1.MediaTypeImageManifest, Digest: extraDigest, Size: int64(len(extraContents))},
+		},
+	}
+	topBlob, err := json.Marshal(top)
+	require.NoError(t, err)
+	topDigest := digest.FromBytes(topBlob)
+	m := fakeManifest{mimeType: imgspecv1.MediaTypeImageIndex, contents: topBlob}
+	registry.manifests[manifestKey("repo", "top")] = m
+	registry.manifests[manifestKey("repo", topDigest.String())] = m
+
+	err = DeleteImageWithOptions(context.Background(), sys, dockerRefFromString(t, "//"+registry.host()+"/repo:top"),
+		&DeleteImageOptions{DeleteInstances: true})
+	require.NoError(t, err)
+	for _, d := range []digest.Digest{topDigest, indexDigest, instanceDigests[0], instanceDigests[1], extraDigest} {
+		_, ok := registry.manifest("repo", d.String())
+		assert.False(t, ok, d.String())
+	}
+	assert.NoFileExists(t, deleteTestSignaturePath(lookasideDir, indexDigest))
+	assert.NoFileExists(t, deleteTestSignaturePath(lookasideDir, instanceDigests[1]))
+
+	// Manifests are deleted from the leaves up, and the top-level manifest last.
+	deletes := []string{}
+	for _, req := range registry.recordedRequests() {
+		if strings.HasPrefix(req, http.MethodDelete+" ") {
+			deletes = append(deletes, req)
+		}
+	}
+	expected := []string{}
+	for _, d := range []digest.Digest{instanceDigests[0], instanceDigests[1], indexDigest, extraDigest, topDigest} {
+		expected = append(expected, http.MethodDelete+" /v2/repo/manifests/"+d.String())
+	}
+	assert.Equal(t, expected, deletes)
+}
+
+func TestDeleteTag(t *testing.T) {
+	registry, sys, lookasideDir, indexDigest, _ := deleteTestSetup(t)
+	ref := dockerRefFromString(t, "//"+registry.host()+"/repo:list")
+
+	err := DeleteTag(context.Background(), sys, ref)
+	assert.Equal(t, ErrTagDeletionUnsupported, err)
+	_, ok := registry.manifest("repo", "list")
+	assert.True(t, ok)
+	registry.tagDeletionErrorCode = "UNSUPPORTED"
+	err = DeleteTag(context.Background(), sys, ref)
+	assert.Equal(t, ErrTagDeletionUnsupported, err)
+	// Other errors are not hidden
+	registry.tagDeletionErrorCode = "TAG_INVALID"
+	err = DeleteTag(context.Background(), sys, ref)
+	assert.Error(t, err)
+	assert.NotEqual(t, ErrTagDeletionUnsupported, err)
+	_, ok = registry.manifest("repo", "list")
+	assert.True(t, ok)
+
+	registry.supportsTagDeletion = true
+	err = DeleteTag(context.Background(), sys, ref)
+	require.NoError(t, err)
+	_, ok = registry.manifest("repo", "list")
+	assert.False(t, ok)
+	_, ok = registry.manifest("repo", indexDigest.String())
+	assert.True(t, ok)
+	assert.FileExists(t, deleteTestSignaturePath(lookasideDir, indexDigest))
+
+	err = DeleteTag(context.Background(), sys, ref)
+	assert.Error(t, err)
+	err = DeleteTag(context.Background(), sys, dockerRefFromString(t, "//"+registry.host()+"/repo@"+indexDigest.String()))
+	assert.Error(t, err)
+}
+
+func TestDeleteBlob(t *testing.T) {
+	registry := newFakeRegistry(t)
+	blob := []byte("blob")
+	blobDigest := digest.FromBytes(blob)
+	registry.blobs["repo@"+blobDigest.String()] = blob
+	sys := sigstoreAttachmentsTestSystemContext(t, registry.host(), false)
+	ref := dockerRefFromString(t, "//"+registry.host()+"/repo:ignored")
+
+	err := DeleteBlob(context.Background(), sys, ref, blobDigest)
+	require.NoError(t, err)
+	_, ok := registry.blobs["repo@"+blobDigest.String()]
+	assert.False(t, ok)
+
+	err = DeleteBlob(context.Background(), sys, ref, blobDigest)
+	assert.Error(t, err)
+	err = DeleteBlob(context.Background(), sys, ref, digest.Digest("sha256:invalid"))
+	assert.Error(t, err)
+}
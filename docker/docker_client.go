@@ -17,6 +17,8 @@ import (
 
 	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/internal/iolimits"
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/manifest"
 	"github.com/containers/image/v5/pkg/docker/config"
 	"github.com/containers/image/v5/pkg/sysregistriesv2"
 	"github.com/containers/image/v5/pkg/tlsclientconfig"
@@ -26,8 +28,10 @@ import (
 	clientLib "github.com/docker/distribution/registry/client"
 	"github.com/docker/go-connections/tlsconfig"
 	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
 )
 
 const (
@@ -42,6 +46,7 @@ const (
 	blobsPath               = "/v2/%s/blobs/%s"
 	blobUploadPath          = "/v2/%s/blobs/uploads/"
 	extensionsSignaturePath = "/extensions/v2/%s/signatures/%s"
+	referrersPath           = "/v2/%s/referrers/%s"
 
 	minimumTokenLifetimeSeconds = 60
 
@@ -87,24 +92,35 @@ type bearerToken struct {
 	AccessToken    string    `json:"access_token"`
 	ExpiresIn      int       `json:"expires_in"`
 	IssuedAt       time.Time `json:"issued_at"`
+	RefreshToken   string    `json:"refresh_token"`
 	expirationTime time.Time
 }
 
 // dockerClient is configuration for dealing with a single container registry.
 type dockerClient struct {
 	// The following members are set by newDockerClient and do not change afterwards.
-	sys       *types.SystemContext
-	registry  string
-	userAgent string
+	sys         *types.SystemContext
+	registry    string
+	userAgent   string
+	retryPolicy *types.RetryPolicy // nil if requests should not be retried
 
 	// tlsClientConfig is setup by newDockerClient and will be used and updated
 	// by detectProperties(). Callers can edit tlsClientConfig.InsecureSkipVerify in the meantime.
 	tlsClientConfig *tls.Config
+	// proxy is setup by newDockerClient and will be used by detectProperties(); if nil, the proxy is determined by the environment.
+	// Callers can edit it in the meantime.
+	proxy func(*http.Request) (*url.URL, error)
+	// logicalRegistry is the registry of the image reference being accessed, reported in types.DockerRegistryRequestEvent.
+	// It is setup by newDockerClient; callers can edit it before making any requests, e.g. when accessing a mirror.
+	logicalRegistry string
+	// bandwidthLimiter, if not nil, limits the throughput of blob transfers. It is setup by newDockerClient; callers can edit it in the meantime.
+	bandwidthLimiter *rate.Limiter
 	// The following members are not set by newDockerClient and must be set by callers if needed.
-	auth          types.DockerAuthConfig
-	registryToken string
-	signatureBase signatureStorageBase
-	scope         authScope
+	auth                   types.DockerAuthConfig
+	registryToken          string
+	signatureBase          signatureStorageBase
+	useSigstoreAttachments bool
+	scope                  authScope
 
 	// The following members are detected registry properties:
 	// They are set after a successful detectProperties(), and never change afterwards.
@@ -121,8 +137,9 @@ type dockerClient struct {
 }
 
 type authScope struct {
-	remoteName string
-	actions    string
+	resourceType string
+	remoteName   string
+	actions      string
 }
 
 // sendAuth determines whether we need authentication for v2 or v1 endpoint.
@@ -216,7 +233,11 @@ func newDockerClientFromRef(sys *types.SystemContext, ref dockerReference, write
 		return nil, errors.Wrapf(err, "getting username and password")
 	}
 
-	sigBase, err := SignatureStorageBaseURL(sys, ref, write)
+	registryConfig, err := loadRegistryConfiguration(sys)
+	if err != nil {
+		return nil, err
+	}
+	sigBase, err := registryConfig.signatureStorageBaseURL(ref, write)
 	if err != nil {
 		return nil, err
 	}
@@ -231,6 +252,8 @@ func newDockerClientFromRef(sys *types.SystemContext, ref dockerReference, write
 		client.registryToken = sys.DockerBearerRegistryToken
 	}
 	client.signatureBase = sigBase
+	client.useSigstoreAttachments = registryConfig.useSigstoreAttachments(ref)
+	client.scope.resourceType = "repository"
 	client.scope.actions = actions
 	client.scope.remoteName = reference.Path(ref.ref)
 	return client, nil
@@ -265,6 +288,8 @@ func newDockerClient(sys *types.SystemContext, registry, reference string) (*doc
 	// Check if TLS verification shall be skipped (default=false) which can
 	// be specified in the sysregistriesv2 configuration.
 	skipVerify := false
+	var proxy func(*http.Request) (*url.URL, error)
+	var bandwidthLimiter *rate.Limiter
 	reg, err := sysregistriesv2.FindRegistry(sys, reference)
 	if err != nil {
 		return nil, errors.Wrapf(err, "loading registries")
@@ -274,6 +299,14 @@ func newDockerClient(sys *types.SystemContext, registry, reference string) (*doc
 			return nil, fmt.Errorf("registry %s is blocked in %s or %s", reg.Prefix, sysregistriesv2.ConfigPath(sys), sysregistriesv2.ConfigDirPath(sys))
 		}
 		skipVerify = reg.Insecure
+		proxy, err = reg.ProxyFunc()
+		if err != nil {
+			return nil, err
+		}
+		if err := reg.ConfigureTLS(tlsClientConfig); err != nil {
+			return nil, err
+		}
+		bandwidthLimiter = registryBandwidthLimiter(registry, reg.BandwidthLimit)
 	}
 	tlsClientConfig.InsecureSkipVerify = skipVerify
 
@@ -282,11 +315,20 @@ func newDockerClient(sys *types.SystemContext, registry, reference string) (*doc
 		userAgent = sys.DockerRegistryUserAgent
 	}
 
+	retryPolicy, err := sysregistriesv2.RetryPolicy(sys)
+	if err != nil {
+		return nil, errors.Wrapf(err, "loading registries")
+	}
+
 	return &dockerClient{
-		sys:             sys,
-		registry:        registry,
-		userAgent:       userAgent,
-		tlsClientConfig: tlsClientConfig,
+		sys:              sys,
+		registry:         registry,
+		logicalRegistry:  hostName,
+		bandwidthLimiter: bandwidthLimiter,
+		userAgent:        userAgent,
+		retryPolicy:      retryPolicy,
+		tlsClientConfig:  tlsClientConfig,
+		proxy:            proxy,
 	}, nil
 }
 
@@ -469,6 +511,28 @@ func (c *dockerClient) makeRequest(ctx context.Context, method, path string, hea
 	return c.makeRequestToResolvedURL(ctx, method, url, headers, stream, -1, auth, extraScope)
 }
 
+// nextPagePath returns the path of the next page of a paginated response res, as specified by its "Link" header,
+// or "" if there is no next page.
+func nextPagePath(res *http.Response) (string, error) {
+	link := res.Header.Get("Link")
+	if link == "" {
+		return "", nil
+	}
+	linkURLStr := strings.Trim(strings.Split(link, ";")[0], "<>")
+	linkURL, err := url.Parse(linkURLStr)
+	if err != nil {
+		return "", err
+	}
+	// can be relative or absolute, but we only want the path (and I
+	// guess we're in trouble if it forwards to a new place...)
+	path := linkURL.Path
+	if linkURL.RawQuery != "" {
+		path += "?"
+		path += linkURL.RawQuery
+	}
+	return path, nil
+}
+
 // parseRetryAfter determines the delay required by the "Retry-After" header in res and returns it,
 // silently falling back to fallbackDelay if the header is missing or invalid.
 func parseRetryAfter(res *http.Response, fallbackDelay time.Duration) time.Duration {
@@ -501,8 +565,20 @@ func parseRetryAfter(res *http.Response, fallbackDelay time.Duration) time.Durat
 // streamLen, if not -1, specifies the length of the data expected on stream.
 // makeRequest should generally be preferred.
 // In case of an HTTP 429 status code in the response, it may automatically retry a few times.
+// Idempotent requests which fail with a possibly transient error are retried according to c.retryPolicy.
 // TODO(runcom): too many arguments here, use a struct
 func (c *dockerClient) makeRequestToResolvedURL(ctx context.Context, method string, url *url.URL, headers map[string][]string, stream io.Reader, streamLen int64, auth sendAuth, extraScope *authScope) (*http.Response, error) {
+	ctx = withRequestAttempts(ctx)
+	if c.retryPolicy != nil && c.retryPolicy.MaxRetries > 0 && retryableRequest(method, stream) {
+		return c.makeRequestToResolvedURLWithRetries(ctx, c.retryPolicy, method, url, headers, auth, extraScope)
+	}
+	return c.makeRequestToResolvedURLRateLimited(ctx, method, url, headers, stream, streamLen, auth, extraScope)
+}
+
+// makeRequestToResolvedURLRateLimited creates and executes a http.Request with the specified parameters, adding authentication and TLS options for the Docker client.
+// streamLen, if not -1, specifies the length of the data expected on stream.
+// In case of an HTTP 429 status code in the response, it may automatically retry a few times.
+func (c *dockerClient) makeRequestToResolvedURLRateLimited(ctx context.Context, method string, url *url.URL, headers map[string][]string, stream io.Reader, streamLen int64, auth sendAuth, extraScope *authScope) (*http.Response, error) {
 	delay := backoffInitialDelay
 	attempts := 0
 	for {
@@ -556,7 +632,11 @@ func (c *dockerClient) makeRequestToResolvedURLOnce(ctx context.Context, method
 		}
 	}
 	logrus.Debugf("%s %s", method, url.Redacted())
-	res, err := c.client.Do(req)
+	kind := types.DockerRegistryRequestRegistry
+	if url.Host != c.registry {
+		kind = types.DockerRegistryRequestExternal
+	}
+	res, err := c.doRequest(req, kind, nextRequestAttempt(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -588,7 +668,7 @@ func (c *dockerClient) setupRequestAuth(req *http.Request, extraScope *authScope
 				scopes := []authScope{c.scope}
 				if extraScope != nil {
 					// Using ':' as a separator here is unambiguous because getBearerToken below uses the same separator when formatting a remote request (and because repository names can't contain colons).
-					cacheKey = fmt.Sprintf("%s:%s", extraScope.remoteName, extraScope.actions)
+					cacheKey = fmt.Sprintf("%s:%s:%s", extraScope.resourceType, extraScope.remoteName, extraScope.actions)
 					scopes = append(scopes, *extraScope)
 				}
 				var token bearerToken
@@ -597,15 +677,7 @@ func (c *dockerClient) setupRequestAuth(req *http.Request, extraScope *authScope
 					token = t.(bearerToken)
 				}
 				if !inCache || time.Now().After(token.expirationTime) {
-					var (
-						t   *bearerToken
-						err error
-					)
-					if c.auth.IdentityToken != "" {
-						t, err = c.getBearerTokenOAuth2(req.Context(), challenge, scopes)
-					} else {
-						t, err = c.getBearerToken(req.Context(), challenge, scopes)
-					}
+					t, err := c.obtainBearerToken(req.Context(), challenge, scopes)
 					if err != nil {
 						return err
 					}
@@ -625,8 +697,65 @@ func (c *dockerClient) setupRequestAuth(req *http.Request, extraScope *authScope
 	return nil
 }
 
+// obtainBearerToken returns a bearer token for scopes, from sharedBearerTokenCache if possible.
+func (c *dockerClient) obtainBearerToken(ctx context.Context, challenge challenge, scopes []authScope) (*bearerToken, error) {
+	cachePath := ""
+	if c.sys != nil {
+		cachePath = c.sys.DockerBearerTokenCachePath
+	}
+	cacheKey := bearerTokenCacheKey(challenge, scopes, c.auth)
+	if token, ok := sharedBearerTokenCache.lookup(cachePath, cacheKey); ok {
+		return &token, nil
+	}
+
+	var (
+		token *bearerToken
+		err   error
+	)
+	if c.auth.IdentityToken != "" {
+		token, err = c.getBearerTokenOAuth2(ctx, challenge, scopes)
+	} else {
+		token, err = c.getBearerToken(ctx, challenge, scopes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sharedBearerTokenCache.store(cachePath, cacheKey, *token)
+	return token, nil
+}
+
+// getBearerTokenOAuth2 obtains a bearer token for scopes using c.auth.IdentityToken, or a refresh token
+// previously returned by the token server in exchange for it.
 func (c *dockerClient) getBearerTokenOAuth2(ctx context.Context, challenge challenge,
 	scopes []authScope) (*bearerToken, error) {
+	refreshKey := refreshTokenCacheKey(challenge, c.auth)
+	refreshToken, ok := sharedBearerTokenCache.lookupRefreshToken(refreshKey)
+	if ok {
+		token, err := c.getBearerTokenOAuth2WithRefreshToken(ctx, challenge, scopes, refreshToken)
+		if err == nil {
+			if token.RefreshToken != "" {
+				sharedBearerTokenCache.storeRefreshToken(refreshKey, token.RefreshToken)
+			}
+			return token, nil
+		}
+		// The refresh token might have been revoked or expired; fall back to the original identity token.
+		logrus.Debugf("Obtaining a bearer token using a cached refresh token failed, retrying with the identity token: %v", err)
+		sharedBearerTokenCache.storeRefreshToken(refreshKey, "")
+	}
+
+	token, err := c.getBearerTokenOAuth2WithRefreshToken(ctx, challenge, scopes, c.auth.IdentityToken)
+	if err != nil {
+		return nil, err
+	}
+	if token.RefreshToken != "" {
+		sharedBearerTokenCache.storeRefreshToken(refreshKey, token.RefreshToken)
+	}
+	return token, nil
+}
+
+// getBearerTokenOAuth2WithRefreshToken obtains a bearer token for scopes using refreshToken.
+func (c *dockerClient) getBearerTokenOAuth2WithRefreshToken(ctx context.Context, challenge challenge,
+	scopes []authScope, refreshToken string) (*bearerToken, error) {
 	realm, ok := challenge.Parameters["realm"]
 	if !ok {
 		return nil, errors.Errorf("missing realm in bearer auth challenge")
@@ -644,19 +773,19 @@ func (c *dockerClient) getBearerTokenOAuth2(ctx context.Context, challenge chall
 		params.Add("service", service)
 	}
 	for _, scope := range scopes {
-		if scope.remoteName != "" && scope.actions != "" {
-			params.Add("scope", fmt.Sprintf("repository:%s:%s", scope.remoteName, scope.actions))
+		if scope.resourceType != "" && scope.remoteName != "" && scope.actions != "" {
+			params.Add("scope", fmt.Sprintf("%s:%s:%s", scope.resourceType, scope.remoteName, scope.actions))
 		}
 	}
 	params.Add("grant_type", "refresh_token")
-	params.Add("refresh_token", c.auth.IdentityToken)
+	params.Add("refresh_token", refreshToken)
 	params.Add("client_id", "containers/image")
 
 	authReq.Body = io.NopCloser(strings.NewReader(params.Encode()))
 	authReq.Header.Add("User-Agent", c.userAgent)
 	authReq.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	logrus.Debugf("%s %s", authReq.Method, authReq.URL.Redacted())
-	res, err := c.client.Do(authReq)
+	res, err := c.doRequest(authReq, types.DockerRegistryRequestTokenServer, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -695,8 +824,8 @@ func (c *dockerClient) getBearerToken(ctx context.Context, challenge challenge,
 	}
 
 	for _, scope := range scopes {
-		if scope.remoteName != "" && scope.actions != "" {
-			params.Add("scope", fmt.Sprintf("repository:%s:%s", scope.remoteName, scope.actions))
+		if scope.resourceType != "" && scope.remoteName != "" && scope.actions != "" {
+			params.Add("scope", fmt.Sprintf("%s:%s:%s", scope.resourceType, scope.remoteName, scope.actions))
 		}
 	}
 
@@ -708,7 +837,7 @@ func (c *dockerClient) getBearerToken(ctx context.Context, challenge challenge,
 	authReq.Header.Add("User-Agent", c.userAgent)
 
 	logrus.Debugf("%s %s", authReq.Method, authReq.URL.Redacted())
-	res, err := c.client.Do(authReq)
+	res, err := c.doRequest(authReq, types.DockerRegistryRequestTokenServer, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -734,6 +863,9 @@ func (c *dockerClient) detectPropertiesHelper(ctx context.Context) error {
 	}
 	tr := tlsclientconfig.NewTransport()
 	tr.TLSClientConfig = c.tlsClientConfig
+	if c.proxy != nil {
+		tr.Proxy = c.proxy
+	}
 	c.client = &http.Client{Transport: tr}
 
 	ping := func(scheme string) error {
@@ -826,3 +958,241 @@ func (c *dockerClient) getExtensionsSignatures(ctx context.Context, ref dockerRe
 	}
 	return &parsedBody, nil
 }
+
+// referrersTagSchemaTag returns the tag of the referrers index for the specified digest,
+// as used by the referrers tag schema on registries which don’t support the referrers API.
+func referrersTagSchemaTag(d digest.Digest) (string, error) {
+	if err := d.Validate(); err != nil { // doesn't REALLY matter for us, but we shouldn't be creating invalid tags
+		return "", err
+	}
+	return strings.Replace(d.String(), ":", "-", 1), nil
+}
+
+// sigstoreAttachmentTag returns a sigstore attachment tag for the specified digest.
+func sigstoreAttachmentTag(d digest.Digest) (string, error) {
+	tag, err := referrersTagSchemaTag(d)
+	if err != nil {
+		return "", err
+	}
+	return tag + ".sig", nil
+}
+
+// referrersIndex is an OCI image index listing referrers, as returned by the referrers API
+// and stored by the referrers tag schema.
+type referrersIndex struct {
+	SchemaVersion int                `json:"schemaVersion"`
+	MediaType     string             `json:"mediaType"`
+	Manifests     []private.Referrer `json:"manifests"`
+	Annotations   map[string]string  `json:"annotations,omitempty"`
+}
+
+// newReferrersIndex returns a new, empty, referrersIndex.
+func newReferrersIndex() *referrersIndex {
+	return &referrersIndex{
+		SchemaVersion: 2,
+		MediaType:     imgspecv1.MediaTypeImageIndex,
+		Manifests:     []private.Referrer{},
+	}
+}
+
+// filterReferrers returns the referrers in refs which have artifactType, or all of them if artifactType is empty.
+func filterReferrers(refs []private.Referrer, artifactType string) []private.Referrer {
+	res := []private.Referrer{}
+	for _, r := range refs {
+		if artifactType == "" || r.ArtifactType == artifactType {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// getReferrers returns referrers of manifestDigest in ref, optionally restricted to artifactType.
+// It uses the referrers API if the registry supports it, and falls back to the referrers tag schema otherwise.
+func (c *dockerClient) getReferrers(ctx context.Context, ref dockerReference, manifestDigest digest.Digest, artifactType string) ([]private.Referrer, error) {
+	if err := manifestDigest.Validate(); err != nil { // Make sure manifestDigest.String() does not contain any unexpected characters
+		return nil, err
+	}
+	path := fmt.Sprintf(referrersPath, reference.Path(ref.ref), manifestDigest.String())
+	if artifactType != "" {
+		path += "?" + url.Values{"artifactType": {artifactType}}.Encode()
+	}
+	headers := map[string][]string{
+		"Accept": {imgspecv1.MediaTypeImageIndex},
+	}
+	res := []private.Referrer{}
+	for firstPage := true; path != ""; firstPage = false {
+		page, nextPath, found, err := c.getReferrersPage(ctx, ref, manifestDigest, artifactType, path, headers)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			if !firstPage {
+				return nil, errors.Errorf("listing referrers of %s in %s: next page %s not found", manifestDigest.String(), ref.ref.Name(), path)
+			}
+			logrus.Debugf("Referrers API not supported for %s, falling back to the referrers tag schema", ref.ref.Name())
+			index, err := c.getReferrersTagSchemaIndex(ctx, ref, manifestDigest)
+			if err != nil {
+				return nil, err
+			}
+			if index == nil {
+				return []private.Referrer{}, nil
+			}
+			return filterReferrers(index.Manifests, artifactType), nil
+		}
+		res = append(res, page...)
+		path = nextPath
+	}
+	return res, nil
+}
+
+// getReferrersPage reads a single page of the referrers API response for manifestDigest in ref at path,
+// and returns the referrers matching artifactType (if not ""), and the path of the next page ("" if there is none).
+// It returns found == false if the page does not exist.
+func (c *dockerClient) getReferrersPage(ctx context.Context, ref dockerReference, manifestDigest digest.Digest, artifactType, path string, headers map[string][]string) (referrers []private.Referrer, nextPath string, found bool, err error) {
+	resp, err := c.makeRequest(ctx, http.MethodGet, path, headers, nil, v2Auth, nil)
+	if err != nil {
+		return nil, "", false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", false, nil
+	}
+	if err := httpResponseToError(resp, ""); err != nil {
+		return nil, "", false, errors.Wrapf(err, "listing referrers of %s in %s", manifestDigest.String(), ref.ref.Name())
+	}
+	body, err := iolimits.ReadAtMost(resp.Body, iolimits.MaxManifestBodySize)
+	if err != nil {
+		return nil, "", false, err
+	}
+	var index referrersIndex
+	if err := json.Unmarshal(body, &index); err != nil {
+		return nil, "", false, errors.Wrapf(err, "decoding referrers of %s in %s", manifestDigest.String(), ref.ref.Name())
+	}
+	filtersApplied := false
+	for _, f := range strings.Split(resp.Header.Get("OCI-Filters-Applied"), ",") {
+		if strings.TrimSpace(f) == "artifactType" {
+			filtersApplied = true
+		}
+	}
+	if filtersApplied {
+		referrers = index.Manifests
+	} else {
+		referrers = filterReferrers(index.Manifests, artifactType)
+	}
+	nextPath, err = nextPagePath(resp)
+	if err != nil {
+		return nil, "", false, err
+	}
+	return referrers, nextPath, true, nil
+}
+
+// getReferrersTagSchemaIndex loads and parses the referrers tag schema index for manifestDigest in ref.
+// It returns (nil, nil) if the index does not exist.
+func (c *dockerClient) getReferrersTagSchemaIndex(ctx context.Context, ref dockerReference, manifestDigest digest.Digest) (*referrersIndex, error) {
+	tag, err := referrersTagSchemaTag(manifestDigest)
+	if err != nil {
+		return nil, err
+	}
+	path := fmt.Sprintf(manifestPath, reference.Path(ref.ref), tag)
+	headers := map[string][]string{
+		"Accept": {imgspecv1.MediaTypeImageIndex},
+	}
+	res, err := c.makeRequest(ctx, http.MethodGet, path, headers, nil, v2Auth, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		logrus.Debugf("Referrers tag schema index %s:%s does not exist", ref.ref.Name(), tag)
+		return nil, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Wrapf(registryHTTPResponseToError(res), "reading referrers index %s in %s", tag, ref.ref.Name())
+	}
+	body, err := iolimits.ReadAtMost(res.Body, iolimits.MaxManifestBodySize)
+	if err != nil {
+		return nil, err
+	}
+	mimeType := simplifyContentType(res.Header.Get("Content-Type"))
+	if mimeType != imgspecv1.MediaTypeImageIndex {
+		return nil, errors.Errorf("referrers index %s in %s uses unexpected MIME type %q", tag, ref.ref.Name(), mimeType)
+	}
+	var index referrersIndex
+	if err := json.Unmarshal(body, &index); err != nil {
+		return nil, errors.Wrapf(err, "parsing referrers index %s in %s", tag, ref.ref.Name())
+	}
+	if index.Manifests == nil {
+		index.Manifests = []private.Referrer{}
+	}
+	return &index, nil
+}
+
+// getSigstoreAttachmentManifest loads and parses the manifest for sigstore attachments for
+// digest in ref.
+// It returns (nil, nil) if the manifest does not exist.
+func (c *dockerClient) getSigstoreAttachmentManifest(ctx context.Context, ref dockerReference, digest digest.Digest) (*manifest.OCI1, error) {
+	tag, err := sigstoreAttachmentTag(digest)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Debugf("Looking for sigstore attachments in %s:%s", ref.ref.Name(), tag)
+
+	path := fmt.Sprintf(manifestPath, reference.Path(ref.ref), tag)
+	headers := map[string][]string{
+		"Accept": {imgspecv1.MediaTypeImageManifest},
+	}
+	res, err := c.makeRequest(ctx, http.MethodGet, path, headers, nil, v2Auth, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		logrus.Debugf("Sigstore attachment manifest %s:%s does not exist", ref.ref.Name(), tag)
+		return nil, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Wrapf(registryHTTPResponseToError(res), "reading sigstore attachment manifest %s in %s", tag, ref.ref.Name())
+	}
+
+	manifestBlob, err := iolimits.ReadAtMost(res.Body, iolimits.MaxManifestBodySize)
+	if err != nil {
+		return nil, err
+	}
+	mimeType := simplifyContentType(res.Header.Get("Content-Type"))
+	// FIXME: Are we going to need to accept other manifest formats?
+	if manifest.NormalizedMIMEType(mimeType) != imgspecv1.MediaTypeImageManifest {
+		return nil, errors.Errorf("sigstore attachment manifest %s in %s uses unexpected MIME type %q", tag, ref.ref.Name(), mimeType)
+	}
+	parsed, err := manifest.OCI1FromManifest(manifestBlob)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing sigstore attachment manifest %s in %s", tag, ref.ref.Name())
+	}
+	return parsed, nil
+}
+
+// getOCIDescriptorContents returns the contents of a blob specified by desc in ref, which must fit within maxSize.
+func (c *dockerClient) getOCIDescriptorContents(ctx context.Context, ref dockerReference, desc imgspecv1.Descriptor, maxSize int) ([]byte, error) {
+	if err := desc.Digest.Validate(); err != nil { // .Algorithm() might panic without this check
+		return nil, errors.Wrapf(err, "invalid digest %q", desc.Digest.String())
+	}
+	path := fmt.Sprintf(blobsPath, reference.Path(ref.ref), desc.Digest.String())
+	res, err := c.makeRequest(ctx, http.MethodGet, path, nil, nil, v2Auth, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Wrapf(registryHTTPResponseToError(res), "reading blob %s in %s", desc.Digest.String(), ref.ref.Name())
+	}
+	payload, err := iolimits.ReadAtMost(res.Body, maxSize)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading blob %s in %s", desc.Digest.String(), ref.ref.Name())
+	}
+	if !desc.Digest.Algorithm().Available() {
+		return nil, errors.Errorf("unsupported digest algorithm %q", desc.Digest.Algorithm().String())
+	}
+	if desc.Digest.Algorithm().FromBytes(payload) != desc.Digest {
+		return nil, errors.Errorf("blob %s in %s does not match its digest", desc.Digest.String(), ref.ref.Name())
+	}
+	return payload, nil
+}
@@ -2,15 +2,26 @@ package docker
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecs "github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -187,3 +198,271 @@ func TestUserAgent(t *testing.T) {
 		}
 	}
 }
+
+func TestReferrersTagSchemaTag(t *testing.T) {
+	tag, err := referrersTagSchemaTag("sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+	require.NoError(t, err)
+	assert.Equal(t, "sha256-0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", tag)
+
+	_, err = referrersTagSchemaTag("sha256:invalid")
+	assert.Error(t, err)
+}
+
+func TestSigstoreAttachmentTag(t *testing.T) {
+	tag, err := sigstoreAttachmentTag("sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+	require.NoError(t, err)
+	assert.Equal(t, "sha256-0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef.sig", tag)
+
+	_, err = sigstoreAttachmentTag("sha256:invalid")
+	assert.Error(t, err)
+}
+
+func TestDockerClientGetSigstoreAttachments(t *testing.T) {
+	payload := []byte(`{"critical":{}}`)
+	payloadDigest := digest.FromBytes(payload)
+	manifestDigest := digest.FromString("manifest")
+	attachmentTag, err := sigstoreAttachmentTag(manifestDigest)
+	require.NoError(t, err)
+	attachmentManifest, err := json.Marshal(imgspecv1.Manifest{
+		Versioned: imgspecs.Versioned{SchemaVersion: 2},
+		MediaType: imgspecv1.MediaTypeImageManifest,
+		Config: imgspecv1.Descriptor{
+			MediaType: imgspecv1.MediaTypeImageConfig,
+			Digest:    digest.FromString("config"),
+			Size:      6,
+		},
+		Layers: []imgspecv1.Descriptor{{
+			MediaType:   "application/vnd.dev.cosign.simplesigning.v1+json",
+			Digest:      payloadDigest,
+			Size:        int64(len(payload)),
+			Annotations: map[string]string{"dev.cosignproject.cosign/signature": "c2lnbmF0dXJl"},
+		}},
+	})
+	require.NoError(t, err)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case "/v2/repo/manifests/" + attachmentTag:
+			w.Header().Set("Content-Type", imgspecv1.MediaTypeImageManifest)
+			_, err := w.Write(attachmentManifest)
+			assert.NoError(t, err)
+		case "/v2/repo/blobs/" + payloadDigest.String():
+			_, err := w.Write(payload)
+			assert.NoError(t, err)
+		case "/v2/repo/blobs/" + digest.FromString("corrupt").String():
+			_, err := w.Write(payload)
+			assert.NoError(t, err)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+	registry := strings.TrimPrefix(s.URL, "http://")
+	ref := dockerRefFromString(t, "//"+registry+"/repo")
+	c, err := newDockerClient(&types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue}, registry, ref.ref.Name())
+	require.NoError(t, err)
+	err = c.detectProperties(context.Background())
+	require.NoError(t, err)
+
+	// Attachments exist
+	m, err := c.getSigstoreAttachmentManifest(context.Background(), ref, manifestDigest)
+	require.NoError(t, err)
+	require.NotNil(t, m)
+	require.Len(t, m.Layers, 1)
+	contents, err := c.getOCIDescriptorContents(context.Background(), ref, m.Layers[0], 1024)
+	require.NoError(t, err)
+	assert.Equal(t, payload, contents)
+
+	// No attachments
+	m, err = c.getSigstoreAttachmentManifest(context.Background(), ref, digest.FromString("unsigned"))
+	require.NoError(t, err)
+	assert.Nil(t, m)
+
+	// Blob contents do not match the digest
+	_, err = c.getOCIDescriptorContents(context.Background(), ref, imgspecv1.Descriptor{Digest: digest.FromString("corrupt")}, 1024)
+	assert.Error(t, err)
+	// Blob is too large
+	_, err = c.getOCIDescriptorContents(context.Background(), ref, imgspecv1.Descriptor{Digest: payloadDigest}, 1)
+	assert.Error(t, err)
+	// Blob does not exist
+	_, err = c.getOCIDescriptorContents(context.Background(), ref, imgspecv1.Descriptor{Digest: digest.FromString("missing")}, 1024)
+	assert.Error(t, err)
+}
+
+// fakeProxy is a HTTP proxy which forwards all non-CONNECT requests to target, recording the Proxy-Authorization headers it receives.
+type fakeProxy struct {
+	server         *httptest.Server
+	mutex          sync.Mutex
+	authorizations []string
+}
+
+// newFakeProxy returns a new fakeProxy forwarding to target, which will be shut down at the end of t.
+func newFakeProxy(t *testing.T, target string) *fakeProxy {
+	p := &fakeProxy{}
+	p.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodConnect { // Only plain HTTP is supported; this makes the client fall back from HTTPS.
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		p.mutex.Lock()
+		p.authorizations = append(p.authorizations, req.Header.Get("Proxy-Authorization"))
+		p.mutex.Unlock()
+
+		out := req.Clone(req.Context())
+		out.RequestURI = ""
+		out.URL.Host = target
+		out.Header.Del("Proxy-Authorization")
+		res, err := (&http.Transport{}).RoundTrip(out)
+		if !assert.NoError(t, err) {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer res.Body.Close()
+		for k, v := range res.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(res.StatusCode)
+		_, err = io.Copy(w, res.Body)
+		assert.NoError(t, err)
+	}))
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// requestAuthorizations returns the Proxy-Authorization headers of requests forwarded so far.
+func (p *fakeProxy) requestAuthorizations() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]string{}, p.authorizations...)
+}
+
+func TestDockerClientProxy(t *testing.T) {
+	// The registry can only be reached through the proxy: names in the .example TLD never resolve.
+	registry := newFakeRegistryWithRepos(t, "repo")
+	ref := dockerRefFromString(t, "//registry.example/repo:latest")
+	expectedAuthorization := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:password"))
+
+	for _, c := range []struct {
+		name     string
+		conf     string
+		success  bool
+		viaProxy bool
+	}{
+		{"environment", "", false, false},
+		{"proxy", `[[registry]]
+location = "registry.example"
+proxy = "http://user:password@%[1]s"
+`, true, true},
+		{"no-proxy", `[[registry]]
+location = "registry.example"
+proxy = "http://user:password@%[1]s"
+no-proxy = ["example"]
+`, false, false},
+		{"mirror", `[[registry]]
+location = "registry.example"
+proxy = "direct"
+[[registry.mirror]]
+location = "mirror.example"
+proxy = "http://user:password@%[1]s"
+`, true, true},
+		{"direct mirror", `[[registry]]
+location = "registry.example"
+proxy = "http://user:password@%[1]s"
+[[registry.mirror]]
+location = "mirror.example"
+proxy = "direct"
+`, true, true},
+	} {
+		proxy := newFakeProxy(t, registry.host())
+		sys := sigstoreAttachmentsTestSystemContext(t, "registry.example", false)
+		conf := c.conf
+		if conf != "" {
+			conf = fmt.Sprintf(conf, strings.TrimPrefix(proxy.server.URL, "http://"))
+		}
+		err := os.WriteFile(sys.SystemRegistriesConfPath, []byte(conf), 0644)
+		require.NoError(t, err, c.name)
+
+		src, err := newImageSource(context.Background(), sys, ref)
+		if c.success {
+			require.NoError(t, err, c.name)
+			src.Close()
+		} else {
+			assert.Error(t, err, c.name)
+		}
+		authorizations := proxy.requestAuthorizations()
+		if !c.viaProxy {
+			assert.Empty(t, authorizations, c.name)
+		} else {
+			require.NotEmpty(t, authorizations, c.name)
+			for _, a := range authorizations {
+				assert.Equal(t, expectedAuthorization, a, c.name)
+			}
+		}
+	}
+}
+
+func TestDockerClientTLSSettings(t *testing.T) {
+	registry := newFakeRegistryWithRepos(t, "repo")
+	var clientCertMutex sync.Mutex
+	clientCertSubjects := []string{}
+	server := httptest.NewUnstartedServer(registry.server.Config.Handler)
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAnyClientCert,
+		MaxVersion: tls.VersionTLS12,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			cert, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+			clientCertMutex.Lock()
+			defer clientCertMutex.Unlock()
+			clientCertSubjects = append(clientCertSubjects, cert.Subject.CommonName)
+			return nil
+		},
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "https://")
+	ref := dockerRefFromString(t, "//"+host+"/repo:latest")
+
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(caBundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644)
+	require.NoError(t, err)
+	certDir, err := filepath.Abs("../pkg/tlsclientconfig/testdata/full")
+	require.NoError(t, err)
+	clientCert := fmt.Sprintf("client-cert = %q\nclient-key = %q\n", filepath.Join(certDir, "client-cert-1.cert"), filepath.Join(certDir, "client-cert-1.key"))
+
+	for _, c := range []struct {
+		name     string
+		settings string
+		success  bool
+	}{
+		{"no settings", "", false},
+		{"CA bundle only", fmt.Sprintf("ca-bundle = %q\n", caBundle), false},
+		{"client certificate", fmt.Sprintf("ca-bundle = %q\n", caBundle) + clientCert, true},
+		{"minimum version", fmt.Sprintf("ca-bundle = %q\ntls-min-version = \"1.3\"\n", caBundle) + clientCert, false},
+		{"cipher suites", fmt.Sprintf("ca-bundle = %q\ntls-cipher-suites = [\"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256\"]\n", caBundle) + clientCert, true},
+		{"unusable cipher suites", fmt.Sprintf("ca-bundle = %q\ntls-cipher-suites = [\"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256\"]\n", caBundle) + clientCert, false},
+	} {
+		sys := sigstoreAttachmentsTestSystemContext(t, host, false)
+		sys.DockerInsecureSkipTLSVerify = types.OptionalBoolFalse
+		err := os.WriteFile(sys.SystemRegistriesConfPath, []byte(fmt.Sprintf("[[registry]]\nlocation = %q\n%s", host, c.settings)), 0644)
+		require.NoError(t, err, c.name)
+		clientCertMutex.Lock()
+		clientCertSubjects = []string{}
+		clientCertMutex.Unlock()
+
+		src, err := newImageSource(context.Background(), sys, ref)
+		if c.success {
+			require.NoError(t, err, c.name)
+			src.Close()
+			clientCertMutex.Lock()
+			assert.Contains(t, clientCertSubjects, "containers/image test client certificate 1", c.name)
+			clientCertMutex.Unlock()
+		} else {
+			assert.Error(t, err, c.name)
+		}
+	}
+}
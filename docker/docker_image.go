@@ -2,11 +2,9 @@ package docker
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
-	"net/url"
-	"strings"
 
 	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/image"
@@ -54,54 +52,22 @@ func (i *Image) GetRepositoryTags(ctx context.Context) ([]string, error) {
 // GetRepositoryTags list all tags available in the repository. The tag
 // provided inside the ImageReference will be ignored.
 func GetRepositoryTags(ctx context.Context, sys *types.SystemContext, ref types.ImageReference) ([]string, error) {
-	dr, ok := ref.(dockerReference)
-	if !ok {
-		return nil, errors.Errorf("ref must be a dockerReference")
-	}
-
-	path := fmt.Sprintf(tagsPath, reference.Path(dr.ref))
-	client, err := newDockerClientFromRef(sys, dr, false, "pull")
+	lister, err := NewTagLister(sys, ref, nil)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create client")
+		return nil, err
 	}
 
 	tags := make([]string, 0)
-
 	for {
-		res, err := client.makeRequest(ctx, http.MethodGet, path, nil, nil, v2Auth, nil)
-		if err != nil {
-			return nil, err
-		}
-		defer res.Body.Close()
-		if err := httpResponseToError(res, "fetching tags list"); err != nil {
-			return nil, err
-		}
-
-		var tagsHolder struct {
-			Tags []string
-		}
-		if err = json.NewDecoder(res.Body).Decode(&tagsHolder); err != nil {
-			return nil, err
-		}
-		tags = append(tags, tagsHolder.Tags...)
-
-		link := res.Header.Get("Link")
-		if link == "" {
+		page, err := lister.NextPage(ctx)
+		if err == io.EOF {
 			break
 		}
-
-		linkURLStr := strings.Trim(strings.Split(link, ";")[0], "<>")
-		linkURL, err := url.Parse(linkURLStr)
 		if err != nil {
-			return tags, err
+			return nil, err
 		}
-
-		// can be relative or absolute, but we only want the path (and I
-		// guess we're in trouble if it forwards to a new place...)
-		path = linkURL.Path
-		if linkURL.RawQuery != "" {
-			path += "?"
-			path += linkURL.RawQuery
+		for _, tag := range page {
+			tags = append(tags, tag.Name)
 		}
 	}
 	return tags, nil
@@ -129,19 +95,24 @@ func GetDigest(ctx context.Context, sys *types.SystemContext, ref types.ImageRef
 		return "", errors.Wrap(err, "failed to create client")
 	}
 
-	path := fmt.Sprintf(manifestPath, reference.Path(dr.ref), tagOrDigest)
+	return client.getManifestDigest(ctx, dr, tagOrDigest)
+}
+
+// getManifestDigest returns the digest of the manifest tagOrDigest in the repository of ref, using a HEAD request.
+func (c *dockerClient) getManifestDigest(ctx context.Context, ref dockerReference, tagOrDigest string) (digest.Digest, error) {
+	path := fmt.Sprintf(manifestPath, reference.Path(ref.ref), tagOrDigest)
 	headers := map[string][]string{
 		"Accept": manifest.DefaultRequestedManifestMIMETypes,
 	}
 
-	res, err := client.makeRequest(ctx, http.MethodHead, path, headers, nil, v2Auth, nil)
+	res, err := c.makeRequest(ctx, http.MethodHead, path, headers, nil, v2Auth, nil)
 	if err != nil {
 		return "", err
 	}
 
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return "", errors.Wrapf(registryHTTPResponseToError(res), "reading digest %s in %s", tagOrDigest, dr.ref.Name())
+		return "", errors.Wrapf(registryHTTPResponseToError(res), "reading digest %s in %s", tagOrDigest, ref.ref.Name())
 	}
 
 	dig, err := digest.Parse(res.Header.Get("Docker-Content-Digest"))
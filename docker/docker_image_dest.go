@@ -11,14 +11,20 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/internal/bandwidth"
 	"github.com/containers/image/v5/internal/blobinfocache"
+	"github.com/containers/image/v5/internal/iolimits"
+	"github.com/containers/image/v5/internal/private"
 	"github.com/containers/image/v5/internal/putblobdigest"
+	"github.com/containers/image/v5/internal/signature"
 	"github.com/containers/image/v5/internal/streamdigest"
 	"github.com/containers/image/v5/internal/uploadreader"
 	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
 	"github.com/containers/image/v5/types"
 	"github.com/docker/distribution/registry/api/errcode"
 	v2 "github.com/docker/distribution/registry/api/v2"
@@ -122,6 +128,18 @@ func (d *dockerImageDestination) HasThreadSafePutBlob() bool {
 	return true
 }
 
+// SupportsPutBlobPartial returns true if PutBlobPartial is supported.
+func (d *dockerImageDestination) SupportsPutBlobPartial() bool {
+	return false
+}
+
+// PutBlobPartial attempts to create a blob using the data that is already present
+// at the destination. chunkAccessor is accessed in a non-sequential way to retrieve the missing chunks.
+// It is available only if SupportsPutBlobPartial().
+func (d *dockerImageDestination) PutBlobPartial(ctx context.Context, chunkAccessor private.BlobChunkAccessor, srcInfo types.BlobInfo, cache types.BlobInfoCache) (types.BlobInfo, error) {
+	return types.BlobInfo{}, errors.New("internal error: PutBlobPartial is not supported by the docker transport")
+}
+
 // PutBlob writes contents of stream and returns data representing the result (with all data filled in).
 // inputInfo.Digest can be optionally provided if known; if provided, and stream is read to the end without error, the digest MUST match the stream contents.
 // inputInfo.Size is the expected length of stream, if known.
@@ -130,6 +148,21 @@ func (d *dockerImageDestination) HasThreadSafePutBlob() bool {
 // to any other readers for download using the supplied digest.
 // If stream.Read() at any time, ESPECIALLY at end of input, returns an error, PutBlob MUST 1) fail, and 2) delete any data stored so far.
 func (d *dockerImageDestination) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, cache types.BlobInfoCache, isConfig bool) (types.BlobInfo, error) {
+	return d.PutBlobWithOptions(ctx, stream, inputInfo, private.PutBlobOptions{
+		Cache:    cache,
+		IsConfig: isConfig,
+	})
+}
+
+// PutBlobWithOptions writes contents of stream and returns data representing the result.
+// inputInfo.Digest can be optionally provided if known; if provided, and stream is read to the end without error, the digest MUST match the stream contents.
+// inputInfo.Size is the expected length of stream, if known.
+// inputInfo.MediaType describes the blob format, if known.
+// WARNING: The contents of stream are being verified on the fly.  Until stream.Read() returns io.EOF, the contents of the data SHOULD NOT be available
+// to any other readers for download using the supplied digest.
+// If stream.Read() at any time, ESPECIALLY at end of input, returns an error, PutBlob MUST 1) fail, and 2) delete any data stored so far.
+func (d *dockerImageDestination) PutBlobWithOptions(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, options private.PutBlobOptions) (types.BlobInfo, error) {
+	cache := options.Cache
 	// If requested, precompute the blob digest to prevent uploading layers that already exist on the registry.
 	// This functionality is particularly useful when BlobInfoCache has not been populated with compressed digests,
 	// the source blob is uncompressed, and the destination blob is being compressed "on the fly".
@@ -155,7 +188,7 @@ func (d *dockerImageDestination) PutBlob(ctx context.Context, stream io.Reader,
 		}
 	}
 
-	// FIXME? Chunked upload, progress reporting, etc.
+	// FIXME? Progress reporting, etc.
 	uploadPath := fmt.Sprintf(blobUploadPath, reference.Path(d.ref.ref))
 	logrus.Debugf("Uploading %s", uploadPath)
 	res, err := d.c.makeRequest(ctx, http.MethodPost, uploadPath, nil, nil, v2Auth, nil)
@@ -175,27 +208,19 @@ func (d *dockerImageDestination) PutBlob(ctx context.Context, stream io.Reader,
 	digester, stream := putblobdigest.DigestIfCanonicalUnknown(stream, inputInfo)
 	sizeCounter := &sizeCounter{}
 	stream = io.TeeReader(stream, sizeCounter)
+	// Limit the upload throughput, if a bandwidth limit is configured for the registry.
+	stream = bandwidth.NewReader(ctx, stream, d.c.bandwidthLimiter)
 
-	uploadLocation, err = func() (*url.URL, error) { // A scope for defer
-		uploadReader := uploadreader.NewUploadReader(stream)
-		// This error text should never be user-visible, we terminate only after makeRequestToResolvedURL
-		// returns, so there isn’t a way for the error text to be provided to any of our callers.
-		defer uploadReader.Terminate(errors.New("Reading data from an already terminated upload"))
-		res, err = d.c.makeRequestToResolvedURL(ctx, http.MethodPatch, uploadLocation, map[string][]string{"Content-Type": {"application/octet-stream"}}, uploadReader, inputInfo.Size, v2Auth, nil)
-		if err != nil {
-			logrus.Debugf("Error uploading layer chunked %v", err)
-			return nil, err
-		}
-		defer res.Body.Close()
-		if !successStatus(res.StatusCode) {
-			return nil, errors.Wrapf(registryHTTPResponseToError(res), "uploading layer chunked")
-		}
-		uploadLocation, err := res.Location()
-		if err != nil {
-			return nil, errors.Wrap(err, "determining upload URL")
+	if d.c.sys != nil && d.c.sys.DockerRegistryPushChunkSize > 0 {
+		chunkSize := d.c.sys.DockerRegistryPushChunkSize
+		if minLength, err := strconv.ParseInt(res.Header.Get("OCI-Chunk-Min-Length"), 10, 64); err == nil && minLength > chunkSize {
+			logrus.Debugf("Using chunk size %d required by the registry", minLength)
+			chunkSize = minLength
 		}
-		return uploadLocation, nil
-	}()
+		uploadLocation, err = d.uploadBlobChunked(ctx, uploadLocation, stream, chunkSize)
+	} else {
+		uploadLocation, err = d.uploadBlobMonolithic(ctx, uploadLocation, stream, inputInfo.Size)
+	}
 	if err != nil {
 		return types.BlobInfo{}, err
 	}
@@ -221,6 +246,29 @@ func (d *dockerImageDestination) PutBlob(ctx context.Context, stream io.Reader,
 	return types.BlobInfo{Digest: blobDigest, Size: sizeCounter.size}, nil
 }
 
+// uploadBlobMonolithic uploads all of stream, with the expected size (or -1 if unknown), to uploadLocation in a single request,
+// and returns the location to use for finishing the upload.
+func (d *dockerImageDestination) uploadBlobMonolithic(ctx context.Context, uploadLocation *url.URL, stream io.Reader, size int64) (*url.URL, error) {
+	uploadReader := uploadreader.NewUploadReader(stream)
+	// This error text should never be user-visible, we terminate only after makeRequestToResolvedURL
+	// returns, so there isn’t a way for the error text to be provided to any of our callers.
+	defer uploadReader.Terminate(errors.New("Reading data from an already terminated upload"))
+	res, err := d.c.makeRequestToResolvedURL(ctx, http.MethodPatch, uploadLocation, map[string][]string{"Content-Type": {"application/octet-stream"}}, uploadReader, size, v2Auth, nil)
+	if err != nil {
+		logrus.Debugf("Error uploading layer chunked %v", err)
+		return nil, err
+	}
+	defer res.Body.Close()
+	if !successStatus(res.StatusCode) {
+		return nil, errors.Wrapf(registryHTTPResponseToError(res), "uploading layer chunked")
+	}
+	uploadLocation, err = res.Location()
+	if err != nil {
+		return nil, errors.Wrap(err, "determining upload URL")
+	}
+	return uploadLocation, nil
+}
+
 // blobExists returns true iff repo contains a blob with digest, and if so, also its size.
 // If the destination does not contain the blob, or it is unknown, blobExists ordinarily returns (false, -1, nil);
 // it returns a non-nil error only on an unexpected failure.
@@ -248,13 +296,19 @@ func (d *dockerImageDestination) blobExists(ctx context.Context, repo reference.
 }
 
 // mountBlob tries to mount blob srcDigest from srcRepo to the current destination.
+// If srcRepo is nil, the registry is asked to find the blob in any repository.
 func (d *dockerImageDestination) mountBlob(ctx context.Context, srcRepo reference.Named, srcDigest digest.Digest, extraScope *authScope) error {
+	query := url.Values{
+		"mount": {srcDigest.String()},
+	}
+	srcName := "any repository"
+	if srcRepo != nil {
+		query.Set("from", reference.Path(srcRepo))
+		srcName = srcRepo.Name()
+	}
 	u := url.URL{
-		Path: fmt.Sprintf(blobUploadPath, reference.Path(d.ref.ref)),
-		RawQuery: url.Values{
-			"mount": {srcDigest.String()},
-			"from":  {reference.Path(srcRepo)},
-		}.Encode(),
+		Path:     fmt.Sprintf(blobUploadPath, reference.Path(d.ref.ref)),
+		RawQuery: query.Encode(),
 	}
 	logrus.Debugf("Trying to mount %s", u.Redacted())
 	res, err := d.c.makeRequest(ctx, http.MethodPost, u.String(), nil, nil, v2Auth, extraScope)
@@ -285,10 +339,10 @@ func (d *dockerImageDestination) mountBlob(ctx context.Context, srcRepo referenc
 			}
 		}
 		// Anyway, if canceling the upload fails, ignore it and return the more important error:
-		return fmt.Errorf("Mounting %s from %s to %s started an upload instead", srcDigest, srcRepo.Name(), d.ref.ref.Name())
+		return fmt.Errorf("Mounting %s from %s to %s started an upload instead", srcDigest, srcName, d.ref.ref.Name())
 	default:
 		logrus.Debugf("Error mounting, response %#v", *res)
-		return errors.Wrapf(registryHTTPResponseToError(res), "mounting %s from %s to %s", srcDigest, srcRepo.Name(), d.ref.ref.Name())
+		return errors.Wrapf(registryHTTPResponseToError(res), "mounting %s from %s to %s", srcDigest, srcName, d.ref.ref.Name())
 	}
 }
 
@@ -317,6 +371,22 @@ func (d *dockerImageDestination) tryReusingExactBlob(ctx context.Context, info t
 // If the transport can not reuse the requested blob, TryReusingBlob returns (false, {}, nil); it returns a non-nil error only on an unexpected failure.
 // May use and/or update cache.
 func (d *dockerImageDestination) TryReusingBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache, canSubstitute bool) (bool, types.BlobInfo, error) {
+	return d.TryReusingBlobWithOptions(ctx, info, private.TryReusingBlobOptions{
+		Cache:         cache,
+		CanSubstitute: canSubstitute,
+	})
+}
+
+// TryReusingBlobWithOptions checks whether the transport already contains, or can efficiently reuse, a blob, and if so, applies it to the current destination
+// (e.g. if the blob is a filesystem layer, this signifies that the changes it describes need to be applied again when composing a filesystem tree).
+// info.Digest must not be empty.
+// If the blob has been successfully reused, returns (true, info, nil); info must contain at least a digest and size, and may
+// include CompressionOperation and CompressionAlgorithm fields to indicate that a change to the compression type should be
+// reflected in the manifest that will be written.
+// If the transport can not reuse the requested blob, TryReusingBlob returns (false, {}, nil); it returns a non-nil error only on an unexpected failure.
+func (d *dockerImageDestination) TryReusingBlobWithOptions(ctx context.Context, info types.BlobInfo, options private.TryReusingBlobOptions) (bool, types.BlobInfo, error) {
+	cache := options.Cache
+	canSubstitute := options.CanSubstitute
 	if info.Digest == "" {
 		return false, types.BlobInfo{}, errors.Errorf(`"Can not check for a blob with unknown digest`)
 	}
@@ -329,10 +399,14 @@ func (d *dockerImageDestination) TryReusingBlob(ctx context.Context, info types.
 	if haveBlob {
 		return true, reusedInfo, nil
 	}
+	if options.NoCrossRepositoryReuse {
+		return false, types.BlobInfo{}, nil
+	}
 
 	// Then try reusing blobs from other locations.
 	bic := blobinfocache.FromBlobInfoCache(cache)
 	candidates := bic.CandidateLocations2(d.ref.Transport(), bicTransportScope(d.ref), info.Digest, canSubstitute)
+	candidates = append(candidates, d.explicitMountCandidates(info.Digest, candidates)...)
 	for _, candidate := range candidates {
 		candidateRepo, err := parseBICLocationReference(candidate.Location)
 		if err != nil {
@@ -360,8 +434,9 @@ func (d *dockerImageDestination) TryReusingBlob(ctx context.Context, info types.
 		// Checking candidateRepo, and mounting from it, requires an
 		// expanded token scope.
 		extraScope := &authScope{
-			remoteName: reference.Path(candidateRepo),
-			actions:    "pull",
+			resourceType: "repository",
+			remoteName:   reference.Path(candidateRepo),
+			actions:      "pull",
 		}
 		// This existence check is not, strictly speaking, necessary: We only _really_ need it to get the blob size, and we could record that in the cache instead.
 		// But a "failed" d.mountBlob currently leaves around an unterminated server-side upload, which we would try to cancel.
@@ -387,6 +462,8 @@ func (d *dockerImageDestination) TryReusingBlob(ctx context.Context, info types.
 		}
 
 		bic.RecordKnownLocation(d.ref.Transport(), bicTransportScope(d.ref), candidate.Digest, newBICLocationReference(d.ref))
+		// candidate.Location may not be in the cache yet if it comes from explicitMountCandidates.
+		bic.RecordKnownLocation(d.ref.Transport(), bicTransportScope(d.ref), candidate.Digest, candidate.Location)
 
 		compressionOperation, compressionAlgorithm, err := blobinfocache.OperationAndAlgorithmForCompressor(candidate.CompressorName)
 		if err != nil {
@@ -394,12 +471,63 @@ func (d *dockerImageDestination) TryReusingBlob(ctx context.Context, info types.
 			continue
 		}
 
+		if options.ReportReusedFrom != nil && candidateRepo.Name() != d.ref.ref.Name() {
+			options.ReportReusedFrom(candidateRepo.Name())
+		}
+
 		return true, types.BlobInfo{Digest: candidate.Digest, MediaType: info.MediaType, Size: size, CompressionOperation: compressionOperation, CompressionAlgorithm: compressionAlgorithm}, nil
 	}
 
+	// Finally, ask the registry to find the blob on its own, if allowed.
+	if d.c.sys != nil && d.c.sys.DockerRegistryMountWithoutSource {
+		if err := d.mountBlob(ctx, nil, info.Digest, nil); err != nil {
+			logrus.Debugf("... Mount failed: %v", err)
+			return false, types.BlobInfo{}, nil
+		}
+		// The response to a mount does not include the blob size.
+		return d.tryReusingExactBlob(ctx, info, cache)
+	}
+
 	return false, types.BlobInfo{}, nil
 }
 
+// explicitMountCandidates returns candidates for mounting blobDigest from repositories in d.c.sys.DockerRegistryMountSourceRepositories,
+// other than d.ref and those already included in existing.
+func (d *dockerImageDestination) explicitMountCandidates(blobDigest digest.Digest, existing []blobinfocache.BICReplacementCandidate2) []blobinfocache.BICReplacementCandidate2 {
+	if d.c.sys == nil {
+		return nil
+	}
+	known := map[types.BICLocationReference]struct{}{}
+	for _, c := range existing {
+		if c.Digest == blobDigest {
+			known[c.Location] = struct{}{}
+		}
+	}
+	res := []blobinfocache.BICReplacementCandidate2{}
+	for _, repo := range d.c.sys.DockerRegistryMountSourceRepositories {
+		named, err := reference.ParseNormalizedNamed(repo)
+		if err != nil {
+			logrus.Debugf("Ignoring invalid mount source repository %q: %v", repo, err)
+			continue
+		}
+		named = reference.TrimNamed(named)
+		if reference.Domain(named) != reference.Domain(d.ref.ref) || named.Name() == d.ref.ref.Name() {
+			continue
+		}
+		location := types.BICLocationReference{Opaque: named.Name()}
+		if _, ok := known[location]; ok {
+			continue
+		}
+		known[location] = struct{}{}
+		res = append(res, blobinfocache.BICReplacementCandidate2{
+			Digest:         blobDigest,
+			CompressorName: blobinfocache.UnknownCompression,
+			Location:       location,
+		})
+	}
+	return res
+}
+
 // PutManifest writes manifest to the destination.
 // When the primary manifest is a manifest list, if instanceDigest is nil, we're saving the list
 // itself, else instanceDigest contains a digest of the specific manifest instance to overwrite the
@@ -442,7 +570,21 @@ func (d *dockerImageDestination) PutManifest(ctx context.Context, m []byte, inst
 		}
 	}
 
-	path := fmt.Sprintf(manifestPath, reference.Path(d.ref.ref), refTail)
+	subjectProcessed, err := d.uploadManifest(ctx, m, refTail)
+	if err != nil {
+		return err
+	}
+	if !subjectProcessed {
+		return d.addToReferrersTagSchemaIndex(ctx, m)
+	}
+	return nil
+}
+
+// uploadManifest writes manifest to tagOrDigest.
+// It returns true if the registry has indicated (using the OCI-Subject header) that it has processed the "subject" field of the manifest,
+// i.e. that the manifest is available using the referrers API.
+func (d *dockerImageDestination) uploadManifest(ctx context.Context, m []byte, tagOrDigest string) (bool, error) {
+	path := fmt.Sprintf(manifestPath, reference.Path(d.ref.ref), tagOrDigest)
 
 	headers := map[string][]string{}
 	mimeType := manifest.GuessMIMEType(m)
@@ -451,16 +593,16 @@ func (d *dockerImageDestination) PutManifest(ctx context.Context, m []byte, inst
 	}
 	res, err := d.c.makeRequest(ctx, http.MethodPut, path, headers, bytes.NewReader(m), v2Auth, nil)
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer res.Body.Close()
 	if !successStatus(res.StatusCode) {
 		rawErr := registryHTTPResponseToError(res)
-		err := errors.Wrapf(rawErr, "uploading manifest %s to %s", refTail, d.ref.ref.Name())
+		err := errors.Wrapf(rawErr, "uploading manifest %s to %s", tagOrDigest, d.ref.ref.Name())
 		if isManifestInvalidError(rawErr) {
 			err = types.ManifestTypeRejectedError{Err: err}
 		}
-		return err
+		return false, err
 	}
 	// A HTTP server may not be a registry at all, and just return 200 OK to everything
 	// (in particular that can fairly easily happen after tearing down a website and
@@ -474,7 +616,74 @@ func (d *dockerImageDestination) PutManifest(ctx context.Context, m []byte, inst
 	if v := res.Header.Values("Docker-Content-Digest"); len(v) == 0 {
 		logrus.Debugf("Manifest upload response didn’t contain a Docker-Content-Digest header, it might not be a container registry")
 	}
-	return nil
+	return res.Header.Get("OCI-Subject") != "", nil
+}
+
+// referrerManifestFields are the fields of an OCI manifest relevant to the referrers API.
+type referrerManifestFields struct {
+	MediaType    string `json:"mediaType"`
+	ArtifactType string `json:"artifactType"`
+	Config       struct {
+		MediaType string `json:"mediaType"`
+	} `json:"config"`
+	Subject     *imgspecv1.Descriptor `json:"subject"`
+	Annotations map[string]string     `json:"annotations"`
+}
+
+// addToReferrersTagSchemaIndex adds m, if it has a "subject" field, to the referrers tag schema index for the subject,
+// for registries which don’t support the referrers API.
+// NOTE: The index is updated using a read-modify-write cycle; concurrent updates of the same index may lose data.
+func (d *dockerImageDestination) addToReferrersTagSchemaIndex(ctx context.Context, m []byte) error {
+	var fields referrerManifestFields
+	if err := json.Unmarshal(m, &fields); err != nil {
+		return errors.Wrap(err, "parsing manifest to look for a subject")
+	}
+	if fields.Subject == nil {
+		return nil
+	}
+	manifestDigest, err := manifest.Digest(m)
+	if err != nil {
+		return err
+	}
+	referrer := private.Referrer{
+		MediaType:    fields.MediaType,
+		Digest:       manifestDigest,
+		Size:         int64(len(m)),
+		ArtifactType: fields.ArtifactType,
+		Annotations:  fields.Annotations,
+	}
+	if referrer.MediaType == "" {
+		referrer.MediaType = manifest.GuessMIMEType(m)
+	}
+	if referrer.ArtifactType == "" {
+		referrer.ArtifactType = fields.Config.MediaType
+	}
+
+	index, err := d.c.getReferrersTagSchemaIndex(ctx, d.ref, fields.Subject.Digest)
+	if err != nil {
+		return err
+	}
+	if index == nil {
+		index = newReferrersIndex()
+	}
+	for _, r := range index.Manifests {
+		if r.Digest == referrer.Digest {
+			logrus.Debugf("Referrer %s is already listed in the referrers index of %s", referrer.Digest.String(), fields.Subject.Digest.String())
+			return nil
+		}
+	}
+	index.Manifests = append(index.Manifests, referrer)
+	indexBlob, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	tag, err := referrersTagSchemaTag(fields.Subject.Digest)
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("Uploading referrers index %s", tag)
+	_, err = d.uploadManifest(ctx, indexBlob, tag)
+	return err
 }
 
 // successStatus returns true if the argument is a successful HTTP response
@@ -530,17 +739,47 @@ func (d *dockerImageDestination) PutSignatures(ctx context.Context, signatures [
 		instanceDigest = &d.manifestDigest
 	}
 
+	var simpleSigs [][]byte
+	var sigstoreSigs []signature.Sigstore
+	for _, sig := range signatures {
+		parsed, err := signature.FromBlob(sig)
+		if err != nil {
+			return err
+		}
+		switch parsed := parsed.(type) {
+		case signature.SimpleSigning:
+			simpleSigs = append(simpleSigs, parsed.UntrustedSignature())
+		case signature.Sigstore:
+			sigstoreSigs = append(sigstoreSigs, parsed)
+		default:
+			return signature.UnsupportedFormatError(parsed)
+		}
+	}
+	if len(sigstoreSigs) != 0 && !d.c.useSigstoreAttachments {
+		return errors.New("writing sigstore attachments is disabled by configuration")
+	}
+
 	if err := d.c.detectProperties(ctx); err != nil {
 		return err
 	}
 	switch {
 	case d.c.supportsSignatures:
-		return d.putSignaturesToAPIExtension(ctx, signatures, *instanceDigest)
+		if err := d.putSignaturesToAPIExtension(ctx, simpleSigs, *instanceDigest); err != nil {
+			return err
+		}
 	case d.c.signatureBase != nil:
-		return d.putSignaturesToLookaside(signatures, *instanceDigest)
+		if err := d.putSignaturesToLookaside(simpleSigs, *instanceDigest); err != nil {
+			return err
+		}
 	default:
 		return errors.Errorf("Internal error: X-Registry-Supports-Signatures extension not supported, and lookaside should not be empty configuration")
 	}
+	if len(sigstoreSigs) != 0 {
+		if err := d.putSignaturesToSigstoreAttachments(ctx, sigstoreSigs, *instanceDigest); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // putSignaturesToLookaside implements PutSignatures() from the lookaside location configured in s.c.signatureBase,
@@ -581,6 +820,126 @@ func (d *dockerImageDestination) putSignaturesToLookaside(signatures [][]byte, m
 	return nil
 }
 
+// putSignaturesToSigstoreAttachments implements PutSignatures() using the sigstore attachment ("sha256-….sig" tag),
+// for a manifest with manifestDigest. Signatures which already exist in the attachment are not duplicated.
+func (d *dockerImageDestination) putSignaturesToSigstoreAttachments(ctx context.Context, signatures []signature.Sigstore, manifestDigest digest.Digest) error {
+	ociManifest, err := d.c.getSigstoreAttachmentManifest(ctx, d.ref, manifestDigest)
+	if err != nil {
+		return err
+	}
+	var ociConfig imgspecv1.Image
+	if ociManifest == nil {
+		ociManifest = manifest.OCI1FromComponents(imgspecv1.Descriptor{
+			MediaType: imgspecv1.MediaTypeImageConfig,
+			Digest:    "", // We will fill this in later.
+			Size:      0,
+		}, nil)
+		ociConfig.RootFS.Type = "layers"
+	} else {
+		logrus.Debugf("Fetching sigstore attachment config %s", ociManifest.Config.Digest.String())
+		configBlob, err := d.c.getOCIDescriptorContents(ctx, d.ref, ociManifest.Config, iolimits.MaxConfigBodySize)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(configBlob, &ociConfig); err != nil {
+			return errors.Wrapf(err, "parsing sigstore attachment config %s in %s", ociManifest.Config.Digest.String(), d.ref.ref.Name())
+		}
+	}
+
+	addedSignatures := false
+	for _, sig := range signatures {
+		mimeType := sig.UntrustedMIMEType()
+		payloadBlob := sig.UntrustedPayload()
+		annotations := sig.UntrustedAnnotations()
+
+		alreadyOnRegistry := false
+		for _, layer := range ociManifest.Layers {
+			if layerMatchesSigstoreSignature(layer, mimeType, payloadBlob, annotations) {
+				logrus.Debugf("Signature with digest %s already exists on the registry", layer.Digest.String())
+				alreadyOnRegistry = true
+				break
+			}
+		}
+		if alreadyOnRegistry {
+			continue
+		}
+
+		sigDesc, err := d.putBlobBytesAsOCI(ctx, payloadBlob, mimeType, false)
+		if err != nil {
+			return err
+		}
+		sigDesc.Annotations = annotations
+		logrus.Debugf("Adding new signature, digest %s", sigDesc.Digest.String())
+		ociManifest.Layers = append(ociManifest.Layers, sigDesc)
+		ociConfig.RootFS.DiffIDs = append(ociConfig.RootFS.DiffIDs, sigDesc.Digest)
+		addedSignatures = true
+	}
+	if !addedSignatures {
+		return nil
+	}
+
+	configBlob, err := json.Marshal(ociConfig)
+	if err != nil {
+		return err
+	}
+	configDesc, err := d.putBlobBytesAsOCI(ctx, configBlob, imgspecv1.MediaTypeImageConfig, true)
+	if err != nil {
+		return err
+	}
+	ociManifest.Config = configDesc
+
+	manifestBlob, err := ociManifest.Serialize()
+	if err != nil {
+		return err
+	}
+	attachmentTag, err := sigstoreAttachmentTag(manifestDigest)
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("Uploading sigstore attachment manifest %s", attachmentTag)
+	_, err = d.uploadManifest(ctx, manifestBlob, attachmentTag)
+	return err
+}
+
+// layerMatchesSigstoreSignature returns true if layer contains a sigstore signature with the specified components.
+func layerMatchesSigstoreSignature(layer imgspecv1.Descriptor, mimeType string,
+	payloadBlob []byte, annotations map[string]string) bool {
+	if layer.MediaType != mimeType ||
+		layer.Size != int64(len(payloadBlob)) ||
+		// Layers using a different digest algorithm are never matched; at worst, this creates a duplicate entry.
+		layer.Digest != digest.FromBytes(payloadBlob) ||
+		len(layer.Annotations) != len(annotations) {
+		return false
+	}
+	for k, v := range annotations {
+		if layerValue, ok := layer.Annotations[k]; !ok || layerValue != v {
+			return false
+		}
+	}
+	// Ignore layer.URLs and layer.Platform, we don’t use them for signatures.
+	return true
+}
+
+// putBlobBytesAsOCI uploads a blob with the specified contents, and returns an appropriate
+// OCI descriptor.
+func (d *dockerImageDestination) putBlobBytesAsOCI(ctx context.Context, contents []byte, mimeType string, isConfig bool) (imgspecv1.Descriptor, error) {
+	blobDigest := digest.FromBytes(contents)
+	info, err := d.PutBlob(ctx, bytes.NewReader(contents),
+		types.BlobInfo{
+			Digest:    blobDigest,
+			Size:      int64(len(contents)),
+			MediaType: mimeType,
+		}, none.NoCache, isConfig)
+	if err != nil {
+		return imgspecv1.Descriptor{}, errors.Wrapf(err, "writing blob %s", blobDigest.String())
+	}
+	return imgspecv1.Descriptor{
+		MediaType: mimeType,
+		Digest:    info.Digest,
+		Size:      info.Size,
+	}, nil
+}
+
 // putOneSignature stores one signature to url.
 // NOTE: Keep this in sync with docs/signature-protocols.md!
 func (d *dockerImageDestination) putOneSignature(url *url.URL, signature []byte) error {
@@ -3,13 +3,28 @@ package docker
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/containers/image/v5/internal/blobinfocache"
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/internal/signature"
+	"github.com/containers/image/v5/pkg/blobinfocache/memory"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+var _ private.ImageDestination = (*dockerImageDestination)(nil)
+
 func TestIsManifestInvalidError(t *testing.T) {
 	// Sadly only a smoke test; this really should record all known errors exactly as they happen.
 
@@ -30,3 +45,392 @@ func TestIsManifestInvalidError(t *testing.T) {
 	res := isManifestInvalidError(err)
 	assert.True(t, res, "%#v", err)
 }
+
+// sigstoreAttachmentsTestSystemContext returns a SystemContext for accessing registry, with sigstore attachments
+// enabled if useSigstoreAttachments.
+func sigstoreAttachmentsTestSystemContext(t *testing.T, registry string, useSigstoreAttachments bool) *types.SystemContext {
+	registriesDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(registriesDir, "test.yaml"),
+		[]byte(fmt.Sprintf("docker:\n  %s:\n    use-sigstore-attachments: %t\n", registry, useSigstoreAttachments)), 0644)
+	require.NoError(t, err)
+	registriesConf := filepath.Join(t.TempDir(), "registries.conf")
+	err = os.WriteFile(registriesConf, []byte{}, 0644)
+	require.NoError(t, err)
+	return &types.SystemContext{
+		RegistriesDirPath:           registriesDir,
+		SystemRegistriesConfPath:    registriesConf,
+		SystemRegistriesConfDirPath: "/this/does/not/exist",
+		AuthFilePath:                "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+	}
+}
+
+func TestPutSignaturesToSigstoreAttachments(t *testing.T) {
+	registry := newFakeRegistry(t)
+	ref := dockerRefFromString(t, "//"+registry.host()+"/repo:tag")
+	manifestBlob := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:0000000000000000000000000000000000000000000000000000000000000000","size":1},"layers":[]}`)
+	manifestDigest := digest.FromBytes(manifestBlob)
+	attachmentTag, err := sigstoreAttachmentTag(manifestDigest)
+	require.NoError(t, err)
+
+	sigBlob := func(payload, base64Sig string) []byte {
+		res, err := signature.Blob(signature.SigstoreFromComponents(signature.SigstoreSignatureMIMEType, []byte(payload),
+			map[string]string{signature.SigstoreSignatureAnnotationKey: base64Sig}))
+		require.NoError(t, err)
+		return res
+	}
+	sig1 := sigBlob("payload 1", "c2lnbmF0dXJlIDE=")
+	sig2 := sigBlob("payload 2", "c2lnbmF0dXJlIDI=")
+
+	// Writing sigstore signatures must be enabled
+	sys := sigstoreAttachmentsTestSystemContext(t, registry.host(), false)
+	dest, err := newImageDestination(sys, ref)
+	require.NoError(t, err)
+	defer dest.Close()
+	err = dest.PutSignatures(context.Background(), [][]byte{sig1}, &manifestDigest)
+	assert.Error(t, err)
+
+	sys = sigstoreAttachmentsTestSystemContext(t, registry.host(), true)
+	dest, err = newImageDestination(sys, ref)
+	require.NoError(t, err)
+	defer dest.Close()
+	err = dest.PutManifest(context.Background(), manifestBlob, nil)
+	require.NoError(t, err)
+	src, err := newImageSource(context.Background(), sys, ref)
+	require.NoError(t, err)
+	defer src.Close()
+
+	// No attachments exist yet
+	sigs, err := src.GetSignatures(context.Background(), &manifestDigest)
+	require.NoError(t, err)
+	assert.Empty(t, sigs)
+
+	// Creating the attachment
+	err = dest.PutSignatures(context.Background(), [][]byte{sig1}, &manifestDigest)
+	require.NoError(t, err)
+	sigs, err = src.GetSignatures(context.Background(), &manifestDigest)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{sig1}, sigs)
+	attachment, ok := registry.manifest("repo", attachmentTag)
+	require.True(t, ok)
+	assert.Equal(t, imgspecv1.MediaTypeImageManifest, attachment.mimeType)
+
+	// Adding a signature, with an existing one not duplicated
+	err = dest.PutSignatures(context.Background(), [][]byte{sig1, sig2}, &manifestDigest)
+	require.NoError(t, err)
+	sigs, err = src.GetSignatures(context.Background(), &manifestDigest)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{sig1, sig2}, sigs)
+	attachment, ok = registry.manifest("repo", attachmentTag)
+	require.True(t, ok)
+	var parsed imgspecv1.Manifest
+	err = json.Unmarshal(attachment.contents, &parsed)
+	require.NoError(t, err)
+	assert.Len(t, parsed.Layers, 2)
+	config, ok := registry.blobs["repo@"+parsed.Config.Digest.String()]
+	require.True(t, ok)
+	var parsedConfig imgspecv1.Image
+	err = json.Unmarshal(config, &parsedConfig)
+	require.NoError(t, err)
+	assert.Equal(t, "layers", parsedConfig.RootFS.Type)
+	assert.Equal(t, []digest.Digest{parsed.Layers[0].Digest, parsed.Layers[1].Digest}, parsedConfig.RootFS.DiffIDs)
+
+	// Only existing signatures: the attachment is not modified
+	requestsBefore := len(registry.recordedRequests())
+	err = dest.PutSignatures(context.Background(), [][]byte{sig2}, &manifestDigest)
+	require.NoError(t, err)
+	for _, r := range registry.recordedRequests()[requestsBefore:] {
+		assert.False(t, strings.HasPrefix(r, http.MethodPut+" "), r)
+	}
+}
+
+func TestLayerMatchesSigstoreSignature(t *testing.T) {
+	payload := []byte("payload")
+	annotations := map[string]string{"a": "b"}
+	layer := imgspecv1.Descriptor{
+		MediaType:   signature.SigstoreSignatureMIMEType,
+		Digest:      digest.FromBytes(payload),
+		Size:        int64(len(payload)),
+		Annotations: map[string]string{"a": "b"},
+	}
+	assert.True(t, layerMatchesSigstoreSignature(layer, signature.SigstoreSignatureMIMEType, payload, annotations))
+
+	for _, c := range []struct {
+		mimeType    string
+		payload     []byte
+		annotations map[string]string
+	}{
+		{"application/octet-stream", payload, annotations},
+		{signature.SigstoreSignatureMIMEType, []byte("other payload"), annotations},
+		{signature.SigstoreSignatureMIMEType, []byte("PAYLOAD"), annotations},
+		{signature.SigstoreSignatureMIMEType, payload, nil},
+		{signature.SigstoreSignatureMIMEType, payload, map[string]string{"a": "c"}},
+		{signature.SigstoreSignatureMIMEType, payload, map[string]string{"c": "b"}},
+		{signature.SigstoreSignatureMIMEType, payload, map[string]string{"a": "b", "c": "d"}},
+	} {
+		assert.False(t, layerMatchesSigstoreSignature(layer, c.mimeType, c.payload, c.annotations), fmt.Sprintf("%#v", c))
+	}
+}
+
+// referrersTestArtifact returns an OCI artifact manifest referring to subject.
+func referrersTestArtifact(subject digest.Digest, artifactType, annotation string) []byte {
+	return []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","artifactType":%q,`+
+		`"config":{"mediaType":"application/vnd.oci.empty.v1+json","digest":"sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a","size":2},"layers":[],`+
+		`"subject":{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":%q,"size":1},"annotations":{"test":%q}}`,
+		artifactType, subject.String(), annotation))
+}
+
+// referrersTestPutManifest uploads m to refString using a dockerImageDestination.
+func referrersTestPutManifest(t *testing.T, sys *types.SystemContext, refString string, m []byte) {
+	dest, err := newImageDestination(sys, dockerRefFromString(t, refString))
+	require.NoError(t, err)
+	defer dest.Close()
+	err = dest.PutManifest(context.Background(), m, nil)
+	require.NoError(t, err)
+}
+
+func TestPutManifestWithSubject(t *testing.T) {
+	imageBlob := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:0000000000000000000000000000000000000000000000000000000000000000","size":1},"layers":[]}`)
+	imageDigest := digest.FromBytes(imageBlob)
+	indexTag, err := referrersTagSchemaTag(imageDigest)
+	require.NoError(t, err)
+	sbom := referrersTestArtifact(imageDigest, "application/spdx+json", "sbom")
+	sbomDigest := digest.FromBytes(sbom)
+	attestation := referrersTestArtifact(imageDigest, "application/vnd.in-toto+json", "attestation")
+	attestationDigest := digest.FromBytes(attestation)
+
+	// A registry which supports the referrers API: the tag schema index is not created.
+	registry := newFakeRegistry(t)
+	registry.supportsReferrers = true
+	sys := sigstoreAttachmentsTestSystemContext(t, registry.host(), false)
+	referrersTestPutManifest(t, sys, "//"+registry.host()+"/repo:tag", imageBlob)
+	referrersTestPutManifest(t, sys, "//"+registry.host()+"/repo@"+sbomDigest.String(), sbom)
+	_, ok := registry.manifest("repo", indexTag)
+	assert.False(t, ok)
+
+	// A registry without the referrers API: the tag schema index is created and updated.
+	registry = newFakeRegistry(t)
+	sys = sigstoreAttachmentsTestSystemContext(t, registry.host(), false)
+	referrersTestPutManifest(t, sys, "//"+registry.host()+"/repo:tag", imageBlob)
+	_, ok = registry.manifest("repo", indexTag)
+	assert.False(t, ok) // No subject, so no index.
+	referrersTestPutManifest(t, sys, "//"+registry.host()+"/repo@"+sbomDigest.String(), sbom)
+	referrersTestPutManifest(t, sys, "//"+registry.host()+"/repo@"+attestationDigest.String(), attestation)
+	// Uploading the same artifact again does not duplicate the index entry.
+	referrersTestPutManifest(t, sys, "//"+registry.host()+"/repo@"+sbomDigest.String(), sbom)
+	index, ok := registry.manifest("repo", indexTag)
+	require.True(t, ok)
+	assert.Equal(t, imgspecv1.MediaTypeImageIndex, index.mimeType)
+	var parsedIndex referrersIndex
+	err = json.Unmarshal(index.contents, &parsedIndex)
+	require.NoError(t, err)
+	assert.Equal(t, referrersIndex{
+		SchemaVersion: 2,
+		MediaType:     imgspecv1.MediaTypeImageIndex,
+		Manifests: []private.Referrer{
+			{
+				MediaType:    imgspecv1.MediaTypeImageManifest,
+				Digest:       sbomDigest,
+				Size:         int64(len(sbom)),
+				ArtifactType: "application/spdx+json",
+				Annotations:  map[string]string{"test": "sbom"},
+			},
+			{
+				MediaType:    imgspecv1.MediaTypeImageManifest,
+				Digest:       attestationDigest,
+				Size:         int64(len(attestation)),
+				ArtifactType: "application/vnd.in-toto+json",
+				Annotations:  map[string]string{"test": "attestation"},
+			},
+		},
+	}, parsedIndex)
+
+	// The artifact type defaults to the config media type
+	registry = newFakeRegistry(t)
+	sys = sigstoreAttachmentsTestSystemContext(t, registry.host(), false)
+	noArtifactType := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json",`+
+		`"config":{"mediaType":"application/vnd.example.config+json","digest":"sha256:0000000000000000000000000000000000000000000000000000000000000000","size":1},"layers":[],`+
+		`"subject":{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":%q,"size":1}}`, imageDigest.String()))
+	referrersTestPutManifest(t, sys, "//"+registry.host()+"/repo@"+digest.FromBytes(noArtifactType).String(), noArtifactType)
+	index, ok = registry.manifest("repo", indexTag)
+	require.True(t, ok)
+	parsedIndex = referrersIndex{}
+	err = json.Unmarshal(index.contents, &parsedIndex)
+	require.NoError(t, err)
+	require.Len(t, parsedIndex.Manifests, 1)
+	assert.Equal(t, "application/vnd.example.config+json", parsedIndex.Manifests[0].ArtifactType)
+}
+
+func TestTryReusingBlobWithOptionsReportReusedFrom(t *testing.T) {
+	registry := newFakeRegistry(t)
+	sys := sigstoreAttachmentsTestSystemContext(t, registry.host(), false)
+	blob := []byte("blob contents")
+	blobDigest := digest.FromBytes(blob)
+	registry.blobs["other@"+blobDigest.String()] = blob
+	otherRef := dockerRefFromString(t, "//"+registry.host()+"/other:tag")
+	cache := memory.New()
+
+	dest, err := newImageDestination(sys, dockerRefFromString(t, "//"+registry.host()+"/repo:tag"))
+	require.NoError(t, err)
+	defer dest.Close()
+	d, ok := dest.(*dockerImageDestination)
+	require.True(t, ok)
+
+	reusedFrom := []string{}
+	options := private.TryReusingBlobOptions{
+		Cache:            cache,
+		ReportReusedFrom: func(location string) { reusedFrom = append(reusedFrom, location) },
+	}
+
+	// The blob location is not known
+	reused, _, err := d.TryReusingBlobWithOptions(context.Background(), types.BlobInfo{Digest: blobDigest}, options)
+	require.NoError(t, err)
+	assert.False(t, reused)
+	assert.Empty(t, reusedFrom)
+
+	// The blob is mounted from a location known to the cache
+	cache.RecordKnownLocation(otherRef.Transport(), bicTransportScope(otherRef), blobDigest, newBICLocationReference(otherRef))
+	blobinfocache.FromBlobInfoCache(cache).RecordDigestCompressorName(blobDigest, blobinfocache.Uncompressed)
+	reused, info, err := d.TryReusingBlobWithOptions(context.Background(), types.BlobInfo{Digest: blobDigest}, options)
+	require.NoError(t, err)
+	assert.True(t, reused)
+	assert.Equal(t, blobDigest, info.Digest)
+	assert.Equal(t, int64(len(blob)), info.Size)
+	assert.Equal(t, []string{registry.host() + "/other"}, reusedFrom)
+
+	// The blob now exists in the destination repository
+	reusedFrom = []string{}
+	reused, _, err = d.TryReusingBlobWithOptions(context.Background(), types.BlobInfo{Digest: blobDigest}, options)
+	require.NoError(t, err)
+	assert.True(t, reused)
+	assert.Empty(t, reusedFrom)
+}
+
+func TestTryReusingBlobWithOptionsExplicitMountSources(t *testing.T) {
+	registry := newFakeRegistry(t)
+	blob := []byte("blob contents")
+	blobDigest := digest.FromBytes(blob)
+	registry.blobs["base@"+blobDigest.String()] = blob
+	baseRef := dockerRefFromString(t, "//"+registry.host()+"/base:tag")
+
+	for _, c := range []struct {
+		name    string
+		sources []string
+		reused  bool
+	}{
+		{"no sources", nil, false},
+		{"other registry", []string{"other.example.com/base"}, false},
+		{"invalid", []string{"@invalid"}, false},
+		{"missing blob", []string{registry.host() + "/missing"}, false},
+		{"matching source", []string{registry.host() + "/missing", registry.host() + "/base:ignored"}, true},
+	} {
+		delete(registry.blobs, "repo@"+blobDigest.String())
+		sys := sigstoreAttachmentsTestSystemContext(t, registry.host(), false)
+		sys.DockerRegistryMountSourceRepositories = c.sources
+		cache := memory.New()
+		dest, err := newImageDestination(sys, dockerRefFromString(t, "//"+registry.host()+"/repo:tag"))
+		require.NoError(t, err, c.name)
+		defer dest.Close()
+		d, ok := dest.(*dockerImageDestination)
+		require.True(t, ok, c.name)
+
+		reusedFrom := []string{}
+		reused, info, err := d.TryReusingBlobWithOptions(context.Background(), types.BlobInfo{Digest: blobDigest}, private.TryReusingBlobOptions{
+			Cache:            cache,
+			ReportReusedFrom: func(location string) { reusedFrom = append(reusedFrom, location) },
+		})
+		require.NoError(t, err, c.name)
+		assert.Equal(t, c.reused, reused, c.name)
+		_, inRepo := registry.blobs["repo@"+blobDigest.String()]
+		assert.Equal(t, c.reused, inRepo, c.name)
+		if c.reused {
+			assert.Equal(t, int64(len(blob)), info.Size, c.name)
+			assert.Equal(t, []string{registry.host() + "/base"}, reusedFrom, c.name)
+			// Both the destination and the source are recorded in the cache.
+			locations := map[types.BICLocationReference]struct{}{}
+			for _, candidate := range blobinfocache.FromBlobInfoCache(cache).CandidateLocations2(d.ref.Transport(), bicTransportScope(d.ref), blobDigest, false) {
+				locations[candidate.Location] = struct{}{}
+			}
+			assert.Contains(t, locations, newBICLocationReference(d.ref), c.name)
+			assert.Contains(t, locations, newBICLocationReference(baseRef), c.name)
+		}
+	}
+}
+
+func TestTryReusingBlobWithOptionsMountWithoutSource(t *testing.T) {
+	for _, supported := range []bool{false, true} {
+		registry := newFakeRegistry(t)
+		registry.supportsMountWithoutFrom = supported
+		blob := []byte("blob contents")
+		blobDigest := digest.FromBytes(blob)
+		registry.blobs["base@"+blobDigest.String()] = blob
+		sys := sigstoreAttachmentsTestSystemContext(t, registry.host(), false)
+		cache := memory.New()
+		dest, err := newImageDestination(sys, dockerRefFromString(t, "//"+registry.host()+"/repo:tag"))
+		require.NoError(t, err)
+		defer dest.Close()
+		d, ok := dest.(*dockerImageDestination)
+		require.True(t, ok)
+
+		// Without DockerRegistryMountWithoutSource, no mount is attempted.
+		reused, _, err := d.TryReusingBlobWithOptions(context.Background(), types.BlobInfo{Digest: blobDigest}, private.TryReusingBlobOptions{Cache: cache})
+		require.NoError(t, err)
+		assert.False(t, reused)
+		assert.Empty(t, registry.uploads)
+
+		sys.DockerRegistryMountWithoutSource = true
+		reused, info, err := d.TryReusingBlobWithOptions(context.Background(), types.BlobInfo{Digest: blobDigest}, private.TryReusingBlobOptions{Cache: cache})
+		require.NoError(t, err)
+		assert.Equal(t, supported, reused)
+		_, inRepo := registry.blobs["repo@"+blobDigest.String()]
+		assert.Equal(t, supported, inRepo)
+		if supported {
+			assert.Equal(t, int64(len(blob)), info.Size)
+		}
+		// An upload started by an unsupported mount is canceled.
+		assert.Empty(t, registry.uploads)
+	}
+}
+
+func TestTryReusingBlobWithOptionsNoCrossRepositoryReuse(t *testing.T) {
+	registry := newFakeRegistry(t)
+	registry.supportsMountWithoutFrom = true
+	blob := []byte("blob contents")
+	blobDigest := digest.FromBytes(blob)
+	registry.blobs["base@"+blobDigest.String()] = blob
+	baseRef := dockerRefFromString(t, "//"+registry.host()+"/base:tag")
+	sys := sigstoreAttachmentsTestSystemContext(t, registry.host(), false)
+	sys.DockerRegistryMountSourceRepositories = []string{registry.host() + "/base"}
+	sys.DockerRegistryMountWithoutSource = true
+	cache := memory.New()
+	cache.RecordKnownLocation(baseRef.Transport(), bicTransportScope(baseRef), blobDigest, newBICLocationReference(baseRef))
+	dest, err := newImageDestination(sys, dockerRefFromString(t, "//"+registry.host()+"/repo:tag"))
+	require.NoError(t, err)
+	defer dest.Close()
+	d, ok := dest.(*dockerImageDestination)
+	require.True(t, ok)
+
+	// No mounts are attempted, neither from the cache, nor from explicit sources, nor without a source.
+	reused, _, err := d.TryReusingBlobWithOptions(context.Background(), types.BlobInfo{Digest: blobDigest}, private.TryReusingBlobOptions{
+		Cache:                  cache,
+		NoCrossRepositoryReuse: true,
+	})
+	require.NoError(t, err)
+	assert.False(t, reused)
+	_, inRepo := registry.blobs["repo@"+blobDigest.String()]
+	assert.False(t, inRepo)
+	for _, req := range registry.requests {
+		assert.False(t, strings.HasPrefix(req, http.MethodPost+" "), req)
+	}
+
+	// Blobs already present at the destination are still found.
+	registry.blobs["repo@"+blobDigest.String()] = blob
+	reused, info, err := d.TryReusingBlobWithOptions(context.Background(), types.BlobInfo{Digest: blobDigest}, private.TryReusingBlobOptions{
+		Cache:                  cache,
+		NoCrossRepositoryReuse: true,
+	})
+	require.NoError(t, err)
+	assert.True(t, reused)
+	assert.Equal(t, int64(len(blob)), info.Size)
+}
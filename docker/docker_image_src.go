@@ -15,8 +15,10 @@ import (
 	"sync"
 
 	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/internal/bandwidth"
 	"github.com/containers/image/v5/internal/iolimits"
 	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/internal/signature"
 	"github.com/containers/image/v5/manifest"
 	"github.com/containers/image/v5/pkg/sysregistriesv2"
 	"github.com/containers/image/v5/types"
@@ -123,6 +125,15 @@ func newImageSourceAttempt(ctx context.Context, sys *types.SystemContext, logica
 		return nil, err
 	}
 	client.tlsClientConfig.InsecureSkipVerify = pullSource.Endpoint.Insecure
+	client.logicalRegistry = reference.Domain(logicalRef.ref)
+	client.proxy, err = pullSource.Endpoint.ProxyFunc()
+	if err != nil {
+		return nil, err
+	}
+	if err := pullSource.Endpoint.ConfigureTLS(client.tlsClientConfig); err != nil {
+		return nil, err
+	}
+	client.bandwidthLimiter = registryBandwidthLimiter(client.registry, pullSource.Endpoint.BandwidthLimit)
 
 	s := &dockerImageSource{
 		logicalRef:  logicalRef,
@@ -152,6 +163,11 @@ func (s *dockerImageSource) SupportsGetBlobAt() bool {
 	return true
 }
 
+// SupportsReferrers returns true if GetReferrers is supported.
+func (s *dockerImageSource) SupportsReferrers() bool {
+	return true
+}
+
 // LayerInfosForCopy returns either nil (meaning the values in the manifest are fine), or updated values for the layer
 // blobsums that are listed in the image's manifest.  If values are returned, they should be used when using GetBlob()
 // to read the image's layers.
@@ -260,6 +276,7 @@ func (s *dockerImageSource) getExternalBlob(ctx context.Context, urls []string)
 		// NOTE: we must not authenticate on additional URLs as those
 		//       can be abused to leak credentials or tokens.  Please
 		//       refer to CVE-2020-15157 for more information.
+		// The proxy configuration of the endpoint, including its no-proxy exceptions, applies to these URLs as well.
 		resp, err = s.c.makeRequestToResolvedURL(ctx, http.MethodGet, url, nil, nil, -1, noAuth, nil)
 		if err == nil {
 			if resp.StatusCode != http.StatusOK {
@@ -424,7 +441,7 @@ func (s *dockerImageSource) GetBlobAt(ctx context.Context, info types.BlobInfo,
 		// streams as it would have been done with 206.
 		streams := make(chan io.ReadCloser)
 		errs := make(chan error)
-		go splitHTTP200ResponseToPartial(streams, errs, res.Body, chunks)
+		go splitHTTP200ResponseToPartial(streams, errs, bandwidth.NewReadCloser(ctx, res.Body, s.c.bandwidthLimiter), chunks)
 		return streams, errs, nil
 	case http.StatusPartialContent:
 		mediaType, params, err := parseMediaType(res.Header.Get("Content-Type"))
@@ -435,7 +452,7 @@ func (s *dockerImageSource) GetBlobAt(ctx context.Context, info types.BlobInfo,
 		streams := make(chan io.ReadCloser)
 		errs := make(chan error)
 
-		go handle206Response(streams, errs, res.Body, chunks, mediaType, params)
+		go handle206Response(streams, errs, bandwidth.NewReadCloser(ctx, res.Body, s.c.bandwidthLimiter), chunks, mediaType, params)
 		return streams, errs, nil
 	case http.StatusBadRequest:
 		res.Body.Close()
@@ -459,7 +476,7 @@ func (s *dockerImageSource) GetBlob(ctx context.Context, info types.BlobInfo, ca
 		if err != nil {
 			return nil, 0, err
 		} else if r != nil {
-			return r, s, nil
+			return bandwidth.NewReadCloser(ctx, r, s.c.bandwidthLimiter), s, nil
 		}
 	}
 
@@ -474,7 +491,13 @@ func (s *dockerImageSource) GetBlob(ctx context.Context, info types.BlobInfo, ca
 		return nil, 0, err
 	}
 	cache.RecordKnownLocation(s.physicalRef.Transport(), bicTransportScope(s.physicalRef), info.Digest, newBICLocationReference(s.physicalRef))
-	return res.Body, getBlobSize(res), nil
+	size := getBlobSize(res)
+	resumeSize := size
+	if resumeSize == -1 {
+		resumeSize = info.Size
+	}
+	// If the connection fails, resume reading the blob using range requests, to avoid restarting a possibly large download.
+	return bandwidth.NewReadCloser(ctx, newBodyReader(ctx, s, info, resumeSize, res.Body), s.c.bandwidthLimiter), size, nil
 }
 
 // GetSignatures returns the image's signatures.  It may use a remote (= slow) service.
@@ -485,14 +508,31 @@ func (s *dockerImageSource) GetSignatures(ctx context.Context, instanceDigest *d
 	if err := s.c.detectProperties(ctx); err != nil {
 		return nil, err
 	}
+	var res [][]byte
 	switch {
 	case s.c.supportsSignatures:
-		return s.getSignaturesFromAPIExtension(ctx, instanceDigest)
+		sigs, err := s.getSignaturesFromAPIExtension(ctx, instanceDigest)
+		if err != nil {
+			return nil, err
+		}
+		res = sigs
 	case s.c.signatureBase != nil:
-		return s.getSignaturesFromLookaside(ctx, instanceDigest)
+		sigs, err := s.getSignaturesFromLookaside(ctx, instanceDigest)
+		if err != nil {
+			return nil, err
+		}
+		res = sigs
 	default:
 		return nil, errors.Errorf("Internal error: X-Registry-Supports-Signatures extension not supported, and lookaside should not be empty configuration")
 	}
+	if s.c.useSigstoreAttachments {
+		sigs, err := s.getSignaturesFromSigstoreAttachments(ctx, instanceDigest)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, sigs...)
+	}
+	return res, nil
 }
 
 // manifestDigest returns a digest of the manifest, from instanceDigest if non-nil; or from the supplied reference,
@@ -559,7 +599,7 @@ func (s *dockerImageSource) getOneSignature(ctx context.Context, url *url.URL) (
 		if err != nil {
 			return nil, false, err
 		}
-		res, err := s.c.client.Do(req)
+		res, err := s.c.doRequest(req, types.DockerRegistryRequestExternal, 0)
 		if err != nil {
 			return nil, false, err
 		}
@@ -580,6 +620,40 @@ func (s *dockerImageSource) getOneSignature(ctx context.Context, url *url.URL) (
 	}
 }
 
+// getSignaturesFromSigstoreAttachments implements GetSignatures() using the sigstore attachment ("sha256-….sig" tag) of the image.
+func (s *dockerImageSource) getSignaturesFromSigstoreAttachments(ctx context.Context, instanceDigest *digest.Digest) ([][]byte, error) {
+	manifestDigest, err := s.manifestDigest(ctx, instanceDigest)
+	if err != nil {
+		return nil, err
+	}
+
+	ociManifest, err := s.c.getSigstoreAttachmentManifest(ctx, s.physicalRef, manifestDigest)
+	if err != nil {
+		return nil, err
+	}
+	if ociManifest == nil {
+		return nil, nil
+	}
+
+	logrus.Debugf("Found a sigstore attachment manifest with %d layers", len(ociManifest.Layers))
+	res := [][]byte{}
+	for layerIndex, layer := range ociManifest.Layers {
+		// Note that this copies all kinds of attachments: attestations, and whatever else is there,
+		// not just signatures. We leave the signature consumers to decide based on the MIME type.
+		logrus.Debugf("Fetching sigstore attachment %d/%d: %s", layerIndex+1, len(ociManifest.Layers), layer.Digest.String())
+		payload, err := s.c.getOCIDescriptorContents(ctx, s.physicalRef, layer, iolimits.MaxSignatureBodySize)
+		if err != nil {
+			return nil, err
+		}
+		sig, err := signature.Blob(signature.SigstoreFromComponents(layer.MediaType, payload, layer.Annotations))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, sig)
+	}
+	return res, nil
+}
+
 // getSignaturesFromAPIExtension implements GetSignatures() using the X-Registry-Supports-Signatures API extension.
 func (s *dockerImageSource) getSignaturesFromAPIExtension(ctx context.Context, instanceDigest *digest.Digest) ([][]byte, error) {
 	manifestDigest, err := s.manifestDigest(ctx, instanceDigest)
@@ -601,8 +675,17 @@ func (s *dockerImageSource) getSignaturesFromAPIExtension(ctx context.Context, i
 	return sigs, nil
 }
 
+// GetReferrers returns descriptors of manifests (typically artifacts, e.g. signatures, SBOMs or attestations) in the same repository
+// whose "subject" field refers to the manifest with manifestDigest.
+// If artifactType is not empty, only referrers with that artifact type are returned.
+// It is available only if SupportsReferrers().
+func (s *dockerImageSource) GetReferrers(ctx context.Context, manifestDigest digest.Digest, artifactType string) ([]private.Referrer, error) {
+	return s.c.getReferrers(ctx, s.physicalRef, manifestDigest, artifactType)
+}
+
 // deleteImage deletes the named image from the registry, if supported.
-func deleteImage(ctx context.Context, sys *types.SystemContext, ref dockerReference) error {
+// options may be nil.
+func deleteImage(ctx context.Context, sys *types.SystemContext, ref dockerReference, options *DeleteImageOptions) error {
 	// docker/distribution does not document what action should be used for deleting images.
 	//
 	// Current docker/distribution requires "pull" for reading the manifest and "delete" for deleting it.
@@ -615,34 +698,122 @@ func deleteImage(ctx context.Context, sys *types.SystemContext, ref dockerRefere
 		return err
 	}
 
-	headers := map[string][]string{
-		"Accept": manifest.DefaultRequestedManifestMIMETypes,
-	}
 	refTail, err := ref.tagOrDigest()
 	if err != nil {
 		return err
 	}
-	getPath := fmt.Sprintf(manifestPath, reference.Path(ref.ref), refTail)
-	get, err := c.makeRequest(ctx, http.MethodGet, getPath, headers, nil, v2Auth, nil)
+	manifestBody, mimeType, missing, err := c.fetchManifestForDeletion(ctx, ref, refTail)
 	if err != nil {
 		return err
 	}
-	defer get.Body.Close()
-	manifestBody, err := iolimits.ReadAtMost(get.Body, iolimits.MaxManifestBodySize)
+	if missing {
+		return errors.Errorf("Unable to delete %v. Image may not exist or is not stored with a v2 Schema in a v2 registry", ref.ref)
+	}
+	manifestDigest, err := manifest.Digest(manifestBody)
+	if err != nil {
+		return fmt.Errorf("computing manifest digest: %w", err)
+	}
+
+	if options != nil && options.DeleteInstances {
+		// Find all of the instances before deleting anything, and delete them from the leaves up. The top-level manifest
+		// is deleted last, so that if deleting an instance fails, the remaining ones can still be found.
+		instances, err := c.manifestInstancesForDeletion(ctx, ref, manifestDigest, manifestBody, mimeType, map[digest.Digest]struct{}{manifestDigest: {}})
+		if err != nil {
+			return err
+		}
+		for _, instanceDigest := range instances {
+			missing, err := c.deleteManifest(ctx, ref, instanceDigest)
+			if err != nil {
+				return err
+			}
+			if missing {
+				logrus.Debugf("Manifest %s of %s does not exist, skipping it", instanceDigest.String(), ref.ref.Name())
+			}
+		}
+	}
+
+	missing, err = c.deleteManifest(ctx, ref, manifestDigest)
 	if err != nil {
 		return err
 	}
+	if missing {
+		return errors.Errorf("Unable to delete %v. Image may not exist or is not stored with a v2 Schema in a v2 registry", ref.ref)
+	}
+	return nil
+}
+
+// fetchManifestForDeletion reads the manifest with tagOrDigest in the repository of ref, and returns it with its MIME type.
+// If it successfully determines that the manifest does not exist, returns (nil, "", true, nil)
+func (c *dockerClient) fetchManifestForDeletion(ctx context.Context, ref dockerReference, tagOrDigest string) (manifestBody []byte, mimeType string, missing bool, err error) {
+	headers := map[string][]string{
+		"Accept": manifest.DefaultRequestedManifestMIMETypes,
+	}
+	getPath := fmt.Sprintf(manifestPath, reference.Path(ref.ref), tagOrDigest)
+	get, err := c.makeRequest(ctx, http.MethodGet, getPath, headers, nil, v2Auth, nil)
+	if err != nil {
+		return nil, "", false, err
+	}
+	defer get.Body.Close()
+	manifestBody, err = iolimits.ReadAtMost(get.Body, iolimits.MaxManifestBodySize)
+	if err != nil {
+		return nil, "", false, err
+	}
 	switch get.StatusCode {
 	case http.StatusOK:
 	case http.StatusNotFound:
-		return errors.Errorf("Unable to delete %v. Image may not exist or is not stored with a v2 Schema in a v2 registry", ref.ref)
+		return nil, "", true, nil
 	default:
-		return errors.Errorf("Failed to delete %v: %s (%v)", ref.ref, manifestBody, get.Status)
+		return nil, "", false, errors.Errorf("Failed to delete %v: %s (%v)", getPath, manifestBody, get.Status)
+	}
+	mimeType = simplifyContentType(get.Header.Get("Content-Type"))
+	if mimeType == "" {
+		mimeType = manifest.GuessMIMEType(manifestBody)
 	}
+	return manifestBody, mimeType, false, nil
+}
 
-	manifestDigest, err := manifest.Digest(manifestBody)
+// manifestInstancesForDeletion returns the digests of all manifests manifestBody (with manifestDigest and mimeType) refers to,
+// recursively, excluding manifestDigest itself; each manifest list is ordered after the manifests it refers to.
+// seen contains the digests which have already been included, or should be excluded, and is updated.
+func (c *dockerClient) manifestInstancesForDeletion(ctx context.Context, ref dockerReference, manifestDigest digest.Digest,
+	manifestBody []byte, mimeType string, seen map[digest.Digest]struct{}) ([]digest.Digest, error) {
+	if !manifest.MIMETypeIsMultiImage(mimeType) {
+		return nil, nil
+	}
+	list, err := manifest.ListFromBlob(manifestBody, mimeType)
 	if err != nil {
-		return fmt.Errorf("computing manifest digest: %w", err)
+		return nil, errors.Wrapf(err, "parsing manifest list %s", manifestDigest.String())
+	}
+	res := []digest.Digest{}
+	for _, instanceDigest := range list.Instances() {
+		if _, ok := seen[instanceDigest]; ok {
+			continue
+		}
+		seen[instanceDigest] = struct{}{}
+		instanceBody, instanceMIMEType, missing, err := c.fetchManifestForDeletion(ctx, ref, instanceDigest.String())
+		if err != nil {
+			return nil, err
+		}
+		if missing {
+			// Probably shared with another, already deleted, manifest list.
+			logrus.Debugf("Manifest %s of %s does not exist, skipping it", instanceDigest.String(), ref.ref.Name())
+			continue
+		}
+		instances, err := c.manifestInstancesForDeletion(ctx, ref, instanceDigest, instanceBody, instanceMIMEType, seen)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, instances...)
+		res = append(res, instanceDigest)
+	}
+	return res, nil
+}
+
+// deleteManifest deletes the manifest with manifestDigest from the repository of ref, along with its lookaside signatures.
+// If it successfully determines that the manifest does not exist, returns (true, nil)
+func (c *dockerClient) deleteManifest(ctx context.Context, ref dockerReference, manifestDigest digest.Digest) (missing bool, err error) {
+	headers := map[string][]string{
+		"Accept": manifest.DefaultRequestedManifestMIMETypes,
 	}
 	deletePath := fmt.Sprintf(manifestPath, reference.Path(ref.ref), manifestDigest)
 
@@ -650,30 +821,34 @@ func deleteImage(ctx context.Context, sys *types.SystemContext, ref dockerRefere
 	//   "Accept": "application/vnd.docker.distribution.manifest.v2+json"
 	delete, err := c.makeRequest(ctx, http.MethodDelete, deletePath, headers, nil, v2Auth, nil)
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer delete.Body.Close()
 
 	body, err := iolimits.ReadAtMost(delete.Body, iolimits.MaxErrorBodySize)
 	if err != nil {
-		return err
+		return false, err
 	}
-	if delete.StatusCode != http.StatusAccepted {
-		return errors.Errorf("Failed to delete %v: %s (%v)", deletePath, string(body), delete.Status)
+	switch delete.StatusCode {
+	case http.StatusAccepted:
+	case http.StatusNotFound:
+		return true, nil
+	default:
+		return false, errors.Errorf("Failed to delete %v: %s (%v)", deletePath, string(body), delete.Status)
 	}
 
 	for i := 0; ; i++ {
 		url := signatureStorageURL(c.signatureBase, manifestDigest, i)
 		missing, err := c.deleteOneSignature(url)
 		if err != nil {
-			return err
+			return false, err
 		}
 		if missing {
 			break
 		}
 	}
 
-	return nil
+	return false, nil
 }
 
 type bufferedNetworkReaderBuffer struct {
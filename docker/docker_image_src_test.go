@@ -9,11 +9,14 @@ import (
 	"net/url"
 	"os"
 	"regexp"
+	"sort"
 	"strings"
 	"testing"
 
 	"github.com/containers/image/v5/internal/private"
 	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -210,3 +213,67 @@ func TestParseMediaType(t *testing.T) {
 	_, _, err = parseMediaType("multipart/byteranges; boundary=@")
 	require.Error(t, err)
 }
+
+func TestDockerImageSourceGetReferrers(t *testing.T) {
+	imageBlob := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:0000000000000000000000000000000000000000000000000000000000000000","size":1},"layers":[]}`)
+	imageDigest := digest.FromBytes(imageBlob)
+	artifacts := [][]byte{
+		referrersTestArtifact(imageDigest, "application/spdx+json", "sbom 1"),
+		referrersTestArtifact(imageDigest, "application/vnd.in-toto+json", "attestation"),
+		referrersTestArtifact(imageDigest, "application/spdx+json", "sbom 2"),
+		referrersTestArtifact(digest.FromString("some other image"), "application/spdx+json", "unrelated"),
+	}
+
+	for _, c := range []struct {
+		name              string
+		supportsReferrers bool
+		pageSize          int
+	}{
+		{"tag schema", false, 0},
+		{"referrers API", true, 0},
+		{"referrers API with pagination", true, 1},
+	} {
+		registry := newFakeRegistry(t)
+		registry.supportsReferrers = c.supportsReferrers
+		registry.referrersPageSize = c.pageSize
+		sys := sigstoreAttachmentsTestSystemContext(t, registry.host(), false)
+		referrersTestPutManifest(t, sys, "//"+registry.host()+"/repo:tag", imageBlob)
+		for _, a := range artifacts {
+			referrersTestPutManifest(t, sys, "//"+registry.host()+"/repo@"+digest.FromBytes(a).String(), a)
+		}
+
+		src, err := newImageSource(context.Background(), sys, dockerRefFromString(t, "//"+registry.host()+"/repo:tag"))
+		require.NoError(t, err, c.name)
+		defer src.Close()
+		require.True(t, src.SupportsReferrers())
+
+		referrerAnnotations := func(refs []private.Referrer) []string {
+			res := []string{}
+			for _, r := range refs {
+				assert.Equal(t, imgspecv1.MediaTypeImageManifest, r.MediaType, c.name)
+				res = append(res, r.Annotations["test"])
+			}
+			sort.Strings(res)
+			return res
+		}
+
+		refs, err := src.GetReferrers(context.Background(), imageDigest, "")
+		require.NoError(t, err, c.name)
+		assert.Equal(t, []string{"attestation", "sbom 1", "sbom 2"}, referrerAnnotations(refs), c.name)
+
+		refs, err = src.GetReferrers(context.Background(), imageDigest, "application/spdx+json")
+		require.NoError(t, err, c.name)
+		assert.Equal(t, []string{"sbom 1", "sbom 2"}, referrerAnnotations(refs), c.name)
+
+		refs, err = src.GetReferrers(context.Background(), imageDigest, "application/this-does-not-exist")
+		require.NoError(t, err, c.name)
+		assert.Empty(t, refs, c.name)
+
+		refs, err = src.GetReferrers(context.Background(), digest.FromString("no referrers"), "")
+		require.NoError(t, err, c.name)
+		assert.Empty(t, refs, c.name)
+
+		_, err = src.GetReferrers(context.Background(), digest.Digest("sha256:invalid"), "")
+		assert.Error(t, err, c.name)
+	}
+}
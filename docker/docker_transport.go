@@ -150,9 +150,16 @@ func (ref dockerReference) NewImageDestination(ctx context.Context, sys *types.S
 	return newImageDestination(sys, ref)
 }
 
+// NewImageDestinationForProbe returns a types.ImageDestination for this reference, which can only be used to read destination properties
+// and to check for existing blobs; see private.ImageReferenceWithProbe.
+// The caller must call .Close() on the returned ImageDestination.
+func (ref dockerReference) NewImageDestinationForProbe(ctx context.Context, sys *types.SystemContext) (types.ImageDestination, error) {
+	return newImageDestination(sys, ref) // Opening a registry destination does not contact the registry.
+}
+
 // DeleteImage deletes the named image from the registry, if supported.
 func (ref dockerReference) DeleteImage(ctx context.Context, sys *types.SystemContext) error {
-	return deleteImage(ctx, sys, ref)
+	return deleteImage(ctx, sys, ref, nil)
 }
 
 // tagOrDigest returns a tag or digest from the reference.
@@ -15,6 +15,8 @@ var (
 	ErrV1NotSupported = errors.New("can't talk to a V1 container registry")
 	// ErrTooManyRequests is returned when the status code returned is 429
 	ErrTooManyRequests = errors.New("too many requests to registry")
+	// ErrTagDeletionUnsupported is returned by DeleteTag if the registry does not support deleting tags.
+	ErrTagDeletionUnsupported = errors.New("the registry does not support deleting tags")
 )
 
 // ErrUnauthorizedForCredentials is returned when the status code returned is 401
@@ -0,0 +1,489 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/containers/image/v5/internal/private"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRegistry is a minimal in-memory implementation of the registry API, sufficient for tests in this package.
+type fakeRegistry struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mutex      sync.Mutex
+	blobs      map[string][]byte       // Indexed by repo@digest
+	manifests  map[string]fakeManifest // Indexed by repo:tag and repo@digest
+	uploads    map[string][]byte       // Indexed by upload ID
+	nextUpload int
+	requests   []string // "METHOD path", in order
+
+	// If supportsReferrers, the registry implements the referrers API, returning at most referrersPageSize entries per page if not 0.
+	supportsReferrers bool
+	referrersPageSize int
+
+	// Each entry of blobReadFailures causes one blob GET response to be aborted after sending the specified number of bytes.
+	blobReadFailures []int
+	// Each entry of uploadFailures causes one upload PATCH request to fail after storing the specified number of bytes.
+	uploadFailures []int
+	// If not 0, returned as OCI-Chunk-Min-Length when starting an upload.
+	chunkMinLength int
+	// The sizes of the bodies of upload PATCH requests, in order.
+	uploadChunkSizes []int
+	// If > 0, the number of following manifest GET/HEAD requests which fail with http.StatusServiceUnavailable.
+	manifestServerErrors int
+	// If supportsTagDeletion, DELETE requests for manifests can use a tag.
+	supportsTagDeletion bool
+	// If not "", the error code returned for DELETE requests for manifests using a tag when !supportsTagDeletion,
+	// instead of DIGEST_INVALID.
+	tagDeletionErrorCode string
+	// If supportsMountWithoutFrom, blob mount requests without a "from" parameter find the blob in any repository.
+	supportsMountWithoutFrom bool
+}
+
+// fakeManifest is a manifest stored in fakeRegistry.
+type fakeManifest struct {
+	mimeType string
+	contents []byte
+}
+
+// newFakeRegistry returns a new, empty, fakeRegistry, which will be shut down at the end of t.
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	r := &fakeRegistry{
+		t:         t,
+		blobs:     map[string][]byte{},
+		manifests: map[string]fakeManifest{},
+		uploads:   map[string][]byte{},
+	}
+	r.server = httptest.NewServer(r)
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+// host returns the host:port value of the registry.
+func (r *fakeRegistry) host() string {
+	return strings.TrimPrefix(r.server.URL, "http://")
+}
+
+// recordedRequests returns the requests received so far.
+func (r *fakeRegistry) recordedRequests() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string{}, r.requests...)
+}
+
+// manifest returns a manifest stored in repo for tagOrDigest, if any.
+func (r *fakeRegistry) manifest(repo, tagOrDigest string) (fakeManifest, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	m, ok := r.manifests[manifestKey(repo, tagOrDigest)]
+	return m, ok
+}
+
+// manifestKey returns a key for fakeRegistry.manifests.
+func manifestKey(repo, tagOrDigest string) string {
+	if strings.Contains(tagOrDigest, ":") {
+		return repo + "@" + tagOrDigest
+	}
+	return repo + ":" + tagOrDigest
+}
+
+func (r *fakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.requests = append(r.requests, req.Method+" "+req.URL.Path)
+
+	if req.URL.Path == "/v2/" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	if path == "_catalog" {
+		r.serveCatalog(w, req)
+		return
+	}
+	if strings.HasSuffix(path, "/tags/list") {
+		r.serveTags(w, req, strings.TrimSuffix(path, "/tags/list"))
+		return
+	}
+	if i := strings.LastIndex(path, "/manifests/"); i != -1 {
+		r.serveManifest(w, req, path[:i], path[i+len("/manifests/"):])
+		return
+	}
+	if i := strings.LastIndex(path, "/referrers/"); i != -1 && r.supportsReferrers {
+		r.serveReferrers(w, req, path[:i], path[i+len("/referrers/"):])
+		return
+	}
+	if i := strings.LastIndex(path, "/blobs/uploads/"); i != -1 {
+		r.serveUpload(w, req, path[:i], path[i+len("/blobs/uploads/"):])
+		return
+	}
+	if i := strings.LastIndex(path, "/blobs/"); i != -1 {
+		r.serveBlob(w, req, path[:i], path[i+len("/blobs/"):])
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func (r *fakeRegistry) serveManifest(w http.ResponseWriter, req *http.Request, repo, tagOrDigest string) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		if r.manifestServerErrors > 0 {
+			r.manifestServerErrors--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		m, ok := r.manifests[manifestKey(repo, tagOrDigest)]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", m.mimeType)
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(m.contents).String())
+		w.Header().Set("Content-Length", strconv.Itoa(len(m.contents)))
+		w.WriteHeader(http.StatusOK)
+		if req.Method == http.MethodGet {
+			_, err := w.Write(m.contents)
+			assert.NoError(r.t, err)
+		}
+	case http.MethodPut:
+		contents, err := io.ReadAll(req.Body)
+		assert.NoError(r.t, err)
+		m := fakeManifest{mimeType: req.Header.Get("Content-Type"), contents: contents}
+		manifestDigest := digest.FromBytes(contents)
+		r.manifests[manifestKey(repo, tagOrDigest)] = m
+		r.manifests[manifestKey(repo, manifestDigest.String())] = m
+		w.Header().Set("Docker-Content-Digest", manifestDigest.String())
+		if r.supportsReferrers {
+			if subject := fakeManifestSubject(contents); subject != "" {
+				w.Header().Set("OCI-Subject", subject.String())
+			}
+		}
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		m, ok := r.manifests[manifestKey(repo, tagOrDigest)]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if !strings.Contains(tagOrDigest, ":") { // A tag
+			if !r.supportsTagDeletion {
+				code := r.tagDeletionErrorCode
+				if code == "" {
+					code = "DIGEST_INVALID" // As docker/distribution does
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, `{"errors":[{"code":%q,"message":"tag deletion failed"}]}`, code)
+				return
+			}
+			delete(r.manifests, manifestKey(repo, tagOrDigest))
+		} else {
+			// Deleting a manifest by digest deletes all tags referring to it.
+			for key, other := range r.manifests {
+				if strings.HasPrefix(key, repo+":") && digest.FromBytes(other.contents) == digest.FromBytes(m.contents) {
+					delete(r.manifests, key)
+				}
+			}
+			delete(r.manifests, manifestKey(repo, tagOrDigest))
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *fakeRegistry) serveTags(w http.ResponseWriter, req *http.Request, repo string) {
+	if req.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	tags := []string{}
+	for key := range r.manifests {
+		if tag := strings.TrimPrefix(key, repo+":"); tag != key {
+			tags = append(tags, tag)
+		}
+	}
+	r.servePage(w, req, fmt.Sprintf("/v2/%s/tags/list", repo), "tags", repo, tags)
+}
+
+func (r *fakeRegistry) serveCatalog(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	repoSet := map[string]struct{}{}
+	for key := range r.manifests {
+		repoSet[key[:strings.IndexAny(key, ":@")]] = struct{}{}
+	}
+	for key := range r.blobs {
+		repoSet[key[:strings.LastIndex(key, "@")]] = struct{}{}
+	}
+	repos := []string{}
+	for repo := range repoSet {
+		repos = append(repos, repo)
+	}
+	r.servePage(w, req, "/v2/_catalog", "repositories", "", repos)
+}
+
+// servePage responds to a paginated list request at path, returning a page of items in a JSON object field named field,
+// along with a "name" field if name is not "".
+func (r *fakeRegistry) servePage(w http.ResponseWriter, req *http.Request, path, field, name string, items []string) {
+	sort.Strings(items)
+	if last := req.URL.Query().Get("last"); last != "" {
+		i := sort.SearchStrings(items, last)
+		if i < len(items) && items[i] == last {
+			i++
+		}
+		items = items[i:]
+	}
+	if n := req.URL.Query().Get("n"); n != "" {
+		pageSize, err := strconv.Atoi(n)
+		assert.NoError(r.t, err)
+		if pageSize < len(items) {
+			items = items[:pageSize]
+			w.Header().Set("Link", fmt.Sprintf(`<%s?last=%s&n=%d>; rel="next"`, path, url.QueryEscape(items[len(items)-1]), pageSize))
+		}
+	}
+	res := map[string]interface{}{field: items}
+	if name != "" {
+		res["name"] = name
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(res)
+	assert.NoError(r.t, err)
+}
+
+func (r *fakeRegistry) serveBlob(w http.ResponseWriter, req *http.Request, repo, blobDigest string) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		blob, ok := r.blobs[repo+"@"+blobDigest]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		status := http.StatusOK
+		start, end := 0, len(blob)
+		if rangeHeader := req.Header.Get("Range"); rangeHeader != "" {
+			var last int
+			if _, err := fmt.Sscanf(rangeHeader, "bytes=%d-%d", &start, &last); err != nil || start > last || start >= len(blob) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			if last < end-1 {
+				end = last + 1
+			}
+			status = http.StatusPartialContent
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, len(blob)))
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(end-start))
+		w.WriteHeader(status)
+		if req.Method == http.MethodGet {
+			data := blob[start:end]
+			if len(r.blobReadFailures) > 0 {
+				failAfter := r.blobReadFailures[0]
+				r.blobReadFailures = r.blobReadFailures[1:]
+				_, err := w.Write(data[:failAfter])
+				assert.NoError(r.t, err)
+				w.(http.Flusher).Flush()
+				panic(http.ErrAbortHandler) // Drops the connection
+			}
+			_, err := w.Write(data)
+			assert.NoError(r.t, err)
+		}
+	case http.MethodDelete:
+		if _, ok := r.blobs[repo+"@"+blobDigest]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(r.blobs, repo+"@"+blobDigest)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *fakeRegistry) serveUpload(w http.ResponseWriter, req *http.Request, repo, uploadID string) {
+	if uploadID == "" {
+		if req.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if mount := req.URL.Query().Get("mount"); mount != "" {
+			blob, ok := r.blobs[req.URL.Query().Get("from")+"@"+mount]
+			if !ok && r.supportsMountWithoutFrom && req.URL.Query().Get("from") == "" {
+				for key, b := range r.blobs {
+					if strings.HasSuffix(key, "@"+mount) {
+						blob, ok = b, true
+						break
+					}
+				}
+			}
+			if ok {
+				r.blobs[repo+"@"+mount] = blob
+				w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", repo, mount))
+				w.Header().Set("Docker-Content-Digest", mount)
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		}
+		uploadID = strconv.Itoa(r.nextUpload)
+		r.nextUpload++
+		r.uploads[uploadID] = []byte{}
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repo, uploadID))
+		if r.chunkMinLength != 0 {
+			w.Header().Set("OCI-Chunk-Min-Length", strconv.Itoa(r.chunkMinLength))
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	data, ok := r.uploads[uploadID]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if req.Method == http.MethodGet {
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repo, uploadID))
+		w.Header().Set("Range", fakeUploadRange(data))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if req.Method == http.MethodDelete {
+		delete(r.uploads, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	contents, err := io.ReadAll(req.Body)
+	assert.NoError(r.t, err)
+	if req.Method == http.MethodPatch {
+		r.uploadChunkSizes = append(r.uploadChunkSizes, len(contents))
+		if contentRange := req.Header.Get("Content-Range"); contentRange != "" {
+			var start, end int
+			if _, err := fmt.Sscanf(contentRange, "%d-%d", &start, &end); err != nil || start != len(data) || end != start+len(contents)-1 {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+		}
+		if len(r.uploadFailures) > 0 {
+			stored := r.uploadFailures[0]
+			r.uploadFailures = r.uploadFailures[1:]
+			r.uploads[uploadID] = append(data, contents[:stored]...)
+			panic(http.ErrAbortHandler) // Drops the connection
+		}
+	}
+	data = append(data, contents...)
+	r.uploads[uploadID] = data
+	switch req.Method {
+	case http.MethodPatch:
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repo, uploadID))
+		w.Header().Set("Range", fakeUploadRange(data))
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPut:
+		expectedDigest, err := digest.Parse(req.URL.Query().Get("digest"))
+		if err != nil || expectedDigest != digest.FromBytes(data) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		delete(r.uploads, uploadID)
+		r.blobs[repo+"@"+expectedDigest.String()] = data
+		w.Header().Set("Docker-Content-Digest", expectedDigest.String())
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// fakeUploadRange returns a Range header value for an upload which has received data, in the format used by docker/distribution.
+func fakeUploadRange(data []byte) string {
+	if len(data) == 0 {
+		return "0-0"
+	}
+	return fmt.Sprintf("0-%d", len(data)-1)
+}
+
+// fakeManifestSubject returns the digest in the "subject" field of contents, if any.
+func fakeManifestSubject(contents []byte) digest.Digest {
+	var m referrerManifestFields
+	if err := json.Unmarshal(contents, &m); err != nil || m.Subject == nil {
+		return ""
+	}
+	return m.Subject.Digest
+}
+
+func (r *fakeRegistry) serveReferrers(w http.ResponseWriter, req *http.Request, repo, subjectDigest string) {
+	if req.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	artifactType := req.URL.Query().Get("artifactType")
+	referrers := []private.Referrer{}
+	for key, m := range r.manifests {
+		if !strings.HasPrefix(key, repo+"@") || fakeManifestSubject(m.contents).String() != subjectDigest {
+			continue
+		}
+		var fields referrerManifestFields
+		err := json.Unmarshal(m.contents, &fields)
+		assert.NoError(r.t, err)
+		referrer := private.Referrer{
+			MediaType:    m.mimeType,
+			Digest:       digest.FromBytes(m.contents),
+			Size:         int64(len(m.contents)),
+			ArtifactType: fields.ArtifactType,
+			Annotations:  fields.Annotations,
+		}
+		if referrer.ArtifactType == "" {
+			referrer.ArtifactType = fields.Config.MediaType
+		}
+		if artifactType == "" || referrer.ArtifactType == artifactType {
+			referrers = append(referrers, referrer)
+		}
+	}
+	sort.Slice(referrers, func(i, j int) bool { return referrers[i].Digest < referrers[j].Digest })
+
+	if r.referrersPageSize != 0 {
+		page, _ := strconv.Atoi(req.URL.Query().Get("page"))
+		start := page * r.referrersPageSize
+		if start > len(referrers) {
+			start = len(referrers)
+		}
+		end := start + r.referrersPageSize
+		if end < len(referrers) {
+			next := url.Values{"page": {strconv.Itoa(page + 1)}}
+			if artifactType != "" {
+				next.Set("artifactType", artifactType)
+			}
+			w.Header().Set("Link", fmt.Sprintf(`</v2/%s/referrers/%s?%s>; rel="next"`, repo, subjectDigest, next.Encode()))
+		} else {
+			end = len(referrers)
+		}
+		referrers = referrers[start:end]
+	}
+
+	index := newReferrersIndex()
+	index.Manifests = referrers
+	body, err := json.Marshal(index)
+	assert.NoError(r.t, err)
+	w.Header().Set("Content-Type", imgspecv1.MediaTypeImageIndex)
+	if artifactType != "" {
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(body)
+	assert.NoError(r.t, err)
+}
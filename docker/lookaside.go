@@ -44,10 +44,11 @@ type registryConfiguration struct {
 	Docker map[string]registryNamespace `json:"docker"`
 }
 
-// registryNamespace defines lookaside locations for a single namespace.
+// registryNamespace defines lookaside locations, and other signature-related options, for a single namespace.
 type registryNamespace struct {
-	SigStore        string `json:"sigstore"`         // For reading, and if SigStoreStaging is not present, for writing.
-	SigStoreStaging string `json:"sigstore-staging"` // For writing only.
+	SigStore               string `json:"sigstore"`                           // For reading, and if SigStoreStaging is not present, for writing.
+	SigStoreStaging        string `json:"sigstore-staging"`                   // For writing only.
+	UseSigstoreAttachments *bool  `json:"use-sigstore-attachments,omitempty"` // For reading and writing; nil means "unset", falling back to parent namespaces.
 }
 
 // signatureStorageBase is an "opaque" type representing a lookaside Docker signature storage.
@@ -63,17 +64,28 @@ func SignatureStorageBaseURL(sys *types.SystemContext, ref types.ImageReference,
 	if !ok {
 		return nil, errors.Errorf("ref must be a dockerReference")
 	}
-	// FIXME? Loading and parsing the config could be cached across calls.
-	dirPath := registriesDirPath(sys)
-	logrus.Debugf(`Using registries.d directory %s for sigstore configuration`, dirPath)
-	config, err := loadAndMergeConfig(dirPath)
+	config, err := loadRegistryConfiguration(sys)
 	if err != nil {
 		return nil, err
 	}
+	return config.signatureStorageBaseURL(dr, write)
+}
 
+// loadRegistryConfiguration returns a registryConfiguration appropriate for sys.
+func loadRegistryConfiguration(sys *types.SystemContext) (*registryConfiguration, error) {
+	// FIXME? Loading and parsing the config could be cached across calls.
+	dirPath := registriesDirPath(sys)
+	logrus.Debugf(`Using registries.d directory %s for sigstore configuration`, dirPath)
+	return loadAndMergeConfig(dirPath)
+}
+
+// signatureStorageBaseURL returns an appropriate signature storage URL for ref, for write access if “write”.
+// the usage of the BaseURL is defined under docker/distribution registries—separate storage of docs/signature-protocols.md
+func (config *registryConfiguration) signatureStorageBaseURL(dr dockerReference, write bool) (*url.URL, error) {
 	topLevel := config.signatureTopLevel(dr, write)
 	var url *url.URL
 	if topLevel != "" {
+		var err error
 		url, err = url.Parse(topLevel)
 		if err != nil {
 			return nil, errors.Wrapf(err, "Invalid signature storage URL %s", topLevel)
@@ -225,6 +237,38 @@ func (ns registryNamespace) signatureTopLevel(write bool) string {
 	return ""
 }
 
+// config.useSigstoreAttachments returns whether sigstore attachments should be used for ref.
+func (config *registryConfiguration) useSigstoreAttachments(ref dockerReference) bool {
+	if config.Docker != nil {
+		// Look for a full match.
+		identity := ref.PolicyConfigurationIdentity()
+		if ns, ok := config.Docker[identity]; ok {
+			logrus.Debugf(` Sigstore attachments: using "docker" namespace %s`, identity)
+			if ns.UseSigstoreAttachments != nil {
+				return *ns.UseSigstoreAttachments
+			}
+		}
+
+		// Look for a match of the possible parent namespaces.
+		for _, name := range ref.PolicyConfigurationNamespaces() {
+			if ns, ok := config.Docker[name]; ok {
+				logrus.Debugf(` Sigstore attachments: using "docker" namespace %s`, name)
+				if ns.UseSigstoreAttachments != nil {
+					return *ns.UseSigstoreAttachments
+				}
+			}
+		}
+	}
+	// Look for a default location
+	if config.DefaultDocker != nil {
+		logrus.Debugf(` Sigstore attachments: using "default-docker" configuration`)
+		if config.DefaultDocker.UseSigstoreAttachments != nil {
+			return *config.DefaultDocker.UseSigstoreAttachments
+		}
+	}
+	return false
+}
+
 // signatureStorageURL returns an URL usable for accessing signature index in base with known manifestDigest.
 // base is not nil from the caller
 // NOTE: Keep this in sync with docs/signature-protocols.md!
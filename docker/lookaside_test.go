@@ -271,6 +271,50 @@ func TestRegistryNamespaceSignatureTopLevel(t *testing.T) {
 	}
 }
 
+func TestRegistryConfigurationUseSigstoreAttachments(t *testing.T) {
+	rTrue := true
+	rFalse := false
+
+	config := registryConfiguration{
+		DefaultDocker: &registryNamespace{UseSigstoreAttachments: &rTrue},
+		Docker: map[string]registryNamespace{
+			"example.com":                        {UseSigstoreAttachments: &rFalse},
+			"example.com/ns1":                    {SigStore: "https://sigstore.example.com"}, // Unset, inherits from parent namespaces.
+			"example.com/ns1/ns2":                {UseSigstoreAttachments: &rTrue},
+			"example.com/ns1/ns2/repo:notlatest": {UseSigstoreAttachments: &rFalse},
+		},
+	}
+	for _, c := range []struct {
+		input    string
+		expected bool
+	}{
+		{"example.com/ns1/ns2/repo:notlatest", false},
+		{"example.com/ns1/ns2/repo:unmatched", true},
+		{"example.com/ns1/notns2/repo:notlatest", false},
+		{"example.com/ns1/repo", false},
+		{"unknown.example.com/busybox", true},
+	} {
+		dr := dockerRefFromString(t, "//"+c.input)
+		res := config.useSigstoreAttachments(dr)
+		assert.Equal(t, c.expected, res, c.input)
+	}
+
+	// Nothing is set
+	config = registryConfiguration{
+		DefaultDocker: &registryNamespace{SigStore: "https://sigstore.example.com"},
+		Docker: map[string]registryNamespace{
+			"example.com": {SigStore: "https://sigstore.example.com"},
+		},
+	}
+	for _, input := range []string{"example.com/repo", "unknown.example.com/busybox"} {
+		dr := dockerRefFromString(t, "//"+input)
+		res := config.useSigstoreAttachments(dr)
+		assert.False(t, res, input)
+	}
+	res := (&registryConfiguration{}).useSigstoreAttachments(dockerRefFromString(t, "//busybox"))
+	assert.False(t, res)
+}
+
 func TestSignatureStorageBaseSignatureStorageURL(t *testing.T) {
 	const mdInput = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
 	const mdMapped = "sha256=0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
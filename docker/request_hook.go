@@ -0,0 +1,127 @@
+package docker
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/types"
+)
+
+// requestAttemptsKey is the context key of an *int counting the attempts to make a request in makeRequestToResolvedURL.
+type requestAttemptsKey struct{}
+
+// withRequestAttempts returns a context which counts the attempts to make a single request, for DockerRegistryRequestEvent.Retry.
+func withRequestAttempts(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestAttemptsKey{}, new(int))
+}
+
+// nextRequestAttempt returns the number of preceding attempts to make a request using ctx, and records another one.
+func nextRequestAttempt(ctx context.Context) int {
+	attempts, ok := ctx.Value(requestAttemptsKey{}).(*int)
+	if !ok {
+		return 0
+	}
+	res := *attempts
+	*attempts++
+	return res
+}
+
+// doRequest executes req using c.client, reporting the request to c.sys.DockerRegistryRequestHook, if any.
+// retry is the number of preceding attempts to make the same request.
+func (c *dockerClient) doRequest(req *http.Request, kind types.DockerRegistryRequestKind, retry int) (*http.Response, error) {
+	if c.sys == nil || c.sys.DockerRegistryRequestHook == nil {
+		return c.client.Do(req)
+	}
+
+	event := types.DockerRegistryRequestEvent{
+		Kind:            kind,
+		Method:          req.Method,
+		URL:             req.URL.Redacted(),
+		Registry:        c.registry,
+		LogicalRegistry: c.logicalRegistry,
+		Retry:           retry,
+		Start:           time.Now(),
+	}
+	if kind != types.DockerRegistryRequestExternal {
+		event.AuthChallengeScheme = c.authChallengeScheme()
+	}
+	var sent *countingReadCloser
+	if req.Body != nil && req.Body != http.NoBody {
+		sent = &countingReadCloser{ReadCloser: req.Body}
+		req.Body = sent
+	}
+
+	res, err := c.client.Do(req)
+	event.HeaderDuration = time.Since(event.Start)
+	if sent != nil {
+		event.BytesSent = sent.count
+	}
+	if err != nil {
+		event.Err = err
+		event.Duration = event.HeaderDuration
+		c.sys.DockerRegistryRequestHook(event)
+		return nil, err
+	}
+	event.StatusCode = res.StatusCode
+	if challenges := parseAuthHeader(res.Header); len(challenges) != 0 {
+		event.AuthChallengeScheme = challenges[0].Scheme
+	}
+	res.Body = &requestHookBody{
+		countingReadCloser: countingReadCloser{ReadCloser: res.Body},
+		hook:               c.sys.DockerRegistryRequestHook,
+		event:              event,
+	}
+	return res, nil
+}
+
+// authChallengeScheme returns the scheme of the challenge from c.challenges which setupRequestAuth uses,
+// or of the first challenge if none of them is supported; "" if there are no challenges.
+func (c *dockerClient) authChallengeScheme() string {
+	for _, challenge := range c.challenges {
+		if challenge.Scheme == "basic" || challenge.Scheme == "bearer" {
+			return challenge.Scheme
+		}
+	}
+	if len(c.challenges) != 0 {
+		return c.challenges[0].Scheme
+	}
+	return ""
+}
+
+// countingReadCloser is an io.ReadCloser which counts the bytes read from it.
+type countingReadCloser struct {
+	io.ReadCloser
+	count int64
+	err   error // The first error returned by Read, other than io.EOF
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.count += int64(n)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+// requestHookBody is a response body which reports the request to hook when it is closed.
+type requestHookBody struct {
+	countingReadCloser
+	hook      func(types.DockerRegistryRequestEvent)
+	event     types.DockerRegistryRequestEvent
+	closeOnce sync.Once
+}
+
+func (b *requestHookBody) Close() error {
+	err := b.countingReadCloser.Close()
+	b.closeOnce.Do(func() {
+		b.event.BytesReceived = b.count
+		b.event.Err = b.err
+		b.event.Duration = time.Since(b.event.Start)
+		b.hook(b.event)
+	})
+	return err
+}
@@ -0,0 +1,178 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/pkg/blobinfocache/memory"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// requestEventRecorder records the events passed to types.SystemContext.DockerRegistryRequestHook.
+type requestEventRecorder struct {
+	mutex  sync.Mutex
+	events []types.DockerRegistryRequestEvent
+}
+
+func (r *requestEventRecorder) hook(event types.DockerRegistryRequestEvent) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, event)
+}
+
+// recorded returns the events recorded so far with the specified method and URL.
+func (r *requestEventRecorder) recorded(method, url string) []types.DockerRegistryRequestEvent {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	res := []types.DockerRegistryRequestEvent{}
+	for _, e := range r.events {
+		if e.Method == method && e.URL == url {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+func TestDockerRegistryRequestHook(t *testing.T) {
+	manifestBlob := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:0000000000000000000000000000000000000000000000000000000000000000","size":1},"layers":[]}`)
+	blob := []byte("blob contents")
+	blobDigest := digest.FromBytes(blob)
+	registry := newFakeRegistry(t)
+	registry.manifests[manifestKey("repo", "tag")] = fakeManifest{mimeType: imgspecv1.MediaTypeImageManifest, contents: manifestBlob}
+	registry.blobs["repo@"+blobDigest.String()] = blob
+	registry.manifestServerErrors = 1
+	recorder := &requestEventRecorder{}
+	sys := sigstoreAttachmentsTestSystemContext(t, registry.host(), false)
+	sys.DockerRegistryRetryPolicy = &types.RetryPolicy{MaxRetries: 1, InitialDelay: time.Millisecond}
+	sys.DockerRegistryRequestHook = recorder.hook
+
+	src, err := newImageSource(context.Background(), sys, dockerRefFromString(t, "//"+registry.host()+"/repo:tag"))
+	require.NoError(t, err)
+	defer src.Close()
+	reader, _, err := src.GetBlob(context.Background(), types.BlobInfo{Digest: blobDigest, Size: -1}, memory.New())
+	require.NoError(t, err)
+	_, err = io.ReadAll(reader)
+	require.NoError(t, err)
+	err = reader.Close()
+	require.NoError(t, err)
+
+	// The ping falls back from HTTPS to HTTP.
+	events := recorder.recorded("GET", "https://"+registry.host()+"/v2/")
+	require.Len(t, events, 1)
+	assert.Error(t, events[0].Err)
+	assert.Equal(t, 0, events[0].StatusCode)
+	events = recorder.recorded("GET", "http://"+registry.host()+"/v2/")
+	require.Len(t, events, 1)
+	assert.Equal(t, 200, events[0].StatusCode)
+
+	events = recorder.recorded("GET", fmt.Sprintf("http://%s/v2/repo/manifests/tag", registry.host()))
+	require.Len(t, events, 2)
+	for i, e := range events {
+		assert.Equal(t, types.DockerRegistryRequestRegistry, e.Kind)
+		assert.Equal(t, registry.host(), e.Registry)
+		assert.Equal(t, registry.host(), e.LogicalRegistry)
+		assert.Equal(t, i, e.Retry)
+	}
+	assert.Equal(t, 503, events[0].StatusCode)
+	assert.Equal(t, 200, events[1].StatusCode)
+	assert.Equal(t, int64(len(manifestBlob)), events[1].BytesReceived)
+
+	events = recorder.recorded("GET", fmt.Sprintf("http://%s/v2/repo/blobs/%s", registry.host(), blobDigest.String()))
+	require.Len(t, events, 1)
+	assert.Equal(t, 200, events[0].StatusCode)
+	assert.Equal(t, 0, events[0].Retry)
+	assert.Equal(t, int64(len(blob)), events[0].BytesReceived)
+	assert.NoError(t, events[0].Err)
+	assert.False(t, events[0].Start.IsZero())
+	assert.True(t, events[0].Duration >= events[0].HeaderDuration)
+}
+
+func TestDockerRegistryRequestHookMirror(t *testing.T) {
+	mirror := newFakeRegistryWithRepos(t, "repo")
+	recorder := &requestEventRecorder{}
+	sys := sigstoreAttachmentsTestSystemContext(t, "registry.example", false)
+	sys.DockerRegistryRequestHook = recorder.hook
+	err := os.WriteFile(sys.SystemRegistriesConfPath, []byte(fmt.Sprintf("[[registry]]\nlocation = \"registry.example\"\n[[registry.mirror]]\nlocation = %q\n", mirror.host())), 0644)
+	require.NoError(t, err)
+
+	src, err := newImageSource(context.Background(), sys, dockerRefFromString(t, "//registry.example/repo:latest"))
+	require.NoError(t, err)
+	src.Close()
+	events := recorder.recorded("GET", fmt.Sprintf("http://%s/v2/repo/manifests/latest", mirror.host()))
+	require.Len(t, events, 1)
+	assert.Equal(t, mirror.host(), events[0].Registry)
+	assert.Equal(t, "registry.example", events[0].LogicalRegistry)
+}
+
+func TestDockerRegistryRequestHookTokenServer(t *testing.T) {
+	sharedBearerTokenCache = newBearerTokenCache()
+	defer func() { sharedBearerTokenCache = newBearerTokenCache() }()
+	tokenServer := newFakeTokenServer(t)
+	recorder := &requestEventRecorder{}
+	sys := &types.SystemContext{DockerRegistryRequestHook: recorder.hook}
+
+	c := newTokenTestClient(sys, tokenServer, types.DockerAuthConfig{Username: "user", Password: "pass"}, "repo")
+	authorizationFor(t, c)
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	require.Len(t, recorder.events, 1)
+	e := recorder.events[0]
+	assert.Equal(t, types.DockerRegistryRequestTokenServer, e.Kind)
+	assert.Equal(t, "GET", e.Method)
+	assert.Equal(t, "bearer", e.AuthChallengeScheme)
+	assert.Equal(t, 200, e.StatusCode)
+	assert.NotZero(t, e.BytesReceived)
+}
+
+func TestDockerRegistryRequestHookAuthChallengeScheme(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			w.Header().Add("WWW-Authenticate", `Bearer realm="https://auth.example/token",service="registry.example"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	for _, c := range []struct {
+		path       string
+		kind       types.DockerRegistryRequestKind
+		challenges []challenge
+		expected   string
+	}{
+		{"/v2/", types.DockerRegistryRequestRegistry, nil, "bearer"},                                         // From the response
+		{"/v2/", types.DockerRegistryRequestRegistry, []challenge{{Scheme: "basic"}}, "bearer"},              // The response takes precedence
+		{"/v2/repo/tags/list", types.DockerRegistryRequestRegistry, nil, ""},                                 // No challenges at all
+		{"/v2/repo/tags/list", types.DockerRegistryRequestRegistry, []challenge{{Scheme: "basic"}}, "basic"}, // From the ping
+		{"/v2/repo/tags/list", types.DockerRegistryRequestTokenServer, []challenge{{Scheme: "negotiate"}, {Scheme: "bearer"}}, "bearer"},
+		{"/v2/repo/tags/list", types.DockerRegistryRequestRegistry, []challenge{{Scheme: "negotiate"}}, "negotiate"},
+		{"/v2/repo/tags/list", types.DockerRegistryRequestExternal, []challenge{{Scheme: "basic"}}, ""}, // Registry challenges don’t apply
+	} {
+		recorder := &requestEventRecorder{}
+		client := &dockerClient{
+			sys:        &types.SystemContext{DockerRegistryRequestHook: recorder.hook},
+			client:     server.Client(),
+			challenges: c.challenges,
+		}
+		req, err := http.NewRequest(http.MethodGet, server.URL+c.path, nil)
+		require.NoError(t, err)
+		res, err := client.doRequest(req, c.kind, 0)
+		require.NoError(t, err)
+		res.Body.Close()
+		events := recorder.recorded(http.MethodGet, server.URL+c.path)
+		require.Len(t, events, 1)
+		assert.Equal(t, c.expected, events[0].AuthChallengeScheme, c.path)
+	}
+}
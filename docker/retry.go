@@ -0,0 +1,90 @@
+package docker
+
+import (
+	"context"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// retryableRequest returns true if a request using method and stream may be retried according to a types.RetryPolicy.
+// Only idempotent requests without a body (i.e. manifest and blob GET/HEAD requests) are retried.
+func retryableRequest(method string, stream io.Reader) bool {
+	return stream == nil && (method == http.MethodGet || method == http.MethodHead)
+}
+
+// isTransientRequestFailure returns true if the result of a request (res, err) indicates a possibly transient failure
+// which is worth retrying.
+func isTransientRequestFailure(res *http.Response, err error) bool {
+	if err != nil {
+		return isTransientError(err)
+	}
+	return res != nil && res.StatusCode >= http.StatusInternalServerError && res.StatusCode != http.StatusNotImplemented
+}
+
+// isTransientError returns true if err, returned from an HTTP request, is a connection reset,
+// a TLS handshake timeout or an unexpected EOF.
+func isTransientError(err error) bool {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	}
+	// net/http does not export the type of this error.
+	return strings.Contains(err.Error(), "TLS handshake timeout")
+}
+
+// retryDelay returns the delay before retry number retry (starting at 0) according to policy,
+// including a random jitter of up to a half of the delay.
+func retryDelay(policy *types.RetryPolicy, retry int) time.Duration {
+	delay := policy.InitialDelay
+	for i := 0; i < retry && (policy.MaxDelay <= 0 || delay < policy.MaxDelay) && delay <= math.MaxInt64/2; i++ {
+		delay *= 2 // exponential back off
+	}
+	if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	if delay > 1 {
+		delay -= time.Duration(rand.Int63n(int64(delay / 2)))
+	}
+	return delay
+}
+
+// makeRequestToResolvedURLWithRetries calls makeRequestToResolvedURLRateLimited, retrying according to policy
+// if the request fails with a possibly transient error.
+// The request must satisfy retryableRequest.
+func (c *dockerClient) makeRequestToResolvedURLWithRetries(ctx context.Context, policy *types.RetryPolicy, method string, url *url.URL, headers map[string][]string, auth sendAuth, extraScope *authScope) (*http.Response, error) {
+	start := time.Now()
+	for retry := 0; ; retry++ {
+		res, err := c.makeRequestToResolvedURLRateLimited(ctx, method, url, headers, nil, -1, auth, extraScope)
+		if retry >= policy.MaxRetries || !isTransientRequestFailure(res, err) {
+			return res, err
+		}
+		delay := retryDelay(policy, retry)
+		if policy.Timeout > 0 && time.Since(start)+delay > policy.Timeout {
+			return res, err
+		}
+		if res != nil {
+			res.Body.Close()
+			logrus.Debugf("%s %s failed with status %d: retrying in %s", method, url.Redacted(), res.StatusCode, delay)
+		} else {
+			logrus.Debugf("%s %s failed: %v: retrying in %s", method, url.Redacted(), err, delay)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+			// Nothing
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetManifestWithRetries(t *testing.T) {
+	manifestBlob := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:0000000000000000000000000000000000000000000000000000000000000000","size":1},"layers":[]}`)
+	for _, c := range []struct {
+		name         string
+		policy       *types.RetryPolicy
+		serverErrors int
+		success      bool
+	}{
+		{"no policy", nil, 1, false},
+		{"retrying disabled", &types.RetryPolicy{MaxRetries: 0, InitialDelay: time.Millisecond}, 1, false},
+		{"retried", &types.RetryPolicy{MaxRetries: 3, InitialDelay: time.Millisecond}, 3, true},
+		{"too many failures", &types.RetryPolicy{MaxRetries: 3, InitialDelay: time.Millisecond}, 4, false},
+		{"timeout", &types.RetryPolicy{MaxRetries: 3, InitialDelay: time.Hour, Timeout: time.Minute}, 1, false},
+	} {
+		registry := newFakeRegistry(t)
+		registry.manifests[manifestKey("repo", "tag")] = fakeManifest{mimeType: imgspecv1.MediaTypeImageManifest, contents: manifestBlob}
+		registry.manifestServerErrors = c.serverErrors
+		sys := sigstoreAttachmentsTestSystemContext(t, registry.host(), false)
+		sys.DockerRegistryRetryPolicy = c.policy
+		// newImageSource reads the manifest.
+		src, err := newImageSource(context.Background(), sys, dockerRefFromString(t, "//"+registry.host()+"/repo:tag"))
+		if c.success {
+			require.NoError(t, err, c.name)
+			defer src.Close()
+			manifest, _, err := src.GetManifest(context.Background(), nil)
+			require.NoError(t, err, c.name)
+			assert.Equal(t, manifestBlob, manifest, c.name)
+		} else {
+			assert.Error(t, err, c.name)
+		}
+	}
+}
+
+func TestIsTransientRequestFailure(t *testing.T) {
+	for _, c := range []struct {
+		status    int
+		err       error
+		transient bool
+	}{
+		{http.StatusOK, nil, false},
+		{http.StatusNotFound, nil, false},
+		{http.StatusTooManyRequests, nil, false},
+		{http.StatusInternalServerError, nil, true},
+		{http.StatusNotImplemented, nil, false},
+		{http.StatusBadGateway, nil, true},
+		{http.StatusServiceUnavailable, nil, true},
+		{0, &url.Error{Op: "Get", URL: "https://example.com", Err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}}, true},
+		{0, &url.Error{Op: "Get", URL: "https://example.com", Err: io.ErrUnexpectedEOF}, true},
+		{0, &url.Error{Op: "Get", URL: "https://example.com", Err: fmt.Errorf("net/http: TLS handshake timeout")}, true},
+		{0, &url.Error{Op: "Get", URL: "https://example.com", Err: syscall.ECONNREFUSED}, false},
+		{0, &url.Error{Op: "Get", URL: "https://example.com", Err: context.Canceled}, false},
+	} {
+		var res *http.Response
+		if c.err == nil {
+			res = &http.Response{StatusCode: c.status}
+		}
+		assert.Equal(t, c.transient, isTransientRequestFailure(res, c.err), "%d %v", c.status, c.err)
+	}
+}
+
+func TestRetryableRequest(t *testing.T) {
+	assert.True(t, retryableRequest(http.MethodGet, nil))
+	assert.True(t, retryableRequest(http.MethodHead, nil))
+	assert.False(t, retryableRequest(http.MethodPut, nil))
+	assert.False(t, retryableRequest(http.MethodDelete, nil))
+	assert.False(t, retryableRequest(http.MethodGet, &io.LimitedReader{}))
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := &types.RetryPolicy{MaxRetries: 10, InitialDelay: time.Second, MaxDelay: 10 * time.Second}
+	for retry, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		delay := retryDelay(policy, retry)
+		assert.LessOrEqual(t, delay, expected, "%d", retry)
+		assert.Greater(t, delay, expected/2, "%d", retry)
+	}
+
+	policy = &types.RetryPolicy{MaxRetries: 100, InitialDelay: time.Second}
+	delay := retryDelay(policy, 100)
+	assert.Greater(t, delay, time.Duration(0))
+}
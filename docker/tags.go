@@ -0,0 +1,300 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/semaphore"
+)
+
+// defaultTagDigestConcurrency is the default value of TagListOptions.DigestConcurrency.
+const defaultTagDigestConcurrency = 4
+
+// TagFilter selects tags returned by a TagLister; it returns true if tag should be returned.
+type TagFilter func(tag string) bool
+
+// RegexpTagFilter returns a TagFilter accepting tags which match re.
+func RegexpTagFilter(re *regexp.Regexp) TagFilter {
+	return re.MatchString
+}
+
+// SemverTagFilter returns a TagFilter accepting tags which are semantic versions, optionally prefixed by "v",
+// and are >= min and < max.  min and max are semantic versions as well, and either can be "" to not restrict
+// the range in that direction.
+func SemverTagFilter(min, max string) (TagFilter, error) {
+	var minVersion, maxVersion *semver
+	if min != "" {
+		v, ok := parseSemver(min)
+		if !ok {
+			return nil, errors.Errorf("invalid semantic version %q", min)
+		}
+		minVersion = &v
+	}
+	if max != "" {
+		v, ok := parseSemver(max)
+		if !ok {
+			return nil, errors.Errorf("invalid semantic version %q", max)
+		}
+		maxVersion = &v
+	}
+	return func(tag string) bool {
+		v, ok := parseSemver(tag)
+		if !ok {
+			return false
+		}
+		return (minVersion == nil || v.compare(*minVersion) >= 0) && (maxVersion == nil || v.compare(*maxVersion) < 0)
+	}, nil
+}
+
+// TagListOptions are options for NewTagLister.
+type TagListOptions struct {
+	// If > 0, the number of tags to ask the registry for in each request.
+	// Registries may return fewer tags per request.
+	PageSize int
+	// If not "", only tags sorted after Last are returned.
+	Last string
+	// If not nil, only tags accepted by Filter are returned.
+	Filter TagFilter
+	// If true, the digest of the manifest each returned tag refers to is looked up, using a HEAD request per tag.
+	ResolveDigests bool
+	// The maximum number of concurrent requests used with ResolveDigests; defaultTagDigestConcurrency if <= 0.
+	DigestConcurrency int
+}
+
+// RepositoryTag is a tag returned by TagLister.
+type RepositoryTag struct {
+	Name string
+	// The digest of the manifest the tag refers to; only set if TagListOptions.ResolveDigests was set.
+	Digest digest.Digest
+}
+
+// TagLister lists tags of a repository one page at a time, without loading the full list into memory.
+type TagLister struct {
+	client  *dockerClient
+	ref     dockerReference
+	options TagListOptions
+	path    string // The path of the next page, or "" if all pages have been read.
+}
+
+// NewTagLister returns a TagLister for the repository of ref; the tag in ref, if any, is ignored.
+// options may be nil.
+func NewTagLister(sys *types.SystemContext, ref types.ImageReference, options *TagListOptions) (*TagLister, error) {
+	dr, ok := ref.(dockerReference)
+	if !ok {
+		return nil, errors.Errorf("ref must be a dockerReference")
+	}
+	client, err := newDockerClientFromRef(sys, dr, false, "pull")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create client")
+	}
+
+	l := &TagLister{
+		client: client,
+		ref:    dr,
+	}
+	if options != nil {
+		l.options = *options
+	}
+	if l.options.DigestConcurrency <= 0 {
+		l.options.DigestConcurrency = defaultTagDigestConcurrency
+	}
+	l.path = fmt.Sprintf(tagsPath, reference.Path(dr.ref))
+	query := url.Values{}
+	if l.options.PageSize > 0 {
+		query.Set("n", strconv.Itoa(l.options.PageSize))
+	}
+	if l.options.Last != "" {
+		query.Set("last", l.options.Last)
+	}
+	if len(query) != 0 {
+		l.path += "?" + query.Encode()
+	}
+	return l, nil
+}
+
+// NextPage returns the next non-empty page of tags, in the order returned by the registry.
+// It returns io.EOF after all tags have been returned.
+func (l *TagLister) NextPage(ctx context.Context) ([]RepositoryTag, error) {
+	for l.path != "" {
+		names, err := l.fetchPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		tags := []RepositoryTag{}
+		for _, name := range names {
+			if l.options.Filter == nil || l.options.Filter(name) {
+				tags = append(tags, RepositoryTag{Name: name})
+			}
+		}
+		if len(tags) == 0 {
+			continue
+		}
+		if l.options.ResolveDigests {
+			if err := l.resolveDigests(ctx, tags); err != nil {
+				return nil, err
+			}
+		}
+		return tags, nil
+	}
+	return nil, io.EOF
+}
+
+// fetchPage reads the page at l.path, and updates l.path to point to the next page.
+func (l *TagLister) fetchPage(ctx context.Context) ([]string, error) {
+	res, err := l.client.makeRequest(ctx, http.MethodGet, l.path, nil, nil, v2Auth, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if err := httpResponseToError(res, "fetching tags list"); err != nil {
+		return nil, err
+	}
+
+	var tagsHolder struct {
+		Tags []string
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tagsHolder); err != nil {
+		return nil, err
+	}
+
+	l.path, err = nextPagePath(res)
+	if err != nil {
+		return nil, err
+	}
+	return tagsHolder.Tags, nil
+}
+
+// resolveDigests sets the Digest field of all of tags, using up to l.options.DigestConcurrency concurrent requests.
+func (l *TagLister) resolveDigests(ctx context.Context, tags []RepositoryTag) error {
+	sem := semaphore.NewWeighted(int64(l.options.DigestConcurrency))
+	var (
+		wg       sync.WaitGroup
+		errMutex sync.Mutex
+		firstErr error
+	)
+	for i := range tags {
+		if err := sem.Acquire(ctx, 1); err != nil {
+			errMutex.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			errMutex.Unlock()
+			break
+		}
+		wg.Add(1)
+		go func(tag *RepositoryTag) {
+			defer sem.Release(1)
+			defer wg.Done()
+			d, err := l.client.getManifestDigest(ctx, l.ref, tag.Name)
+			if err != nil {
+				errMutex.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errMutex.Unlock()
+				return
+			}
+			tag.Digest = d
+		}(&tags[i])
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// semver is a parsed semantic version, as defined by https://semver.org .
+type semver struct {
+	major, minor, patch uint64
+	prerelease          []string // nil if none
+}
+
+// semverRegexp matches a semantic version, optionally prefixed by "v".
+var semverRegexp = regexp.MustCompile(`^v?(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(?:-([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?(?:\+[0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*)?$`)
+
+// parseSemver parses s as a semantic version, optionally prefixed by "v".
+func parseSemver(s string) (semver, bool) {
+	m := semverRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return semver{}, false
+	}
+	var res semver
+	for i, dest := range []*uint64{&res.major, &res.minor, &res.patch} {
+		v, err := strconv.ParseUint(m[i+1], 10, 64)
+		if err != nil {
+			return semver{}, false
+		}
+		*dest = v
+	}
+	if m[4] != "" {
+		res.prerelease = strings.Split(m[4], ".")
+	}
+	return res, true
+}
+
+// compare returns -1, 0 or 1 if v is lower than, equal to, or greater than other, respectively, in semantic version precedence.
+func (v semver) compare(other semver) int {
+	for _, pair := range [][2]uint64{{v.major, other.major}, {v.minor, other.minor}, {v.patch, other.patch}} {
+		if pair[0] != pair[1] {
+			if pair[0] < pair[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+	// A version without a prerelease has a higher precedence than one with a prerelease.
+	switch {
+	case v.prerelease == nil && other.prerelease == nil:
+		return 0
+	case v.prerelease == nil:
+		return 1
+	case other.prerelease == nil:
+		return -1
+	}
+	for i := 0; i < len(v.prerelease) && i < len(other.prerelease); i++ {
+		if c := comparePrereleaseIdentifiers(v.prerelease[i], other.prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(v.prerelease) < len(other.prerelease):
+		return -1
+	case len(v.prerelease) > len(other.prerelease):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// comparePrereleaseIdentifiers compares two dot-separated identifiers of a semantic version prerelease.
+func comparePrereleaseIdentifiers(a, b string) int {
+	aNum, aErr := strconv.ParseUint(a, 10, 64)
+	bNum, bErr := strconv.ParseUint(b, 10, 64)
+	switch {
+	case aErr == nil && bErr == nil:
+		switch {
+		case aNum < bNum:
+			return -1
+		case aNum > bNum:
+			return 1
+		default:
+			return 0
+		}
+	case aErr == nil: // Numeric identifiers have a lower precedence than alphanumeric ones.
+		return -1
+	case bErr == nil:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
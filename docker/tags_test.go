@@ -0,0 +1,127 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagLister(t *testing.T) {
+	registry := newFakeRegistry(t)
+	tagNames := []string{"1.0.0", "1.1.0", "1.2.0-rc.1", "2.0.0", "latest", "v1.1.5"}
+	digests := map[string]digest.Digest{}
+	for i, tag := range tagNames {
+		manifestBlob := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:0000000000000000000000000000000000000000000000000000000000000000","size":%d},"layers":[]}`, i))
+		registry.manifests[manifestKey("repo", tag)] = fakeManifest{mimeType: imgspecv1.MediaTypeImageManifest, contents: manifestBlob}
+		digests[tag] = digest.FromBytes(manifestBlob)
+	}
+	sys := sigstoreAttachmentsTestSystemContext(t, registry.host(), false)
+	ref := dockerRefFromString(t, "//"+registry.host()+"/repo:ignored")
+
+	semverFilter, err := SemverTagFilter("1.1.0", "2.0.0")
+	require.NoError(t, err)
+	for _, c := range []struct {
+		name    string
+		options *TagListOptions
+		pages   [][]string
+	}{
+		{"default", nil, [][]string{tagNames}},
+		{"paged", &TagListOptions{PageSize: 4}, [][]string{{"1.0.0", "1.1.0", "1.2.0-rc.1", "2.0.0"}, {"latest", "v1.1.5"}}},
+		{"last", &TagListOptions{PageSize: 2, Last: "1.2.0-rc.1"}, [][]string{{"2.0.0", "latest"}, {"v1.1.5"}}},
+		{"regexp", &TagListOptions{Filter: RegexpTagFilter(regexp.MustCompile(`^1\.`))}, [][]string{{"1.0.0", "1.1.0", "1.2.0-rc.1"}}},
+		{"empty pages are skipped", &TagListOptions{PageSize: 2, Filter: RegexpTagFilter(regexp.MustCompile(`^v`))}, [][]string{{"v1.1.5"}}},
+		{"semver", &TagListOptions{PageSize: 3, Filter: semverFilter}, [][]string{{"1.1.0", "1.2.0-rc.1"}, {"v1.1.5"}}},
+	} {
+		lister, err := NewTagLister(sys, ref, c.options)
+		require.NoError(t, err, c.name)
+		pages := [][]string{}
+		for {
+			page, err := lister.NextPage(context.Background())
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err, c.name)
+			names := []string{}
+			for _, tag := range page {
+				names = append(names, tag.Name)
+				assert.Equal(t, digest.Digest(""), tag.Digest, c.name)
+			}
+			pages = append(pages, names)
+		}
+		assert.Equal(t, c.pages, pages, c.name)
+	}
+
+	// ResolveDigests
+	lister, err := NewTagLister(sys, ref, &TagListOptions{PageSize: 4, ResolveDigests: true, DigestConcurrency: 2})
+	require.NoError(t, err)
+	seen := 0
+	for {
+		page, err := lister.NextPage(context.Background())
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		for _, tag := range page {
+			assert.Equal(t, digests[tag.Name], tag.Digest, tag.Name)
+			seen++
+		}
+	}
+	assert.Equal(t, len(tagNames), seen)
+
+	// GetRepositoryTags
+	tags, err := GetRepositoryTags(context.Background(), sys, ref)
+	require.NoError(t, err)
+	assert.Equal(t, tagNames, tags)
+}
+
+func TestSemverTagFilter(t *testing.T) {
+	_, err := SemverTagFilter("1.0", "")
+	assert.Error(t, err)
+	_, err = SemverTagFilter("", "latest")
+	assert.Error(t, err)
+
+	filter, err := SemverTagFilter("", "")
+	require.NoError(t, err)
+	for _, tag := range []string{"0.0.0", "1.2.3", "v1.2.3", "1.0.0-alpha.1", "1.0.0-0.3.7"} {
+		assert.True(t, filter(tag), tag)
+	}
+	for _, tag := range []string{"latest", "1.2", "01.2.3", "1.2.3.4", "V1.2.3", "1.0.0-"} {
+		assert.False(t, filter(tag), tag)
+	}
+
+	filter, err = SemverTagFilter("1.0.0-beta", "2.0.0")
+	require.NoError(t, err)
+	for _, tag := range []string{"1.0.0-beta", "1.0.0-beta.2", "1.0.0-rc.1", "1.0.0", "1.9.99", "2.0.0-rc.1"} {
+		assert.True(t, filter(tag), tag)
+	}
+	for _, tag := range []string{"1.0.0-alpha", "1.0.0-5", "0.9.0", "2.0.0", "3.0.0"} {
+		assert.False(t, filter(tag), tag)
+	}
+}
+
+func TestSemverCompare(t *testing.T) {
+	// The precedence example from https://semver.org .
+	ordered := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "2.0.0", "2.1.0", "2.1.1"}
+	for i, a := range ordered {
+		va, ok := parseSemver(a)
+		require.True(t, ok, a)
+		for j, b := range ordered {
+			vb, ok := parseSemver(b)
+			require.True(t, ok, b)
+			expected := 0
+			if i < j {
+				expected = -1
+			} else if i > j {
+				expected = 1
+			}
+			assert.Equal(t, expected, va.compare(vb), "%s vs. %s", a, b)
+		}
+	}
+}
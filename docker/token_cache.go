@@ -0,0 +1,218 @@
+package docker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	"github.com/containers/storage/pkg/ioutils"
+	"github.com/sirupsen/logrus"
+)
+
+// bearerTokenCache is a cache of bearer tokens, shared by dockerClient instances, and optionally backed by a file
+// to share the tokens with other processes.
+// Tokens are keyed by the realm, service, scopes and credentials used to obtain them (see bearerTokenCacheKey);
+// the credentials are only included as a hash, so the keys can be stored without revealing them.
+type bearerTokenCache struct {
+	mutex         sync.Mutex
+	tokens        map[string]bearerToken            // Keyed by bearerTokenCacheKey
+	refreshTokens map[string]refreshTokenCacheEntry // Keyed by refreshTokenCacheKey; never stored in the file.
+}
+
+// refreshTokenCacheEntry is a single refresh token in bearerTokenCache.
+type refreshTokenCacheEntry struct {
+	token    string
+	lastUsed time.Time
+}
+
+// refreshTokenIdleLifetime is the time after which unused refresh tokens are removed from bearerTokenCache.
+// Refresh tokens don’t have a known expiration time; this only bounds the memory used by long-running processes.
+const refreshTokenIdleLifetime = 24 * time.Hour
+
+// bearerTokenCacheFile is the format of types.SystemContext.DockerBearerTokenCachePath.
+type bearerTokenCacheFile struct {
+	Tokens map[string]bearerTokenCacheFileEntry `json:"tokens"`
+}
+
+// bearerTokenCacheFileEntry is a single token in bearerTokenCacheFile.
+type bearerTokenCacheFileEntry struct {
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// sharedBearerTokenCache is used by all dockerClient instances in this process.
+var sharedBearerTokenCache = newBearerTokenCache()
+
+// newBearerTokenCache returns a new, empty, bearerTokenCache.
+func newBearerTokenCache() *bearerTokenCache {
+	return &bearerTokenCache{
+		tokens:        map[string]bearerToken{},
+		refreshTokens: map[string]refreshTokenCacheEntry{},
+	}
+}
+
+// bearerTokenCacheKey returns a key identifying tokens obtained from the server described by challenge, for scopes, using auth.
+func bearerTokenCacheKey(challenge challenge, scopes []authScope, auth types.DockerAuthConfig) string {
+	scopeStrings := []string{}
+	for _, scope := range scopes {
+		if scope.resourceType != "" && scope.remoteName != "" && scope.actions != "" {
+			scopeStrings = append(scopeStrings, fmt.Sprintf("%s:%s:%s", scope.resourceType, scope.remoteName, scope.actions))
+		}
+	}
+	sort.Strings(scopeStrings)
+	scopeStrings = append([]string{"token"}, scopeStrings...)
+	return hashTokenCacheKey(challenge, auth, scopeStrings)
+}
+
+// refreshTokenCacheKey returns a key identifying OAuth2 refresh tokens obtained from the server described by challenge, using auth.
+func refreshTokenCacheKey(challenge challenge, auth types.DockerAuthConfig) string {
+	return hashTokenCacheKey(challenge, auth, []string{"refresh"})
+}
+
+// hashTokenCacheKey returns a hash of challenge, auth and extra, usable as a key in bearerTokenCache.
+func hashTokenCacheKey(challenge challenge, auth types.DockerAuthConfig, extra []string) string {
+	h := sha256.New()
+	for _, v := range append([]string{challenge.Parameters["realm"], challenge.Parameters["service"], auth.Username, auth.Password, auth.IdentityToken}, extra...) {
+		// Include the length of every value, so that the boundaries between values are unambiguous.
+		fmt.Fprintf(h, "%d:%s\x00", len(v), v)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// lookup returns an unexpired token for key, if any; if path is not "", it is used as a file-backed cache.
+func (c *bearerTokenCache) lookup(path, key string) (bearerToken, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	if token, ok := c.tokens[key]; ok {
+		if now.Before(token.expirationTime) {
+			return token, true
+		}
+		delete(c.tokens, key)
+	}
+	if path == "" {
+		return bearerToken{}, false
+	}
+	file, err := readBearerTokenCacheFile(path)
+	if err != nil {
+		logrus.Debugf("Error reading bearer token cache %q, ignoring it: %v", path, err)
+		return bearerToken{}, false
+	}
+	entry, ok := file.Tokens[key]
+	if !ok || !now.Before(entry.Expires) {
+		return bearerToken{}, false
+	}
+	token := bearerToken{Token: entry.Token, expirationTime: entry.Expires}
+	c.tokens[key] = token
+	return token, true
+}
+
+// store records token for key, and removes expired tokens; if path is not "", it is used as a file-backed cache.
+// Failures to update the file are logged and otherwise ignored.
+func (c *bearerTokenCache) store(path, key string, token bearerToken) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.pruneLocked(time.Now())
+	c.tokens[key] = token
+	if path == "" {
+		return
+	}
+	if err := updateBearerTokenCacheFile(path, key, token); err != nil {
+		logrus.Debugf("Error updating bearer token cache %q: %v", path, err)
+	}
+}
+
+// lookupRefreshToken returns a refresh token for key, if any.
+func (c *bearerTokenCache) lookupRefreshToken(key string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.refreshTokens[key]
+	if !ok {
+		return "", false
+	}
+	entry.lastUsed = time.Now()
+	c.refreshTokens[key] = entry
+	return entry.token, true
+}
+
+// storeRefreshToken records refreshToken for key; if refreshToken is "", any recorded value is removed.
+func (c *bearerTokenCache) storeRefreshToken(key, refreshToken string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	c.pruneLocked(now)
+	if refreshToken == "" {
+		delete(c.refreshTokens, key)
+	} else {
+		c.refreshTokens[key] = refreshTokenCacheEntry{token: refreshToken, lastUsed: now}
+	}
+}
+
+// pruneLocked removes tokens which have expired at now, and refresh tokens unused for refreshTokenIdleLifetime.
+// The caller must hold c.mutex.
+func (c *bearerTokenCache) pruneLocked(now time.Time) {
+	for k, v := range c.tokens {
+		if !now.Before(v.expirationTime) {
+			delete(c.tokens, k)
+		}
+	}
+	for k, v := range c.refreshTokens {
+		if now.Sub(v.lastUsed) >= refreshTokenIdleLifetime {
+			delete(c.refreshTokens, k)
+		}
+	}
+}
+
+// readBearerTokenCacheFile reads path, returning an empty cache if it does not exist.
+func readBearerTokenCacheFile(path string) (bearerTokenCacheFile, error) {
+	res := bearerTokenCacheFile{Tokens: map[string]bearerTokenCacheFileEntry{}}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return res, nil
+		}
+		return bearerTokenCacheFile{}, err
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return bearerTokenCacheFile{}, err
+	}
+	if res.Tokens == nil {
+		res.Tokens = map[string]bearerTokenCacheFileEntry{}
+	}
+	return res, nil
+}
+
+// updateBearerTokenCacheFile records token for key in path, and removes expired tokens.
+// Concurrent updates by other processes may be lost; that only causes them to obtain new tokens later.
+func updateBearerTokenCacheFile(path, key string, token bearerToken) error {
+	file, err := readBearerTokenCacheFile(path)
+	if err != nil {
+		logrus.Debugf("Error reading bearer token cache %q, replacing it: %v", path, err)
+		file = bearerTokenCacheFile{Tokens: map[string]bearerTokenCacheFileEntry{}}
+	}
+	now := time.Now()
+	for k, v := range file.Tokens {
+		if !now.Before(v.Expires) {
+			delete(file.Tokens, k)
+		}
+	}
+	file.Tokens[key] = bearerTokenCacheFileEntry{Token: token.Token, Expires: token.expirationTime}
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutils.AtomicWriteFile(path, data, 0600)
+}
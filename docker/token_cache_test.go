@@ -0,0 +1,201 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTokenServer is a minimal token server, recording the requests it receives.
+type fakeTokenServer struct {
+	server       *httptest.Server
+	mutex        sync.Mutex
+	requests     []*http.Request // With parsed forms
+	refreshToken string          // If not "", returned as refresh_token
+}
+
+// newFakeTokenServer returns a new fakeTokenServer, which will be shut down at the end of t.
+func newFakeTokenServer(t *testing.T) *fakeTokenServer {
+	s := &fakeTokenServer{}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		err := req.ParseForm()
+		assert.NoError(t, err)
+		s.requests = append(s.requests, req)
+		err = json.NewEncoder(w).Encode(map[string]interface{}{
+			"token":         "token-" + req.Form.Get("scope"),
+			"expires_in":    3600,
+			"refresh_token": s.refreshToken,
+		})
+		assert.NoError(t, err)
+	}))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// numRequests returns the number of token requests received so far.
+func (s *fakeTokenServer) numRequests() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.requests)
+}
+
+// newTokenTestClient returns a dockerClient which uses tokenServer for bearer authentication.
+func newTokenTestClient(sys *types.SystemContext, tokenServer *fakeTokenServer, auth types.DockerAuthConfig, remoteName string) *dockerClient {
+	return &dockerClient{
+		sys:       sys,
+		userAgent: defaultUserAgent,
+		auth:      auth,
+		scope:     authScope{resourceType: "repository", remoteName: remoteName, actions: "pull"},
+		client:    tokenServer.server.Client(),
+		challenges: []challenge{{
+			Scheme:     "bearer",
+			Parameters: map[string]string{"realm": tokenServer.server.URL, "service": "registry.example"},
+		}},
+	}
+}
+
+// authorizationFor returns the Authorization header c uses for a request.
+func authorizationFor(t *testing.T, c *dockerClient) string {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "https://registry.example/v2/", nil)
+	require.NoError(t, err)
+	err = c.setupRequestAuth(req, nil)
+	require.NoError(t, err)
+	return req.Header.Get("Authorization")
+}
+
+func TestSharedBearerTokenCache(t *testing.T) {
+	sharedBearerTokenCache = newBearerTokenCache()
+	defer func() { sharedBearerTokenCache = newBearerTokenCache() }()
+	tokenServer := newFakeTokenServer(t)
+	auth := types.DockerAuthConfig{Username: "user", Password: "pass"}
+
+	// Tokens are shared by clients.
+	for i := 0; i < 3; i++ {
+		c := newTokenTestClient(nil, tokenServer, auth, "repo")
+		assert.Equal(t, "Bearer token-repository:repo:pull", authorizationFor(t, c))
+	}
+	assert.Equal(t, 1, tokenServer.numRequests())
+
+	// Different scopes or credentials use different tokens.
+	c := newTokenTestClient(nil, tokenServer, auth, "other")
+	assert.Equal(t, "Bearer token-repository:other:pull", authorizationFor(t, c))
+	assert.Equal(t, 2, tokenServer.numRequests())
+	c = newTokenTestClient(nil, tokenServer, types.DockerAuthConfig{Username: "user", Password: "other"}, "repo")
+	authorizationFor(t, c)
+	assert.Equal(t, 3, tokenServer.numRequests())
+
+	// Expired tokens are not used.
+	key := bearerTokenCacheKey(c.challenges[0], []authScope{c.scope}, c.auth)
+	sharedBearerTokenCache.store("", key, bearerToken{Token: "expired", expirationTime: time.Now().Add(-time.Second)})
+	c = newTokenTestClient(nil, tokenServer, c.auth, "repo")
+	assert.Equal(t, "Bearer token-repository:repo:pull", authorizationFor(t, c))
+	assert.Equal(t, 4, tokenServer.numRequests())
+}
+
+func TestBearerTokenCachePruning(t *testing.T) {
+	cache := newBearerTokenCache()
+	now := time.Now()
+	cache.store("", "expired", bearerToken{Token: "expired", expirationTime: now.Add(-time.Second)})
+	cache.store("", "valid", bearerToken{Token: "valid", expirationTime: now.Add(time.Hour)})
+	cache.storeRefreshToken("idle", "idle")
+	cache.storeRefreshToken("used", "used")
+	cache.refreshTokens["idle"] = refreshTokenCacheEntry{token: "idle", lastUsed: now.Add(-refreshTokenIdleLifetime - time.Second)}
+	cache.refreshTokens["used"] = refreshTokenCacheEntry{token: "used", lastUsed: now.Add(-refreshTokenIdleLifetime - time.Second)}
+	_, ok := cache.lookupRefreshToken("used")
+	assert.True(t, ok)
+
+	// Storing a token removes expired tokens and idle refresh tokens.
+	cache.store("", "new", bearerToken{Token: "new", expirationTime: now.Add(time.Hour)})
+	assert.Len(t, cache.tokens, 2)
+	assert.Contains(t, cache.tokens, "valid")
+	assert.Contains(t, cache.tokens, "new")
+	assert.Len(t, cache.refreshTokens, 1)
+	assert.Contains(t, cache.refreshTokens, "used")
+
+	// So does storing a refresh token.
+	cache.tokens["valid"] = bearerToken{Token: "valid", expirationTime: now.Add(-time.Second)}
+	cache.storeRefreshToken("other", "other")
+	assert.Len(t, cache.tokens, 1)
+	assert.Contains(t, cache.tokens, "new")
+	assert.Len(t, cache.refreshTokens, 2)
+}
+
+func TestBearerTokenCacheFile(t *testing.T) {
+	sharedBearerTokenCache = newBearerTokenCache()
+	defer func() { sharedBearerTokenCache = newBearerTokenCache() }()
+	tokenServer := newFakeTokenServer(t)
+	auth := types.DockerAuthConfig{Username: "user", Password: "pass"}
+	cachePath := filepath.Join(t.TempDir(), "subdir", "tokens.json")
+	sys := &types.SystemContext{DockerBearerTokenCachePath: cachePath}
+
+	c := newTokenTestClient(sys, tokenServer, auth, "repo")
+	assert.Equal(t, "Bearer token-repository:repo:pull", authorizationFor(t, c))
+	assert.Equal(t, 1, tokenServer.numRequests())
+	fi, err := os.Stat(cachePath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	contents, err := os.ReadFile(cachePath)
+	require.NoError(t, err)
+	assert.NotContains(t, string(contents), "pass")
+
+	// Simulate a different process.
+	sharedBearerTokenCache = newBearerTokenCache()
+	c = newTokenTestClient(sys, tokenServer, auth, "repo")
+	assert.Equal(t, "Bearer token-repository:repo:pull", authorizationFor(t, c))
+	assert.Equal(t, 1, tokenServer.numRequests())
+
+	// Without the file, the token is not found.
+	sharedBearerTokenCache = newBearerTokenCache()
+	c = newTokenTestClient(nil, tokenServer, auth, "repo")
+	authorizationFor(t, c)
+	assert.Equal(t, 2, tokenServer.numRequests())
+
+	// Expired tokens are removed from the file.
+	cache := newBearerTokenCache()
+	cache.store(cachePath, "expired", bearerToken{Token: "expired", expirationTime: time.Now().Add(-time.Second)})
+	cache.store(cachePath, "valid", bearerToken{Token: "valid", expirationTime: time.Now().Add(time.Hour)})
+	file, err := readBearerTokenCacheFile(cachePath)
+	require.NoError(t, err)
+	assert.NotContains(t, file.Tokens, "expired")
+	assert.Contains(t, file.Tokens, "valid")
+	_, ok := newBearerTokenCache().lookup(cachePath, "expired")
+	assert.False(t, ok)
+
+	// An invalid file is ignored, and replaced.
+	err = os.WriteFile(cachePath, []byte("invalid"), 0600)
+	require.NoError(t, err)
+	_, ok = newBearerTokenCache().lookup(cachePath, "valid")
+	assert.False(t, ok)
+	cache.store(cachePath, "valid", bearerToken{Token: "valid", expirationTime: time.Now().Add(time.Hour)})
+	token, ok := newBearerTokenCache().lookup(cachePath, "valid")
+	require.True(t, ok)
+	assert.Equal(t, "valid", token.Token)
+}
+
+func TestBearerTokenOAuth2RefreshTokenReuse(t *testing.T) {
+	sharedBearerTokenCache = newBearerTokenCache()
+	defer func() { sharedBearerTokenCache = newBearerTokenCache() }()
+	tokenServer := newFakeTokenServer(t)
+	tokenServer.refreshToken = "refreshed"
+	auth := types.DockerAuthConfig{IdentityToken: "identity"}
+
+	c := newTokenTestClient(nil, tokenServer, auth, "repo")
+	authorizationFor(t, c)
+	c = newTokenTestClient(nil, tokenServer, auth, "other")
+	authorizationFor(t, c)
+	require.Equal(t, 2, tokenServer.numRequests())
+	assert.Equal(t, "identity", tokenServer.requests[0].Form.Get("refresh_token"))
+	assert.Equal(t, "refreshed", tokenServer.requests[1].Form.Get("refresh_token"))
+}
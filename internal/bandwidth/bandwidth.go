@@ -0,0 +1,60 @@
+// Package bandwidth implements limiting the throughput of data streams using a shared token-bucket rate limiter.
+package bandwidth
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// NewLimiter returns a rate limiter allowing bytesPerSecond bytes per second, with a burst of one second worth of data.
+func NewLimiter(bytesPerSecond int64) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(bytesPerSecond))
+}
+
+// limitedReader is an io.Reader which waits for limiter before returning data.
+type limitedReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *rate.Limiter
+}
+
+// NewReader returns a reader which reads data from reader, waiting as necessary so that the total throughput
+// of all readers using limiter does not exceed the limit of limiter.
+// ctx is used to cancel waiting.
+func NewReader(ctx context.Context, reader io.Reader, limiter *rate.Limiter) io.Reader {
+	if limiter == nil || limiter.Limit() == rate.Inf {
+		return reader
+	}
+	return &limitedReader{ctx: ctx, reader: reader, limiter: limiter}
+}
+
+// readCloser is the return value of NewReadCloser.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// NewReadCloser is like NewReader, but returns an io.ReadCloser which closes reader.
+func NewReadCloser(ctx context.Context, reader io.ReadCloser, limiter *rate.Limiter) io.ReadCloser {
+	if limiter == nil || limiter.Limit() == rate.Inf {
+		return reader
+	}
+	return readCloser{Reader: NewReader(ctx, reader, limiter), Closer: reader}
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	// rate.Limiter.WaitN fails if asked for more than the burst size, so read at most that much at a time.
+	if burst := r.limiter.Burst(); len(p) > burst && burst > 0 {
+		p = p[:burst]
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		// Account for the data after reading it, so that a slow source is not penalized for the size of the buffer.
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
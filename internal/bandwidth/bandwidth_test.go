@@ -0,0 +1,60 @@
+package bandwidth
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestNewReader(t *testing.T) {
+	data := bytes.Repeat([]byte{'x'}, 15000)
+
+	// No limit
+	for _, limiter := range []*rate.Limiter{nil, rate.NewLimiter(rate.Inf, 0)} {
+		src := bytes.NewReader(data)
+		assert.Equal(t, src, NewReader(context.Background(), src, limiter))
+	}
+
+	// The burst is available immediately, the rest is limited.
+	limiter := NewLimiter(10000)
+	start := time.Now()
+	read, err := io.ReadAll(NewReader(context.Background(), bytes.NewReader(data), limiter))
+	require.NoError(t, err)
+	assert.Equal(t, data, read)
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+
+	// The limit is shared by readers.
+	limiter = NewLimiter(10000)
+	start = time.Now()
+	for i := 0; i < 2; i++ {
+		read, err := io.ReadAll(NewReader(context.Background(), bytes.NewReader(data[:7500]), limiter))
+		require.NoError(t, err)
+		assert.Equal(t, data[:7500], read)
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+
+	// Waiting can be canceled.
+	limiter = NewLimiter(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = io.ReadAll(NewReader(ctx, bytes.NewReader(data), limiter))
+	assert.Error(t, err)
+}
+
+func TestNewReadCloser(t *testing.T) {
+	src := io.NopCloser(bytes.NewReader([]byte("data")))
+	assert.Equal(t, src, NewReadCloser(context.Background(), src, nil))
+
+	rc := NewReadCloser(context.Background(), src, NewLimiter(1000))
+	read, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), read)
+	err = rc.Close()
+	assert.NoError(t, err)
+}
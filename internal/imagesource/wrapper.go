@@ -7,6 +7,7 @@ import (
 
 	"github.com/containers/image/v5/internal/private"
 	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
 )
 
 // FromPublic(src) returns an object that provides the private.ImageSource API
@@ -45,3 +46,16 @@ func (w *wrapped) SupportsGetBlobAt() bool {
 func (w *wrapped) GetBlobAt(ctx context.Context, info types.BlobInfo, chunks []private.ImageSourceChunk) (chan io.ReadCloser, chan error, error) {
 	return nil, nil, fmt.Errorf("internal error: GetBlobAt is not supported by the %q transport", w.Reference().Transport().Name())
 }
+
+// SupportsReferrers returns true if GetReferrers is supported.
+func (w *wrapped) SupportsReferrers() bool {
+	return false
+}
+
+// GetReferrers returns descriptors of manifests (typically artifacts, e.g. signatures, SBOMs or attestations) in the same repository
+// whose "subject" field refers to the manifest with manifestDigest.
+// If artifactType is not empty, only referrers with that artifact type are returned.
+// It is available only if SupportsReferrers().
+func (w *wrapped) GetReferrers(ctx context.Context, manifestDigest digest.Digest, artifactType string) ([]private.Referrer, error) {
+	return nil, fmt.Errorf("internal error: GetReferrers is not supported by the %q transport", w.Reference().Transport().Name())
+}
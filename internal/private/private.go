@@ -6,6 +6,7 @@ import (
 
 	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
 )
 
 // ImageSource is an internal extension to the types.ImageSource interface.
@@ -16,6 +17,14 @@ type ImageSource interface {
 	SupportsGetBlobAt() bool
 	// BlobChunkAccessor.GetBlobAt is available only if SupportsGetBlobAt().
 	BlobChunkAccessor
+
+	// SupportsReferrers returns true if GetReferrers is supported.
+	SupportsReferrers() bool
+	// GetReferrers returns descriptors of manifests (typically artifacts, e.g. signatures, SBOMs or attestations) in the same repository
+	// whose "subject" field refers to the manifest with manifestDigest.
+	// If artifactType is not empty, only referrers with that artifact type are returned.
+	// It is available only if SupportsReferrers().
+	GetReferrers(ctx context.Context, manifestDigest digest.Digest, artifactType string) ([]Referrer, error)
 }
 
 // ImageDestination is an internal extension to the types.ImageDestination
@@ -52,6 +61,19 @@ type ImageDestination interface {
 	TryReusingBlobWithOptions(ctx context.Context, info types.BlobInfo, options TryReusingBlobOptions) (bool, types.BlobInfo, error)
 }
 
+// ImageReferenceWithProbe is an optional internal extension to the types.ImageReference interface,
+// for transports which can open a destination without modifying it.
+type ImageReferenceWithProbe interface {
+	types.ImageReference
+
+	// NewImageDestinationForProbe returns a types.ImageDestination for this reference, which can only be used
+	// to read destination properties and to check for existing blobs using TryReusingBlob (or TryReusingBlobWithOptions);
+	// opening it must not modify the destination. Other methods (e.g. PutBlob, PutManifest or Commit) must not be called.
+	// TryReusingBlob reports what a copy using a destination returned by NewImageDestination would find.
+	// The caller must call .Close() on the returned ImageDestination.
+	NewImageDestinationForProbe(ctx context.Context, sys *types.SystemContext) (types.ImageDestination, error)
+}
+
 // PutBlobOptions are used in PutBlobWithOptions.
 type PutBlobOptions struct {
 	Cache    types.BlobInfoCache // Cache to optionally update with the uploaded bloblook up blob infos.
@@ -77,6 +99,27 @@ type TryReusingBlobOptions struct {
 	EmptyLayer bool            // True if the blob is an "empty"/"throwaway" layer, and may not necessarily be physically represented.
 	LayerIndex *int            // If the blob is a layer, a zero-based index of the layer within the image; nil otherwise.
 	SrcRef     reference.Named // A reference to the source image that contains the input blob.
+
+	// ReportReusedFrom, if not nil, is called with a human-readable description of the location (e.g. another repository,
+	// found via Cache) the blob has been reused from, if it was not already present at the destination.
+	// Transports that can’t reuse blobs from other locations never call it.
+	ReportReusedFrom func(location string)
+
+	// NoCrossRepositoryReuse, if set, only allows reusing blobs which already exist at the destination;
+	// blobs must not be mounted or copied from other locations (e.g. other repositories of a registry),
+	// regardless of the cache contents or transport-specific options.
+	NoCrossRepositoryReuse bool
+}
+
+// Referrer describes a manifest which refers to another manifest using its "subject" field.
+// It uses the same representation as descriptors in an OCI referrers index.
+type Referrer struct {
+	MediaType string        `json:"mediaType"`
+	Digest    digest.Digest `json:"digest"`
+	Size      int64         `json:"size"`
+	// ArtifactType is the "artifactType" field of the referring manifest, or its config media type if that field is not set.
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
 }
 
 // ImageSourceChunk is a portion of a blob.
@@ -0,0 +1,101 @@
+// Package signature provides in-memory representations of image signatures of various formats,
+// and a way to carry them as []byte through the public types.ImageSource / types.ImageDestination APIs.
+package signature
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// FormatID identifies a signature format.
+type FormatID string
+
+const (
+	// SimpleSigningFormat is a "simple signing" signature, signed using OpenPGP.
+	SimpleSigningFormat FormatID = "simple-signing"
+	// SigstoreFormat is a sigstore (cosign-compatible) signature.
+	SigstoreFormat FormatID = "sigstore-json"
+	// Update also UnsupportedFormatError below
+)
+
+// Signature is an image signature of some kind.
+type Signature interface {
+	FormatID() FormatID
+	// blobChunk returns a representation of signature as a []byte, suitable for long-term storage.
+	// Almost everyone should use signature.Blob() instead.
+	blobChunk() ([]byte, error)
+}
+
+// Blob returns a representation of sig as a []byte, suitable for long-term storage.
+func Blob(sig Signature) ([]byte, error) {
+	chunk, err := sig.blobChunk()
+	if err != nil {
+		return nil, err
+	}
+
+	format := sig.FormatID()
+	switch format {
+	case SimpleSigningFormat:
+		// For compatibility with existing storage (lookaside, the dir: transport, …),
+		// simple signing signatures are stored as is, without any format identification.
+		return chunk, nil
+	default:
+		// Start with a zero byte to clearly mark this is a binary format, and disambiguate from simple signing signatures,
+		// which are OpenPGP messages and never start with a zero byte.
+		res := []byte{0}
+		res = append(res, []byte(format)...)
+		res = append(res, '\n')
+		res = append(res, chunk...)
+		return res, nil
+	}
+}
+
+// FromBlob returns a signature from parsing a blob created by signature.Blob.
+func FromBlob(blob []byte) (Signature, error) {
+	if len(blob) == 0 {
+		return nil, errors.New("empty signature blob")
+	}
+	if blob[0] != 0 {
+		// Historically we’ve just been using GPG with no identification.
+		return SimpleSigningFromBlob(blob), nil
+	}
+
+	// The newer format: binary 0, format name, newline, data
+	blob = blob[1:]
+	newline := bytes.IndexByte(blob, '\n')
+	if newline == -1 {
+		return nil, errors.New("invalid signature format, missing newline")
+	}
+	formatBytes, blobChunk := blob[:newline], blob[newline+1:]
+	for _, b := range formatBytes {
+		if b < 32 || b >= 0x7F {
+			return nil, errors.Errorf("invalid signature format, non-ASCII byte %#x", b)
+		}
+	}
+	switch FormatID(formatBytes) {
+	case SimpleSigningFormat:
+		return SimpleSigningFromBlob(blobChunk), nil
+	case SigstoreFormat:
+		return sigstoreFromBlobChunk(blobChunk)
+	default:
+		return nil, errors.Errorf("unrecognized signature format %q", string(formatBytes))
+	}
+}
+
+// UnsupportedFormatError returns an error complaining about sig having an unsupported format.
+func UnsupportedFormatError(sig Signature) error {
+	formatID := sig.FormatID()
+	switch formatID {
+	case SimpleSigningFormat, SigstoreFormat:
+		return fmt.Errorf("unsupported signature format %s", string(formatID))
+	default:
+		return fmt.Errorf("unsupported, and unrecognized, signature format %q", string(formatID))
+	}
+}
+
+// copyBytes returns a copy of b; it is used so that callers can't modify the contents of signature objects.
+func copyBytes(b []byte) []byte {
+	return append([]byte{}, b...)
+}
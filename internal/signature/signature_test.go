@@ -0,0 +1,108 @@
+package signature
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlobSimpleSigning(t *testing.T) {
+	simpleSigData := []byte("\x8c\x0dsome OpenPGP data")
+	simpleSig := SimpleSigningFromBlob(simpleSigData)
+
+	simpleBlob, err := Blob(simpleSig)
+	require.NoError(t, err)
+	assert.Equal(t, simpleSigData, simpleBlob)
+
+	fromBlob, err := FromBlob(simpleBlob)
+	require.NoError(t, err)
+	fromBlobSimple, ok := fromBlob.(SimpleSigning)
+	require.True(t, ok)
+	assert.Equal(t, simpleSigData, fromBlobSimple.UntrustedSignature())
+
+	// Using the newer format is accepted as well.
+	fromBlob, err = FromBlob([]byte("\x00simple-signing\n" + string(simpleSigData)))
+	require.NoError(t, err)
+	fromBlobSimple, ok = fromBlob.(SimpleSigning)
+	require.True(t, ok)
+	assert.Equal(t, simpleSigData, fromBlobSimple.UntrustedSignature())
+}
+
+func TestBlobSigstore(t *testing.T) {
+	sigstoreSig := SigstoreFromComponents(SigstoreSignatureMIMEType,
+		[]byte("payload"),
+		map[string]string{SigstoreSignatureAnnotationKey: "c2lnbmF0dXJl"})
+
+	sigstoreBlob, err := Blob(sigstoreSig)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(sigstoreBlob, []byte("\x00sigstore-json\n{")))
+
+	fromBlob, err := FromBlob(sigstoreBlob)
+	require.NoError(t, err)
+	fromBlobSigstore, ok := fromBlob.(Sigstore)
+	require.True(t, ok)
+	assert.Equal(t, sigstoreSig.UntrustedMIMEType(), fromBlobSigstore.UntrustedMIMEType())
+	assert.Equal(t, sigstoreSig.UntrustedPayload(), fromBlobSigstore.UntrustedPayload())
+	assert.Equal(t, sigstoreSig.UntrustedAnnotations(), fromBlobSigstore.UntrustedAnnotations())
+}
+
+func TestFromBlobInvalid(t *testing.T) {
+	// Round-tripping valid data has been tested in TestBlobSimpleSigning and TestBlobSigstore above.
+	for _, c := range []string{
+		"",                             // Empty
+		"\x00",                         // Only the zero byte
+		"\x00simple-signing",           // No newline
+		"\x00simple\x01signing\n",      // Non-ASCII format ID
+		"\x00unknown-format\ndata",     // Unknown format
+		"\x00sigstore-json\nnot JSON",  // Invalid sigstore data
+		"\x00sigstore-json\n[1, 2, 3]", // Invalid sigstore data
+	} {
+		_, err := FromBlob([]byte(c))
+		assert.Error(t, err, c)
+	}
+}
+
+func TestUnsupportedFormatError(t *testing.T) {
+	// Warning: The exact text returned by the function is not an API commitment.
+	for _, c := range []struct {
+		input    Signature
+		expected string
+	}{
+		{SimpleSigningFromBlob(nil), "unsupported signature format simple-signing"},
+		{SigstoreFromComponents("", nil, nil), "unsupported signature format sigstore-json"},
+		{unrecognizedSignature{}, `unsupported, and unrecognized, signature format "unrecognized"`},
+	} {
+		res := UnsupportedFormatError(c.input)
+		assert.Equal(t, c.expected, res.Error(), string(c.input.FormatID()))
+	}
+}
+
+// unrecognizedSignature is a Signature of a format not recognized by UnsupportedFormatError.
+type unrecognizedSignature struct{}
+
+func (unrecognizedSignature) FormatID() FormatID {
+	return "unrecognized"
+}
+
+func (unrecognizedSignature) blobChunk() ([]byte, error) {
+	return []byte("data"), nil
+}
+
+func TestSigstoreComponentsAreCopied(t *testing.T) {
+	payload := []byte("payload")
+	annotations := map[string]string{"a": "b"}
+	sig := SigstoreFromComponents("mime", payload, annotations)
+	payload[0] = 'P'
+	annotations["a"] = "c"
+	assert.Equal(t, []byte("payload"), sig.UntrustedPayload())
+	assert.Equal(t, map[string]string{"a": "b"}, sig.UntrustedAnnotations())
+
+	returnedPayload := sig.UntrustedPayload()
+	returnedPayload[0] = 'P'
+	returnedAnnotations := sig.UntrustedAnnotations()
+	returnedAnnotations["a"] = "c"
+	assert.Equal(t, []byte("payload"), sig.UntrustedPayload())
+	assert.Equal(t, map[string]string{"a": "b"}, sig.UntrustedAnnotations())
+}
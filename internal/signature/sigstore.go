@@ -0,0 +1,90 @@
+package signature
+
+import "encoding/json"
+
+const (
+	// SigstoreSignatureMIMEType is the MIME type of the payload of a sigstore signature,
+	// and the media type of sigstore signature layers in "sha256-….sig" attachment images.
+	SigstoreSignatureMIMEType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// SigstoreSignatureAnnotationKey is the annotation key containing the base64-encoded signature of the payload.
+	SigstoreSignatureAnnotationKey = "dev.cosignproject.cosign/signature"
+)
+
+// Sigstore is a sigstore signature.
+// It is represented as a manifest layer in sigstore attachments:
+// the payload is the layer contents, and the actual signature is in the annotations.
+type Sigstore struct {
+	untrustedMIMEType    string
+	untrustedPayload     []byte
+	untrustedAnnotations map[string]string
+}
+
+// sigstoreJSONRepresentation is the representation of Sigstore in blobChunk.
+// It needs the fields to be public, which we don’t want for the main Sigstore type.
+type sigstoreJSONRepresentation struct {
+	UntrustedMIMEType    string            `json:"mimeType"`
+	UntrustedPayload     []byte            `json:"payload"`
+	UntrustedAnnotations map[string]string `json:"annotations"`
+}
+
+// SigstoreFromComponents returns a Sigstore object from its components.
+func SigstoreFromComponents(untrustedMimeType string, untrustedPayload []byte, untrustedAnnotations map[string]string) Sigstore {
+	return Sigstore{
+		untrustedMIMEType:    untrustedMimeType,
+		untrustedPayload:     copyBytes(untrustedPayload),
+		untrustedAnnotations: copyStringMap(untrustedAnnotations),
+	}
+}
+
+// sigstoreFromBlobChunk converts a Sigstore signature, as returned by Sigstore.blobChunk, into a Sigstore object.
+func sigstoreFromBlobChunk(blobChunk []byte) (Sigstore, error) {
+	var v sigstoreJSONRepresentation
+	if err := json.Unmarshal(blobChunk, &v); err != nil {
+		return Sigstore{}, err
+	}
+	return SigstoreFromComponents(v.UntrustedMIMEType,
+		v.UntrustedPayload,
+		v.UntrustedAnnotations), nil
+}
+
+// FormatID returns the format of the signature.
+func (s Sigstore) FormatID() FormatID {
+	return SigstoreFormat
+}
+
+// blobChunk returns a representation of signature as a []byte, suitable for long-term storage.
+// Almost everyone should use signature.Blob() instead.
+func (s Sigstore) blobChunk() ([]byte, error) {
+	return json.Marshal(sigstoreJSONRepresentation{
+		UntrustedMIMEType:    s.UntrustedMIMEType(),
+		UntrustedPayload:     s.UntrustedPayload(),
+		UntrustedAnnotations: s.UntrustedAnnotations(),
+	})
+}
+
+// UntrustedMIMEType returns the MIME type of the payload, WITHOUT ANY VERIFICATION.
+func (s Sigstore) UntrustedMIMEType() string {
+	return s.untrustedMIMEType
+}
+
+// UntrustedPayload returns the payload, WITHOUT ANY VERIFICATION.
+func (s Sigstore) UntrustedPayload() []byte {
+	return copyBytes(s.untrustedPayload)
+}
+
+// UntrustedAnnotations returns the annotations, WITHOUT ANY VERIFICATION.
+func (s Sigstore) UntrustedAnnotations() map[string]string {
+	return copyStringMap(s.untrustedAnnotations)
+}
+
+// copyStringMap returns a copy of m.
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	res := make(map[string]string, len(m))
+	for k, v := range m {
+		res[k] = v
+	}
+	return res
+}
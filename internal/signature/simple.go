@@ -0,0 +1,29 @@
+package signature
+
+// SimpleSigning is a “simple signing” signature.
+type SimpleSigning struct {
+	untrustedSignature []byte
+}
+
+// SimpleSigningFromBlob converts a “simple signing” signature into a SimpleSigning object.
+func SimpleSigningFromBlob(blobChunk []byte) SimpleSigning {
+	return SimpleSigning{
+		untrustedSignature: copyBytes(blobChunk),
+	}
+}
+
+// FormatID returns the format of the signature.
+func (s SimpleSigning) FormatID() FormatID {
+	return SimpleSigningFormat
+}
+
+// blobChunk returns a representation of signature as a []byte, suitable for long-term storage.
+// Almost everyone should use signature.Blob() instead.
+func (s SimpleSigning) blobChunk() ([]byte, error) {
+	return copyBytes(s.untrustedSignature), nil
+}
+
+// UntrustedSignature returns the signature blob, WITHOUT ANY VERIFICATION.
+func (s SimpleSigning) UntrustedSignature() []byte {
+	return copyBytes(s.untrustedSignature)
+}
@@ -26,6 +26,25 @@ type ociImageDestination struct {
 
 // newImageDestination returns an ImageDestination for writing to an existing directory.
 func newImageDestination(sys *types.SystemContext, ref ociReference) (types.ImageDestination, error) {
+	d, err := newImageDestinationForProbe(sys, ref)
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureDirectoryExists(ref.dir); err != nil {
+		return nil, err
+	}
+	// Per the OCI image specification, layouts MUST have a "blobs" subdirectory,
+	// but it MAY be empty (e.g. if we never end up calling PutBlob)
+	// https://github.com/opencontainers/image-spec/blame/7c889fafd04a893f5c5f50b7ab9963d5d64e5242/image-layout.md#L19
+	if err := ensureDirectoryExists(filepath.Join(ref.dir, "blobs")); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// newImageDestinationForProbe returns an ImageDestination which can only be used to read destination properties
+// and to check for existing blobs; unlike newImageDestination, it does not create any directories.
+func newImageDestinationForProbe(sys *types.SystemContext, ref ociReference) (types.ImageDestination, error) {
 	var index *imgspecv1.Index
 	if indexExists(ref) {
 		var err error
@@ -47,16 +66,6 @@ func newImageDestination(sys *types.SystemContext, ref ociReference) (types.Imag
 		d.sharedBlobDir = sys.OCISharedBlobDirPath
 		d.acceptUncompressedLayers = sys.OCIAcceptUncompressedLayers
 	}
-
-	if err := ensureDirectoryExists(d.ref.dir); err != nil {
-		return nil, err
-	}
-	// Per the OCI image specification, layouts MUST have a "blobs" subdirectory,
-	// but it MAY be empty (e.g. if we never end up calling PutBlob)
-	// https://github.com/opencontainers/image-spec/blame/7c889fafd04a893f5c5f50b7ab9963d5d64e5242/image-layout.md#L19
-	if err := ensureDirectoryExists(filepath.Join(d.ref.dir, "blobs")); err != nil {
-		return nil, err
-	}
 	return d, nil
 }
 
@@ -236,6 +236,13 @@ func (ref ociReference) NewImageDestination(ctx context.Context, sys *types.Syst
 	return newImageDestination(sys, ref)
 }
 
+// NewImageDestinationForProbe returns a types.ImageDestination for this reference, which can only be used to read destination properties
+// and to check for existing blobs; see private.ImageReferenceWithProbe.
+// The caller must call .Close() on the returned ImageDestination.
+func (ref ociReference) NewImageDestinationForProbe(ctx context.Context, sys *types.SystemContext) (types.ImageDestination, error) {
+	return newImageDestinationForProbe(sys, ref)
+}
+
 // DeleteImage deletes the named image from the registry, if supported.
 func (ref ociReference) DeleteImage(ctx context.Context, sys *types.SystemContext) error {
 	return errors.Errorf("Deleting images not implemented for oci: images")
@@ -1,10 +1,14 @@
 package sysregistriesv2
 
 import (
+	"crypto/tls"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/types"
@@ -54,6 +58,7 @@ func TestV2RegistriesConfNonempty(t *testing.T) {
 		{UnqualifiedSearchRegistries: []string{"example.com"}},
 		{CredentialHelpers: []string{"a"}},
 		{ShortNameMode: "enforcing"},
+		{Retry: &RetryConf{MaxRetries: 3}},
 		{shortNameAliasConf: shortNameAliasConf{Aliases: map[string]string{"a": "example.com/b"}}},
 	} {
 		copy := c // A shallow copy
@@ -425,6 +430,18 @@ func TestInvalidV2Configs(t *testing.T) {
 		{"testdata/blocked-conflicts.conf", "registry 'registry.com' is defined multiple times with conflicting 'blocked' setting"},
 		{"testdata/missing-mirror-location.conf", "invalid condition: mirror location is unset"},
 		{"testdata/invalid-prefix.conf", "invalid location"},
+		{"testdata/invalid-proxy.conf", `unsupported proxy URL scheme "ftp" for "registry.com"`},
+		{"testdata/invalid-no-proxy.conf", `no-proxy is set for "mirror.registry.com" without setting proxy`},
+		{"testdata/proxy-conflicts.conf", "registry 'registry.com' is defined multiple times with conflicting 'proxy' or 'no-proxy' settings"},
+		{"testdata/invalid-tls-min-version.conf", `unsupported tls-min-version value "1.4" for "registry.com"`},
+		{"testdata/invalid-tls-cipher-suites.conf", `unknown or insecure TLS cipher suite "TLS_RSA_WITH_RC4_128_SHA" for "registry.com"`},
+		{"testdata/invalid-client-cert.conf", `client-cert and client-key must be set together for "mirror.registry.com"`},
+		{"testdata/invalid-ca-bundle.conf", `ca-bundle path "relative/ca.pem" for "registry.com" is not absolute`},
+		{"testdata/missing-ca-bundle.conf", `reading ca-bundle for "registry.com"`},
+		{"testdata/invalid-ca-bundle-contents.conf", `no certificates found in ca-bundle "/dev/null" for "registry.com"`},
+		{"testdata/invalid-client-key-pair.conf", `loading client-cert and client-key for "mirror.registry.com"`},
+		{"testdata/tls-conflicts.conf", "registry 'registry.com' is defined multiple times with conflicting TLS settings"},
+		{"testdata/invalid-bandwidth-limit.conf", `invalid bandwidth-limit value -1 for "mirror.registry.com"`},
 		{"testdata/this-does-not-exist.conf", "no such file or directory"},
 	} {
 		_, err := GetRegistries(&types.SystemContext{SystemRegistriesConfPath: c.path})
@@ -435,6 +452,117 @@ func TestInvalidV2Configs(t *testing.T) {
 	}
 }
 
+func TestProxyFunc(t *testing.T) {
+	sys := &types.SystemContext{
+		SystemRegistriesConfPath:    "testdata/proxy.conf",
+		SystemRegistriesConfDirPath: "testdata/this-does-not-exist",
+	}
+	proxyFor := func(e *Endpoint, u string) *url.URL {
+		proxyFunc, err := e.ProxyFunc()
+		require.NoError(t, err)
+		require.NotNil(t, proxyFunc)
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		require.NoError(t, err)
+		proxy, err := proxyFunc(req)
+		require.NoError(t, err)
+		return proxy
+	}
+
+	reg, err := FindRegistry(sys, "docker.io/library/busybox")
+	require.NoError(t, err)
+	require.NotNil(t, reg)
+	proxy := proxyFor(&reg.Endpoint, "https://registry-1.docker.io/v2/")
+	require.NotNil(t, proxy)
+	assert.Equal(t, "proxy.example.com:3128", proxy.Host)
+	password, ok := proxy.User.Password()
+	assert.True(t, ok)
+	assert.Equal(t, "user", proxy.User.Username())
+	assert.Equal(t, "password", password)
+	proxy = proxyFor(&reg.Endpoint, "http://registry-1.docker.io/v2/")
+	assert.NotNil(t, proxy)
+	for _, u := range []string{"https://cdn.example.com/blob", "https://eu.cdn.example.com/blob", "https://10.1.2.3/blob"} {
+		assert.Nil(t, proxyFor(&reg.Endpoint, u), u)
+	}
+	require.Len(t, reg.Mirrors, 1)
+	assert.Nil(t, proxyFor(&reg.Mirrors[0], "https://mirror.internal.example.com/v2/"))
+
+	reg, err = FindRegistry(sys, "internal.example.com/repo")
+	require.NoError(t, err)
+	require.NotNil(t, reg)
+	assert.Nil(t, proxyFor(&reg.Endpoint, "https://internal.example.com/v2/"))
+
+	reg, err = FindRegistry(sys, "default.example.com/repo")
+	require.NoError(t, err)
+	require.NotNil(t, reg)
+	proxyFunc, err := reg.ProxyFunc()
+	require.NoError(t, err)
+	assert.Nil(t, proxyFunc)
+
+	_, err = (&Endpoint{Location: "registry.com", Proxy: "http://proxy.example.com", NoProxy: []string{"a.com,b.com"}}).ProxyFunc()
+	assert.Error(t, err)
+}
+
+func TestConfigureTLS(t *testing.T) {
+	certDir, err := filepath.Abs("../tlsclientconfig/testdata/full")
+	require.NoError(t, err)
+	confPath := filepath.Join(t.TempDir(), "registries.conf")
+	err = os.WriteFile(confPath, []byte(fmt.Sprintf(`[[registry]]
+location = "registry.com"
+ca-bundle = %q
+client-cert = %q
+client-key = %q
+tls-min-version = "1.2"
+tls-cipher-suites = ["TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"]
+
+[[registry.mirror]]
+location = "mirror.registry.com"
+`, filepath.Join(certDir, "ca-cert-1.crt"), filepath.Join(certDir, "client-cert-1.cert"), filepath.Join(certDir, "client-cert-1.key"))), 0644)
+	require.NoError(t, err)
+	sys := &types.SystemContext{
+		SystemRegistriesConfPath:    confPath,
+		SystemRegistriesConfDirPath: "testdata/this-does-not-exist",
+	}
+	reg, err := FindRegistry(sys, "registry.com/repo")
+	require.NoError(t, err)
+	require.NotNil(t, reg)
+
+	existingCert := tls.Certificate{Certificate: [][]byte{[]byte("existing")}}
+	config := &tls.Config{MinVersion: tls.VersionTLS10, Certificates: []tls.Certificate{existingCert}}
+	err = reg.ConfigureTLS(config)
+	require.NoError(t, err)
+	assert.NotNil(t, config.RootCAs)
+	require.Len(t, config.Certificates, 1)
+	assert.NotEqual(t, existingCert.Certificate, config.Certificates[0].Certificate)
+	assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, config.CipherSuites)
+
+	// Mirrors don’t inherit the settings of the primary endpoint.
+	require.Len(t, reg.Mirrors, 1)
+	config = &tls.Config{MinVersion: tls.VersionTLS10}
+	err = reg.Mirrors[0].ConfigureTLS(config)
+	require.NoError(t, err)
+	assert.Nil(t, config.RootCAs)
+	assert.Empty(t, config.Certificates)
+	assert.Equal(t, uint16(tls.VersionTLS10), config.MinVersion)
+	assert.Nil(t, config.CipherSuites)
+
+	// Referenced files are loaded and parsed by validateTLS.
+	for _, c := range []struct {
+		e              Endpoint
+		errorSubstring string
+	}{
+		{Endpoint{Location: "registry.com", CABundle: filepath.Join(certDir, "this-does-not-exist")}, "no such file or directory"},
+		{Endpoint{Location: "registry.com", CABundle: filepath.Join(certDir, "client-cert-1.key")}, "no certificates found in ca-bundle"},
+		{Endpoint{Location: "registry.com", ClientCert: filepath.Join(certDir, "client-cert-1.cert"), ClientKey: filepath.Join(certDir, "client-cert-2.key")}, "loading client-cert and client-key"},
+		{Endpoint{Location: "registry.com", ClientCert: filepath.Join(certDir, "this-does-not-exist"), ClientKey: filepath.Join(certDir, "client-cert-1.key")}, "no such file or directory"},
+	} {
+		err := c.e.validateTLS()
+		assert.ErrorContains(t, err, c.errorSubstring)
+		err = c.e.ConfigureTLS(&tls.Config{})
+		assert.Error(t, err)
+	}
+}
+
 func TestUnmarshalConfig(t *testing.T) {
 	registries, err := GetRegistries(&types.SystemContext{
 		SystemRegistriesConfPath:    "testdata/unmarshal.conf",
@@ -953,3 +1081,72 @@ func TestCredentialHelpers(t *testing.T) {
 		require.Equal(t, test.helpers, helpers, "%v", test)
 	}
 }
+
+func TestRetryPolicy(t *testing.T) {
+	tests := []struct {
+		confPath    string
+		confDirPath string
+		policy      *types.RetryPolicy
+		mustFail    bool
+	}{
+		{
+			confPath:    "testdata/empty.conf",
+			confDirPath: "testdata/this-does-not-exist",
+			policy:      nil,
+		},
+		{
+			confPath:    "testdata/retry.conf",
+			confDirPath: "testdata/this-does-not-exist",
+			policy:      &types.RetryPolicy{MaxRetries: 3, InitialDelay: time.Second, MaxDelay: 10 * time.Second, Timeout: time.Minute},
+		},
+		{
+			confPath:    "testdata/retry.conf",
+			confDirPath: "testdata/registries.conf.d",
+			policy:      &types.RetryPolicy{MaxRetries: 3, InitialDelay: time.Second, MaxDelay: 10 * time.Second, Timeout: time.Minute},
+		},
+		{
+			confPath:    "testdata/retry.conf",
+			confDirPath: "testdata/registries.conf.d-retry",
+			policy:      &types.RetryPolicy{MaxRetries: 5, InitialDelay: 500 * time.Millisecond},
+		},
+		{
+			confPath:    "testdata/invalid-retry.conf",
+			confDirPath: "testdata/this-does-not-exist",
+			mustFail:    true,
+		},
+	}
+
+	for _, test := range tests {
+		sys := &types.SystemContext{
+			SystemRegistriesConfPath:    test.confPath,
+			SystemRegistriesConfDirPath: test.confDirPath,
+		}
+		policy, err := RetryPolicy(sys)
+		if test.mustFail {
+			assert.Error(t, err, "%v", test)
+			continue
+		}
+		require.NoError(t, err, "%v", test)
+		assert.Equal(t, test.policy, policy, "%v", test)
+	}
+
+	// An explicit SystemContext value overrides the configuration.
+	explicit := &types.RetryPolicy{MaxRetries: 1}
+	policy, err := RetryPolicy(&types.SystemContext{
+		SystemRegistriesConfPath:    "testdata/retry.conf",
+		SystemRegistriesConfDirPath: "testdata/this-does-not-exist",
+		DockerRegistryRetryPolicy:   explicit,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, explicit, policy)
+
+	for _, conf := range []RetryConf{
+		{MaxRetries: -1},
+		{InitialDelay: "-1s"},
+		{MaxDelay: "x"},
+		{Timeout: "1"},
+	} {
+		_, err := parseRetryConf(&conf)
+		assert.Error(t, err, "%#v", conf)
+	}
+}
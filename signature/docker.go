@@ -45,6 +45,21 @@ func SignDockerManifest(m []byte, dockerReference string, mech SigningMechanism,
 	return SignDockerManifestWithOptions(m, dockerReference, mech, keyIdentity, nil)
 }
 
+// SignDockerManifestWithSigstorePrivateKey returns a sigstore (cosign-compatible) signature for manifest as the specified dockerReference,
+// using privateKeyPEM, a PEM-encoded ECDSA P-256 private key, possibly encrypted using passphrase (e.g. as created by "cosign generate-key-pair").
+// The returned signature uses the representation of types.ImageSource.GetSignatures and types.ImageDestination.PutSignatures.
+func SignDockerManifestWithSigstorePrivateKey(m []byte, dockerReference string, privateKeyPEM []byte, passphrase []byte) ([]byte, error) {
+	manifestDigest, err := manifest.Digest(m)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := sigstorePrivateKeyFromPEM(privateKeyPEM, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return signSigstorePayload(privateKey, untrustedSigstorePayload{newUntrustedSignature(manifestDigest, dockerReference)})
+}
+
 // VerifyDockerManifestSignature checks that unverifiedSignature uses expectedKeyIdentity to sign unverifiedManifest as expectedDockerReference,
 // using mech.
 func VerifyDockerManifestSignature(unverifiedSignature, unverifiedManifest []byte,
@@ -5,6 +5,8 @@ import (
 	"os/exec"
 	"testing"
 
+	"github.com/containers/image/v5/internal/signature"
+	digest "github.com/opencontainers/go-digest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -167,3 +169,39 @@ func TestVerifyDockerManifestSignature(t *testing.T) {
 	assert.Error(t, err)
 	assert.Nil(t, sig)
 }
+
+func TestSignDockerManifestWithSigstorePrivateKey(t *testing.T) {
+	passphrase := []byte("a passphrase")
+	privateKey, _ := sigstoreTestECDSAKey(t)
+	privateKeyPEM := sigstoreTestEncryptPrivateKey(t, privateKey, passphrase)
+	manifest, err := os.ReadFile("fixtures/image.manifest.json")
+	require.NoError(t, err)
+
+	// Successful signing
+	blob, err := SignDockerManifestWithSigstorePrivateKey(manifest, TestImageSignatureReference, privateKeyPEM, passphrase)
+	require.NoError(t, err)
+	sig, err := signature.FromBlob(blob)
+	require.NoError(t, err)
+	sigstoreSig, ok := sig.(signature.Sigstore)
+	require.True(t, ok)
+	assert.Equal(t, signature.SigstoreSignatureMIMEType, sigstoreSig.UntrustedMIMEType())
+	verified, err := verifySigstorePayload(privateKey.Public(), sigstoreSig.UntrustedPayload(),
+		sigstoreSig.UntrustedAnnotations()[signature.SigstoreSignatureAnnotationKey], sigstoreAcceptanceRules{
+			validateSignedDockerReference: func(ref string) error {
+				assert.Equal(t, TestImageSignatureReference, ref)
+				return nil
+			},
+			validateSignedDockerManifestDigest: func(d digest.Digest) error {
+				assert.Equal(t, TestImageManifestDigest, d)
+				return nil
+			},
+		})
+	require.NoError(t, err)
+	assert.Equal(t, TestImageManifestDigest, verified.DockerManifestDigest)
+
+	// Invalid private key or passphrase
+	_, err = SignDockerManifestWithSigstorePrivateKey(manifest, TestImageSignatureReference, []byte("not a key"), passphrase)
+	assert.Error(t, err)
+	_, err = SignDockerManifestWithSigstorePrivateKey(manifest, TestImageSignatureReference, privateKeyPEM, []byte("wrong"))
+	assert.Error(t, err)
+}
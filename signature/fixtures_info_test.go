@@ -15,4 +15,10 @@ const (
 	TestKeyFingerprintWithPassphrase = "E3EB7611D815211F141946B5B0CDE60B42557346"
 	// TestPassphrase is the passphrase for TestKeyFingerprintWithPassphrase.
 	TestPassphrase = "WithPassphrase123"
+	// TestRevokedKeyFingerprint is the fingerprint of the public key in "revoked-key.gpg", which has been revoked by its owner.
+	// "revoked-key.signature" is a signature of "dir-img-valid/manifest.json" as "testing/manifest:latest" made using this key.
+	TestRevokedKeyFingerprint = "919DD7C5625191C463AC810ACF0A553D6C50DB93"
+	// TestCosignSignatureReference is the Docker image reference signed in "cosign.payload", in the format created by cosign
+	// (the payload has "optional": null). "cosign.signature" is its base64-encoded signature made by the key in "cosign.pub".
+	TestCosignSignatureReference = "example.com/testing/manifest"
 )
@@ -184,6 +184,10 @@ func (m *gpgmeSigningMechanism) Verify(unverifiedSignature []byte) (contents []b
 		return nil, "", InvalidSignatureError{msg: fmt.Sprintf("Unexpected GPG signature count %d", len(sigs))}
 	}
 	sig := sigs[0]
+	// Only report a revoked key if the signature itself is good; otherwise anyone could claim to be using the revoked key.
+	if sig.Summary&gpgme.SigSumKeyRevoked != 0 && sig.Summary&gpgme.SigSumRed == 0 {
+		return nil, "", newRevokedKeyError(sig.Fingerprint, "")
+	}
 	// This is sig.Summary == gpgme.SigSumValid except for key trust, which we handle ourselves
 	if sig.Status != nil || sig.Validity == gpgme.ValidityNever || sig.ValidityReason != nil || sig.WrongKeyUsage {
 		// FIXME: Better error reporting eventually
@@ -22,6 +22,8 @@ import (
 	// use this frozen deprecated implementation.
 	//lint:ignore SA1019 See above
 	"golang.org/x/crypto/openpgp" //nolint:staticcheck
+	//lint:ignore SA1019 See above
+	"golang.org/x/crypto/openpgp/packet" //nolint:staticcheck
 )
 
 // A GPG/OpenPGP signing mechanism, implemented using x/crypto/openpgp.
@@ -144,8 +146,18 @@ func (m *openpgpSigningMechanism) Verify(unverifiedSignature []byte) (contents [
 		return nil, "", fmt.Errorf("signature error: %v", md.SignatureError)
 	}
 	if md.SignedBy == nil {
+		// openpgp.ReadMessage ignores revoked keys; if the signature was made by a revoked key, say so.
+		// The key ID in the signature is not authenticated, so only do that if the signature actually verifies using that key.
+		for _, key := range m.keyring.KeysById(md.SignedByKeyId) {
+			if err := openpgpKeyRevocationError(key); err != nil && openpgpSignatureVerifiesWithKey(unverifiedSignature, key) {
+				return nil, "", err
+			}
+		}
 		return nil, "", InvalidSignatureError{msg: fmt.Sprintf("Invalid GPG signature: %#v", md.Signature)}
 	}
+	if err := openpgpKeyRevocationError(*md.SignedBy); err != nil {
+		return nil, "", err
+	}
 	if md.Signature != nil {
 		if md.Signature.SigLifetimeSecs != nil {
 			expiry := md.Signature.CreationTime.Add(time.Duration(*md.Signature.SigLifetimeSecs) * time.Second)
@@ -163,6 +175,58 @@ func (m *openpgpSigningMechanism) Verify(unverifiedSignature []byte) (contents [
 	return content, strings.ToUpper(fmt.Sprintf("%x", md.SignedBy.PublicKey.Fingerprint)), nil
 }
 
+// openpgpKeyRevocationError returns a RevokedKeyError if key, or its primary key, has been revoked by its owner; nil otherwise.
+func openpgpKeyRevocationError(key openpgp.Key) error {
+	var revocation *packet.Signature
+	if key.Entity != nil && len(key.Entity.Revocations) != 0 {
+		revocation = key.Entity.Revocations[0]
+	} else if key.SelfSignature != nil && key.SelfSignature.SigType == packet.SigTypeSubkeyRevocation {
+		revocation = key.SelfSignature
+	}
+	if revocation == nil {
+		return nil
+	}
+	// Uppercase the fingerprint to be compatible with gpgme
+	return newRevokedKeyError(strings.ToUpper(fmt.Sprintf("%x", key.PublicKey.Fingerprint)), revocation.RevocationReasonText)
+}
+
+// openpgpSignatureVerifiesWithKey returns true if unverifiedSignature is a valid signature made by key, ignoring
+// whether key has been revoked.
+func openpgpSignatureVerifiesWithKey(unverifiedSignature []byte, key openpgp.Key) bool {
+	md, err := openpgp.ReadMessage(bytes.NewReader(unverifiedSignature), openpgpSingleKeyRing{key}, nil, nil)
+	if err != nil || md.SignedBy == nil {
+		return false
+	}
+	if _, err := io.ReadAll(md.UnverifiedBody); err != nil {
+		return false
+	}
+	return md.SignatureError == nil && (md.Signature != nil || md.SignatureV3 != nil)
+}
+
+// openpgpSingleKeyRing is an openpgp.KeyRing containing a single key, which is returned even if it has been revoked.
+type openpgpSingleKeyRing struct {
+	key openpgp.Key
+}
+
+// KeysById returns the set of keys that have the given key id.
+func (r openpgpSingleKeyRing) KeysById(id uint64) []openpgp.Key {
+	if r.key.PublicKey == nil || r.key.PublicKey.KeyId != id {
+		return nil
+	}
+	return []openpgp.Key{r.key}
+}
+
+// KeysByIdUsage returns the set of keys with the given id that also meet the key usage given by requiredUsage.
+// Unlike openpgp.EntityList.KeysByIdUsage, it does not ignore revoked keys, and it ignores requiredUsage.
+func (r openpgpSingleKeyRing) KeysByIdUsage(id uint64, requiredUsage byte) []openpgp.Key {
+	return r.KeysById(id)
+}
+
+// DecryptionKeys returns all private keys that are valid for decryption.
+func (r openpgpSingleKeyRing) DecryptionKeys() []openpgp.Key {
+	return nil
+}
+
 // UntrustedSignatureContents returns UNTRUSTED contents of the signature WITHOUT ANY VERIFICATION,
 // along with a short identifier of the key used for signing.
 // WARNING: The short key identifier (which corresponds to "Key ID" for OpenPGP keys)
@@ -261,6 +261,30 @@ func TestGPGSigningMechanismVerify(t *testing.T) {
 	// The various GPG/GPGME failures cases are not obviously easy to reach.
 }
 
+func TestGPGSigningMechanismVerifyRevokedKey(t *testing.T) {
+	revokedKey, err := os.ReadFile("./fixtures/revoked-key.gpg")
+	require.NoError(t, err)
+	mech, keyIdentities, err := NewEphemeralGPGSigningMechanism(revokedKey)
+	require.NoError(t, err)
+	defer mech.Close()
+	assert.Equal(t, []string{TestRevokedKeyFingerprint}, keyIdentities)
+
+	signature, err := os.ReadFile("./fixtures/revoked-key.signature")
+	require.NoError(t, err)
+	content, signingFingerprint, err := mech.Verify(signature)
+	assertSigningError(t, content, signingFingerprint, err)
+	require.IsType(t, RevokedKeyError{}, err)
+	assert.Equal(t, TestRevokedKeyFingerprint, err.(RevokedKeyError).KeyIdentity)
+
+	// A signature which claims to be made by the revoked key, but does not verify
+	signature, err = os.ReadFile("./fixtures/revoked-key-corrupt.signature")
+	require.NoError(t, err)
+	content, signingFingerprint, err = mech.Verify(signature)
+	assertSigningError(t, content, signingFingerprint, err)
+	_, isRevoked := err.(RevokedKeyError)
+	assert.False(t, isRevoked)
+}
+
 func TestGPGSigningMechanismUntrustedSignatureContents(t *testing.T) {
 	mech, _, err := NewEphemeralGPGSigningMechanism([]byte{})
 	require.NoError(t, err)
@@ -0,0 +1,301 @@
+package signature
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mozilla.org/pkcs7"
+)
+
+// An X.509 signing mechanism, creating and verifying detached CMS (PKCS #7) SignedData signatures, implemented using go.mozilla.org/pkcs7.
+//
+// SigningMechanism.Verify and SigningMechanism.UntrustedSignatureContents only have access to the signature,
+// so the signatures created by this mechanism are x509SignatureEnvelope objects, carrying the signed content
+// along with a detached CMS signature of the content.
+// Key identities are the uppercase hexadecimal SHA-256 fingerprints of the signing (end-entity) certificates.
+type x509SigningMechanism struct {
+	// trustedCAs, if not nil, is the set of CAs used to verify signing certificates. If nil, verification always fails.
+	trustedCAs *x509.CertPool
+	// extKeyUsages, if not empty, are the accepted extended key usages of signing certificates; if empty, x509DefaultExtKeyUsages is used.
+	// Unless it includes x509.ExtKeyUsageAny, the signing certificate must explicitly include one of the usages.
+	extKeyUsages []x509.ExtKeyUsage
+	// certificateChain and privateKey, if not nil, are used for signing.
+	// certificateChain starts with the end-entity certificate, followed by any intermediate certificates.
+	certificateChain []*x509.Certificate
+	privateKey       crypto.Signer
+	// acceptCertificate, if not nil, is called to validate the end-entity certificate after the chain has been verified.
+	acceptCertificate func(*x509.Certificate) error
+}
+
+// x509DefaultExtKeyUsages are the extended key usages accepted by x509SigningMechanism by default.
+var x509DefaultExtKeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}
+
+// x509SignatureEnvelope is the format of signatures created by x509SigningMechanism.
+type x509SignatureEnvelope struct {
+	// Payload is the signed content.
+	Payload []byte `json:"payload"`
+	// CMS is a DER-encoded detached CMS SignedData signature of Payload.
+	CMS []byte `json:"cms"`
+}
+
+// NewX509SigningMechanism returns a new X.509 signing mechanism, creating and verifying CMS (PKCS #7) SignedData signatures.
+// Signatures are verified using trustedCAsPEM, a set of PEM-encoded CA certificates; if empty, signatures can’t be verified.
+// If certificateChainPEM (a PEM-encoded end-entity certificate optionally followed by intermediate certificates) and
+// privateKeyPEM (the PEM-encoded private key of the end-entity certificate) are not empty, the mechanism supports signing.
+// The caller must call .Close() on the returned SigningMechanism.
+func NewX509SigningMechanism(trustedCAsPEM, certificateChainPEM, privateKeyPEM []byte) (SigningMechanism, error) {
+	return newX509SigningMechanism(trustedCAsPEM, certificateChainPEM, privateKeyPEM)
+}
+
+// newX509SigningMechanism is NewX509SigningMechanism, except it returns the private type.
+func newX509SigningMechanism(trustedCAsPEM, certificateChainPEM, privateKeyPEM []byte) (*x509SigningMechanism, error) {
+	m := &x509SigningMechanism{}
+	if len(trustedCAsPEM) != 0 {
+		cas, err := x509CertificatesFromPEM(trustedCAsPEM)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing trusted CA certificates")
+		}
+		m.trustedCAs = x509.NewCertPool()
+		for _, ca := range cas {
+			m.trustedCAs.AddCert(ca)
+		}
+	}
+
+	switch {
+	case len(certificateChainPEM) == 0 && len(privateKeyPEM) == 0:
+	case len(certificateChainPEM) != 0 && len(privateKeyPEM) != 0:
+		chain, err := x509CertificatesFromPEM(certificateChainPEM)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing signing certificates")
+		}
+		privateKey, err := x509PrivateKeyFromPEM(privateKeyPEM)
+		if err != nil {
+			return nil, err
+		}
+		if !publicKeysEqual(chain[0].PublicKey, privateKey.Public()) {
+			return nil, errors.New("the private key does not match the signing certificate")
+		}
+		m.certificateChain = chain
+		m.privateKey = privateKey
+	default:
+		return nil, errors.New("both a signing certificate and a private key must be specified for signing")
+	}
+	return m, nil
+}
+
+// x509CertificatesFromPEM parses one or more PEM-encoded certificates.
+func x509CertificatesFromPEM(data []byte) ([]*x509.Certificate, error) {
+	var res []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, errors.Errorf("unexpected PEM block type %q, expected a certificate", block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, cert)
+	}
+	if len(bytes.TrimSpace(data)) != 0 {
+		return nil, errors.New("unexpected non-PEM data")
+	}
+	if len(res) == 0 {
+		return nil, errors.New("no PEM-encoded certificates found")
+	}
+	return res, nil
+}
+
+// x509PrivateKeyFromPEM parses a single PEM-encoded private key.
+func x509PrivateKeyFromPEM(data []byte) (crypto.Signer, error) {
+	block, rest := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found in private key")
+	}
+	if len(bytes.TrimSpace(rest)) != 0 {
+		return nil, errors.New("unexpected data after the PEM-encoded private key")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, errors.Errorf("unexpected PEM block type %q, expected a private key", block.Type)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing private key")
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok { // Coverage: All of the key types returned by the parsers above implement crypto.Signer.
+		return nil, errors.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+// publicKeysEqual returns true if a and b are the same public key.
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	ak, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && ak.Equal(b)
+}
+
+// x509KeyIdentity returns the key identity used by x509SigningMechanism for cert.
+func x509KeyIdentity(cert *x509.Certificate) string {
+	return strings.ToUpper(fmt.Sprintf("%x", sha256.Sum256(cert.Raw)))
+}
+
+// Close removes resources associated with the mechanism, if any.
+func (m *x509SigningMechanism) Close() error {
+	return nil
+}
+
+// SupportsSigning returns nil if the mechanism supports signing, or a SigningNotSupportedError.
+func (m *x509SigningMechanism) SupportsSigning() error {
+	if m.privateKey == nil {
+		return SigningNotSupportedError("signing requires an X.509 certificate and a private key")
+	}
+	return nil
+}
+
+// Sign creates a signature of input using keyIdentity: an x509SignatureEnvelope containing input and its detached CMS signature.
+// Fails with a SigningNotSupportedError if the mechanism does not support signing.
+func (m *x509SigningMechanism) Sign(input []byte, keyIdentity string) ([]byte, error) {
+	if err := m.SupportsSigning(); err != nil {
+		return nil, err
+	}
+	if keyIdentity != x509KeyIdentity(m.certificateChain[0]) {
+		return nil, errors.Errorf("key identity %q does not match the signing certificate %s", keyIdentity, x509KeyIdentity(m.certificateChain[0]))
+	}
+	sd, err := pkcs7.NewSignedData(input)
+	if err != nil {
+		return nil, err
+	}
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err := sd.AddSignerChain(m.certificateChain[0], m.privateKey, m.certificateChain[1:], pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, errors.Wrap(err, "creating CMS signature")
+	}
+	sd.Detach()
+	cms, err := sd.Finish()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(x509SignatureEnvelope{Payload: input, CMS: cms})
+}
+
+// Verify parses unverifiedSignature and returns the content and the signer's identity
+func (m *x509SigningMechanism) Verify(unverifiedSignature []byte) (contents []byte, keyIdentity string, err error) {
+	p7, signer, err := parseX509Signature(unverifiedSignature)
+	if err != nil {
+		return nil, "", err
+	}
+	if m.trustedCAs == nil {
+		return nil, "", errors.New("no trusted X.509 CA certificates available for verification")
+	}
+	// This only verifies the cryptographic signature; the certificate chain is verified below,
+	// at the current time instead of the signer-provided signing time.
+	if err := p7.Verify(); err != nil {
+		return nil, "", InvalidSignatureError{msg: fmt.Sprintf("CMS signature verification failed: %v", err)}
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range p7.Certificates {
+		if c != signer {
+			intermediates.AddCert(c)
+		}
+	}
+	keyUsages := m.extKeyUsages
+	if len(keyUsages) == 0 {
+		keyUsages = x509DefaultExtKeyUsages
+	}
+	if _, err := signer.Verify(x509.VerifyOptions{
+		Intermediates: intermediates,
+		Roots:         m.trustedCAs,
+		CurrentTime:   time.Now(),
+		KeyUsages:     keyUsages,
+	}); err != nil {
+		return nil, "", InvalidSignatureError{msg: fmt.Sprintf("signing certificate is not trusted: %v", err)}
+	}
+	// x509.Certificate.Verify accepts end-entity certificates without an extended key usage extension for any usage;
+	// require the extension, so that only certificates explicitly issued for signing are accepted.
+	if !x509ExtKeyUsagesIntersect(keyUsages, signer.ExtKeyUsage) {
+		return nil, "", InvalidSignatureError{msg: "signing certificate does not include an accepted extended key usage"}
+	}
+	if m.acceptCertificate != nil {
+		if err := m.acceptCertificate(signer); err != nil {
+			return nil, "", err
+		}
+	}
+	return p7.Content, x509KeyIdentity(signer), nil
+}
+
+// UntrustedSignatureContents returns UNTRUSTED contents of the signature WITHOUT ANY VERIFICATION,
+// along with a short identifier of the key used for signing.
+// WARNING: The short key identifier (which corresponds to "Key ID" for OpenPGP keys)
+// is NOT the same as a "key identity" used in other calls to this interface, and
+// the values may have no recognizable relationship if the public key is not available.
+func (m *x509SigningMechanism) UntrustedSignatureContents(untrustedSignature []byte) (untrustedContents []byte, shortKeyIdentifier string, err error) {
+	p7, signer, err := parseX509Signature(untrustedSignature)
+	if err != nil {
+		return nil, "", err
+	}
+	return p7.Content, x509KeyIdentity(signer), nil
+}
+
+// x509ExtKeyUsagesIntersect returns true if certUsages includes any of accepted, or if accepted includes x509.ExtKeyUsageAny.
+func x509ExtKeyUsagesIntersect(accepted, certUsages []x509.ExtKeyUsage) bool {
+	for _, a := range accepted {
+		if a == x509.ExtKeyUsageAny {
+			return true
+		}
+		for _, u := range certUsages {
+			if u == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseX509Signature parses an x509SignatureEnvelope containing a detached CMS signature with exactly one signer,
+// and returns the CMS signature (with the content set to the envelope payload) along with the signing certificate,
+// WITHOUT ANY VERIFICATION.
+func parseX509Signature(unverifiedSignature []byte) (*pkcs7.PKCS7, *x509.Certificate, error) {
+	var envelope x509SignatureEnvelope
+	if err := paranoidUnmarshalJSONObjectExactFields(unverifiedSignature, map[string]interface{}{
+		"payload": &envelope.Payload,
+		"cms":     &envelope.CMS,
+	}); err != nil {
+		return nil, nil, InvalidSignatureError{msg: fmt.Sprintf("invalid X.509 signature: %v", err)}
+	}
+	p7, err := pkcs7.Parse(envelope.CMS)
+	if err != nil {
+		return nil, nil, InvalidSignatureError{msg: fmt.Sprintf("invalid CMS signature: %v", err)}
+	}
+	if len(p7.Content) != 0 {
+		return nil, nil, InvalidSignatureError{msg: "unexpected CMS signature with encapsulated content, expected a detached signature"}
+	}
+	p7.Content = envelope.Payload
+	if len(p7.Signers) != 1 {
+		return nil, nil, InvalidSignatureError{msg: fmt.Sprintf("expected exactly one CMS signer, got %d", len(p7.Signers))}
+	}
+	signer := p7.GetOnlySigner()
+	if signer == nil {
+		return nil, nil, InvalidSignatureError{msg: "signing certificate not found in the CMS signature"}
+	}
+	return p7, signer, nil
+}
@@ -14,11 +14,14 @@
 package signature
 
 import (
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
+	"time"
 
 	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/transports"
@@ -242,6 +245,10 @@ func newPolicyRequirementFromJSON(data []byte) (PolicyRequirement, error) {
 		res = &prSignedBy{}
 	case prTypeSignedBaseLayer:
 		res = &prSignedBaseLayer{}
+	case prTypeSigstoreSigned:
+		res = &prSigstoreSigned{}
+	case prTypeSignedByThreshold:
+		res = &prSignedByThreshold{}
 	default:
 		return nil, InvalidPolicyFormatError(fmt.Sprintf("Unknown policy requirement type \"%s\"", typeField.Type))
 	}
@@ -312,28 +319,52 @@ func (pr *prReject) UnmarshalJSON(data []byte) error {
 }
 
 // newPRSignedBy returns a new prSignedBy if parameters are valid.
-func newPRSignedBy(keyType sbKeyType, keyPath string, keyData []byte, signedIdentity PolicyReferenceMatch) (*prSignedBy, error) {
+func newPRSignedBy(keyType sbKeyType, options SBOptions, signedIdentity PolicyReferenceMatch) (*prSignedBy, error) {
 	if !keyType.IsValid() {
 		return nil, InvalidPolicyFormatError(fmt.Sprintf("invalid keyType \"%s\"", keyType))
 	}
-	if len(keyPath) > 0 && len(keyData) > 0 {
+	if len(options.KeyPath) > 0 && len(options.KeyData) > 0 {
 		return nil, InvalidPolicyFormatError("keyType and keyData cannot be used simultaneously")
 	}
 	if signedIdentity == nil {
 		return nil, InvalidPolicyFormatError("signedIdentity not specified")
 	}
+	if x509Identity := options.X509Identity; x509Identity != nil {
+		if keyType != SBKeyTypeSignedByX509CAs {
+			return nil, InvalidPolicyFormatError(fmt.Sprintf("x509Identity can only be used with keyType \"%s\"", SBKeyTypeSignedByX509CAs))
+		}
+		if x509Identity.isEmpty() {
+			return nil, InvalidPolicyFormatError("x509Identity does not specify any constraints")
+		}
+		if _, err := x509Identity.extKeyUsages(); err != nil {
+			return nil, err
+		}
+	}
+	if options.SignatureTimestamp != nil {
+		if err := options.SignatureTimestamp.validate(); err != nil {
+			return nil, err
+		}
+	}
+	for _, fingerprint := range options.RevokedFingerprints {
+		if normalizeKeyFingerprint(fingerprint) == "" {
+			return nil, InvalidPolicyFormatError("revokedFingerprints contains an empty value")
+		}
+	}
 	return &prSignedBy{
-		prCommon:       prCommon{Type: prTypeSignedBy},
-		KeyType:        keyType,
-		KeyPath:        keyPath,
-		KeyData:        keyData,
-		SignedIdentity: signedIdentity,
+		prCommon:            prCommon{Type: prTypeSignedBy},
+		KeyType:             keyType,
+		KeyPath:             options.KeyPath,
+		KeyData:             options.KeyData,
+		SignedIdentity:      signedIdentity,
+		X509Identity:        options.X509Identity,
+		SignatureTimestamp:  options.SignatureTimestamp,
+		RevokedFingerprints: options.RevokedFingerprints,
 	}, nil
 }
 
 // newPRSignedByKeyPath is NewPRSignedByKeyPath, except it returns the private type.
 func newPRSignedByKeyPath(keyType sbKeyType, keyPath string, signedIdentity PolicyReferenceMatch) (*prSignedBy, error) {
-	return newPRSignedBy(keyType, keyPath, nil, signedIdentity)
+	return newPRSignedBy(keyType, SBOptions{KeyPath: keyPath}, signedIdentity)
 }
 
 // NewPRSignedByKeyPath returns a new "signedBy" PolicyRequirement using a KeyPath
@@ -343,7 +374,7 @@ func NewPRSignedByKeyPath(keyType sbKeyType, keyPath string, signedIdentity Poli
 
 // newPRSignedByKeyData is NewPRSignedByKeyData, except it returns the private type.
 func newPRSignedByKeyData(keyType sbKeyType, keyData []byte, signedIdentity PolicyReferenceMatch) (*prSignedBy, error) {
-	return newPRSignedBy(keyType, "", keyData, signedIdentity)
+	return newPRSignedBy(keyType, SBOptions{KeyData: keyData}, signedIdentity)
 }
 
 // NewPRSignedByKeyData returns a new "signedBy" PolicyRequirement using a KeyData
@@ -351,6 +382,29 @@ func NewPRSignedByKeyData(keyType sbKeyType, keyData []byte, signedIdentity Poli
 	return newPRSignedByKeyData(keyType, keyData, signedIdentity)
 }
 
+// NewPRSignedByX509CAsKeyPath returns a new "signedBy" PolicyRequirement with keyType "signedByX509CAs",
+// using a KeyPath, and with optional x509Identity constraints on the signing certificate.
+func NewPRSignedByX509CAsKeyPath(keyPath string, x509Identity *SBX509Identity, signedIdentity PolicyReferenceMatch) (PolicyRequirement, error) {
+	return newPRSignedBy(SBKeyTypeSignedByX509CAs, SBOptions{KeyPath: keyPath, X509Identity: x509Identity}, signedIdentity)
+}
+
+// NewPRSignedByX509CAsKeyData returns a new "signedBy" PolicyRequirement with keyType "signedByX509CAs",
+// using a KeyData, and with optional x509Identity constraints on the signing certificate.
+func NewPRSignedByX509CAsKeyData(keyData []byte, x509Identity *SBX509Identity, signedIdentity PolicyReferenceMatch) (PolicyRequirement, error) {
+	return newPRSignedBy(SBKeyTypeSignedByX509CAs, SBOptions{KeyData: keyData, X509Identity: x509Identity}, signedIdentity)
+}
+
+// NewPRSignedByWithOptions returns a new "signedBy" PolicyRequirement using keyType and the settings in options,
+// including any constraints on the signing certificate, the signature creation time, or revoked keys.
+func NewPRSignedByWithOptions(keyType sbKeyType, options SBOptions, signedIdentity PolicyReferenceMatch) (PolicyRequirement, error) {
+	return newPRSignedBy(keyType, options, signedIdentity)
+}
+
+// normalizeKeyFingerprint returns fingerprint in the format used for key identities: uppercase, without spaces.
+func normalizeKeyFingerprint(fingerprint string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(fingerprint), ""))
+}
+
 // Compile-time check that prSignedBy implements json.Unmarshaler.
 var _ json.Unmarshaler = (*prSignedBy)(nil)
 
@@ -374,6 +428,12 @@ func (pr *prSignedBy) UnmarshalJSON(data []byte) error {
 			return &tmp.KeyData
 		case "signedIdentity":
 			return &signedIdentity
+		case "x509Identity":
+			return &tmp.X509Identity
+		case "signatureTimestamp":
+			return &tmp.SignatureTimestamp
+		case "revokedFingerprints":
+			return &tmp.RevokedFingerprints
 		default:
 			return nil
 		}
@@ -400,9 +460,19 @@ func (pr *prSignedBy) UnmarshalJSON(data []byte) error {
 	case gotKeyPath && gotKeyData:
 		return InvalidPolicyFormatError("keyPath and keyData cannot be used simultaneously")
 	case gotKeyPath && !gotKeyData:
-		res, err = newPRSignedByKeyPath(tmp.KeyType, tmp.KeyPath, tmp.SignedIdentity)
+		res, err = newPRSignedBy(tmp.KeyType, SBOptions{
+			KeyPath:             tmp.KeyPath,
+			X509Identity:        tmp.X509Identity,
+			SignatureTimestamp:  tmp.SignatureTimestamp,
+			RevokedFingerprints: tmp.RevokedFingerprints,
+		}, tmp.SignedIdentity)
 	case !gotKeyPath && gotKeyData:
-		res, err = newPRSignedByKeyData(tmp.KeyType, tmp.KeyData, tmp.SignedIdentity)
+		res, err = newPRSignedBy(tmp.KeyType, SBOptions{
+			KeyData:             tmp.KeyData,
+			X509Identity:        tmp.X509Identity,
+			SignatureTimestamp:  tmp.SignatureTimestamp,
+			RevokedFingerprints: tmp.RevokedFingerprints,
+		}, tmp.SignedIdentity)
 	case !gotKeyPath && !gotKeyData:
 		return InvalidPolicyFormatError("At least one of keyPath and keyData mus be specified")
 	default: // Coverage: This should never happen
@@ -444,6 +514,160 @@ func (kt *sbKeyType) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// isEmpty returns true if id does not specify any constraints.
+func (id *SBX509Identity) isEmpty() bool {
+	return len(id.SubjectCommonNames) == 0 && len(id.SubjectOrganizations) == 0 &&
+		len(id.SANDNSNames) == 0 && len(id.SANEmailAddresses) == 0 && len(id.SANURIs) == 0 &&
+		len(id.ExtendedKeyUsages) == 0
+}
+
+// sbX509ExtKeyUsages maps the values of SBX509Identity.ExtendedKeyUsages to X.509 extended key usages.
+var sbX509ExtKeyUsages = map[string]x509.ExtKeyUsage{
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"any":             x509.ExtKeyUsageAny,
+}
+
+// extKeyUsages returns the extended key usages accepted by id, or nil if the default should be used,
+// or an InvalidPolicyFormatError if id.ExtendedKeyUsages is invalid.
+func (id *SBX509Identity) extKeyUsages() ([]x509.ExtKeyUsage, error) {
+	var res []x509.ExtKeyUsage
+	for _, name := range id.ExtendedKeyUsages {
+		usage, ok := sbX509ExtKeyUsages[name]
+		if !ok {
+			return nil, InvalidPolicyFormatError(fmt.Sprintf("Unrecognized x509Identity extendedKeyUsages value \"%s\"", name))
+		}
+		res = append(res, usage)
+	}
+	return res, nil
+}
+
+// Compile-time check that SBX509Identity implements json.Unmarshaler.
+var _ json.Unmarshaler = (*SBX509Identity)(nil)
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (id *SBX509Identity) UnmarshalJSON(data []byte) error {
+	*id = SBX509Identity{}
+	var tmp SBX509Identity
+	if err := paranoidUnmarshalJSONObject(data, func(key string) interface{} {
+		switch key {
+		case "subjectCommonNames":
+			return &tmp.SubjectCommonNames
+		case "subjectOrganizations":
+			return &tmp.SubjectOrganizations
+		case "sanDNSNames":
+			return &tmp.SANDNSNames
+		case "sanEmailAddresses":
+			return &tmp.SANEmailAddresses
+		case "sanURIs":
+			return &tmp.SANURIs
+		case "extendedKeyUsages":
+			return &tmp.ExtendedKeyUsages
+		default:
+			return nil
+		}
+	}); err != nil {
+		return err
+	}
+	*id = tmp
+	return nil
+}
+
+// isEmpty returns true if c does not specify any constraints.
+func (c *SBTimestampConstraints) isEmpty() bool {
+	return c.MaxAge == 0 && c.NotBefore == nil && c.NotAfter == nil && len(c.KeyNotAfter) == 0
+}
+
+// validate returns an InvalidPolicyFormatError if c is not a valid set of constraints.
+func (c *SBTimestampConstraints) validate() error {
+	if c.isEmpty() {
+		return InvalidPolicyFormatError("signatureTimestamp does not specify any constraints")
+	}
+	if c.MaxAge < 0 {
+		return InvalidPolicyFormatError(fmt.Sprintf("signatureTimestamp maxAge %s is negative", c.MaxAge))
+	}
+	if c.NotBefore != nil && c.NotAfter != nil && c.NotAfter.Before(*c.NotBefore) {
+		return InvalidPolicyFormatError("signatureTimestamp notAfter is before notBefore")
+	}
+	for keyIdentity := range c.KeyNotAfter {
+		if normalizeKeyFingerprint(keyIdentity) == "" {
+			return InvalidPolicyFormatError("signatureTimestamp keyNotAfter contains an empty key identity")
+		}
+	}
+	return nil
+}
+
+// sbTimestampConstraintsJSON is the JSON representation of SBTimestampConstraints.
+type sbTimestampConstraintsJSON struct {
+	MaxAge      string               `json:"maxAge,omitempty"`
+	NotBefore   *time.Time           `json:"notBefore,omitempty"`
+	NotAfter    *time.Time           `json:"notAfter,omitempty"`
+	KeyNotAfter map[string]time.Time `json:"keyNotAfter,omitempty"`
+}
+
+// Compile-time check that SBTimestampConstraints implements json.Marshaler.
+var _ json.Marshaler = (*SBTimestampConstraints)(nil)
+
+// MarshalJSON implements the json.Marshaler interface.
+func (c *SBTimestampConstraints) MarshalJSON() ([]byte, error) {
+	tmp := sbTimestampConstraintsJSON{
+		NotBefore:   c.NotBefore,
+		NotAfter:    c.NotAfter,
+		KeyNotAfter: c.KeyNotAfter,
+	}
+	if c.MaxAge != 0 {
+		tmp.MaxAge = c.MaxAge.String()
+	}
+	return json.Marshal(tmp)
+}
+
+// Compile-time check that SBTimestampConstraints implements json.Unmarshaler.
+var _ json.Unmarshaler = (*SBTimestampConstraints)(nil)
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (c *SBTimestampConstraints) UnmarshalJSON(data []byte) error {
+	*c = SBTimestampConstraints{}
+	var tmp sbTimestampConstraintsJSON
+	var gotMaxAge bool
+	if err := paranoidUnmarshalJSONObject(data, func(key string) interface{} {
+		switch key {
+		case "maxAge":
+			gotMaxAge = true
+			return &tmp.MaxAge
+		case "notBefore":
+			return &tmp.NotBefore
+		case "notAfter":
+			return &tmp.NotAfter
+		case "keyNotAfter":
+			return &tmp.KeyNotAfter
+		default:
+			return nil
+		}
+	}); err != nil {
+		return err
+	}
+
+	res := SBTimestampConstraints{
+		NotBefore:   tmp.NotBefore,
+		NotAfter:    tmp.NotAfter,
+		KeyNotAfter: tmp.KeyNotAfter,
+	}
+	if gotMaxAge {
+		d, err := time.ParseDuration(tmp.MaxAge)
+		if err != nil {
+			return InvalidPolicyFormatError(fmt.Sprintf("Invalid signatureTimestamp maxAge %q: %v", tmp.MaxAge, err))
+		}
+		if d <= 0 {
+			return InvalidPolicyFormatError(fmt.Sprintf("signatureTimestamp maxAge %q is not positive", tmp.MaxAge))
+		}
+		res.MaxAge = d
+	}
+	*c = res
+	return nil
+}
+
 // newPRSignedBaseLayer is NewPRSignedBaseLayer, except it returns the private type.
 func newPRSignedBaseLayer(baseLayerIdentity PolicyReferenceMatch) (*prSignedBaseLayer, error) {
 	if baseLayerIdentity == nil {
@@ -484,13 +708,284 @@ func (pr *prSignedBaseLayer) UnmarshalJSON(data []byte) error {
 	}
 	res, err := newPRSignedBaseLayer(bli)
 	if err != nil {
-		// Coverage: This should never happen, newPolicyReferenceMatchFromJSON has ensured bli is valid.
 		return err
 	}
 	*pr = *res
 	return nil
 }
 
+// newPRSigstoreSigned returns a new prSigstoreSigned if parameters are valid.
+func newPRSigstoreSigned(keyPath string, keyData []byte, signedIdentity PolicyReferenceMatch) (*prSigstoreSigned, error) {
+	if len(keyPath) > 0 && len(keyData) > 0 {
+		return nil, InvalidPolicyFormatError("keyPath and keyData cannot be used simultaneously")
+	}
+	if len(keyPath) == 0 && len(keyData) == 0 {
+		return nil, InvalidPolicyFormatError("At least one of keyPath and keyData must be specified")
+	}
+	if signedIdentity == nil {
+		return nil, InvalidPolicyFormatError("signedIdentity not specified")
+	}
+	return &prSigstoreSigned{
+		prCommon:       prCommon{Type: prTypeSigstoreSigned},
+		KeyPath:        keyPath,
+		KeyData:        keyData,
+		SignedIdentity: signedIdentity,
+	}, nil
+}
+
+// newPRSigstoreSignedKeyPath is NewPRSigstoreSignedKeyPath, except it returns the private type.
+func newPRSigstoreSignedKeyPath(keyPath string, signedIdentity PolicyReferenceMatch) (*prSigstoreSigned, error) {
+	return newPRSigstoreSigned(keyPath, nil, signedIdentity)
+}
+
+// NewPRSigstoreSignedKeyPath returns a new "sigstoreSigned" PolicyRequirement using a KeyPath
+func NewPRSigstoreSignedKeyPath(keyPath string, signedIdentity PolicyReferenceMatch) (PolicyRequirement, error) {
+	return newPRSigstoreSignedKeyPath(keyPath, signedIdentity)
+}
+
+// newPRSigstoreSignedKeyData is NewPRSigstoreSignedKeyData, except it returns the private type.
+func newPRSigstoreSignedKeyData(keyData []byte, signedIdentity PolicyReferenceMatch) (*prSigstoreSigned, error) {
+	return newPRSigstoreSigned("", keyData, signedIdentity)
+}
+
+// NewPRSigstoreSignedKeyData returns a new "sigstoreSigned" PolicyRequirement using a KeyData
+func NewPRSigstoreSignedKeyData(keyData []byte, signedIdentity PolicyReferenceMatch) (PolicyRequirement, error) {
+	return newPRSigstoreSignedKeyData(keyData, signedIdentity)
+}
+
+// Compile-time check that prSigstoreSigned implements json.Unmarshaler.
+var _ json.Unmarshaler = (*prSigstoreSigned)(nil)
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (pr *prSigstoreSigned) UnmarshalJSON(data []byte) error {
+	*pr = prSigstoreSigned{}
+	var tmp prSigstoreSigned
+	var gotKeyPath, gotKeyData = false, false
+	var signedIdentity json.RawMessage
+	if err := paranoidUnmarshalJSONObject(data, func(key string) interface{} {
+		switch key {
+		case "type":
+			return &tmp.Type
+		case "keyPath":
+			gotKeyPath = true
+			return &tmp.KeyPath
+		case "keyData":
+			gotKeyData = true
+			return &tmp.KeyData
+		case "signedIdentity":
+			return &signedIdentity
+		default:
+			return nil
+		}
+	}); err != nil {
+		return err
+	}
+
+	if tmp.Type != prTypeSigstoreSigned {
+		return InvalidPolicyFormatError(fmt.Sprintf("Unexpected policy requirement type \"%s\"", tmp.Type))
+	}
+	if signedIdentity == nil {
+		tmp.SignedIdentity = NewPRMMatchRepoDigestOrExact()
+	} else {
+		si, err := newPolicyReferenceMatchFromJSON(signedIdentity)
+		if err != nil {
+			return err
+		}
+		tmp.SignedIdentity = si
+	}
+
+	var res *prSigstoreSigned
+	var err error
+	switch {
+	case gotKeyPath && gotKeyData:
+		return InvalidPolicyFormatError("keyPath and keyData cannot be used simultaneously")
+	case gotKeyPath && !gotKeyData:
+		res, err = newPRSigstoreSignedKeyPath(tmp.KeyPath, tmp.SignedIdentity)
+	case !gotKeyPath && gotKeyData:
+		res, err = newPRSigstoreSignedKeyData(tmp.KeyData, tmp.SignedIdentity)
+	case !gotKeyPath && !gotKeyData:
+		return InvalidPolicyFormatError("At least one of keyPath and keyData must be specified")
+	default: // Coverage: This should never happen
+		return errors.Errorf("Impossible keyPath/keyData presence combination!?")
+	}
+	if err != nil {
+		return err
+	}
+	*pr = *res
+
+	return nil
+}
+
+// newPRSignedByThreshold returns a new prSignedByThreshold if parameters are valid.
+func newPRSignedByThreshold(threshold int, countDistinct sbtCountDistinct, keyGroups []SBKeyGroup, signedIdentity PolicyReferenceMatch) (*prSignedByThreshold, error) {
+	if !countDistinct.IsValid() {
+		return nil, InvalidPolicyFormatError(fmt.Sprintf("invalid countDistinct \"%s\"", countDistinct))
+	}
+	if len(keyGroups) == 0 {
+		return nil, InvalidPolicyFormatError("keyGroups must not be empty")
+	}
+	if signedIdentity == nil {
+		return nil, InvalidPolicyFormatError("signedIdentity not specified")
+	}
+	for i := range keyGroups {
+		if _, err := keyGroups[i].signedBy(signedIdentity); err != nil {
+			return nil, InvalidPolicyFormatError(fmt.Sprintf("key group %d: %v", i, err))
+		}
+	}
+	if threshold < 1 {
+		return nil, InvalidPolicyFormatError(fmt.Sprintf("threshold must be at least 1, not %d", threshold))
+	}
+	if countDistinct == SBTCountKeyGroups && threshold > len(keyGroups) {
+		return nil, InvalidPolicyFormatError(fmt.Sprintf("threshold %d is larger than the number of key groups, %d", threshold, len(keyGroups)))
+	}
+	return &prSignedByThreshold{
+		prCommon:       prCommon{Type: prTypeSignedByThreshold},
+		Threshold:      threshold,
+		CountDistinct:  countDistinct,
+		KeyGroups:      keyGroups,
+		SignedIdentity: signedIdentity,
+	}, nil
+}
+
+// NewPRSignedByThreshold returns a new "signedByThreshold" PolicyRequirement, requiring signatures
+// by at least threshold distinct key groups or keys (as specified by countDistinct) from keyGroups.
+func NewPRSignedByThreshold(threshold int, countDistinct sbtCountDistinct, keyGroups []SBKeyGroup, signedIdentity PolicyReferenceMatch) (PolicyRequirement, error) {
+	return newPRSignedByThreshold(threshold, countDistinct, keyGroups, signedIdentity)
+}
+
+// Compile-time check that prSignedByThreshold implements json.Unmarshaler.
+var _ json.Unmarshaler = (*prSignedByThreshold)(nil)
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (pr *prSignedByThreshold) UnmarshalJSON(data []byte) error {
+	*pr = prSignedByThreshold{}
+	var tmp prSignedByThreshold
+	var gotThreshold = false
+	var signedIdentity json.RawMessage
+	if err := paranoidUnmarshalJSONObject(data, func(key string) interface{} {
+		switch key {
+		case "type":
+			return &tmp.Type
+		case "threshold":
+			gotThreshold = true
+			return &tmp.Threshold
+		case "countDistinct":
+			return &tmp.CountDistinct
+		case "keyGroups":
+			return &tmp.KeyGroups
+		case "signedIdentity":
+			return &signedIdentity
+		default:
+			return nil
+		}
+	}); err != nil {
+		return err
+	}
+
+	if tmp.Type != prTypeSignedByThreshold {
+		return InvalidPolicyFormatError(fmt.Sprintf("Unexpected policy requirement type \"%s\"", tmp.Type))
+	}
+	if !gotThreshold {
+		return InvalidPolicyFormatError("threshold not specified")
+	}
+	if tmp.CountDistinct == "" {
+		tmp.CountDistinct = SBTCountKeyGroups
+	}
+	if signedIdentity == nil {
+		tmp.SignedIdentity = NewPRMMatchRepoDigestOrExact()
+	} else {
+		si, err := newPolicyReferenceMatchFromJSON(signedIdentity)
+		if err != nil {
+			return err
+		}
+		tmp.SignedIdentity = si
+	}
+
+	res, err := newPRSignedByThreshold(tmp.Threshold, tmp.CountDistinct, tmp.KeyGroups, tmp.SignedIdentity)
+	if err != nil {
+		return err
+	}
+	*pr = *res
+	return nil
+}
+
+// IsValid returns true iff cd is a recognized value
+func (cd sbtCountDistinct) IsValid() bool {
+	switch cd {
+	case SBTCountKeyGroups, SBTCountKeys:
+		return true
+	default:
+		return false
+	}
+}
+
+// Compile-time check that sbtCountDistinct implements json.Unmarshaler.
+var _ json.Unmarshaler = (*sbtCountDistinct)(nil)
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (cd *sbtCountDistinct) UnmarshalJSON(data []byte) error {
+	*cd = sbtCountDistinct("")
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if !sbtCountDistinct(s).IsValid() {
+		return InvalidPolicyFormatError(fmt.Sprintf("Unrecognized countDistinct value \"%s\"", s))
+	}
+	*cd = sbtCountDistinct(s)
+	return nil
+}
+
+// signedBy returns a prSignedBy requirement which accepts signatures by keys of g, for signedIdentity,
+// or an error if g is not valid.
+func (g *SBKeyGroup) signedBy(signedIdentity PolicyReferenceMatch) (*prSignedBy, error) {
+	switch g.KeyType {
+	case SBKeyTypeGPGKeys, SBKeyTypeSignedByX509CAs:
+	default:
+		return nil, InvalidPolicyFormatError(fmt.Sprintf("keyType \"%s\" is not supported in key groups", g.KeyType))
+	}
+	if len(g.KeyPath) == 0 && len(g.KeyData) == 0 {
+		return nil, InvalidPolicyFormatError("At least one of keyPath and keyData must be specified")
+	}
+	return newPRSignedBy(g.KeyType, SBOptions{
+		KeyPath:             g.KeyPath,
+		KeyData:             g.KeyData,
+		X509Identity:        g.X509Identity,
+		RevokedFingerprints: g.RevokedFingerprints,
+	}, signedIdentity)
+}
+
+// Compile-time check that SBKeyGroup implements json.Unmarshaler.
+var _ json.Unmarshaler = (*SBKeyGroup)(nil)
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (g *SBKeyGroup) UnmarshalJSON(data []byte) error {
+	*g = SBKeyGroup{}
+	var tmp SBKeyGroup
+	if err := paranoidUnmarshalJSONObject(data, func(key string) interface{} {
+		switch key {
+		case "name":
+			return &tmp.Name
+		case "keyType":
+			return &tmp.KeyType
+		case "keyPath":
+			return &tmp.KeyPath
+		case "keyData":
+			return &tmp.KeyData
+		case "x509Identity":
+			return &tmp.X509Identity
+		case "revokedFingerprints":
+			return &tmp.RevokedFingerprints
+		default:
+			return nil
+		}
+	}); err != nil {
+		return err
+	}
+	*g = tmp
+	return nil
+}
+
 // newPolicyReferenceMatchFromJSON parses JSON data into a PolicyReferenceMatch implementation.
 func newPolicyReferenceMatchFromJSON(data []byte) (PolicyReferenceMatch, error) {
 	var typeField prmCommon
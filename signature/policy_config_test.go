@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/containers/image/v5/directory"
 	"github.com/containers/image/v5/docker"
@@ -51,6 +52,10 @@ var policyFixtureContents = &Policy{
 					"/keys/public-key-signing-ca-file",
 					NewPRMMatchRepoDigestOrExact()),
 			},
+			"example.com/sigstore": {
+				xNewPRSigstoreSignedKeyPath("/keys/public-key",
+					NewPRMMatchRepository()),
+			},
 			"registry.access.redhat.com": {
 				xNewPRSignedByKeyPath(SBKeyTypeSignedByGPGKeys,
 					"/keys/RH-key-signing-key-gpg-keyring",
@@ -354,6 +359,15 @@ func xNewPRSignedByKeyPath(keyType sbKeyType, keyPath string, signedIdentity Pol
 	return pr
 }
 
+// xNewPRSignedByWithOptions is like NewPRSignedByWithOptions, except it must not fail.
+func xNewPRSignedByWithOptions(keyType sbKeyType, options SBOptions, signedIdentity PolicyReferenceMatch) PolicyRequirement {
+	pr, err := NewPRSignedByWithOptions(keyType, options, signedIdentity)
+	if err != nil {
+		panic("xNewPRSignedByWithOptions failed")
+	}
+	return pr
+}
+
 // xNewPRSignedByKeyData is like NewPRSignedByKeyData, except it must not fail.
 func xNewPRSignedByKeyData(keyType sbKeyType, keyData []byte, signedIdentity PolicyReferenceMatch) PolicyRequirement {
 	pr, err := NewPRSignedByKeyData(keyType, keyData, signedIdentity)
@@ -687,7 +701,7 @@ func TestNewPRSignedBy(t *testing.T) {
 	testIdentity := NewPRMMatchRepoDigestOrExact()
 
 	// Success
-	pr, err := newPRSignedBy(SBKeyTypeGPGKeys, testPath, nil, testIdentity)
+	pr, err := newPRSignedBy(SBKeyTypeGPGKeys, SBOptions{KeyPath: testPath}, testIdentity)
 	require.NoError(t, err)
 	assert.Equal(t, &prSignedBy{
 		prCommon:       prCommon{prTypeSignedBy},
@@ -696,7 +710,7 @@ func TestNewPRSignedBy(t *testing.T) {
 		KeyData:        nil,
 		SignedIdentity: testIdentity,
 	}, pr)
-	pr, err = newPRSignedBy(SBKeyTypeGPGKeys, "", testData, testIdentity)
+	pr, err = newPRSignedBy(SBKeyTypeGPGKeys, SBOptions{KeyData: testData}, testIdentity)
 	require.NoError(t, err)
 	assert.Equal(t, &prSignedBy{
 		prCommon:       prCommon{prTypeSignedBy},
@@ -707,18 +721,90 @@ func TestNewPRSignedBy(t *testing.T) {
 	}, pr)
 
 	// Invalid keyType
-	_, err = newPRSignedBy(sbKeyType(""), testPath, nil, testIdentity)
+	_, err = newPRSignedBy(sbKeyType(""), SBOptions{KeyPath: testPath}, testIdentity)
 	assert.Error(t, err)
-	_, err = newPRSignedBy(sbKeyType("this is invalid"), testPath, nil, testIdentity)
+	_, err = newPRSignedBy(sbKeyType("this is invalid"), SBOptions{KeyPath: testPath}, testIdentity)
 	assert.Error(t, err)
 
 	// Both keyPath and keyData specified
-	_, err = newPRSignedBy(SBKeyTypeGPGKeys, testPath, testData, testIdentity)
+	_, err = newPRSignedBy(SBKeyTypeGPGKeys, SBOptions{KeyPath: testPath, KeyData: testData}, testIdentity)
 	assert.Error(t, err)
 
 	// Invalid signedIdentity
-	_, err = newPRSignedBy(SBKeyTypeGPGKeys, testPath, nil, nil)
+	_, err = newPRSignedBy(SBKeyTypeGPGKeys, SBOptions{KeyPath: testPath}, nil)
 	assert.Error(t, err)
+
+	// x509Identity
+	testX509Identity := &SBX509Identity{SANEmailAddresses: []string{"signer@example.com"}}
+	pr, err = newPRSignedBy(SBKeyTypeSignedByX509CAs, SBOptions{KeyPath: testPath, X509Identity: testX509Identity}, testIdentity)
+	require.NoError(t, err)
+	assert.Equal(t, &prSignedBy{
+		prCommon:       prCommon{prTypeSignedBy},
+		KeyType:        SBKeyTypeSignedByX509CAs,
+		KeyPath:        testPath,
+		KeyData:        nil,
+		SignedIdentity: testIdentity,
+		X509Identity:   testX509Identity,
+	}, pr)
+	// x509Identity with a non-X.509 keyType
+	_, err = newPRSignedBy(SBKeyTypeGPGKeys, SBOptions{KeyPath: testPath, X509Identity: testX509Identity}, testIdentity)
+	assert.Error(t, err)
+	// x509Identity without any constraints
+	_, err = newPRSignedBy(SBKeyTypeSignedByX509CAs, SBOptions{KeyPath: testPath, X509Identity: &SBX509Identity{}}, testIdentity)
+	assert.Error(t, err)
+	// x509Identity only relaxing the extended key usage
+	_, err = newPRSignedBy(SBKeyTypeSignedByX509CAs, SBOptions{KeyPath: testPath, X509Identity: &SBX509Identity{ExtendedKeyUsages: []string{"any"}}}, testIdentity)
+	assert.NoError(t, err)
+	// Invalid extended key usage
+	_, err = newPRSignedBy(SBKeyTypeSignedByX509CAs, SBOptions{KeyPath: testPath, X509Identity: &SBX509Identity{ExtendedKeyUsages: []string{"codeSigning", "unknown"}}}, testIdentity)
+	assert.Error(t, err)
+
+	// signatureTimestamp
+	notBefore := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	testTimestamp := &SBTimestampConstraints{
+		MaxAge:      30 * 24 * time.Hour,
+		NotBefore:   &notBefore,
+		NotAfter:    &notAfter,
+		KeyNotAfter: map[string]time.Time{TestKeyFingerprint: notAfter},
+	}
+	pr, err = newPRSignedBy(SBKeyTypeGPGKeys, SBOptions{KeyPath: testPath, SignatureTimestamp: testTimestamp}, testIdentity)
+	require.NoError(t, err)
+	assert.Equal(t, &prSignedBy{
+		prCommon:           prCommon{prTypeSignedBy},
+		KeyType:            SBKeyTypeGPGKeys,
+		KeyPath:            testPath,
+		KeyData:            nil,
+		SignedIdentity:     testIdentity,
+		SignatureTimestamp: testTimestamp,
+	}, pr)
+	for _, c := range []SBTimestampConstraints{
+		{}, // No constraints
+		{MaxAge: -time.Hour},
+		{NotBefore: &notAfter, NotAfter: &notBefore},
+		{KeyNotAfter: map[string]time.Time{"": notAfter}},
+	} {
+		c := c
+		_, err = newPRSignedBy(SBKeyTypeGPGKeys, SBOptions{KeyPath: testPath, SignatureTimestamp: &c}, testIdentity)
+		assert.Error(t, err, "%#v", c)
+	}
+
+	// revokedFingerprints
+	testRevoked := []string{TestKeyFingerprint, "0123 4567"}
+	pr, err = newPRSignedBy(SBKeyTypeGPGKeys, SBOptions{KeyPath: testPath, RevokedFingerprints: testRevoked}, testIdentity)
+	require.NoError(t, err)
+	assert.Equal(t, &prSignedBy{
+		prCommon:            prCommon{prTypeSignedBy},
+		KeyType:             SBKeyTypeGPGKeys,
+		KeyPath:             testPath,
+		KeyData:             nil,
+		SignedIdentity:      testIdentity,
+		RevokedFingerprints: testRevoked,
+	}, pr)
+	for _, revoked := range [][]string{{""}, {TestKeyFingerprint, " "}} {
+		_, err = newPRSignedBy(SBKeyTypeGPGKeys, SBOptions{KeyPath: testPath, RevokedFingerprints: revoked}, testIdentity)
+		assert.Error(t, err, "%#v", revoked)
+	}
 }
 
 func TestNewPRSignedByKeyPath(t *testing.T) {
@@ -741,6 +827,83 @@ func TestNewPRSignedByKeyData(t *testing.T) {
 	// Failure cases tested in TestNewPRSignedBy.
 }
 
+func TestNewPRSignedByX509CAsKeyPath(t *testing.T) {
+	const testPath = "/foo/bar"
+	testX509Identity := &SBX509Identity{SubjectCommonNames: []string{"signer"}}
+	_pr, err := NewPRSignedByX509CAsKeyPath(testPath, testX509Identity, NewPRMMatchRepoDigestOrExact())
+	require.NoError(t, err)
+	pr, ok := _pr.(*prSignedBy)
+	require.True(t, ok)
+	assert.Equal(t, SBKeyTypeSignedByX509CAs, pr.KeyType)
+	assert.Equal(t, testPath, pr.KeyPath)
+	assert.Equal(t, testX509Identity, pr.X509Identity)
+	// Failure cases tested in TestNewPRSignedBy.
+}
+
+func TestNewPRSignedByX509CAsKeyData(t *testing.T) {
+	testData := []byte("abc")
+	_pr, err := NewPRSignedByX509CAsKeyData(testData, nil, NewPRMMatchRepoDigestOrExact())
+	require.NoError(t, err)
+	pr, ok := _pr.(*prSignedBy)
+	require.True(t, ok)
+	assert.Equal(t, SBKeyTypeSignedByX509CAs, pr.KeyType)
+	assert.Equal(t, testData, pr.KeyData)
+	assert.Nil(t, pr.X509Identity)
+	// Failure cases tested in TestNewPRSignedBy.
+}
+
+func TestNewPRSignedByWithOptions(t *testing.T) {
+	const testPath = "/foo/bar"
+	testData := []byte("abc")
+	testX509Identity := &SBX509Identity{SubjectCommonNames: []string{"signer"}}
+	testTimestamp := &SBTimestampConstraints{MaxAge: time.Hour}
+	testRevoked := []string{TestKeyFingerprint}
+
+	_pr, err := NewPRSignedByWithOptions(SBKeyTypeGPGKeys, SBOptions{
+		KeyPath:             testPath,
+		SignatureTimestamp:  testTimestamp,
+		RevokedFingerprints: testRevoked,
+	}, NewPRMMatchRepoDigestOrExact())
+	require.NoError(t, err)
+	pr, ok := _pr.(*prSignedBy)
+	require.True(t, ok)
+	assert.Equal(t, testPath, pr.KeyPath)
+	assert.Nil(t, pr.KeyData)
+	assert.Nil(t, pr.X509Identity)
+	assert.Equal(t, testTimestamp, pr.SignatureTimestamp)
+	assert.Equal(t, testRevoked, pr.RevokedFingerprints)
+
+	// An X.509 requirement with revocations
+	_pr, err = NewPRSignedByWithOptions(SBKeyTypeSignedByX509CAs, SBOptions{
+		KeyData:             testData,
+		X509Identity:        testX509Identity,
+		RevokedFingerprints: testRevoked,
+	}, NewPRMMatchRepoDigestOrExact())
+	require.NoError(t, err)
+	pr, ok = _pr.(*prSignedBy)
+	require.True(t, ok)
+	assert.Equal(t, SBKeyTypeSignedByX509CAs, pr.KeyType)
+	assert.Equal(t, "", pr.KeyPath)
+	assert.Equal(t, testData, pr.KeyData)
+	assert.Equal(t, testX509Identity, pr.X509Identity)
+	assert.Nil(t, pr.SignatureTimestamp)
+	assert.Equal(t, testRevoked, pr.RevokedFingerprints)
+	// Failure cases tested in TestNewPRSignedBy.
+}
+
+func TestNormalizeKeyFingerprint(t *testing.T) {
+	for _, c := range []struct{ input, expected string }{
+		{"", ""},
+		{"  ", ""},
+		{"0123456789ABCDEF", "0123456789ABCDEF"},
+		{"0123456789abcdef", "0123456789ABCDEF"},
+		{"0123 4567 89ab CDEF", "0123456789ABCDEF"},
+		{" 0123\t4567 ", "01234567"},
+	} {
+		assert.Equal(t, c.expected, normalizeKeyFingerprint(c.input), c.input)
+	}
+}
+
 // Return the result of modifying validJSON with fn and unmarshaling it into *pr
 func tryUnmarshalModifiedSignedBy(t *testing.T, pr *prSignedBy, validJSON []byte, modifyFn func(mSI)) error {
 	var tmp mSI
@@ -803,6 +966,97 @@ func TestPRSignedByUnmarshalJSON(t *testing.T) {
 		},
 		duplicateFields: []string{"type", "keyType", "keyPath", "signedIdentity"},
 	}.run(t)
+	// Test the x509Identity-specific aspects
+	policyJSONUmarshallerTests{
+		newDest: func() json.Unmarshaler { return &prSignedBy{} },
+		newValidObject: func() (interface{}, error) {
+			return NewPRSignedByX509CAsKeyPath("/foo/bar", &SBX509Identity{
+				SubjectCommonNames:   []string{"signer"},
+				SubjectOrganizations: []string{"Example Org"},
+				SANDNSNames:          []string{"signer.example.com"},
+				SANEmailAddresses:    []string{"signer@example.com"},
+				SANURIs:              []string{"https://signer.example.com/ci"},
+				ExtendedKeyUsages:    []string{"codeSigning", "emailProtection"},
+			}, NewPRMMatchRepoDigestOrExact())
+		},
+		otherJSONParser: func(validJSON []byte) (interface{}, error) {
+			return newPolicyRequirementFromJSON(validJSON)
+		},
+		breakFns: []func(mSI){
+			// x509Identity with a non-X.509 keyType
+			func(v mSI) { v["keyType"] = "GPGKeys" },
+			// Invalid "x509Identity" field
+			func(v mSI) { v["x509Identity"] = 1 },
+			func(v mSI) { v["x509Identity"] = mSI{} },
+			func(v mSI) { v["x509Identity"] = mSI{"unknown": []string{"a"}} },
+			func(v mSI) { v["x509Identity"] = mSI{"sanURIs": "not an array"} },
+			func(v mSI) { v["x509Identity"] = mSI{"extendedKeyUsages": []string{"this is invalid"}} },
+		},
+		duplicateFields: []string{"type", "keyType", "keyPath", "signedIdentity", "x509Identity"},
+	}.run(t)
+	// Test the signatureTimestamp-specific aspects
+	policyJSONUmarshallerTests{
+		newDest: func() json.Unmarshaler { return &prSignedBy{} },
+		newValidObject: func() (interface{}, error) {
+			notBefore := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+			notAfter := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+			return NewPRSignedByWithOptions(SBKeyTypeGPGKeys, SBOptions{
+				KeyPath: "/foo/bar",
+				SignatureTimestamp: &SBTimestampConstraints{
+					MaxAge:      90 * time.Minute,
+					NotBefore:   &notBefore,
+					NotAfter:    &notAfter,
+					KeyNotAfter: map[string]time.Time{TestKeyFingerprint: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)},
+				},
+			}, NewPRMMatchRepoDigestOrExact())
+		},
+		otherJSONParser: func(validJSON []byte) (interface{}, error) {
+			return newPolicyRequirementFromJSON(validJSON)
+		},
+		breakFns: []func(mSI){
+			// Invalid "signatureTimestamp" field
+			func(v mSI) { v["signatureTimestamp"] = 1 },
+			func(v mSI) { v["signatureTimestamp"] = mSI{} },
+			func(v mSI) { v["signatureTimestamp"] = mSI{"unknown": "1h"} },
+			// Invalid "maxAge" field
+			func(v mSI) { x(v, "signatureTimestamp")["maxAge"] = 1 },
+			func(v mSI) { x(v, "signatureTimestamp")["maxAge"] = "" },
+			func(v mSI) { x(v, "signatureTimestamp")["maxAge"] = "this is invalid" },
+			func(v mSI) { x(v, "signatureTimestamp")["maxAge"] = "0s" },
+			func(v mSI) { x(v, "signatureTimestamp")["maxAge"] = "-1h" },
+			// Invalid "notBefore" field
+			func(v mSI) { x(v, "signatureTimestamp")["notBefore"] = 1 },
+			func(v mSI) { x(v, "signatureTimestamp")["notBefore"] = "this is invalid" },
+			// "notAfter" before "notBefore"
+			func(v mSI) { x(v, "signatureTimestamp")["notAfter"] = "2019-01-01T00:00:00Z" },
+			// Invalid "keyNotAfter" field
+			func(v mSI) { x(v, "signatureTimestamp")["keyNotAfter"] = 1 },
+			func(v mSI) { x(v, "signatureTimestamp")["keyNotAfter"] = mSI{TestKeyFingerprint: 1} },
+			func(v mSI) { x(v, "signatureTimestamp")["keyNotAfter"] = mSI{"": "2025-06-01T12:00:00Z"} },
+		},
+		duplicateFields: []string{"type", "keyType", "keyPath", "signedIdentity", "signatureTimestamp"},
+	}.run(t)
+	// Test the revokedFingerprints-specific aspects
+	policyJSONUmarshallerTests{
+		newDest: func() json.Unmarshaler { return &prSignedBy{} },
+		newValidObject: func() (interface{}, error) {
+			return NewPRSignedByWithOptions(SBKeyTypeGPGKeys, SBOptions{
+				KeyPath:             "/foo/bar",
+				RevokedFingerprints: []string{TestKeyFingerprint, "0123 4567"},
+			}, NewPRMMatchRepoDigestOrExact())
+		},
+		otherJSONParser: func(validJSON []byte) (interface{}, error) {
+			return newPolicyRequirementFromJSON(validJSON)
+		},
+		breakFns: []func(mSI){
+			// Invalid "revokedFingerprints" field
+			func(v mSI) { v["revokedFingerprints"] = 1 },
+			func(v mSI) { v["revokedFingerprints"] = TestKeyFingerprint },
+			func(v mSI) { v["revokedFingerprints"] = []interface{}{1} },
+			func(v mSI) { v["revokedFingerprints"] = []string{""} },
+		},
+		duplicateFields: []string{"type", "keyType", "keyPath", "signedIdentity", "revokedFingerprints"},
+	}.run(t)
 
 	var pr prSignedBy
 
@@ -887,20 +1141,26 @@ func xNewPRSignedBaseLayer(baseLayerIdentity PolicyReferenceMatch) PolicyRequire
 }
 
 func TestNewPRSignedBaseLayer(t *testing.T) {
-	testBLI := NewPRMMatchExact()
-
 	// Success
-	_pr, err := NewPRSignedBaseLayer(testBLI)
-	require.NoError(t, err)
-	pr, ok := _pr.(*prSignedBaseLayer)
-	require.True(t, ok)
-	assert.Equal(t, &prSignedBaseLayer{
-		prCommon:          prCommon{prTypeSignedBaseLayer},
-		BaseLayerIdentity: testBLI,
-	}, pr)
+	for _, testBLI := range []PolicyReferenceMatch{
+		xNewPRMExactReference("registry.access.redhat.com/rhel7/rhel:7.2.3"),
+		xNewPRMExactRepository("registry.access.redhat.com/rhel7/rhel"),
+		// Identities which can't identify a base image are only rejected when evaluating the requirement.
+		NewPRMMatchRepository(),
+		xNewPRMRemapIdentity("example.com", "registry.access.redhat.com"),
+	} {
+		_pr, err := NewPRSignedBaseLayer(testBLI)
+		require.NoError(t, err)
+		pr, ok := _pr.(*prSignedBaseLayer)
+		require.True(t, ok)
+		assert.Equal(t, &prSignedBaseLayer{
+			prCommon:          prCommon{prTypeSignedBaseLayer},
+			BaseLayerIdentity: testBLI,
+		}, pr)
+	}
 
 	// Invalid baseLayerIdentity
-	_, err = NewPRSignedBaseLayer(nil)
+	_, err := NewPRSignedBaseLayer(nil)
 	assert.Error(t, err)
 }
 
@@ -934,6 +1194,321 @@ func TestPRSignedBaseLayerUnmarshalJSON(t *testing.T) {
 	}.run(t)
 }
 
+// xNewPRSigstoreSignedKeyPath is like NewPRSigstoreSignedKeyPath, except it must not fail.
+func xNewPRSigstoreSignedKeyPath(keyPath string, signedIdentity PolicyReferenceMatch) PolicyRequirement {
+	pr, err := NewPRSigstoreSignedKeyPath(keyPath, signedIdentity)
+	if err != nil {
+		panic("xNewPRSigstoreSignedKeyPath failed")
+	}
+	return pr
+}
+
+func TestNewPRSigstoreSigned(t *testing.T) {
+	const testPath = "/foo/bar"
+	testData := []byte("abc")
+	testIdentity := NewPRMMatchRepoDigestOrExact()
+
+	// Success
+	pr, err := newPRSigstoreSigned(testPath, nil, testIdentity)
+	require.NoError(t, err)
+	assert.Equal(t, &prSigstoreSigned{
+		prCommon:       prCommon{prTypeSigstoreSigned},
+		KeyPath:        testPath,
+		KeyData:        nil,
+		SignedIdentity: testIdentity,
+	}, pr)
+	pr, err = newPRSigstoreSigned("", testData, testIdentity)
+	require.NoError(t, err)
+	assert.Equal(t, &prSigstoreSigned{
+		prCommon:       prCommon{prTypeSigstoreSigned},
+		KeyPath:        "",
+		KeyData:        testData,
+		SignedIdentity: testIdentity,
+	}, pr)
+
+	// Both keyPath and keyData specified
+	_, err = newPRSigstoreSigned(testPath, testData, testIdentity)
+	assert.Error(t, err)
+
+	// Neither keyPath nor keyData specified
+	_, err = newPRSigstoreSigned("", nil, testIdentity)
+	assert.Error(t, err)
+
+	// Invalid signedIdentity
+	_, err = newPRSigstoreSigned(testPath, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestNewPRSigstoreSignedKeyPath(t *testing.T) {
+	const testPath = "/foo/bar"
+	_pr, err := NewPRSigstoreSignedKeyPath(testPath, NewPRMMatchRepoDigestOrExact())
+	require.NoError(t, err)
+	pr, ok := _pr.(*prSigstoreSigned)
+	require.True(t, ok)
+	assert.Equal(t, testPath, pr.KeyPath)
+	// Failure cases tested in TestNewPRSigstoreSigned.
+}
+
+func TestNewPRSigstoreSignedKeyData(t *testing.T) {
+	testData := []byte("abc")
+	_pr, err := NewPRSigstoreSignedKeyData(testData, NewPRMMatchRepoDigestOrExact())
+	require.NoError(t, err)
+	pr, ok := _pr.(*prSigstoreSigned)
+	require.True(t, ok)
+	assert.Equal(t, testData, pr.KeyData)
+	// Failure cases tested in TestNewPRSigstoreSigned.
+}
+
+// Return the result of modifying validJSON with fn and unmarshaling it into *pr
+func tryUnmarshalModifiedSigstoreSigned(t *testing.T, pr *prSigstoreSigned, validJSON []byte, modifyFn func(mSI)) error {
+	var tmp mSI
+	err := json.Unmarshal(validJSON, &tmp)
+	require.NoError(t, err)
+
+	modifyFn(tmp)
+
+	*pr = prSigstoreSigned{}
+	return jsonUnmarshalFromObject(t, tmp, &pr)
+}
+
+func TestPRSigstoreSignedUnmarshalJSON(t *testing.T) {
+	keyDataTests := policyJSONUmarshallerTests{
+		newDest: func() json.Unmarshaler { return &prSigstoreSigned{} },
+		newValidObject: func() (interface{}, error) {
+			return NewPRSigstoreSignedKeyData([]byte("abc"), NewPRMMatchRepoDigestOrExact())
+		},
+		otherJSONParser: func(validJSON []byte) (interface{}, error) {
+			return newPolicyRequirementFromJSON(validJSON)
+		},
+		breakFns: []func(mSI){
+			// The "type" field is missing
+			func(v mSI) { delete(v, "type") },
+			// Wrong "type" field
+			func(v mSI) { v["type"] = 1 },
+			func(v mSI) { v["type"] = "this is invalid" },
+			// Extra top-level sub-object
+			func(v mSI) { v["unexpected"] = 1 },
+			// A "keyType" field, which is not used by this requirement
+			func(v mSI) { v["keyType"] = "GPGKeys" },
+			// Both "keyPath" and "keyData" is missing
+			func(v mSI) { delete(v, "keyData") },
+			// Both "keyPath" and "keyData" is present
+			func(v mSI) { v["keyPath"] = "/foo/bar" },
+			// Invalid "keyPath" field
+			func(v mSI) { delete(v, "keyData"); v["keyPath"] = 1 },
+			// Invalid "keyData" field
+			func(v mSI) { v["keyData"] = 1 },
+			func(v mSI) { v["keyData"] = "this is invalid base64" },
+			// Invalid "signedIdentity" field
+			func(v mSI) { v["signedIdentity"] = "this is invalid" },
+			// "signedIdentity" an explicit nil
+			func(v mSI) { v["signedIdentity"] = nil },
+		},
+		duplicateFields: []string{"type", "keyData", "signedIdentity"},
+	}
+	keyDataTests.run(t)
+	// Test the keyPath-specific aspects
+	policyJSONUmarshallerTests{
+		newDest: func() json.Unmarshaler { return &prSigstoreSigned{} },
+		newValidObject: func() (interface{}, error) {
+			return NewPRSigstoreSignedKeyPath("/foo/bar", NewPRMMatchRepoDigestOrExact())
+		},
+		otherJSONParser: func(validJSON []byte) (interface{}, error) {
+			return newPolicyRequirementFromJSON(validJSON)
+		},
+		duplicateFields: []string{"type", "keyPath", "signedIdentity"},
+	}.run(t)
+
+	var pr prSigstoreSigned
+
+	// Start with a valid JSON.
+	_, validJSON := keyDataTests.validObjectAndJSON(t)
+
+	// Various ways to set signedIdentity to the default value
+	signedIdentityDefaultFns := []func(mSI){
+		// Set signedIdentity to the default explicitly
+		func(v mSI) { v["signedIdentity"] = NewPRMMatchRepoDigestOrExact() },
+		// Delete the signedIdentity field
+		func(v mSI) { delete(v, "signedIdentity") },
+	}
+	for _, fn := range signedIdentityDefaultFns {
+		err := tryUnmarshalModifiedSigstoreSigned(t, &pr, validJSON, fn)
+		require.NoError(t, err)
+		assert.Equal(t, NewPRMMatchRepoDigestOrExact(), pr.SignedIdentity)
+	}
+}
+
+// xNewPRSignedByThreshold is like NewPRSignedByThreshold, except it must not fail.
+func xNewPRSignedByThreshold(threshold int, countDistinct sbtCountDistinct, keyGroups []SBKeyGroup, signedIdentity PolicyReferenceMatch) PolicyRequirement {
+	pr, err := NewPRSignedByThreshold(threshold, countDistinct, keyGroups, signedIdentity)
+	if err != nil {
+		panic("xNewPRSignedByThreshold failed")
+	}
+	return pr
+}
+
+func TestNewPRSignedByThreshold(t *testing.T) {
+	testGroups := []SBKeyGroup{
+		{Name: "build", KeyType: SBKeyTypeGPGKeys, KeyPath: "/foo/bar"},
+		{KeyType: SBKeyTypeSignedByX509CAs, KeyData: []byte("abc"), X509Identity: &SBX509Identity{SubjectCommonNames: []string{"security"}}},
+	}
+	testIdentity := NewPRMMatchRepoDigestOrExact()
+
+	// Success
+	for _, c := range []struct {
+		threshold     int
+		countDistinct sbtCountDistinct
+	}{
+		{1, SBTCountKeyGroups},
+		{2, SBTCountKeyGroups},
+		{3, SBTCountKeys},
+	} {
+		_pr, err := NewPRSignedByThreshold(c.threshold, c.countDistinct, testGroups, testIdentity)
+		require.NoError(t, err)
+		pr, ok := _pr.(*prSignedByThreshold)
+		require.True(t, ok)
+		assert.Equal(t, &prSignedByThreshold{
+			prCommon:       prCommon{prTypeSignedByThreshold},
+			Threshold:      c.threshold,
+			CountDistinct:  c.countDistinct,
+			KeyGroups:      testGroups,
+			SignedIdentity: testIdentity,
+		}, pr)
+	}
+
+	// Invalid threshold
+	for _, threshold := range []int{-1, 0} {
+		_, err := NewPRSignedByThreshold(threshold, SBTCountKeys, testGroups, testIdentity)
+		assert.Error(t, err)
+	}
+	// Threshold larger than the number of groups
+	_, err := NewPRSignedByThreshold(3, SBTCountKeyGroups, testGroups, testIdentity)
+	assert.Error(t, err)
+
+	// Invalid countDistinct
+	for _, cd := range []sbtCountDistinct{"", "this is invalid"} {
+		_, err := NewPRSignedByThreshold(1, cd, testGroups, testIdentity)
+		assert.Error(t, err)
+	}
+
+	// Invalid keyGroups
+	for _, groups := range [][]SBKeyGroup{
+		nil,
+		{},
+		{{KeyType: "this is invalid", KeyPath: "/foo/bar"}},
+		{{KeyType: SBKeyTypeSignedByGPGKeys, KeyPath: "/foo/bar"}},
+		{{KeyType: SBKeyTypeGPGKeys}},
+		{{KeyType: SBKeyTypeGPGKeys, KeyPath: "/foo/bar", KeyData: []byte("abc")}},
+		{{KeyType: SBKeyTypeGPGKeys, KeyPath: "/foo/bar", X509Identity: &SBX509Identity{SubjectCommonNames: []string{"security"}}}},
+	} {
+		_, err := NewPRSignedByThreshold(1, SBTCountKeys, groups, testIdentity)
+		assert.Error(t, err)
+	}
+
+	// Invalid signedIdentity
+	_, err = NewPRSignedByThreshold(1, SBTCountKeyGroups, testGroups, nil)
+	assert.Error(t, err)
+}
+
+// Return the result of modifying validJSON with fn and unmarshaling it into *pr
+func tryUnmarshalModifiedSignedByThreshold(t *testing.T, pr *prSignedByThreshold, validJSON []byte, modifyFn func(mSI)) error {
+	var tmp mSI
+	err := json.Unmarshal(validJSON, &tmp)
+	require.NoError(t, err)
+
+	modifyFn(tmp)
+
+	*pr = prSignedByThreshold{}
+	return jsonUnmarshalFromObject(t, tmp, &pr)
+}
+
+func TestPRSignedByThresholdUnmarshalJSON(t *testing.T) {
+	tests := policyJSONUmarshallerTests{
+		newDest: func() json.Unmarshaler { return &prSignedByThreshold{} },
+		newValidObject: func() (interface{}, error) {
+			return NewPRSignedByThreshold(2, SBTCountKeyGroups, []SBKeyGroup{
+				{Name: "build", KeyType: SBKeyTypeGPGKeys, KeyPath: "/foo/bar"},
+				{Name: "security", KeyType: SBKeyTypeGPGKeys, KeyData: []byte("abc"), RevokedFingerprints: []string{TestKeyFingerprint}},
+			}, NewPRMMatchRepository())
+		},
+		otherJSONParser: func(validJSON []byte) (interface{}, error) {
+			return newPolicyRequirementFromJSON(validJSON)
+		},
+		breakFns: []func(mSI){
+			// The "type" field is missing
+			func(v mSI) { delete(v, "type") },
+			// Wrong "type" field
+			func(v mSI) { v["type"] = 1 },
+			func(v mSI) { v["type"] = "this is invalid" },
+			// Extra top-level sub-object
+			func(v mSI) { v["unexpected"] = 1 },
+			// The "threshold" field is missing
+			func(v mSI) { delete(v, "threshold") },
+			// Invalid "threshold" field
+			func(v mSI) { v["threshold"] = "2" },
+			func(v mSI) { v["threshold"] = 0 },
+			func(v mSI) { v["threshold"] = 3 },
+			// Invalid "countDistinct" field
+			func(v mSI) { v["countDistinct"] = 1 },
+			func(v mSI) { v["countDistinct"] = "this is invalid" },
+			// The "keyGroups" field is missing
+			func(v mSI) { delete(v, "keyGroups") },
+			// Invalid "keyGroups" field
+			func(v mSI) { v["keyGroups"] = "this is invalid" },
+			func(v mSI) { v["keyGroups"] = []interface{}{} },
+			func(v mSI) {
+				v["keyGroups"] = []interface{}{mSI{"keyType": "GPGKeys", "keyPath": "/foo/bar", "unexpected": 1}}
+			},
+			func(v mSI) { v["keyGroups"] = []interface{}{mSI{"keyType": "this is invalid", "keyPath": "/foo/bar"}} },
+			func(v mSI) { v["keyGroups"] = []interface{}{mSI{"keyType": "GPGKeys"}} },
+			func(v mSI) {
+				v["keyGroups"] = []interface{}{mSI{"keyType": "GPGKeys", "keyData": "this is invalid base64"}}
+			},
+			func(v mSI) {
+				v["keyGroups"] = []interface{}{mSI{"keyType": "GPGKeys", "keyPath": "/foo/bar", "revokedFingerprints": "this is invalid"}}
+			},
+			func(v mSI) {
+				v["keyGroups"] = []interface{}{mSI{"keyType": "GPGKeys", "keyPath": "/foo/bar", "revokedFingerprints": []interface{}{""}}}
+			},
+			// Invalid "signedIdentity" field
+			func(v mSI) { v["signedIdentity"] = "this is invalid" },
+			// "signedIdentity" an explicit nil
+			func(v mSI) { v["signedIdentity"] = nil },
+		},
+		duplicateFields: []string{"type", "threshold", "countDistinct", "keyGroups", "signedIdentity"},
+	}
+	tests.run(t)
+
+	var pr prSignedByThreshold
+	_, validJSON := tests.validObjectAndJSON(t)
+
+	// "countDistinct" defaults to "keyGroups"
+	err := tryUnmarshalModifiedSignedByThreshold(t, &pr, validJSON, func(v mSI) { delete(v, "countDistinct") })
+	require.NoError(t, err)
+	assert.Equal(t, SBTCountKeyGroups, pr.CountDistinct)
+	// With "countDistinct": "keys", the threshold may be larger than the number of groups.
+	err = tryUnmarshalModifiedSignedByThreshold(t, &pr, validJSON, func(v mSI) {
+		v["countDistinct"] = "keys"
+		v["threshold"] = 3
+	})
+	require.NoError(t, err)
+	assert.Equal(t, SBTCountKeys, pr.CountDistinct)
+	assert.Equal(t, 3, pr.Threshold)
+
+	// Various ways to set signedIdentity to the default value
+	signedIdentityDefaultFns := []func(mSI){
+		// Set signedIdentity to the default explicitly
+		func(v mSI) { v["signedIdentity"] = NewPRMMatchRepoDigestOrExact() },
+		// Delete the signedIdentity field
+		func(v mSI) { delete(v, "signedIdentity") },
+	}
+	for _, fn := range signedIdentityDefaultFns {
+		err := tryUnmarshalModifiedSignedByThreshold(t, &pr, validJSON, fn)
+		require.NoError(t, err)
+		assert.Equal(t, NewPRMMatchRepoDigestOrExact(), pr.SignedIdentity)
+	}
+}
+
 func TestNewPolicyReferenceMatchFromJSON(t *testing.T) {
 	// Sample success. Others tested in the individual PolicyReferenceMatch.UnmarshalJSON implementations.
 	validPRM := NewPRMMatchRepoDigestOrExact()
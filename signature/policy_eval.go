@@ -8,6 +8,7 @@ package signature
 import (
 	"context"
 
+	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/types"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -65,6 +66,25 @@ type PolicyRequirement interface {
 	isRunningImageAllowed(ctx context.Context, image types.UnparsedImage) (bool, error)
 }
 
+// policyContextRequirement is implemented by PolicyRequirements which need the complete policy
+// (e.g. to evaluate other images) to decide whether an image is allowed to run.
+// PolicyContext.IsRunningImageAllowed uses isRunningImageAllowedInContext instead of isRunningImageAllowed for them.
+type policyContextRequirement interface {
+	// isRunningImageAllowedInContext is isRunningImageAllowed, with access to pc.
+	// baseImages are the references of base images being evaluated on the way to this one (outermost first),
+	// to allow detecting cycles.
+	// If report is not nil, details of the evaluation may be recorded in it; the caller records the result.
+	isRunningImageAllowedInContext(ctx context.Context, pc *PolicyContext, image types.UnparsedImage, baseImages []string, report *PolicyRequirementReport) (bool, error)
+}
+
+// signatureReportingRequirement is implemented by PolicyRequirements which can report the results
+// for individual signatures to PolicyContext.ExplainRunningImageAllowed.
+type signatureReportingRequirement interface {
+	// isRunningImageAllowedWithReport is isRunningImageAllowed, which also records the results for individual signatures in report.
+	// report must not be nil; the caller records the overall result.
+	isRunningImageAllowedWithReport(ctx context.Context, image types.UnparsedImage, report *PolicyRequirementReport) (bool, error)
+}
+
 // PolicyReferenceMatch specifies a set of image identities accepted in PolicyRequirement.
 // The type is public, but its implementation is private.
 type PolicyReferenceMatch interface {
@@ -79,6 +99,10 @@ type PolicyReferenceMatch interface {
 type PolicyContext struct {
 	Policy *Policy
 	state  policyContextState // Internal consistency checking
+	// sys is used when accessing base images of "signedBaseLayer" requirements; it may be nil.
+	sys *types.SystemContext
+	// newBaseImageSource, if not nil, replaces the "docker" transport when opening base images; it is used by tests.
+	newBaseImageSource func(ctx context.Context, sys *types.SystemContext, ref reference.Named) (types.ImageSource, error)
 }
 
 // policyContextState is used internally to verify the users are not misusing a PolicyContext.
@@ -105,7 +129,14 @@ func (pc *PolicyContext) changeState(expected, new policyContextState) error {
 // The policy must not be modified while the context exists. FIXME: make a deep copy?
 // If this function succeeds, the caller should call PolicyContext.Destroy() when done.
 func NewPolicyContext(policy *Policy) (*PolicyContext, error) {
-	pc := &PolicyContext{Policy: policy, state: pcInitializing}
+	return NewPolicyContextWithSystemContext(policy, nil)
+}
+
+// NewPolicyContextWithSystemContext is NewPolicyContext, except that base images of "signedBaseLayer" requirements
+// are accessed using sys (e.g. for credentials, registries.conf or TLS configuration).
+// sys may be nil; if not, it must not be modified until PolicyContext.Destroy() returns.
+func NewPolicyContextWithSystemContext(policy *Policy, sys *types.SystemContext) (*PolicyContext, error) {
+	pc := &PolicyContext{Policy: policy, state: pcInitializing, sys: sys}
 	// FIXME: initialize
 	if err := pc.changeState(pcInitializing, pcReady); err != nil {
 		// Huh?! This should never fail, we didn't give the pointer to anybody.
@@ -132,6 +163,14 @@ func policyIdentityLogName(ref types.ImageReference) string {
 
 // requirementsForImageRef selects the appropriate requirements for ref.
 func (pc *PolicyContext) requirementsForImageRef(ref types.ImageReference) PolicyRequirements {
+	reqs, _, _ := pc.requirementsAndScopeForImageRef(ref)
+	return reqs
+}
+
+// requirementsAndScopeForImageRef selects the appropriate requirements for ref, and returns the
+// scope within Policy.Transports[ref.Transport().Name()] they were found in ("" for the transport default).
+// If no scope matched and the requirements are Policy.Default, usesDefault is true.
+func (pc *PolicyContext) requirementsAndScopeForImageRef(ref types.ImageReference) (reqs PolicyRequirements, scope string, usesDefault bool) {
 	// Do we have a PolicyTransportScopes for this transport?
 	transportName := ref.Transport().Name()
 	if transportScopes, ok := pc.Policy.Transports[transportName]; ok {
@@ -139,26 +178,26 @@ func (pc *PolicyContext) requirementsForImageRef(ref types.ImageReference) Polic
 		identity := ref.PolicyConfigurationIdentity()
 		if req, ok := transportScopes[identity]; ok {
 			logrus.Debugf(` Using transport "%s" policy section %s`, transportName, identity)
-			return req
+			return req, identity, false
 		}
 
 		// Look for a match of the possible parent namespaces.
 		for _, name := range ref.PolicyConfigurationNamespaces() {
 			if req, ok := transportScopes[name]; ok {
 				logrus.Debugf(` Using transport "%s" specific policy section %s`, transportName, name)
-				return req
+				return req, name, false
 			}
 		}
 
 		// Look for a default match for the transport.
 		if req, ok := transportScopes[""]; ok {
 			logrus.Debugf(` Using transport "%s" policy section ""`, transportName)
-			return req
+			return req, "", false
 		}
 	}
 
 	logrus.Debugf(" Using default policy section")
-	return pc.Policy.Default
+	return pc.Policy.Default, "", true
 }
 
 // GetSignaturesWithAcceptedAuthor returns those signatures from an image
@@ -266,8 +305,21 @@ func (pc *PolicyContext) IsRunningImageAllowed(ctx context.Context, image types.
 		}
 	}()
 
+	return pc.isRunningImageAllowed(ctx, image, nil, nil)
+}
+
+// isRunningImageAllowed is IsRunningImageAllowed, except that it does not change pc.state, and that
+// it is given the base images being evaluated, for policyContextRequirement.
+// If report is not nil, details of the evaluation are recorded in it; see ExplainRunningImageAllowed.
+func (pc *PolicyContext) isRunningImageAllowed(ctx context.Context, image types.UnparsedImage, baseImages []string, report *PolicyEvaluationReport) (bool, error) {
 	logrus.Debugf("IsRunningImageAllowed for image %s", policyIdentityLogName(image.Reference()))
-	reqs := pc.requirementsForImageRef(image.Reference())
+	reqs, scope, usesDefault := pc.requirementsAndScopeForImageRef(image.Reference())
+	if report != nil {
+		report.Image = policyIdentityLogName(image.Reference())
+		report.Transport = image.Reference().Transport().Name()
+		report.Scope = scope
+		report.UsesDefault = usesDefault
+	}
 
 	if len(reqs) == 0 {
 		return false, PolicyRequirementError("List of verification policy requirements must not be empty")
@@ -275,7 +327,24 @@ func (pc *PolicyContext) IsRunningImageAllowed(ctx context.Context, image types.
 
 	for reqNumber, req := range reqs {
 		// FIXME: supply state
-		allowed, err := req.isRunningImageAllowed(ctx, image)
+		var reqReport *PolicyRequirementReport
+		if report != nil {
+			reqReport = &PolicyRequirementReport{Type: policyRequirementType(req)}
+		}
+		var allowed bool
+		var err error
+		if cr, ok := req.(policyContextRequirement); ok {
+			allowed, err = cr.isRunningImageAllowedInContext(ctx, pc, image, baseImages, reqReport)
+		} else if sr, ok := req.(signatureReportingRequirement); ok && reqReport != nil {
+			allowed, err = sr.isRunningImageAllowedWithReport(ctx, image, reqReport)
+		} else {
+			allowed, err = req.isRunningImageAllowed(ctx, image)
+		}
+		if reqReport != nil {
+			reqReport.Allowed = allowed
+			reqReport.Err = err
+			report.Requirements = append(report.Requirements, *reqReport)
+		}
 		if !allowed {
 			logrus.Debugf("Requirement %d: denied, done", reqNumber)
 			return false, err
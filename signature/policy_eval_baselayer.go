@@ -4,17 +4,180 @@ package signature
 
 import (
 	"context"
+	"fmt"
 
+	"github.com/containers/image/v5/docker/reference"
+	genericImage "github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/transports"
 	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
+// maxBaseLayerNesting is the maximum depth of base images evaluated for nested "signedBaseLayer" requirements.
+// Cycles of base images are detected directly; this is only a backstop against unreasonably long chains.
+const maxBaseLayerNesting = 8
+
 func (pr *prSignedBaseLayer) isSignatureAuthorAccepted(ctx context.Context, image types.UnparsedImage, sig []byte) (signatureAcceptanceResult, *Signature, error) {
 	return sarUnknown, nil, nil
 }
 
 func (pr *prSignedBaseLayer) isRunningImageAllowed(ctx context.Context, image types.UnparsedImage) (bool, error) {
-	// FIXME? Reject this at policy parsing time already?
-	logrus.Errorf("signedBaseLayer not implemented yet!")
-	return false, PolicyRequirementError("signedBaseLayer not implemented yet!")
+	// The base image can only be evaluated against a complete policy; see isRunningImageAllowedInContext.
+	return false, PolicyRequirementError("signedBaseLayer can only be evaluated within a PolicyContext")
+}
+
+func (pr *prSignedBaseLayer) isRunningImageAllowedInContext(ctx context.Context, pc *PolicyContext, image types.UnparsedImage, baseImages []string, report *PolicyRequirementReport) (bool, error) {
+	baseRef, err := pr.baseImageReference()
+	if err != nil {
+		return false, err
+	}
+	baseName := reference.FamiliarString(baseRef)
+	for _, b := range baseImages {
+		if b == baseRef.String() {
+			return false, PolicyRequirementError(fmt.Sprintf("Base image %s is required to be built on top of itself", baseName))
+		}
+	}
+	if len(baseImages) >= maxBaseLayerNesting {
+		return false, PolicyRequirementError(fmt.Sprintf("Base images nested more than %d levels deep", maxBaseLayerNesting))
+	}
+
+	imageLayers, err := layerDigestsForRunningImage(ctx, image)
+	if err != nil {
+		return false, err
+	}
+
+	logrus.Debugf("Looking up base image %s", baseName)
+	src, err := pc.openBaseImage(ctx, baseRef)
+	if err != nil {
+		return false, errors.Wrapf(err, "looking up base image %s", baseName)
+	}
+	defer src.Close()
+
+	// Verify the signatures of the base image as if it were used on its own, using the policy for its scope.
+	baseImage := genericImage.UnparsedInstance(src, nil)
+	nestedBaseImages := append(append([]string{}, baseImages...), baseRef.String())
+	var baseReport *PolicyEvaluationReport
+	if report != nil {
+		baseReport = &PolicyEvaluationReport{}
+		report.BaseImage = baseReport
+	}
+	allowed, err := pc.isRunningImageAllowed(ctx, baseImage, nestedBaseImages, baseReport)
+	if baseReport != nil {
+		baseReport.Allowed = allowed
+		baseReport.Err = err
+	}
+	if !allowed {
+		return false, err
+	}
+
+	baseLayers, err := layerDigestsForBaseImage(ctx, pc.sys, src, baseImage)
+	if err != nil {
+		return false, err
+	}
+	if len(baseLayers) == 0 {
+		return false, PolicyRequirementError(fmt.Sprintf("Base image %s has no layers", baseName))
+	}
+	if len(baseLayers) > len(imageLayers) {
+		return false, PolicyRequirementError(fmt.Sprintf("Image has %d layers, fewer than %d layers of base image %s",
+			len(imageLayers), len(baseLayers), baseName))
+	}
+	for i, d := range baseLayers {
+		if imageLayers[i] != d {
+			return false, PolicyRequirementError(fmt.Sprintf("Layer %d of the image (%s) does not match base image %s (%s)",
+				i, imageLayers[i], baseName, d))
+		}
+	}
+	return true, nil
+}
+
+// baseImageReference returns the reference of the base image identified by pr.BaseLayerIdentity.
+func (pr *prSignedBaseLayer) baseImageReference() (reference.Named, error) {
+	switch prm := pr.BaseLayerIdentity.(type) {
+	case *prmExactReference:
+		return reference.ParseNormalizedNamed(prm.DockerReference)
+	case *prmExactRepository:
+		ref, err := reference.ParseNormalizedNamed(prm.DockerRepository)
+		if err != nil {
+			return nil, err
+		}
+		// The base image must be a single image, so only the "latest" tag is used.
+		return reference.TagNameOnly(ref), nil
+	default:
+		// The other PolicyReferenceMatch types only describe identities relative to the evaluated image,
+		// so they can't be used to find a base image. This is not rejected when parsing the policy, so that
+		// such a requirement only affects images in its scope; ValidatePolicy reports it.
+		return nil, PolicyRequirementError(fmt.Sprintf("baseLayerIdentity must be of type %q or %q", prmTypeExactReference, prmTypeExactRepository))
+	}
+}
+
+// openBaseImage returns an ImageSource for the base image ref, using the "docker" transport (if it is available).
+// The caller must close the returned ImageSource.
+func (pc *PolicyContext) openBaseImage(ctx context.Context, ref reference.Named) (types.ImageSource, error) {
+	if pc.newBaseImageSource != nil {
+		return pc.newBaseImageSource(ctx, pc.sys, ref)
+	}
+	transport := transports.Get("docker")
+	if transport == nil {
+		return nil, errors.New(`the "docker" transport is not available`)
+	}
+	imgRef, err := transport.ParseReference("//" + ref.String())
+	if err != nil {
+		return nil, err
+	}
+	return imgRef.NewImageSource(ctx, pc.sys)
+}
+
+// layerDigestsForRunningImage returns the digests of non-empty layers of image.
+func layerDigestsForRunningImage(ctx context.Context, image types.UnparsedImage) ([]digest.Digest, error) {
+	m, mt, err := image.Manifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.MIMETypeIsMultiImage(mt) {
+		return nil, PolicyRequirementError("signedBaseLayer can only be evaluated for single-platform images, not manifest lists")
+	}
+	return layerDigestsFromManifest(m, mt)
+}
+
+// layerDigestsForBaseImage returns the digests of non-empty layers of baseImage, read from src.
+// If baseImage is a manifest list, the instance for the platform specified by sys is used.
+func layerDigestsForBaseImage(ctx context.Context, sys *types.SystemContext, src types.ImageSource, baseImage types.UnparsedImage) ([]digest.Digest, error) {
+	m, mt, err := baseImage.Manifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.MIMETypeIsMultiImage(mt) {
+		list, err := manifest.ListFromBlob(m, mt)
+		if err != nil {
+			return nil, err
+		}
+		instanceDigest, err := list.ChooseInstance(sys)
+		if err != nil {
+			return nil, err
+		}
+		m, mt, err = genericImage.UnparsedInstance(src, &instanceDigest).Manifest(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return layerDigestsFromManifest(m, mt)
+}
+
+// layerDigestsFromManifest returns the digests of non-empty layers of a single-image manifest.
+func layerDigestsFromManifest(m []byte, mt string) ([]digest.Digest, error) {
+	parsed, err := manifest.FromBlob(m, mt)
+	if err != nil {
+		return nil, err
+	}
+	res := []digest.Digest{}
+	for _, layer := range parsed.LayerInfos() {
+		if layer.EmptyLayer {
+			continue
+		}
+		res = append(res, layer.Digest)
+	}
+	return res, nil
 }
@@ -2,13 +2,24 @@ package signature
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/containers/image/v5/directory"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestPRSignedBaseLayerIsSignatureAuthorAccepted(t *testing.T) {
-	pr, err := NewPRSignedBaseLayer(NewPRMMatchRepository())
+	pr, err := NewPRSignedBaseLayer(xNewPRMExactRepository("testing/manifest"))
 	require.NoError(t, err)
 	// Pass nil pointers to, kind of, test that the return value does not depend on the parameters.
 	sar, parsedSig, err := pr.isSignatureAuthorAccepted(context.Background(), nil, nil)
@@ -16,10 +27,274 @@ func TestPRSignedBaseLayerIsSignatureAuthorAccepted(t *testing.T) {
 }
 
 func TestPRSignedBaseLayerIsRunningImageAllowed(t *testing.T) {
-	// This will obviously need to change after signedBaseLayer is implemented.
-	pr, err := NewPRSignedBaseLayer(NewPRMMatchRepository())
+	// Without a PolicyContext, the base image can't be evaluated.
+	pr, err := NewPRSignedBaseLayer(xNewPRMExactRepository("testing/manifest"))
 	require.NoError(t, err)
 	// Pass a nil pointer to, kind of, test that the return value does not depend on the image.
 	res, err := pr.isRunningImageAllowed(context.Background(), nil)
 	assertRunningRejectedPolicyRequirement(t, res, err)
 }
+
+// dirBaseImageLookup returns a PolicyContext.newBaseImageSource implementation which returns images from
+// the directories in dirs, indexed by reference.Named.String().
+// Each call is recorded in *lookups, and the SystemContext used is recorded in *lastSys.
+func dirBaseImageLookup(dirs map[string]string, lookups *int, lastSys **types.SystemContext) func(ctx context.Context, sys *types.SystemContext, ref reference.Named) (types.ImageSource, error) {
+	return func(ctx context.Context, sys *types.SystemContext, ref reference.Named) (types.ImageSource, error) {
+		*lookups++
+		*lastSys = sys
+		dir, ok := dirs[ref.String()]
+		if !ok {
+			return nil, errors.New("base image not found")
+		}
+		srcRef, err := directory.NewReference(dir)
+		if err != nil {
+			return nil, err
+		}
+		src, err := srcRef.NewImageSource(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		return &dirImageSourceMock{
+			ImageSource: src,
+			ref:         pcImageReferenceMock{transportName: "docker", ref: ref},
+		}, nil
+	}
+}
+
+// createModifiedLayersDir creates a directory suitable for dirImageMock, containing an unsigned image with
+// the manifest of fixtures/dir-img-valid, with layers modified by editLayers.
+func createModifiedLayersDir(t *testing.T, editLayers func([]manifest.Schema2Descriptor) []manifest.Schema2Descriptor) string {
+	manifestBlob, err := os.ReadFile("fixtures/dir-img-valid/manifest.json")
+	require.NoError(t, err)
+	var m manifest.Schema2
+	err = json.Unmarshal(manifestBlob, &m)
+	require.NoError(t, err)
+	m.LayersDescriptors = editLayers(m.LayersDescriptors)
+	manifestBlob, err = json.Marshal(m)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	err = os.WriteFile(filepath.Join(dir, "manifest.json"), manifestBlob, 0644)
+	require.NoError(t, err)
+	return dir
+}
+
+// appendedLayer is a layer added on top of the base image layers in createDerivedImageDir.
+var appendedLayer = manifest.Schema2Descriptor{
+	MediaType: manifest.DockerV2Schema2LayerMediaType,
+	Size:      12,
+	Digest:    digest.FromString("added layer"),
+}
+
+// createDerivedImageDir creates a directory suitable for dirImageMock, containing an unsigned image
+// built on top of fixtures/dir-img-valid.
+func createDerivedImageDir(t *testing.T) string {
+	return createModifiedLayersDir(t, func(layers []manifest.Schema2Descriptor) []manifest.Schema2Descriptor {
+		return append(layers, appendedLayer)
+	})
+}
+
+// createReorderedLayersDir creates a directory suitable for dirImageMock, containing an unsigned image
+// with the layers of fixtures/dir-img-valid in reverse order.
+func createReorderedLayersDir(t *testing.T) string {
+	return createModifiedLayersDir(t, func(layers []manifest.Schema2Descriptor) []manifest.Schema2Descriptor {
+		for i, j := 0, len(layers)-1; i < j; i, j = i+1, j-1 {
+			layers[i], layers[j] = layers[j], layers[i]
+		}
+		return layers
+	})
+}
+
+// createManifestListDir creates a directory suitable for dirImageMock, containing an unsigned manifest list
+// with an amd64 instance equal to fixtures/dir-img-valid, and an arm64 instance with a different first layer.
+func createManifestListDir(t *testing.T) string {
+	dir := t.TempDir()
+	amd64Blob, err := os.ReadFile("fixtures/dir-img-valid/manifest.json")
+	require.NoError(t, err)
+	arm64Dir := createModifiedLayersDir(t, func(layers []manifest.Schema2Descriptor) []manifest.Schema2Descriptor {
+		layers[0] = appendedLayer
+		return layers
+	})
+	arm64Blob, err := os.ReadFile(filepath.Join(arm64Dir, "manifest.json"))
+	require.NoError(t, err)
+
+	list := manifest.Schema2List{
+		SchemaVersion: 2,
+		MediaType:     manifest.DockerV2ListMediaType,
+	}
+	for _, instance := range []struct {
+		arch string
+		blob []byte
+	}{
+		{"amd64", amd64Blob},
+		{"arm64", arm64Blob},
+	} {
+		d := digest.FromBytes(instance.blob)
+		err := os.WriteFile(filepath.Join(dir, d.Encoded()+".manifest.json"), instance.blob, 0644)
+		require.NoError(t, err)
+		list.Manifests = append(list.Manifests, manifest.Schema2ManifestDescriptor{
+			Schema2Descriptor: manifest.Schema2Descriptor{
+				MediaType: manifest.DockerV2Schema2MediaType,
+				Size:      int64(len(instance.blob)),
+				Digest:    d,
+			},
+			Platform: manifest.Schema2PlatformSpec{OS: "linux", Architecture: instance.arch},
+		})
+	}
+	listBlob, err := json.Marshal(list)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, "manifest.json"), listBlob, 0644)
+	require.NoError(t, err)
+	return dir
+}
+
+func TestPRSignedBaseLayerIsRunningImageAllowedInContext(t *testing.T) {
+	const baseRefString = "docker.io/testing/manifest:latest"
+	pr, err := NewPRSignedBaseLayer(xNewPRMExactReference(baseRefString))
+	require.NoError(t, err)
+	signedBy, err := NewPRSignedByKeyPath(SBKeyTypeGPGKeys, "fixtures/public-key.gpg", NewPRMMatchExact())
+	require.NoError(t, err)
+	appRef, err := reference.ParseNormalizedNamed("example.com/app:latest")
+	require.NoError(t, err)
+	sys := &types.SystemContext{ArchitectureChoice: "amd64", OSChoice: "linux"}
+	derivedDir := createDerivedImageDir(t)
+
+	for _, c := range []struct {
+		name      string
+		appDir    string
+		baseDir   string // "" if the base image can't be found
+		baseReqs  PolicyRequirements
+		allowed   bool
+		policyErr bool // If !allowed, true if the error should be a PolicyRequirementError
+	}{
+		{"success", derivedDir, "fixtures/dir-img-valid", PolicyRequirements{signedBy}, true, false},
+		{"image equal to base", "fixtures/dir-img-valid", "fixtures/dir-img-valid", PolicyRequirements{signedBy}, true, false},
+		{"base image not found", derivedDir, "", PolicyRequirements{signedBy}, false, false},
+		{"unsigned base image", derivedDir, "fixtures/dir-img-unsigned", PolicyRequirements{signedBy}, false, true},
+		{"base image rejected by policy", derivedDir, "fixtures/dir-img-valid", PolicyRequirements{NewPRReject()}, false, true},
+		{"layers not matching", createReorderedLayersDir(t), "fixtures/dir-img-valid", PolicyRequirements{signedBy}, false, true},
+		{"base image has more layers", "fixtures/dir-img-valid", derivedDir, PolicyRequirements{NewPRInsecureAcceptAnything()}, false, true},
+		{"base manifest list", derivedDir, createManifestListDir(t), PolicyRequirements{NewPRInsecureAcceptAnything()}, true, false},
+		{"app manifest list", createManifestListDir(t), "fixtures/dir-img-valid", PolicyRequirements{signedBy}, false, true},
+	} {
+		dirs := map[string]string{}
+		if c.baseDir != "" {
+			dirs[baseRefString] = c.baseDir
+		}
+		pc, err := NewPolicyContextWithSystemContext(&Policy{
+			Default: PolicyRequirements{NewPRReject()},
+			Transports: map[string]PolicyTransportScopes{
+				"docker": {
+					"example.com/app":            PolicyRequirements{pr},
+					"docker.io/testing/manifest": c.baseReqs,
+				},
+			},
+		}, sys)
+		require.NoError(t, err, c.name)
+		lookups := 0
+		var usedSys *types.SystemContext
+		pc.newBaseImageSource = dirBaseImageLookup(dirs, &lookups, &usedSys)
+
+		image := dirImageMockWithRef(t, c.appDir, pcImageReferenceMock{transportName: "docker", ref: appRef})
+		allowed, err := pc.IsRunningImageAllowed(context.Background(), image)
+		if c.allowed {
+			assert.True(t, allowed, c.name)
+			assert.NoError(t, err, c.name)
+		} else {
+			assert.False(t, allowed, c.name)
+			assert.Error(t, err, c.name)
+			if c.policyErr {
+				assert.IsType(t, PolicyRequirementError(""), err, c.name)
+			}
+		}
+		if lookups != 0 {
+			assert.Equal(t, sys, usedSys, c.name)
+		}
+		err = pc.Destroy()
+		require.NoError(t, err, c.name)
+	}
+
+	// The platform of a base manifest list is chosen using the SystemContext
+	pc, err := NewPolicyContextWithSystemContext(&Policy{
+		Default: PolicyRequirements{NewPRReject()},
+		Transports: map[string]PolicyTransportScopes{
+			"docker": {
+				"example.com/app":            PolicyRequirements{pr},
+				"docker.io/testing/manifest": PolicyRequirements{NewPRInsecureAcceptAnything()},
+			},
+		},
+	}, &types.SystemContext{ArchitectureChoice: "arm64", OSChoice: "linux"})
+	require.NoError(t, err)
+	lookups := 0
+	var usedSys *types.SystemContext
+	pc.newBaseImageSource = dirBaseImageLookup(map[string]string{baseRefString: createManifestListDir(t)}, &lookups, &usedSys)
+	image := dirImageMockWithRef(t, derivedDir, pcImageReferenceMock{transportName: "docker", ref: appRef})
+	allowed, err := pc.IsRunningImageAllowed(context.Background(), image)
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+	err = pc.Destroy()
+	require.NoError(t, err)
+
+	// A base image which requires itself as a base is rejected without looking it up repeatedly
+	pc, err = NewPolicyContext(&Policy{
+		Default: PolicyRequirements{NewPRReject()},
+		Transports: map[string]PolicyTransportScopes{
+			"docker": {
+				"example.com/app":            PolicyRequirements{pr},
+				"docker.io/testing/manifest": PolicyRequirements{pr},
+			},
+		},
+	})
+	require.NoError(t, err)
+	lookups = 0
+	pc.newBaseImageSource = dirBaseImageLookup(map[string]string{baseRefString: "fixtures/dir-img-valid"}, &lookups, &usedSys)
+	allowed, err = pc.IsRunningImageAllowed(context.Background(), image)
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+	assert.Equal(t, 1, lookups)
+	err = pc.Destroy()
+	require.NoError(t, err)
+}
+
+func TestPRSignedBaseLayerBaseImageReference(t *testing.T) {
+	for _, c := range []struct {
+		identity PolicyReferenceMatch
+		expected string
+	}{
+		{xNewPRMExactReference("example.com/base:1.0"), "example.com/base:1.0"},
+		{xNewPRMExactReference("busybox:1.0"), "docker.io/library/busybox:1.0"},
+		{xNewPRMExactRepository("example.com/base"), "example.com/base:latest"},
+	} {
+		pr, err := newPRSignedBaseLayer(c.identity)
+		require.NoError(t, err)
+		ref, err := pr.baseImageReference()
+		require.NoError(t, err)
+		assert.Equal(t, c.expected, ref.String())
+	}
+
+	// Identities which can't identify a base image
+	for _, identity := range []PolicyReferenceMatch{
+		NewPRMMatchExact(),
+		NewPRMMatchRepoDigestOrExact(),
+		NewPRMMatchRepository(),
+		xNewPRMRemapIdentity("example.com", "registry.access.redhat.com"),
+	} {
+		pr, err := newPRSignedBaseLayer(identity)
+		require.NoError(t, err)
+		_, err = pr.baseImageReference()
+		assert.Error(t, err)
+		assert.IsType(t, PolicyRequirementError(""), err)
+	}
+}
+
+func TestLayerDigestsFromManifest(t *testing.T) {
+	blob, err := os.ReadFile("fixtures/dir-img-valid/manifest.json")
+	require.NoError(t, err)
+	layers, err := layerDigestsFromManifest(blob, manifest.DockerV2Schema2MediaType)
+	require.NoError(t, err)
+	assert.Equal(t, []digest.Digest{
+		"sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f",
+		"sha256:3c3a4604a545cdc127456d94e421cd355bca5b528f4a9c1905b15da2eb4a4c6b",
+		"sha256:ec4b8955958665577945c89419d1af06b5f7636b4ac3da7f12184802ad867736",
+	}, layers)
+
+	_, err = layerDigestsFromManifest([]byte("this is invalid"), imgspecv1.MediaTypeImageManifest)
+	assert.Error(t, err)
+}
@@ -0,0 +1,127 @@
+// Detailed reports of policy evaluation, for explaining policy decisions to users.
+
+package signature
+
+import (
+	"context"
+	"time"
+
+	"github.com/containers/image/v5/internal/signature"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// PolicyEvaluationReport describes how the policy was evaluated for an image.
+// WARNING: Use IsRunningImageAllowed, or the Allowed field, to decide whether to run an image;
+// the other contents of the report are intended only to explain the decision to users.
+type PolicyEvaluationReport struct {
+	// Image is a description of the image identity, for human consumption only.
+	Image string
+	// Transport is the name of the image's transport, which was used to look up scopes in Policy.Transports.
+	Transport string
+	// Scope is the scope in Policy.Transports[Transport] which provided the requirements ("" for the transport default),
+	// unless UsesDefault is true.
+	Scope string
+	// UsesDefault is true if no scope matched the image, and the requirements were taken from Policy.Default.
+	UsesDefault bool
+	// Requirements are the results of the requirements which were evaluated, in order.
+	// Evaluation stops at the first requirement which rejects the image, so the later requirements are not included.
+	Requirements []PolicyRequirementReport
+	// Allowed is the final verdict, as returned by IsRunningImageAllowed.
+	Allowed bool
+	// Err is the reason the image was rejected, as returned by IsRunningImageAllowed; it is nil if Allowed.
+	Err error
+}
+
+// PolicyRequirementReport describes the evaluation of a single PolicyRequirement.
+type PolicyRequirementReport struct {
+	// Type is the "type" value of the requirement in policy.json, e.g. "signedBy".
+	Type string
+	// Allowed is true if the requirement allows running the image.
+	Allowed bool
+	// Err is the reason the requirement rejected the image; it is nil if Allowed.
+	Err error
+	// Signatures are the results for individual signatures of the image, for requirements which verify signatures.
+	// Evaluation stops at the first accepted signature, so the later signatures are not included.
+	Signatures []SignatureEvaluationReport
+	// BaseImage describes the evaluation of the policy for the base image of a "signedBaseLayer" requirement,
+	// if the base image could be found.
+	BaseImage *PolicyEvaluationReport
+}
+
+// SignatureEvaluationReport describes the evaluation of a single signature by a PolicyRequirement.
+// All values except for Accepted are untrusted unless Accepted is true.
+type SignatureEvaluationReport struct {
+	// Index is the index of the signature in the signatures of the image.
+	Index int
+	// Format is the format of the signature, e.g. "simple-signing" or "sigstore-json", if it could be determined.
+	Format string
+	// Skipped is true if the signature was not evaluated because the requirement does not handle signatures of this format.
+	Skipped bool
+	// KeyIdentity identifies the signing key (e.g. a GPG key fingerprint), if the cryptographic signature was valid,
+	// even if the key was not accepted.
+	KeyIdentity string
+	// SignedDockerManifestDigest is the manifest digest claimed by the signature, if verification proceeded to checking it.
+	SignedDockerManifestDigest digest.Digest
+	// SignedDockerReference is the image identity claimed by the signature, if verification proceeded to checking it.
+	SignedDockerReference string
+	// Timestamp is the creation time recorded in the signature, if verification proceeded to checking it and the signature records it.
+	Timestamp *time.Time
+	// Accepted is true if the signature was accepted by the requirement.
+	Accepted bool
+	// Err is the reason the signature was not accepted; it is nil if Accepted or Skipped.
+	Err error
+}
+
+// ExplainRunningImageAllowed evaluates the policy for image the same way as IsRunningImageAllowed, and returns
+// a report of the evaluation: the policy scope used, each requirement evaluated, the results for individual signatures,
+// and the final verdict in report.Allowed and report.Err.
+// The returned error is non-nil only if the evaluation could not be performed at all (e.g. if pc is not ready);
+// a rejection of the image is only recorded in report.Err.
+// WARNING: This validates signatures and the manifest, but does not download or validate the
+// layers. Users must validate that the layers match their expected digests.
+func (pc *PolicyContext) ExplainRunningImageAllowed(ctx context.Context, image types.UnparsedImage) (report *PolicyEvaluationReport, finalErr error) {
+	if err := pc.changeState(pcReady, pcInUse); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := pc.changeState(pcInUse, pcReady); err != nil {
+			report = nil
+			finalErr = err
+		}
+	}()
+
+	report = &PolicyEvaluationReport{}
+	report.Allowed, report.Err = pc.isRunningImageAllowed(ctx, image, nil, report)
+	return report, nil
+}
+
+// policyRequirementType returns the policy.json "type" value of req.
+func policyRequirementType(req PolicyRequirement) string {
+	if c, ok := req.(interface{ requirementType() prTypeIdentifier }); ok {
+		return string(c.requirementType())
+	}
+	return ""
+}
+
+// requirementType returns the policy.json "type" value of the requirement.
+func (c prCommon) requirementType() prTypeIdentifier {
+	return c.Type
+}
+
+// newSignatureEvaluationReport returns a SignatureEvaluationReport for a signature sig with index.
+func newSignatureEvaluationReport(index int, sig []byte) SignatureEvaluationReport {
+	res := SignatureEvaluationReport{Index: index}
+	if parsed, err := signature.FromBlob(sig); err == nil {
+		res.Format = string(parsed.FormatID())
+	}
+	return res
+}
+
+// addSignature records the result for a signature in report, if report is not nil.
+func (report *PolicyRequirementReport) addSignature(sig SignatureEvaluationReport) {
+	if report == nil {
+		return
+	}
+	report.Signatures = append(report.Signatures, sig)
+}
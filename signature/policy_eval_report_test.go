@@ -0,0 +1,180 @@
+package signature
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyContextExplainRunningImageAllowed(t *testing.T) {
+	pc, err := NewPolicyContext(&Policy{
+		Default: PolicyRequirements{NewPRReject()},
+		Transports: map[string]PolicyTransportScopes{
+			"docker": {
+				"docker.io/testing/manifest:latest": {
+					xNewPRSignedByKeyPath(SBKeyTypeGPGKeys, "fixtures/public-key.gpg", NewPRMMatchExact()),
+				},
+				"docker.io/testing/manifest": {
+					xNewPRSignedByKeyPath(SBKeyTypeGPGKeys, "fixtures/public-key.gpg", NewPRMMatchRepository()),
+					NewPRReject(),
+				},
+				"docker.io/testing/manifest:exact": {
+					xNewPRSignedByKeyPath(SBKeyTypeGPGKeys, "fixtures/public-key.gpg", NewPRMMatchExact()),
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	defer func() {
+		err := pc.Destroy()
+		require.NoError(t, err)
+	}()
+
+	// Success
+	img := pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
+	report, err := pc.ExplainRunningImageAllowed(context.Background(), img)
+	require.NoError(t, err)
+	assertRunningAllowed(t, report.Allowed, report.Err)
+	assert.Equal(t, "docker", report.Transport)
+	assert.Equal(t, "docker.io/testing/manifest:latest", report.Scope)
+	assert.False(t, report.UsesDefault)
+	require.Len(t, report.Requirements, 1)
+	req := report.Requirements[0]
+	assert.Equal(t, "signedBy", req.Type)
+	assert.True(t, req.Allowed)
+	assert.NoError(t, req.Err)
+	require.Len(t, req.Signatures, 1)
+	fixtureTimestamp := time.Unix(1464398954, 0)
+	assert.Equal(t, SignatureEvaluationReport{
+		Index:                      0,
+		Format:                     "simple-signing",
+		KeyIdentity:                TestKeyFingerprint,
+		SignedDockerManifestDigest: TestImageManifestDigest,
+		SignedDockerReference:      "testing/manifest:latest",
+		Timestamp:                  &fixtureTimestamp,
+		Accepted:                   true,
+	}, req.Signatures[0])
+
+	// The result is the same as IsRunningImageAllowed
+	allowed, err := pc.IsRunningImageAllowed(context.Background(), img)
+	assertRunningAllowed(t, allowed, err)
+
+	// 1 invalid, 1 valid signature (in this order)
+	img = pcImageMock(t, "fixtures/dir-img-mixed", "testing/manifest:latest")
+	report, err = pc.ExplainRunningImageAllowed(context.Background(), img)
+	require.NoError(t, err)
+	assertRunningAllowed(t, report.Allowed, report.Err)
+	require.Len(t, report.Requirements, 1)
+	sigs := report.Requirements[0].Signatures
+	require.Len(t, sigs, 2)
+	assert.False(t, sigs[0].Accepted)
+	assert.Error(t, sigs[0].Err)
+	assert.Equal(t, 1, sigs[1].Index)
+	assert.True(t, sigs[1].Accepted)
+
+	// Signature for a different manifest
+	img = pcImageMock(t, "fixtures/dir-img-modified-manifest", "testing/manifest:latest")
+	report, err = pc.ExplainRunningImageAllowed(context.Background(), img)
+	require.NoError(t, err)
+	assertRunningRejectedPolicyRequirement(t, report.Allowed, report.Err)
+	require.Len(t, report.Requirements, 1)
+	req = report.Requirements[0]
+	assert.False(t, req.Allowed)
+	assert.Equal(t, report.Err, req.Err)
+	require.Len(t, req.Signatures, 1)
+	assert.Equal(t, TestKeyFingerprint, req.Signatures[0].KeyIdentity)
+	assert.Equal(t, TestImageManifestDigest, req.Signatures[0].SignedDockerManifestDigest)
+	assert.Equal(t, "", req.Signatures[0].SignedDockerReference)
+	assert.False(t, req.Signatures[0].Accepted)
+	assert.IsType(t, PolicyRequirementError(""), req.Signatures[0].Err)
+
+	// Signature for a different identity
+	img = pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:exact")
+	report, err = pc.ExplainRunningImageAllowed(context.Background(), img)
+	require.NoError(t, err)
+	assertRunningRejectedPolicyRequirement(t, report.Allowed, report.Err)
+	assert.Equal(t, "docker.io/testing/manifest:exact", report.Scope)
+	require.Len(t, report.Requirements, 1)
+	require.Len(t, report.Requirements[0].Signatures, 1)
+	assert.Equal(t, "testing/manifest:latest", report.Requirements[0].Signatures[0].SignedDockerReference)
+	assert.IsType(t, PolicyRequirementError(""), report.Requirements[0].Signatures[0].Err)
+
+	// A namespace match; evaluation stops at the first rejecting requirement
+	img = pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:other")
+	report, err = pc.ExplainRunningImageAllowed(context.Background(), img)
+	require.NoError(t, err)
+	assertRunningRejectedPolicyRequirement(t, report.Allowed, report.Err)
+	assert.Equal(t, "docker.io/testing/manifest", report.Scope)
+	require.Len(t, report.Requirements, 2)
+	assert.Equal(t, "signedBy", report.Requirements[0].Type)
+	assert.True(t, report.Requirements[0].Allowed)
+	assert.Equal(t, "reject", report.Requirements[1].Type)
+	assert.False(t, report.Requirements[1].Allowed)
+	assert.Empty(t, report.Requirements[1].Signatures)
+	assert.Equal(t, report.Err, report.Requirements[1].Err)
+
+	// Default policy
+	img = pcImageMock(t, "fixtures/dir-img-valid", "testing/other:latest")
+	report, err = pc.ExplainRunningImageAllowed(context.Background(), img)
+	require.NoError(t, err)
+	assertRunningRejectedPolicyRequirement(t, report.Allowed, report.Err)
+	assert.True(t, report.UsesDefault)
+	require.Len(t, report.Requirements, 1)
+	assert.Equal(t, "reject", report.Requirements[0].Type)
+
+	// Unexpected state (context already destroyed)
+	destroyedPC, err := NewPolicyContext(pc.Policy)
+	require.NoError(t, err)
+	err = destroyedPC.Destroy()
+	require.NoError(t, err)
+	img = pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
+	report, err = destroyedPC.ExplainRunningImageAllowed(context.Background(), img)
+	assert.Error(t, err)
+	assert.Nil(t, report)
+}
+
+func TestPolicyContextExplainRunningImageAllowedBaseImage(t *testing.T) {
+	const baseRefString = "docker.io/testing/manifest:latest"
+	pr, err := NewPRSignedBaseLayer(xNewPRMExactReference(baseRefString))
+	require.NoError(t, err)
+	appRef, err := reference.ParseNormalizedNamed("example.com/app:latest")
+	require.NoError(t, err)
+	pc, err := NewPolicyContext(&Policy{
+		Default: PolicyRequirements{NewPRReject()},
+		Transports: map[string]PolicyTransportScopes{
+			"docker": {
+				"example.com/app":            PolicyRequirements{pr},
+				"docker.io/testing/manifest": PolicyRequirements{NewPRReject()},
+			},
+		},
+	})
+	require.NoError(t, err)
+	defer func() {
+		err := pc.Destroy()
+		require.NoError(t, err)
+	}()
+	lookups := 0
+	var usedSys *types.SystemContext
+	pc.newBaseImageSource = dirBaseImageLookup(map[string]string{baseRefString: "fixtures/dir-img-valid"}, &lookups, &usedSys)
+
+	image := dirImageMockWithRef(t, createDerivedImageDir(t), pcImageReferenceMock{transportName: "docker", ref: appRef})
+	report, err := pc.ExplainRunningImageAllowed(context.Background(), image)
+	require.NoError(t, err)
+	assertRunningRejectedPolicyRequirement(t, report.Allowed, report.Err)
+	assert.Equal(t, "example.com/app", report.Scope)
+	require.Len(t, report.Requirements, 1)
+	req := report.Requirements[0]
+	assert.Equal(t, "signedBaseLayer", req.Type)
+	assert.False(t, req.Allowed)
+	require.NotNil(t, req.BaseImage)
+	assert.Equal(t, "docker.io/testing/manifest", req.BaseImage.Scope)
+	assert.False(t, req.BaseImage.Allowed)
+	assert.Equal(t, req.Err, req.BaseImage.Err)
+	require.Len(t, req.BaseImage.Requirements, 1)
+	assert.Equal(t, "reject", req.BaseImage.Requirements[0].Type)
+}
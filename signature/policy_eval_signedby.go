@@ -4,10 +4,13 @@ package signature
 
 import (
 	"context"
+	"crypto/x509"
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/containers/image/v5/internal/signature"
 	"github.com/containers/image/v5/manifest"
 	"github.com/containers/image/v5/types"
 	digest "github.com/opencontainers/go-digest"
@@ -15,9 +18,15 @@ import (
 )
 
 func (pr *prSignedBy) isSignatureAuthorAccepted(ctx context.Context, image types.UnparsedImage, sig []byte) (signatureAcceptanceResult, *Signature, error) {
+	return pr.isSignatureAuthorAcceptedWithReport(ctx, image, sig, nil)
+}
+
+// isSignatureAuthorAcceptedWithReport is isSignatureAuthorAccepted, which also records the values checked
+// during verification in report, if it is not nil.
+func (pr *prSignedBy) isSignatureAuthorAcceptedWithReport(ctx context.Context, image types.UnparsedImage, sig []byte, report *SignatureEvaluationReport) (signatureAcceptanceResult, *Signature, error) {
 	switch pr.KeyType {
-	case SBKeyTypeGPGKeys:
-	case SBKeyTypeSignedByGPGKeys, SBKeyTypeX509Certificates, SBKeyTypeSignedByX509CAs:
+	case SBKeyTypeGPGKeys, SBKeyTypeSignedByX509CAs:
+	case SBKeyTypeSignedByGPGKeys, SBKeyTypeX509Certificates:
 		// FIXME? Reject this at policy parsing time already?
 		return sarRejected, nil, errors.Errorf(`"Unimplemented "keyType" value "%s"`, string(pr.KeyType))
 	default:
@@ -41,17 +50,38 @@ func (pr *prSignedBy) isSignatureAuthorAccepted(ctx context.Context, image types
 	}
 
 	// FIXME: move this to per-context initialization
-	mech, trustedIdentities, err := NewEphemeralGPGSigningMechanism(data)
-	if err != nil {
-		return sarRejected, nil, err
-	}
-	defer mech.Close()
-	if len(trustedIdentities) == 0 {
-		return sarRejected, nil, PolicyRequirementError("No public keys imported")
-	}
-
-	signature, err := verifyAndExtractSignature(mech, sig, signatureAcceptanceRules{
-		validateKeyIdentity: func(keyIdentity string) error {
+	var mech SigningMechanism
+	var validateKeyIdentity func(keyIdentity string) error
+	if pr.KeyType == SBKeyTypeSignedByX509CAs {
+		x509Mech, err := newX509SigningMechanism(data, nil, nil)
+		if err != nil {
+			return sarRejected, nil, err
+		}
+		if x509Mech.trustedCAs == nil {
+			return sarRejected, nil, PolicyRequirementError("No CA certificates imported")
+		}
+		if pr.X509Identity != nil {
+			extKeyUsages, err := pr.X509Identity.extKeyUsages()
+			if err != nil { // Coverage: This should never happen, newPRSignedBy has validated the values.
+				return sarRejected, nil, err
+			}
+			x509Mech.extKeyUsages = extKeyUsages
+			x509Mech.acceptCertificate = pr.X509Identity.validateCertificate
+		}
+		mech = x509Mech
+		// The mechanism has already verified the certificate chain, and validated the certificate identity.
+		validateKeyIdentity = func(string) error { return nil }
+	} else {
+		gpgMech, trustedIdentities, err := NewEphemeralGPGSigningMechanism(data)
+		if err != nil {
+			return sarRejected, nil, err
+		}
+		mech = gpgMech
+		if len(trustedIdentities) == 0 {
+			mech.Close()
+			return sarRejected, nil, PolicyRequirementError("No public keys imported")
+		}
+		validateKeyIdentity = func(keyIdentity string) error {
 			for _, trustedIdentity := range trustedIdentities {
 				if keyIdentity == trustedIdentity {
 					return nil
@@ -60,14 +90,42 @@ func (pr *prSignedBy) isSignatureAuthorAccepted(ctx context.Context, image types
 			// Coverage: We use a private GPG home directory and only import trusted keys, so this should
 			// not be reachable.
 			return PolicyRequirementError(fmt.Sprintf("Signature by key %s is not accepted", keyIdentity))
+		}
+	}
+	defer mech.Close()
+
+	parsedSig, err := signature.FromBlob(sig)
+	if err != nil {
+		return sarRejected, nil, err
+	}
+	simpleSig, ok := parsedSig.(signature.SimpleSigning)
+	if !ok {
+		return sarRejected, nil, PolicyRequirementError(fmt.Sprintf("Signature of format %s is not a simple signing signature", parsedSig.FormatID()))
+	}
+
+	verifiedSig, err := verifyAndExtractSignature(mech, simpleSig.UntrustedSignature(), signatureAcceptanceRules{
+		validateKeyIdentity: func(keyIdentity string) error {
+			if report != nil {
+				report.KeyIdentity = keyIdentity
+			}
+			if pr.isKeyRevoked(keyIdentity) {
+				return newRevokedKeyError(keyIdentity, "listed in revokedFingerprints")
+			}
+			return validateKeyIdentity(keyIdentity)
 		},
 		validateSignedDockerReference: func(ref string) error {
+			if report != nil {
+				report.SignedDockerReference = ref
+			}
 			if !pr.SignedIdentity.matchesDockerReference(image, ref) {
 				return PolicyRequirementError(fmt.Sprintf("Signature for identity %s is not accepted", ref))
 			}
 			return nil
 		},
 		validateSignedDockerManifestDigest: func(digest digest.Digest) error {
+			if report != nil {
+				report.SignedDockerManifestDigest = digest
+			}
 			m, _, err := image.Manifest(ctx)
 			if err != nil {
 				return err
@@ -81,26 +139,56 @@ func (pr *prSignedBy) isSignatureAuthorAccepted(ctx context.Context, image types
 			}
 			return nil
 		},
+		validateSignatureTimestamp: func(keyIdentity string, timestamp *time.Time) error {
+			if report != nil {
+				report.Timestamp = timestamp
+			}
+			if pr.SignatureTimestamp == nil {
+				return nil
+			}
+			return pr.SignatureTimestamp.validateTimestamp(keyIdentity, timestamp, time.Now())
+		},
 	})
 	if err != nil {
+		if revoked, ok := err.(RevokedKeyError); ok && report != nil && report.KeyIdentity == "" {
+			report.KeyIdentity = revoked.KeyIdentity
+		}
 		return sarRejected, nil, err
 	}
 
-	return sarAccepted, signature, nil
+	return sarAccepted, verifiedSig, nil
 }
 
 func (pr *prSignedBy) isRunningImageAllowed(ctx context.Context, image types.UnparsedImage) (bool, error) {
+	return pr.isRunningImageAllowedWithReport(ctx, image, nil)
+}
+
+// isRunningImageAllowedWithReport is isRunningImageAllowed, which also records the results for individual signatures
+// in report, if it is not nil.
+func (pr *prSignedBy) isRunningImageAllowedWithReport(ctx context.Context, image types.UnparsedImage, report *PolicyRequirementReport) (bool, error) {
 	// FIXME: pass context.Context
 	sigs, err := image.Signatures(ctx)
 	if err != nil {
 		return false, err
 	}
 	var rejections []error
-	for _, s := range sigs {
+	for sigNumber, s := range sigs {
+		sigReport := newSignatureEvaluationReport(sigNumber, s)
+		if parsed, err := signature.FromBlob(s); err == nil {
+			if _, ok := parsed.(signature.SimpleSigning); !ok {
+				// Signatures of other formats are not of interest for this requirement; don’t report them as rejections.
+				sigReport.Skipped = true
+				report.addSignature(sigReport)
+				continue
+			}
+		}
+
 		var reason error
-		switch res, _, err := pr.isSignatureAuthorAccepted(ctx, image, s); res {
+		switch res, _, err := pr.isSignatureAuthorAcceptedWithReport(ctx, image, s, &sigReport); res {
 		case sarAccepted:
 			// One accepted signature is enough.
+			sigReport.Accepted = true
+			report.addSignature(sigReport)
 			return true, nil
 		case sarRejected:
 			reason = err
@@ -110,6 +198,8 @@ func (pr *prSignedBy) isRunningImageAllowed(ctx context.Context, image types.Unp
 		default:
 			reason = errors.Errorf(`Internal error: Unexpected signature verification result "%s"`, string(res))
 		}
+		sigReport.Err = reason
+		report.addSignature(sigReport)
 		rejections = append(rejections, reason)
 	}
 	var summary error
@@ -128,3 +218,92 @@ func (pr *prSignedBy) isRunningImageAllowed(ctx context.Context, image types.Unp
 	}
 	return false, summary
 }
+
+// validateCertificate returns nil if cert matches all constraints of id, or a PolicyRequirementError.
+func (id *SBX509Identity) validateCertificate(cert *x509.Certificate) error {
+	if len(id.SubjectCommonNames) != 0 && !stringsIntersect(id.SubjectCommonNames, []string{cert.Subject.CommonName}) {
+		return PolicyRequirementError(fmt.Sprintf("Signing certificate subject common name %q is not accepted", cert.Subject.CommonName))
+	}
+	if len(id.SubjectOrganizations) != 0 && !stringsIntersect(id.SubjectOrganizations, cert.Subject.Organization) {
+		return PolicyRequirementError(fmt.Sprintf("Signing certificate subject organizations %q are not accepted", cert.Subject.Organization))
+	}
+	if len(id.SANDNSNames) != 0 && !stringsIntersect(id.SANDNSNames, cert.DNSNames) {
+		return PolicyRequirementError(fmt.Sprintf("Signing certificate DNS names %q are not accepted", cert.DNSNames))
+	}
+	if len(id.SANEmailAddresses) != 0 && !stringsIntersect(id.SANEmailAddresses, cert.EmailAddresses) {
+		return PolicyRequirementError(fmt.Sprintf("Signing certificate e-mail addresses %q are not accepted", cert.EmailAddresses))
+	}
+	if len(id.SANURIs) != 0 {
+		uris := make([]string, 0, len(cert.URIs))
+		for _, u := range cert.URIs {
+			uris = append(uris, u.String())
+		}
+		if !stringsIntersect(id.SANURIs, uris) {
+			return PolicyRequirementError(fmt.Sprintf("Signing certificate URIs %q are not accepted", uris))
+		}
+	}
+	return nil
+}
+
+// isKeyRevoked returns true if keyIdentity is listed in pr.RevokedFingerprints.
+func (pr *prSignedBy) isKeyRevoked(keyIdentity string) bool {
+	normalized := normalizeKeyFingerprint(keyIdentity)
+	for _, fingerprint := range pr.RevokedFingerprints {
+		if normalizeKeyFingerprint(fingerprint) == normalized {
+			return true
+		}
+	}
+	return false
+}
+
+// validateTimestamp returns nil if a signature by keyIdentity, recording timestamp (nil if the signature
+// does not record a creation time), verified at now, matches all constraints of c; or a PolicyRequirementError,
+// or a RevokedKeyError if the signature is rejected because of c.KeyNotAfter.
+func (c *SBTimestampConstraints) validateTimestamp(keyIdentity string, timestamp *time.Time, now time.Time) error {
+	var keyNotAfter *time.Time
+	for key, t := range c.KeyNotAfter {
+		if normalizeKeyFingerprint(key) == normalizeKeyFingerprint(keyIdentity) {
+			t := t
+			keyNotAfter = &t
+			break
+		}
+	}
+	if timestamp == nil {
+		if c.MaxAge != 0 || c.NotBefore != nil || c.NotAfter != nil {
+			return PolicyRequirementError("Signature does not record a creation time")
+		}
+		if keyNotAfter != nil {
+			return newRevokedKeyError(keyIdentity, fmt.Sprintf("listed in keyNotAfter with %s, and the signature does not record a creation time",
+				keyNotAfter.UTC().Format(time.RFC3339)))
+		}
+		return nil
+	}
+
+	created := timestamp.UTC().Format(time.RFC3339)
+	if c.MaxAge != 0 && now.Sub(*timestamp) > c.MaxAge {
+		return PolicyRequirementError(fmt.Sprintf("Signature created at %s is older than the maximum age %s", created, c.MaxAge))
+	}
+	if c.NotBefore != nil && timestamp.Before(*c.NotBefore) {
+		return PolicyRequirementError(fmt.Sprintf("Signature created at %s is before %s", created, c.NotBefore.UTC().Format(time.RFC3339)))
+	}
+	if c.NotAfter != nil && timestamp.After(*c.NotAfter) {
+		return PolicyRequirementError(fmt.Sprintf("Signature created at %s is after %s", created, c.NotAfter.UTC().Format(time.RFC3339)))
+	}
+	if keyNotAfter != nil && !timestamp.Before(*keyNotAfter) {
+		return newRevokedKeyError(keyIdentity, fmt.Sprintf("listed in keyNotAfter with %s, and the signature was created at %s",
+			keyNotAfter.UTC().Format(time.RFC3339), created))
+	}
+	return nil
+}
+
+// stringsIntersect returns true if a and b have at least one value in common.
+func stringsIntersect(a, b []string) bool {
+	for _, v1 := range a {
+		for _, v2 := range b {
+			if v1 == v2 {
+				return true
+			}
+		}
+	}
+	return false
+}
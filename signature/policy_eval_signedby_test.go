@@ -2,9 +2,14 @@ package signature
 
 import (
 	"context"
+	"crypto"
+	"crypto/x509"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/containers/image/v5/directory"
 	"github.com/containers/image/v5/docker/reference"
@@ -76,7 +81,6 @@ func TestPRSignedByIsSignatureAuthorAccepted(t *testing.T) {
 	// Unimplemented and invalid KeyType values
 	for _, keyType := range []sbKeyType{SBKeyTypeSignedByGPGKeys,
 		SBKeyTypeX509Certificates,
-		SBKeyTypeSignedByX509CAs,
 		sbKeyType("This is invalid"),
 	} {
 		// Do not use NewPRSignedByKeyData, because it would reject invalid values.
@@ -181,6 +185,186 @@ func TestPRSignedByIsSignatureAuthorAccepted(t *testing.T) {
 	assertSARRejectedPolicyRequirement(t, sar, parsedSig, err)
 }
 
+func TestPRSignedByIsSignatureAuthorAcceptedWithTimestamps(t *testing.T) {
+	prm := NewPRMMatchExact()
+	testImage := dirImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
+	testImageSig, err := os.ReadFile("fixtures/dir-img-valid/signature-1")
+	require.NoError(t, err)
+	// The signature was created at 2016-05-28T01:29:14Z.
+	before := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	after := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	expectedSig := Signature{
+		DockerManifestDigest: TestImageManifestDigest,
+		DockerReference:      "testing/manifest:latest",
+	}
+
+	// Accepted signatures
+	for _, c := range []SBTimestampConstraints{
+		{MaxAge: time.Since(before)},
+		{NotBefore: &before},
+		{NotAfter: &after},
+		{NotBefore: &before, NotAfter: &after},
+		{KeyNotAfter: map[string]time.Time{TestKeyFingerprint: after}},
+		{KeyNotAfter: map[string]time.Time{"0123456789ABCDEF0123456789ABCDEF01234567": before}},
+	} {
+		c := c
+		pr, err := NewPRSignedByWithOptions(SBKeyTypeGPGKeys, SBOptions{KeyPath: "fixtures/public-key.gpg", SignatureTimestamp: &c}, prm)
+		require.NoError(t, err)
+		sar, parsedSig, err := pr.isSignatureAuthorAccepted(context.Background(), testImage, testImageSig)
+		assertSARAccepted(t, sar, parsedSig, err, expectedSig)
+	}
+
+	// Rejected signatures
+	for _, c := range []SBTimestampConstraints{
+		{MaxAge: time.Hour},
+		{NotBefore: &after},
+		{NotAfter: &before},
+	} {
+		c := c
+		pr, err := NewPRSignedByWithOptions(SBKeyTypeGPGKeys, SBOptions{KeyPath: "fixtures/public-key.gpg", SignatureTimestamp: &c}, prm)
+		require.NoError(t, err)
+		sar, parsedSig, err := pr.isSignatureAuthorAccepted(context.Background(), testImage, testImageSig)
+		assertSARRejectedPolicyRequirement(t, sar, parsedSig, err)
+	}
+	// Signatures rejected by keyNotAfter are reported as made by a revoked key
+	for _, c := range []SBTimestampConstraints{
+		{KeyNotAfter: map[string]time.Time{TestKeyFingerprint: before}},
+		{KeyNotAfter: map[string]time.Time{strings.ToLower(TestKeyFingerprint): before}},
+	} {
+		c := c
+		pr, err := NewPRSignedByWithOptions(SBKeyTypeGPGKeys, SBOptions{KeyPath: "fixtures/public-key.gpg", SignatureTimestamp: &c}, prm)
+		require.NoError(t, err)
+		sar, parsedSig, err := pr.isSignatureAuthorAccepted(context.Background(), testImage, testImageSig)
+		assertSARRejected(t, sar, parsedSig, err)
+		require.IsType(t, RevokedKeyError{}, err)
+		assert.Equal(t, TestKeyFingerprint, err.(RevokedKeyError).KeyIdentity)
+	}
+
+	// The same constraints apply to X.509 signatures
+	ca, caKey := x509TestCA(t, "root", nil, nil)
+	leaf, leafKey := x509TestLeaf(t, ca, caKey)
+	x509Sig := x509TestSignedImageSignature(t, "testing/manifest:latest", leaf, leafKey)
+	pr, err := newPRSignedBy(SBKeyTypeSignedByX509CAs, SBOptions{KeyData: x509TestCertificatesPEM(ca), SignatureTimestamp: &SBTimestampConstraints{
+		MaxAge:      time.Hour,
+		KeyNotAfter: map[string]time.Time{x509KeyIdentity(leaf): time.Now().Add(time.Hour)},
+	}}, prm)
+	require.NoError(t, err)
+	sar, parsedSig, err := pr.isSignatureAuthorAccepted(context.Background(), testImage, x509Sig)
+	assertSARAccepted(t, sar, parsedSig, err, expectedSig)
+	pr.SignatureTimestamp.KeyNotAfter[x509KeyIdentity(leaf)] = before
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, x509Sig)
+	assertSARRejected(t, sar, parsedSig, err)
+	assert.IsType(t, RevokedKeyError{}, err)
+
+	// The timestamp is recorded in the report
+	var report SignatureEvaluationReport
+	sar, parsedSig, err = pr.isSignatureAuthorAcceptedWithReport(context.Background(), testImage, x509Sig, &report)
+	assertSARRejected(t, sar, parsedSig, err)
+	assert.IsType(t, RevokedKeyError{}, err)
+	require.NotNil(t, report.Timestamp)
+	assert.WithinDuration(t, time.Now(), *report.Timestamp, time.Hour)
+}
+
+func TestPRSignedByIsSignatureAuthorAcceptedWithRevocations(t *testing.T) {
+	prm := NewPRMMatchExact()
+	testImage := dirImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
+	testImageSig, err := os.ReadFile("fixtures/dir-img-valid/signature-1")
+	require.NoError(t, err)
+
+	// A key not listed in revokedFingerprints
+	pr, err := NewPRSignedByWithOptions(SBKeyTypeGPGKeys, SBOptions{KeyPath: "fixtures/public-key.gpg", RevokedFingerprints: []string{TestRevokedKeyFingerprint}}, prm)
+	require.NoError(t, err)
+	sar, parsedSig, err := pr.isSignatureAuthorAccepted(context.Background(), testImage, testImageSig)
+	assertSARAccepted(t, sar, parsedSig, err, Signature{
+		DockerManifestDigest: TestImageManifestDigest,
+		DockerReference:      "testing/manifest:latest",
+	})
+
+	// A key listed in revokedFingerprints, with various formatting
+	spaced := ""
+	for i := 0; i < len(TestKeyFingerprint); i += 4 {
+		spaced += TestKeyFingerprint[i:i+4] + " "
+	}
+	for _, fingerprint := range []string{TestKeyFingerprint, strings.ToLower(TestKeyFingerprint), spaced} {
+		pr, err := newPRSignedBy(SBKeyTypeGPGKeys, SBOptions{KeyPath: "fixtures/public-key.gpg", RevokedFingerprints: []string{fingerprint}}, prm)
+		require.NoError(t, err)
+		var report SignatureEvaluationReport
+		sar, parsedSig, err := pr.isSignatureAuthorAcceptedWithReport(context.Background(), testImage, testImageSig, &report)
+		assertSARRejected(t, sar, parsedSig, err)
+		require.IsType(t, RevokedKeyError{}, err, fingerprint)
+		assert.Equal(t, TestKeyFingerprint, err.(RevokedKeyError).KeyIdentity)
+		assert.Equal(t, TestKeyFingerprint, report.KeyIdentity)
+	}
+
+	// A key revoked by its owner
+	revokedKeySig, err := os.ReadFile("fixtures/revoked-key.signature")
+	require.NoError(t, err)
+	pr, err = NewPRSignedByKeyPath(SBKeyTypeGPGKeys, "fixtures/revoked-key.gpg", prm)
+	require.NoError(t, err)
+	var report SignatureEvaluationReport
+	sar, parsedSig, err = pr.(*prSignedBy).isSignatureAuthorAcceptedWithReport(context.Background(), testImage, revokedKeySig, &report)
+	assertSARRejected(t, sar, parsedSig, err)
+	assert.IsType(t, RevokedKeyError{}, err)
+	assert.Equal(t, TestRevokedKeyFingerprint, report.KeyIdentity)
+
+	// An X.509 signing certificate listed in revokedFingerprints
+	ca, caKey := x509TestCA(t, "root", nil, nil)
+	leaf, leafKey := x509TestLeaf(t, ca, caKey)
+	x509Sig := x509TestSignedImageSignature(t, "testing/manifest:latest", leaf, leafKey)
+	pr, err = newPRSignedBy(SBKeyTypeSignedByX509CAs, SBOptions{KeyData: x509TestCertificatesPEM(ca), RevokedFingerprints: []string{x509KeyIdentity(leaf)}}, prm)
+	require.NoError(t, err)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, x509Sig)
+	assertSARRejected(t, sar, parsedSig, err)
+	assert.IsType(t, RevokedKeyError{}, err)
+}
+
+func TestSBTimestampConstraintsValidateTimestamp(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	notBefore := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	keyNotAfter := time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)
+	ts := func(t time.Time) *time.Time { return &t }
+
+	for _, c := range []struct {
+		constraints SBTimestampConstraints
+		keyIdentity string
+		timestamp   *time.Time
+		accepted    bool
+	}{
+		// maxAge
+		{SBTimestampConstraints{MaxAge: time.Hour}, "A", ts(now.Add(-30 * time.Minute)), true},
+		{SBTimestampConstraints{MaxAge: time.Hour}, "A", ts(now.Add(-time.Hour)), true},
+		{SBTimestampConstraints{MaxAge: time.Hour}, "A", ts(now.Add(-time.Hour - time.Second)), false},
+		{SBTimestampConstraints{MaxAge: time.Hour}, "A", nil, false},
+		// notBefore / notAfter
+		{SBTimestampConstraints{NotBefore: &notBefore, NotAfter: &notAfter}, "A", ts(notBefore), true},
+		{SBTimestampConstraints{NotBefore: &notBefore, NotAfter: &notAfter}, "A", ts(notAfter), true},
+		{SBTimestampConstraints{NotBefore: &notBefore, NotAfter: &notAfter}, "A", ts(notBefore.Add(-time.Second)), false},
+		{SBTimestampConstraints{NotBefore: &notBefore, NotAfter: &notAfter}, "A", ts(notAfter.Add(time.Second)), false},
+		{SBTimestampConstraints{NotBefore: &notBefore}, "A", nil, false},
+		{SBTimestampConstraints{NotAfter: &notAfter}, "A", nil, false},
+		// keyNotAfter
+		{SBTimestampConstraints{KeyNotAfter: map[string]time.Time{"ABCD": keyNotAfter}}, "ABCD", ts(keyNotAfter.Add(-time.Second)), true},
+		{SBTimestampConstraints{KeyNotAfter: map[string]time.Time{"ABCD": keyNotAfter}}, "ABCD", ts(keyNotAfter), false},
+		{SBTimestampConstraints{KeyNotAfter: map[string]time.Time{"abcd": keyNotAfter}}, "ABCD", ts(keyNotAfter), false},
+		{SBTimestampConstraints{KeyNotAfter: map[string]time.Time{"ABCD": keyNotAfter}}, "ABCD", nil, false},
+		{SBTimestampConstraints{KeyNotAfter: map[string]time.Time{"ABCD": keyNotAfter}}, "EF01", ts(keyNotAfter), true},
+		{SBTimestampConstraints{KeyNotAfter: map[string]time.Time{"ABCD": keyNotAfter}}, "EF01", nil, true},
+	} {
+		err := c.constraints.validateTimestamp(c.keyIdentity, c.timestamp, now)
+		if c.accepted {
+			assert.NoError(t, err, "%#v", c)
+		} else {
+			assert.Error(t, err, "%#v", c)
+			if len(c.constraints.KeyNotAfter) != 0 {
+				assert.IsType(t, RevokedKeyError{}, err)
+			} else {
+				assert.IsType(t, PolicyRequirementError(""), err)
+			}
+		}
+	}
+}
+
 // createInvalidSigDir creates a directory suitable for dirImageMock, in which image.Signatures()
 // fails.
 func createInvalidSigDir(t *testing.T) string {
@@ -249,4 +433,177 @@ func TestPRSignedByIsRunningImageAllowed(t *testing.T) {
 	require.NoError(t, err)
 	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
 	assertRunningRejectedPolicyRequirement(t, allowed, err)
+
+	// Sigstore signatures are ignored
+	sigstoreKey, _ := sigstoreTestECDSAKey(t)
+	sigstoreSig := sigstoreTestSignatureBlob(t, sigstoreKey, TestImageManifestDigest, "testing/manifest:latest")
+	simpleSig, err := os.ReadFile("fixtures/dir-img-valid/signature-1")
+	require.NoError(t, err)
+	image = dirImageMock(t, createSigstoreSignedDir(t, sigstoreSig, simpleSig), "testing/manifest:latest")
+	pr, err = NewPRSignedByKeyPath(ktGPG, "fixtures/public-key.gpg", prm)
+	require.NoError(t, err)
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningAllowed(t, allowed, err)
+	image = dirImageMock(t, createSigstoreSignedDir(t, sigstoreSig), "testing/manifest:latest")
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+}
+
+// x509TestSignedImageSignature returns a signature of the fixtures/dir-img-valid manifest for dockerReference,
+// created using leaf/leafKey.
+func x509TestSignedImageSignature(t *testing.T, dockerReference string, leaf *x509.Certificate, leafKey crypto.PrivateKey) []byte {
+	manifest, err := os.ReadFile("fixtures/dir-img-valid/manifest.json")
+	require.NoError(t, err)
+	mech, err := NewX509SigningMechanism(nil, x509TestCertificatesPEM(leaf), x509TestPrivateKeyPEM(t, leafKey))
+	require.NoError(t, err)
+	defer mech.Close()
+	sig, err := SignDockerManifest(manifest, dockerReference, mech, x509KeyIdentity(leaf))
+	require.NoError(t, err)
+	return sig
+}
+
+func TestPRSignedByX509CAsIsSignatureAuthorAccepted(t *testing.T) {
+	prm := NewPRMMatchExact()
+	ca, caKey := x509TestCA(t, "root", nil, nil)
+	otherCA, _ := x509TestCA(t, "other root", nil, nil)
+	leaf, leafKey := x509TestLeaf(t, ca, caKey)
+	caPEM := x509TestCertificatesPEM(ca)
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(caPath, caPEM, 0644)
+	require.NoError(t, err)
+
+	testImage := dirImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
+	testImageSig := x509TestSignedImageSignature(t, "testing/manifest:latest", leaf, leafKey)
+	expectedSig := Signature{
+		DockerManifestDigest: TestImageManifestDigest,
+		DockerReference:      "testing/manifest:latest",
+	}
+
+	// Successful validation, with KeyData and KeyPath
+	pr, err := NewPRSignedByX509CAsKeyPath(caPath, nil, prm)
+	require.NoError(t, err)
+	sar, parsedSig, err := pr.isSignatureAuthorAccepted(context.Background(), testImage, testImageSig)
+	assertSARAccepted(t, sar, parsedSig, err, expectedSig)
+	pr, err = NewPRSignedByX509CAsKeyData(caPEM, nil, prm)
+	require.NoError(t, err)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, testImageSig)
+	assertSARAccepted(t, sar, parsedSig, err, expectedSig)
+
+	// Successful validation with all x509Identity constraints
+	pr, err = NewPRSignedByX509CAsKeyData(caPEM, &SBX509Identity{
+		SubjectCommonNames:   []string{"someone else", "signer"},
+		SubjectOrganizations: []string{"Other Org"},
+		SANDNSNames:          []string{"signer.example.com"},
+		SANEmailAddresses:    []string{"signer@example.com"},
+		SANURIs:              []string{"https://signer.example.com/ci"},
+	}, prm)
+	require.NoError(t, err)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, testImageSig)
+	assertSARAccepted(t, sar, parsedSig, err, expectedSig)
+
+	// Non-matching x509Identity constraints
+	for _, id := range []SBX509Identity{
+		{SubjectCommonNames: []string{"someone else"}},
+		{SubjectOrganizations: []string{"Another Org"}},
+		{SANDNSNames: []string{"other.example.com"}},
+		{SANEmailAddresses: []string{"other@example.com"}},
+		{SANURIs: []string{"https://signer.example.com/other"}},
+		{SubjectCommonNames: []string{"signer"}, SANEmailAddresses: []string{"other@example.com"}},
+	} {
+		id := id
+		pr, err := NewPRSignedByX509CAsKeyData(caPEM, &id, prm)
+		require.NoError(t, err)
+		sar, parsedSig, err := pr.isSignatureAuthorAccepted(context.Background(), testImage, testImageSig)
+		assertSARRejectedPolicyRequirement(t, sar, parsedSig, err)
+	}
+
+	// A TLS server certificate is only accepted if explicitly allowed
+	serverLeaf, serverLeafKey := x509TestLeafWithExtKeyUsages(t, ca, caKey, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth})
+	serverSig := x509TestSignedImageSignature(t, "testing/manifest:latest", serverLeaf, serverLeafKey)
+	pr, err = NewPRSignedByX509CAsKeyData(caPEM, nil, prm)
+	require.NoError(t, err)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, serverSig)
+	assertSARRejected(t, sar, parsedSig, err)
+	for _, usages := range [][]string{{"serverAuth"}, {"any"}} {
+		pr, err = NewPRSignedByX509CAsKeyData(caPEM, &SBX509Identity{ExtendedKeyUsages: usages}, prm)
+		require.NoError(t, err)
+		sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, serverSig)
+		assertSARAccepted(t, sar, parsedSig, err, expectedSig)
+	}
+	pr, err = NewPRSignedByX509CAsKeyData(caPEM, &SBX509Identity{ExtendedKeyUsages: []string{"clientAuth"}}, prm)
+	require.NoError(t, err)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, testImageSig)
+	assertSARRejected(t, sar, parsedSig, err)
+
+	// A signature by a different CA
+	pr, err = NewPRSignedByX509CAsKeyData(x509TestCertificatesPEM(otherCA), nil, prm)
+	require.NoError(t, err)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, testImageSig)
+	assertSARRejected(t, sar, parsedSig, err)
+
+	// Invalid KeyPath
+	pr, err = NewPRSignedByX509CAsKeyPath("/this/does/not/exist", nil, prm)
+	require.NoError(t, err)
+	// Pass nil pointers to, kind of, test that the return value does not depend on the parameters.
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), nil, nil)
+	assertSARRejected(t, sar, parsedSig, err)
+
+	// Invalid CA data
+	pr, err = NewPRSignedByX509CAsKeyData([]byte("this is not PEM"), nil, prm)
+	require.NoError(t, err)
+	// Pass nil pointers to, kind of, test that the return value does not depend on the parameters.
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), nil, nil)
+	assertSARRejected(t, sar, parsedSig, err)
+
+	// KeyData has no CA certificates.
+	pr, err = NewPRSignedByX509CAsKeyData([]byte{}, nil, prm)
+	require.NoError(t, err)
+	// Pass nil pointers to, kind of, test that the return value does not depend on the parameters.
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), nil, nil)
+	assertSARRejectedPolicyRequirement(t, sar, parsedSig, err)
+
+	// A signature which does not verify
+	pr, err = NewPRSignedByX509CAsKeyData(caPEM, nil, prm)
+	require.NoError(t, err)
+	// Pass a nil pointer to, kind of, test that the return value does not depend on the image.
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), nil, []byte("invalid signature"))
+	assertSARRejected(t, sar, parsedSig, err)
+
+	// Valid signature with non-matching reference
+	sig := x509TestSignedImageSignature(t, "testing/manifest:notlatest", leaf, leafKey)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, sig)
+	assertSARRejectedPolicyRequirement(t, sar, parsedSig, err)
+
+	// A GPG signature
+	gpgSig, err := os.ReadFile("fixtures/dir-img-valid/signature-1")
+	require.NoError(t, err)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, gpgSig)
+	assertSARRejected(t, sar, parsedSig, err)
+}
+
+func TestPRSignedByX509CAsIsRunningImageAllowed(t *testing.T) {
+	prm := NewPRMMatchExact()
+	ca, caKey := x509TestCA(t, "root", nil, nil)
+	leaf, leafKey := x509TestLeaf(t, ca, caKey)
+	pr, err := NewPRSignedByX509CAsKeyData(x509TestCertificatesPEM(ca), nil, prm)
+	require.NoError(t, err)
+
+	// A single valid signature
+	dir := createSigstoreSignedDir(t, x509TestSignedImageSignature(t, "testing/manifest:latest", leaf, leafKey))
+	image := dirImageMock(t, dir, "testing/manifest:latest")
+	allowed, err := pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningAllowed(t, allowed, err)
+
+	// An invalid signature followed by a valid one
+	dir = createSigstoreSignedDir(t,
+		x509TestSignedImageSignature(t, "testing/manifest:notlatest", leaf, leafKey),
+		x509TestSignedImageSignature(t, "testing/manifest:latest", leaf, leafKey))
+	image = dirImageMock(t, dir, "testing/manifest:latest")
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningAllowed(t, allowed, err)
+
+	// Only a GPG signature, which can't be verified by the CA
+	image = dirImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningRejected(t, allowed, err)
 }
@@ -0,0 +1,160 @@
+// Policy evaluation for prSigstoreSigned.
+
+package signature
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/containers/image/v5/internal/signature"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+func (pr *prSigstoreSigned) isSignatureAuthorAccepted(ctx context.Context, image types.UnparsedImage, sig []byte) (signatureAcceptanceResult, *Signature, error) {
+	return pr.isSignatureAuthorAcceptedWithReport(ctx, image, sig, nil)
+}
+
+// isSignatureAuthorAcceptedWithReport is isSignatureAuthorAccepted, which also records the values checked
+// during verification in report, if it is not nil.
+func (pr *prSigstoreSigned) isSignatureAuthorAcceptedWithReport(ctx context.Context, image types.UnparsedImage, sig []byte, report *SignatureEvaluationReport) (signatureAcceptanceResult, *Signature, error) {
+	parsedSig, err := signature.FromBlob(sig)
+	if err != nil {
+		return sarRejected, nil, err
+	}
+	sigstoreSig, ok := parsedSig.(signature.Sigstore)
+	if !ok {
+		return sarRejected, nil, PolicyRequirementError(fmt.Sprintf("Signature of format %s is not a sigstore signature", parsedSig.FormatID()))
+	}
+
+	if pr.KeyPath != "" && pr.KeyData != nil {
+		return sarRejected, nil, errors.New(`Internal inconsistency: both "keyPath" and "keyData" specified`)
+	}
+	// FIXME: move this to per-context initialization
+	var publicKeyPEM []byte
+	if pr.KeyData != nil {
+		publicKeyPEM = pr.KeyData
+	} else {
+		d, err := os.ReadFile(pr.KeyPath)
+		if err != nil {
+			return sarRejected, nil, err
+		}
+		publicKeyPEM = d
+	}
+	publicKey, err := sigstorePublicKeyFromPEM(publicKeyPEM)
+	if err != nil {
+		return sarRejected, nil, err
+	}
+
+	if sigstoreSig.UntrustedMIMEType() != signature.SigstoreSignatureMIMEType {
+		return sarRejected, nil, PolicyRequirementError(fmt.Sprintf("Unsupported sigstore payload MIME type %q", sigstoreSig.UntrustedMIMEType()))
+	}
+	unverifiedBase64Signature, ok := sigstoreSig.UntrustedAnnotations()[signature.SigstoreSignatureAnnotationKey]
+	if !ok {
+		return sarRejected, nil, PolicyRequirementError(fmt.Sprintf("sigstore signature annotation %s not found", signature.SigstoreSignatureAnnotationKey))
+	}
+
+	verifiedSig, err := verifySigstorePayload(publicKey, sigstoreSig.UntrustedPayload(), unverifiedBase64Signature, sigstoreAcceptanceRules{
+		validateSignedDockerReference: func(ref string) error {
+			if report != nil {
+				report.SignedDockerReference = ref
+			}
+			if !pr.SignedIdentity.matchesDockerReference(image, ref) {
+				return PolicyRequirementError(fmt.Sprintf("Signature for identity %s is not accepted", ref))
+			}
+			return nil
+		},
+		validateSignedDockerManifestDigest: func(digest digest.Digest) error {
+			if report != nil {
+				report.SignedDockerManifestDigest = digest
+			}
+			m, _, err := image.Manifest(ctx)
+			if err != nil {
+				return err
+			}
+			digestMatches, err := manifest.MatchesDigest(m, digest)
+			if err != nil {
+				return err
+			}
+			if !digestMatches {
+				return PolicyRequirementError(fmt.Sprintf("Signature for digest %s does not match", digest))
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return sarRejected, nil, err
+	}
+
+	return sarAccepted, verifiedSig, nil
+}
+
+func (pr *prSigstoreSigned) isRunningImageAllowed(ctx context.Context, image types.UnparsedImage) (bool, error) {
+	return pr.isRunningImageAllowedWithReport(ctx, image, nil)
+}
+
+// isRunningImageAllowedWithReport is isRunningImageAllowed, which also records the results for individual signatures
+// in report, if it is not nil.
+func (pr *prSigstoreSigned) isRunningImageAllowedWithReport(ctx context.Context, image types.UnparsedImage, report *PolicyRequirementReport) (bool, error) {
+	sigs, err := image.Signatures(ctx)
+	if err != nil {
+		return false, err
+	}
+	var rejections []error
+	foundNonSigstoreSignatures := 0
+	for sigNumber, s := range sigs {
+		sigReport := newSignatureEvaluationReport(sigNumber, s)
+		if parsed, err := signature.FromBlob(s); err == nil {
+			if _, ok := parsed.(signature.Sigstore); !ok {
+				// Signatures of other formats are common, and not of interest for this requirement; don’t report them as rejections.
+				foundNonSigstoreSignatures++
+				sigReport.Skipped = true
+				report.addSignature(sigReport)
+				continue
+			}
+		}
+
+		var reason error
+		switch res, _, err := pr.isSignatureAuthorAcceptedWithReport(ctx, image, s, &sigReport); res {
+		case sarAccepted:
+			// One accepted signature is enough.
+			sigReport.Accepted = true
+			report.addSignature(sigReport)
+			return true, nil
+		case sarRejected:
+			reason = err
+		case sarUnknown:
+			// Huh?! This should not happen at all; treat it as any other invalid value.
+			fallthrough
+		default:
+			reason = errors.Errorf(`Internal error: Unexpected signature verification result "%s"`, string(res))
+		}
+		sigReport.Err = reason
+		report.addSignature(sigReport)
+		rejections = append(rejections, reason)
+	}
+	var summary error
+	switch len(rejections) {
+	case 0:
+		if foundNonSigstoreSignatures == 0 {
+			summary = PolicyRequirementError("A signature was required, but no signature exists")
+		} else {
+			summary = PolicyRequirementError(fmt.Sprintf("A sigstore signature was required, but no signature exists (%d non-sigstore signatures ignored)",
+				foundNonSigstoreSignatures))
+		}
+	case 1:
+		summary = rejections[0]
+	default:
+		var msgs []string
+		for _, e := range rejections {
+			msgs = append(msgs, e.Error())
+		}
+		summary = PolicyRequirementError(fmt.Sprintf("None of the signatures were accepted, reasons: %s",
+			strings.Join(msgs, "; ")))
+	}
+	return false, summary
+}
@@ -0,0 +1,204 @@
+package signature
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/internal/signature"
+	"github.com/stretchr/testify/require"
+)
+
+// createSigstoreSignedDir creates a directory suitable for dirImageMock, containing the manifest of
+// fixtures/dir-img-valid and sigs as its signatures.
+func createSigstoreSignedDir(t *testing.T, sigs ...[]byte) string {
+	dir := t.TempDir()
+	manifest, err := os.ReadFile("fixtures/dir-img-valid/manifest.json")
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, "manifest.json"), manifest, 0644)
+	require.NoError(t, err)
+	for i, sig := range sigs {
+		err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("signature-%d", i+1)), sig, 0644)
+		require.NoError(t, err)
+	}
+	return dir
+}
+
+func TestPRSigstoreSignedIsSignatureAuthorAccepted(t *testing.T) {
+	prm := NewPRMMatchExact()
+	privateKey, publicKeyPEM := sigstoreTestECDSAKey(t)
+	_, otherPublicKeyPEM := sigstoreTestECDSAKey(t)
+	keyPath := filepath.Join(t.TempDir(), "cosign.pub")
+	err := os.WriteFile(keyPath, publicKeyPEM, 0644)
+	require.NoError(t, err)
+
+	testImage := dirImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
+	testImageSig := sigstoreTestSignatureBlob(t, privateKey, TestImageManifestDigest, "testing/manifest:latest")
+	expectedSig := Signature{
+		DockerManifestDigest: TestImageManifestDigest,
+		DockerReference:      "testing/manifest:latest",
+	}
+
+	// Successful validation, with KeyData and KeyPath
+	pr, err := NewPRSigstoreSignedKeyPath(keyPath, prm)
+	require.NoError(t, err)
+	sar, parsedSig, err := pr.isSignatureAuthorAccepted(context.Background(), testImage, testImageSig)
+	assertSARAccepted(t, sar, parsedSig, err, expectedSig)
+
+	pr, err = NewPRSigstoreSignedKeyData(publicKeyPEM, prm)
+	require.NoError(t, err)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, testImageSig)
+	assertSARAccepted(t, sar, parsedSig, err, expectedSig)
+
+	// Both KeyPath and KeyData set. Do not use newPRSigstoreSigned*, because it would reject this.
+	prSS := &prSigstoreSigned{
+		KeyPath:        keyPath,
+		KeyData:        publicKeyPEM,
+		SignedIdentity: prm,
+	}
+	// Pass nil pointers to, kind of, test that the return value does not depend on the parameters.
+	sar, parsedSig, err = prSS.isSignatureAuthorAccepted(context.Background(), nil, testImageSig)
+	assertSARRejected(t, sar, parsedSig, err)
+
+	// Invalid KeyPath
+	pr, err = NewPRSigstoreSignedKeyPath("/this/does/not/exist", prm)
+	require.NoError(t, err)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), nil, testImageSig)
+	assertSARRejected(t, sar, parsedSig, err)
+
+	// KeyData is not a valid public key
+	pr, err = NewPRSigstoreSignedKeyData([]byte("this is not a key"), prm)
+	require.NoError(t, err)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), nil, testImageSig)
+	assertSARRejected(t, sar, parsedSig, err)
+
+	// An invalid signature blob
+	pr, err = NewPRSigstoreSignedKeyData(publicKeyPEM, prm)
+	require.NoError(t, err)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), nil, []byte{})
+	assertSARRejected(t, sar, parsedSig, err)
+
+	// A simple signing signature
+	simpleSig, err := os.ReadFile("fixtures/dir-img-valid/signature-1")
+	require.NoError(t, err)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, simpleSig)
+	assertSARRejectedPolicyRequirement(t, sar, parsedSig, err)
+
+	// Unexpected MIME type
+	payload := sigstoreTestPayload(t, TestImageManifestDigest, "testing/manifest:latest")
+	annotations := map[string]string{
+		signature.SigstoreSignatureAnnotationKey: sigstoreTestSignECDSA(t, privateKey, payload),
+	}
+	sig, err := signature.Blob(signature.SigstoreFromComponents("application/octet-stream", payload, annotations))
+	require.NoError(t, err)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, sig)
+	assertSARRejectedPolicyRequirement(t, sar, parsedSig, err)
+
+	// Missing signature annotation
+	sig, err = signature.Blob(signature.SigstoreFromComponents(signature.SigstoreSignatureMIMEType, payload, nil))
+	require.NoError(t, err)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, sig)
+	assertSARRejectedPolicyRequirement(t, sar, parsedSig, err)
+
+	// A signature by a different key
+	pr, err = NewPRSigstoreSignedKeyData(otherPublicKeyPEM, prm)
+	require.NoError(t, err)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, testImageSig)
+	assertSARRejectedPolicyRequirement(t, sar, parsedSig, err)
+
+	// A signature with a non-matching Docker reference
+	pr, err = NewPRSigstoreSignedKeyData(publicKeyPEM, prm)
+	require.NoError(t, err)
+	image := dirImageMock(t, "fixtures/dir-img-valid", "testing/manifest:notlatest")
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), image, testImageSig)
+	assertSARRejectedPolicyRequirement(t, sar, parsedSig, err)
+
+	// A signature with a non-matching manifest digest
+	image = dirImageMock(t, "fixtures/dir-img-modified-manifest", "testing/manifest:latest")
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), image, testImageSig)
+	assertSARRejectedPolicyRequirement(t, sar, parsedSig, err)
+
+	// Error reading image manifest
+	image = dirImageMock(t, "fixtures/dir-img-no-manifest", "testing/manifest:latest")
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), image, testImageSig)
+	assertSARRejected(t, sar, parsedSig, err)
+}
+
+func TestPRSigstoreSignedIsRunningImageAllowed(t *testing.T) {
+	prm := NewPRMMatchExact()
+	privateKey, publicKeyPEM := sigstoreTestECDSAKey(t)
+	otherKey, _ := sigstoreTestECDSAKey(t)
+	validSig := sigstoreTestSignatureBlob(t, privateKey, TestImageManifestDigest, "testing/manifest:latest")
+	otherKeySig := sigstoreTestSignatureBlob(t, otherKey, TestImageManifestDigest, "testing/manifest:latest")
+	simpleSig, err := os.ReadFile("fixtures/dir-img-valid/signature-1")
+	require.NoError(t, err)
+
+	pr, err := NewPRSigstoreSignedKeyData(publicKeyPEM, prm)
+	require.NoError(t, err)
+
+	// A simple success case: single valid signature.
+	image := dirImageMock(t, createSigstoreSignedDir(t, validSig), "testing/manifest:latest")
+	allowed, err := pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningAllowed(t, allowed, err)
+
+	// Error reading signatures
+	image = dirImageMock(t, createInvalidSigDir(t), "testing/manifest:latest")
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningRejected(t, allowed, err)
+
+	// No signatures
+	image = dirImageMock(t, "fixtures/dir-img-unsigned", "testing/manifest:latest")
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+
+	// Only a simple signing signature
+	image = dirImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+
+	// 1 invalid signature: a non-matching Docker reference
+	image = dirImageMock(t, createSigstoreSignedDir(t, validSig), "testing/manifest:notlatest")
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+
+	// A simple signing signature and a valid sigstore signature
+	image = dirImageMock(t, createSigstoreSignedDir(t, simpleSig, validSig), "testing/manifest:latest")
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningAllowed(t, allowed, err)
+
+	// One invalid, one valid signature (in this order)
+	image = dirImageMock(t, createSigstoreSignedDir(t, otherKeySig, validSig), "testing/manifest:latest")
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningAllowed(t, allowed, err)
+
+	// 2 invalid signatures
+	image = dirImageMock(t, createSigstoreSignedDir(t, otherKeySig, otherKeySig), "testing/manifest:latest")
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+}
+
+func TestPRSigstoreSignedIsRunningImageAllowedCosignFixture(t *testing.T) {
+	payload, err := os.ReadFile("fixtures/cosign.payload")
+	require.NoError(t, err)
+	base64Sig, err := os.ReadFile("fixtures/cosign.signature")
+	require.NoError(t, err)
+	cosignSig, err := signature.Blob(signature.SigstoreFromComponents(signature.SigstoreSignatureMIMEType, payload, map[string]string{
+		signature.SigstoreSignatureAnnotationKey: string(base64Sig),
+	}))
+	require.NoError(t, err)
+
+	pr, err := NewPRSigstoreSignedKeyPath("fixtures/cosign.pub", NewPRMMatchRepository())
+	require.NoError(t, err)
+
+	// cosign signs the repository, without a tag
+	image := dirImageMock(t, createSigstoreSignedDir(t, cosignSig), TestCosignSignatureReference+":latest")
+	allowed, err := pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningAllowed(t, allowed, err)
+
+	// A different repository
+	image = dirImageMock(t, createSigstoreSignedDir(t, cosignSig), "example.com/testing/other:latest")
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+}
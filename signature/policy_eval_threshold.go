@@ -0,0 +1,212 @@
+// Policy evaluation for prSignedByThreshold.
+
+package signature
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/v5/internal/signature"
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+)
+
+// keyGroupName returns a description of the key group with index i, for error messages.
+func (pr *prSignedByThreshold) keyGroupName(i int) string {
+	if pr.KeyGroups[i].Name != "" {
+		return fmt.Sprintf("key group %q", pr.KeyGroups[i].Name)
+	}
+	return fmt.Sprintf("key group %d", i)
+}
+
+// verifySignature verifies sig against all key groups of pr.
+// It returns the groups which accepted the signature (if any), or an error describing why the signature was rejected.
+// If report is not nil, the values checked during verification are recorded in it.
+func (pr *prSignedByThreshold) verifySignature(ctx context.Context, image types.UnparsedImage, sig []byte, report *SignatureEvaluationReport) (*Signature, []int, error) {
+	var acceptedSig *Signature
+	var acceptedGroups []int
+	var rejections []string
+	for i := range pr.KeyGroups {
+		group, err := pr.KeyGroups[i].signedBy(pr.SignedIdentity)
+		if err != nil { // Coverage: This should never happen, newPRSignedByThreshold ensures the groups are valid.
+			return nil, nil, err
+		}
+		var groupReport SignatureEvaluationReport
+		switch res, as, err := group.isSignatureAuthorAcceptedWithReport(ctx, image, sig, &groupReport); res {
+		case sarAccepted:
+			if acceptedSig != nil && *as != *acceptedSig { // Coverage: this should never happen
+				return nil, nil, errors.New("Internal inconsistency: key groups accepted different contents of a signature")
+			}
+			acceptedSig = as
+			acceptedGroups = append(acceptedGroups, i)
+			if report != nil {
+				report.setVerificationDetails(groupReport)
+			}
+		case sarRejected:
+			rejections = append(rejections, fmt.Sprintf("%s: %v", pr.keyGroupName(i), err))
+			// Prefer reporting the details from a group which recognized the key.
+			if report != nil && acceptedSig == nil && report.KeyIdentity == "" {
+				report.setVerificationDetails(groupReport)
+			}
+		default:
+			return nil, nil, errors.Errorf(`Internal error: Unexpected signature verification result "%s"`, string(res))
+		}
+	}
+	if acceptedSig == nil {
+		return nil, nil, PolicyRequirementError(fmt.Sprintf("Signature was not accepted by any key group: %s", strings.Join(rejections, "; ")))
+	}
+	return acceptedSig, acceptedGroups, nil
+}
+
+// setVerificationDetails copies the values checked during verification from src to report.
+func (report *SignatureEvaluationReport) setVerificationDetails(src SignatureEvaluationReport) {
+	report.KeyIdentity = src.KeyIdentity
+	report.SignedDockerManifestDigest = src.SignedDockerManifestDigest
+	report.SignedDockerReference = src.SignedDockerReference
+	report.Timestamp = src.Timestamp
+}
+
+func (pr *prSignedByThreshold) isSignatureAuthorAccepted(ctx context.Context, image types.UnparsedImage, sig []byte) (signatureAcceptanceResult, *Signature, error) {
+	parsedSig, err := signature.FromBlob(sig)
+	if err != nil {
+		return sarRejected, nil, err
+	}
+	if _, ok := parsedSig.(signature.SimpleSigning); !ok {
+		return sarRejected, nil, PolicyRequirementError(fmt.Sprintf("Signature of format %s is not a simple signing signature", parsedSig.FormatID()))
+	}
+	verifiedSig, _, err := pr.verifySignature(ctx, image, sig, nil)
+	if err != nil {
+		return sarRejected, nil, err
+	}
+	// A single signature by a trusted key is acceptable for further processing; the threshold applies to
+	// running images, in isRunningImageAllowed.
+	return sarAccepted, verifiedSig, nil
+}
+
+func (pr *prSignedByThreshold) isRunningImageAllowed(ctx context.Context, image types.UnparsedImage) (bool, error) {
+	return pr.isRunningImageAllowedWithReport(ctx, image, nil)
+}
+
+// isRunningImageAllowedWithReport is isRunningImageAllowed, which also records the results for individual signatures
+// in report, if it is not nil.
+func (pr *prSignedByThreshold) isRunningImageAllowedWithReport(ctx context.Context, image types.UnparsedImage, report *PolicyRequirementReport) (bool, error) {
+	sigs, err := image.Signatures(ctx)
+	if err != nil {
+		return false, err
+	}
+	// Signatures are only counted together if they claim the same identity; the manifest digest is
+	// always required to match the image.
+	approvals := map[string]*thresholdApprovals{}
+	best := 0
+	var rejections []error
+	for sigNumber, s := range sigs {
+		sigReport := newSignatureEvaluationReport(sigNumber, s)
+		if parsed, err := signature.FromBlob(s); err == nil {
+			if _, ok := parsed.(signature.SimpleSigning); !ok {
+				// Signatures of other formats are not of interest for this requirement; don’t report them as rejections.
+				sigReport.Skipped = true
+				report.addSignature(sigReport)
+				continue
+			}
+		}
+
+		verifiedSig, groups, err := pr.verifySignature(ctx, image, s, &sigReport)
+		if err != nil {
+			sigReport.Err = err
+			report.addSignature(sigReport)
+			rejections = append(rejections, err)
+			continue
+		}
+		sigReport.Accepted = true
+		report.addSignature(sigReport)
+
+		a, ok := approvals[verifiedSig.DockerReference]
+		if !ok {
+			a = &thresholdApprovals{keyGroups: map[string][]int{}}
+			approvals[verifiedSig.DockerReference] = a
+		}
+		a.add(sigReport.KeyIdentity, groups)
+		count := a.count(pr.CountDistinct, len(pr.KeyGroups))
+		if count >= pr.Threshold {
+			return true, nil
+		}
+		if count > best {
+			best = count
+		}
+	}
+
+	if len(rejections) == 0 && len(approvals) == 0 {
+		return false, PolicyRequirementError("A signature was required, but no signature exists")
+	}
+	what := "key groups"
+	if pr.CountDistinct == SBTCountKeys {
+		what = "keys"
+	}
+	msg := fmt.Sprintf("Signatures by at least %d distinct %s are required, but only %d were found", pr.Threshold, what, best)
+	if len(rejections) != 0 {
+		var msgs []string
+		for _, e := range rejections {
+			msgs = append(msgs, e.Error())
+		}
+		msg = fmt.Sprintf("%s; rejected signatures: %s", msg, strings.Join(msgs, "; "))
+	}
+	return false, PolicyRequirementError(msg)
+}
+
+// thresholdApprovals records the trusted signatures for a single signed identity.
+type thresholdApprovals struct {
+	keyGroups map[string][]int // Key identity -> indices of the key groups which accepted a signature by that key
+}
+
+// add records a signature by keyIdentity, accepted by groups.
+func (a *thresholdApprovals) add(keyIdentity string, groups []int) {
+	existing := a.keyGroups[keyIdentity]
+	for _, g := range groups {
+		found := false
+		for _, e := range existing {
+			if e == g {
+				found = true
+				break
+			}
+		}
+		if !found {
+			existing = append(existing, g)
+		}
+	}
+	a.keyGroups[keyIdentity] = existing
+}
+
+// count returns the number of distinct keys, or of distinct key groups (out of numGroups) with a signature, depending on countDistinct.
+// When counting key groups, every key only counts for one of its groups.
+func (a *thresholdApprovals) count(countDistinct sbtCountDistinct, numGroups int) int {
+	if countDistinct == SBTCountKeys {
+		return len(a.keyGroups)
+	}
+	// Find a maximum matching of keys to key groups, using augmenting paths.
+	// The number of keys and groups is expected to be very small.
+	groupKey := make([]string, numGroups) // Key matched to each group, or ""
+	matched := make([]bool, numGroups)
+	var tryKey func(key string, visited []bool) bool
+	tryKey = func(key string, visited []bool) bool {
+		for _, g := range a.keyGroups[key] {
+			if visited[g] {
+				continue
+			}
+			visited[g] = true
+			if !matched[g] || tryKey(groupKey[g], visited) {
+				groupKey[g] = key
+				matched[g] = true
+				return true
+			}
+		}
+		return false
+	}
+	res := 0
+	for key := range a.keyGroups {
+		if tryKey(key, make([]bool, numGroups)) {
+			res++
+		}
+	}
+	return res
+}
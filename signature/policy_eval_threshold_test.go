@@ -0,0 +1,231 @@
+package signature
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPRSignedByThresholdIsSignatureAuthorAccepted(t *testing.T) {
+	ca1, ca1Key := x509TestCA(t, "build root", nil, nil)
+	ca2, ca2Key := x509TestCA(t, "security root", nil, nil)
+	otherCA, otherCAKey := x509TestCA(t, "other root", nil, nil)
+	leaf1, leaf1Key := x509TestLeaf(t, ca1, ca1Key)
+	leaf2, leaf2Key := x509TestLeaf(t, ca2, ca2Key)
+	otherLeaf, otherLeafKey := x509TestLeaf(t, otherCA, otherCAKey)
+	pr := xNewPRSignedByThreshold(2, SBTCountKeyGroups, []SBKeyGroup{
+		{Name: "build", KeyType: SBKeyTypeSignedByX509CAs, KeyData: x509TestCertificatesPEM(ca1)},
+		{Name: "security", KeyType: SBKeyTypeSignedByX509CAs, KeyData: x509TestCertificatesPEM(ca2)},
+	}, NewPRMMatchExact())
+	testImage := dirImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
+	expectedSig := Signature{
+		DockerManifestDigest: TestImageManifestDigest,
+		DockerReference:      "testing/manifest:latest",
+	}
+
+	// A signature accepted by any single key group is accepted, regardless of the threshold.
+	for _, sig := range [][]byte{
+		x509TestSignedImageSignature(t, "testing/manifest:latest", leaf1, leaf1Key),
+		x509TestSignedImageSignature(t, "testing/manifest:latest", leaf2, leaf2Key),
+	} {
+		sar, parsedSig, err := pr.isSignatureAuthorAccepted(context.Background(), testImage, sig)
+		assertSARAccepted(t, sar, parsedSig, err, expectedSig)
+	}
+
+	// A signature by a key not in any key group
+	sig := x509TestSignedImageSignature(t, "testing/manifest:latest", otherLeaf, otherLeafKey)
+	sar, parsedSig, err := pr.isSignatureAuthorAccepted(context.Background(), testImage, sig)
+	assertSARRejectedPolicyRequirement(t, sar, parsedSig, err)
+	assert.Contains(t, err.Error(), `key group "build"`)
+	assert.Contains(t, err.Error(), `key group "security"`)
+
+	// Valid signature with non-matching reference
+	sig = x509TestSignedImageSignature(t, "testing/manifest:notlatest", leaf1, leaf1Key)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, sig)
+	assertSARRejectedPolicyRequirement(t, sar, parsedSig, err)
+
+	// A signature which does not verify
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, []byte("invalid signature"))
+	assertSARRejected(t, sar, parsedSig, err)
+
+	// A sigstore signature
+	sigstoreKey, _ := sigstoreTestECDSAKey(t)
+	sig = sigstoreTestSignatureBlob(t, sigstoreKey, TestImageManifestDigest, "testing/manifest:latest")
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, sig)
+	assertSARRejectedPolicyRequirement(t, sar, parsedSig, err)
+
+	// A signature by a key revoked in its key group
+	pr = xNewPRSignedByThreshold(1, SBTCountKeyGroups, []SBKeyGroup{
+		{Name: "build", KeyType: SBKeyTypeSignedByX509CAs, KeyData: x509TestCertificatesPEM(ca1), RevokedFingerprints: []string{x509KeyIdentity(leaf1)}},
+		{Name: "security", KeyType: SBKeyTypeSignedByX509CAs, KeyData: x509TestCertificatesPEM(ca2)},
+	}, NewPRMMatchExact())
+	sig = x509TestSignedImageSignature(t, "testing/manifest:latest", leaf1, leaf1Key)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, sig)
+	assertSARRejected(t, sar, parsedSig, err)
+	assert.Contains(t, err.Error(), `key group "build"`)
+	sig = x509TestSignedImageSignature(t, "testing/manifest:latest", leaf2, leaf2Key)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, sig)
+	assertSARAccepted(t, sar, parsedSig, err, expectedSig)
+}
+
+func TestPRSignedByThresholdIsRunningImageAllowed(t *testing.T) {
+	ca1, ca1Key := x509TestCA(t, "build root", nil, nil)
+	ca2, ca2Key := x509TestCA(t, "security root", nil, nil)
+	leaf1, leaf1Key := x509TestLeaf(t, ca1, ca1Key)
+	leaf1b, leaf1bKey := x509TestLeaf(t, ca1, ca1Key)
+	leaf2, leaf2Key := x509TestLeaf(t, ca2, ca2Key)
+	ca1Group := SBKeyGroup{Name: "build", KeyType: SBKeyTypeSignedByX509CAs, KeyData: x509TestCertificatesPEM(ca1)}
+	ca2Group := SBKeyGroup{Name: "security", KeyType: SBKeyTypeSignedByX509CAs, KeyData: x509TestCertificatesPEM(ca2)}
+	sig1 := x509TestSignedImageSignature(t, "testing/manifest:latest", leaf1, leaf1Key)
+	sig1b := x509TestSignedImageSignature(t, "testing/manifest:latest", leaf1b, leaf1bKey)
+	sig2 := x509TestSignedImageSignature(t, "testing/manifest:latest", leaf2, leaf2Key)
+
+	pr := xNewPRSignedByThreshold(2, SBTCountKeyGroups, []SBKeyGroup{ca1Group, ca2Group}, NewPRMMatchRepository())
+
+	// Signatures from both key groups
+	image := dirImageMock(t, createSigstoreSignedDir(t, sig1, sig2), "testing/manifest:latest")
+	allowed, err := pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningAllowed(t, allowed, err)
+
+	// A signature from only one key group
+	image = dirImageMock(t, createSigstoreSignedDir(t, sig1), "testing/manifest:latest")
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+	assert.Contains(t, err.Error(), "only 1 were found")
+
+	// Two keys, but both from the same key group
+	image = dirImageMock(t, createSigstoreSignedDir(t, sig1, sig1b), "testing/manifest:latest")
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+
+	// The same signature repeated
+	image = dirImageMock(t, createSigstoreSignedDir(t, sig1, sig1), "testing/manifest:latest")
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+
+	// Signatures from both key groups, but claiming different identities
+	otherIdentitySig := x509TestSignedImageSignature(t, "testing/manifest:other", leaf2, leaf2Key)
+	image = dirImageMock(t, createSigstoreSignedDir(t, sig1, otherIdentitySig), "testing/manifest:latest")
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+
+	// An invalid signature, followed by signatures from both key groups
+	image = dirImageMock(t, createSigstoreSignedDir(t, []byte("invalid signature"), sig1, sig2), "testing/manifest:latest")
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningAllowed(t, allowed, err)
+
+	// Only a GPG signature, which can't be verified by either key group
+	image = dirImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+
+	// No signatures
+	image = dirImageMock(t, "fixtures/dir-img-unsigned", "testing/manifest:latest")
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+
+	// Counting distinct keys
+	pr = xNewPRSignedByThreshold(2, SBTCountKeys, []SBKeyGroup{ca1Group}, NewPRMMatchRepository())
+	image = dirImageMock(t, createSigstoreSignedDir(t, sig1, sig1b), "testing/manifest:latest")
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningAllowed(t, allowed, err)
+	image = dirImageMock(t, createSigstoreSignedDir(t, sig1, sig1), "testing/manifest:latest")
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+
+	// A key trusted by several key groups only counts for one of them
+	bothCAsGroup := SBKeyGroup{Name: "both", KeyType: SBKeyTypeSignedByX509CAs, KeyData: x509TestCertificatesPEM(ca1, ca2)}
+	pr = xNewPRSignedByThreshold(2, SBTCountKeyGroups, []SBKeyGroup{ca1Group, bothCAsGroup}, NewPRMMatchRepository())
+	image = dirImageMock(t, createSigstoreSignedDir(t, sig1), "testing/manifest:latest")
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+	// … but two such keys can satisfy both groups.
+	image = dirImageMock(t, createSigstoreSignedDir(t, sig1, sig1b), "testing/manifest:latest")
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningAllowed(t, allowed, err)
+	image = dirImageMock(t, createSigstoreSignedDir(t, sig2, sig1), "testing/manifest:latest")
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningAllowed(t, allowed, err)
+}
+
+func TestPRSignedByThresholdIsRunningImageAllowedWithReport(t *testing.T) {
+	ca1, ca1Key := x509TestCA(t, "build root", nil, nil)
+	ca2, ca2Key := x509TestCA(t, "security root", nil, nil)
+	leaf1, leaf1Key := x509TestLeaf(t, ca1, ca1Key)
+	leaf2, leaf2Key := x509TestLeaf(t, ca2, ca2Key)
+	pr := xNewPRSignedByThreshold(2, SBTCountKeyGroups, []SBKeyGroup{
+		{Name: "build", KeyType: SBKeyTypeSignedByX509CAs, KeyData: x509TestCertificatesPEM(ca1)},
+		{Name: "security", KeyType: SBKeyTypeSignedByX509CAs, KeyData: x509TestCertificatesPEM(ca2)},
+	}, NewPRMMatchExact()).(*prSignedByThreshold)
+	gpgSig, err := os.ReadFile("fixtures/dir-img-valid/signature-1")
+	require.NoError(t, err)
+	sigstoreKey, _ := sigstoreTestECDSAKey(t)
+
+	dir := createSigstoreSignedDir(t,
+		gpgSig,
+		sigstoreTestSignatureBlob(t, sigstoreKey, TestImageManifestDigest, "testing/manifest:latest"),
+		x509TestSignedImageSignature(t, "testing/manifest:latest", leaf1, leaf1Key),
+		x509TestSignedImageSignature(t, "testing/manifest:latest", leaf2, leaf2Key))
+	image := dirImageMock(t, dir, "testing/manifest:latest")
+	var report PolicyRequirementReport
+	allowed, err := pr.isRunningImageAllowedWithReport(context.Background(), image, &report)
+	assertRunningAllowed(t, allowed, err)
+	require.Len(t, report.Signatures, 4)
+	assert.False(t, report.Signatures[0].Accepted)
+	assert.Error(t, report.Signatures[0].Err)
+	assert.Equal(t, SignatureEvaluationReport{Index: 1, Format: "sigstore-json", Skipped: true}, report.Signatures[1])
+	for _, i := range []int{2, 3} {
+		sig := report.Signatures[i]
+		assert.Equal(t, i, sig.Index)
+		assert.True(t, sig.Accepted, "signature %d", i)
+		assert.NoError(t, sig.Err)
+		assert.Equal(t, TestImageManifestDigest, sig.SignedDockerManifestDigest)
+		assert.Equal(t, "testing/manifest:latest", sig.SignedDockerReference)
+	}
+	assert.Equal(t, x509KeyIdentity(leaf1), report.Signatures[2].KeyIdentity)
+	assert.Equal(t, x509KeyIdentity(leaf2), report.Signatures[3].KeyIdentity)
+
+	// The requirement type is reported by ExplainRunningImageAllowed
+	pc, err := NewPolicyContext(&Policy{Default: PolicyRequirements{pr}})
+	require.NoError(t, err)
+	defer func() {
+		err := pc.Destroy()
+		require.NoError(t, err)
+	}()
+	explained, err := pc.ExplainRunningImageAllowed(context.Background(), pcImageMock(t, dir, "testing/manifest:latest"))
+	require.NoError(t, err)
+	assertRunningAllowed(t, explained.Allowed, explained.Err)
+	require.Len(t, explained.Requirements, 1)
+	assert.Equal(t, "signedByThreshold", explained.Requirements[0].Type)
+}
+
+func TestThresholdApprovalsCount(t *testing.T) {
+	for _, c := range []struct {
+		keyGroups    map[string][]int
+		numGroups    int
+		groups, keys int
+	}{
+		{map[string][]int{}, 2, 0, 0},
+		{map[string][]int{"a": {0}}, 2, 1, 1},
+		{map[string][]int{"a": {0}, "b": {1}}, 2, 2, 2},
+		{map[string][]int{"a": {0}, "b": {0}}, 2, 1, 2},
+		{map[string][]int{"a": {0, 1}}, 2, 1, 1},
+		{map[string][]int{"a": {0, 1}, "b": {0}}, 2, 2, 2},
+		{map[string][]int{"a": {0, 1, 2}, "b": {0, 1}, "c": {0}}, 3, 3, 3},
+		{map[string][]int{"a": {0, 1}, "b": {0, 1}, "c": {0, 1}}, 3, 2, 3},
+	} {
+		a := thresholdApprovals{keyGroups: c.keyGroups}
+		assert.Equal(t, c.groups, a.count(SBTCountKeyGroups, c.numGroups), "%#v", c.keyGroups)
+		assert.Equal(t, c.keys, a.count(SBTCountKeys, c.numGroups), "%#v", c.keyGroups)
+	}
+
+	a := thresholdApprovals{keyGroups: map[string][]int{}}
+	a.add("a", []int{0})
+	a.add("a", []int{0, 1})
+	a.add("b", []int{1})
+	assert.Equal(t, map[string][]int{"a": {0, 1}, "b": {1}}, a.keyGroups)
+	assert.Equal(t, 2, a.count(SBTCountKeyGroups, 2))
+}
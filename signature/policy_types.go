@@ -6,6 +6,8 @@
 
 package signature
 
+import "time"
+
 // NOTE: Keep this in sync with docs/containers-policy.json.5.md!
 
 // Policy defines requirements for considering a signature, or an image, valid.
@@ -46,6 +48,8 @@ const (
 	prTypeReject                 prTypeIdentifier = "reject"
 	prTypeSignedBy               prTypeIdentifier = "signedBy"
 	prTypeSignedBaseLayer        prTypeIdentifier = "signedBaseLayer"
+	prTypeSigstoreSigned         prTypeIdentifier = "sigstoreSigned"
+	prTypeSignedByThreshold      prTypeIdentifier = "signedByThreshold"
 )
 
 // prInsecureAcceptAnything is a PolicyRequirement with type = prTypeInsecureAcceptAnything:
@@ -79,6 +83,73 @@ type prSignedBy struct {
 	// SignedIdentity specifies what image identity the signature must be claiming about the image.
 	// Defaults to "match-exact" if not specified.
 	SignedIdentity PolicyReferenceMatch `json:"signedIdentity"`
+
+	// X509Identity, if not nil, restricts the identity of the signing certificate.
+	// It can only be used with KeyType == SBKeyTypeSignedByX509CAs.
+	X509Identity *SBX509Identity `json:"x509Identity,omitempty"`
+
+	// SignatureTimestamp, if not nil, restricts the creation time recorded in the signature.
+	SignatureTimestamp *SBTimestampConstraints `json:"signatureTimestamp,omitempty"`
+
+	// RevokedFingerprints lists identities of keys (e.g. GPG key fingerprints) which must not be trusted,
+	// even if they are included in KeyPath/KeyData. Comparisons ignore case and spaces.
+	RevokedFingerprints []string `json:"revokedFingerprints,omitempty"`
+}
+
+// SBOptions are the settings of a "signedBy" PolicyRequirement other than the key type and the signed identity,
+// used by NewPRSignedByWithOptions.  The fields have the same semantics as the corresponding fields of prSignedBy.
+type SBOptions struct {
+	// KeyPath is a pathname to a local file containing the trusted key(s). Exactly one of KeyPath and KeyData must be specified.
+	KeyPath string
+	// KeyData contains the trusted key(s). Exactly one of KeyPath and KeyData must be specified.
+	KeyData []byte
+	// X509Identity, if not nil, restricts the identity of the signing certificate.
+	// It can only be used with key type SBKeyTypeSignedByX509CAs.
+	X509Identity *SBX509Identity
+	// SignatureTimestamp, if not nil, restricts the creation time recorded in the signature.
+	SignatureTimestamp *SBTimestampConstraints
+	// RevokedFingerprints lists identities of keys which must not be trusted, even if they are included in KeyPath/KeyData.
+	RevokedFingerprints []string
+}
+
+// SBTimestampConstraints specifies constraints on the creation time recorded in a signature, used by prSignedBy.
+// At least one field must be set.
+// Note that the creation time is recorded by the signer; anyone in possession of the private key can record any value,
+// so these constraints are not a replacement for removing compromised keys.
+type SBTimestampConstraints struct {
+	// MaxAge, if not zero, is the maximum age of accepted signatures at the time of verification.
+	// It is represented in JSON as a string accepted by time.ParseDuration, e.g. "720h".
+	MaxAge time.Duration `json:"-"`
+	// NotBefore, if not nil, rejects signatures created before this time.
+	NotBefore *time.Time `json:"notBefore,omitempty"`
+	// NotAfter, if not nil, rejects signatures created after this time.
+	NotAfter *time.Time `json:"notAfter,omitempty"`
+	// KeyNotAfter maps key identities (e.g. GPG key fingerprints) to a cutoff time; signatures by the key which claim
+	// to have been created at or after that time are rejected, signatures claiming an earlier creation time remain valid.
+	// This is advisory, NOT a security control: the creation time is chosen by the signer, so a compromised key can
+	// still be used to create backdated signatures. Use prSignedBy.RevokedFingerprints to revoke compromised keys.
+	KeyNotAfter map[string]time.Time `json:"keyNotAfter,omitempty"`
+}
+
+// SBX509Identity specifies constraints on the identity of an X.509 signing certificate, used by prSignedBy.
+// Every non-empty field must match; within a field, a match of any of the listed values is sufficient.
+// At least one field must be non-empty.
+type SBX509Identity struct {
+	// SubjectCommonNames lists the accepted values of the certificate Subject common name.
+	SubjectCommonNames []string `json:"subjectCommonNames,omitempty"`
+	// SubjectOrganizations lists the accepted values of the certificate Subject organization;
+	// any of the organizations in the Subject may match.
+	SubjectOrganizations []string `json:"subjectOrganizations,omitempty"`
+	// SANDNSNames lists the accepted DNS names in the certificate Subject Alternative Name extension.
+	SANDNSNames []string `json:"sanDNSNames,omitempty"`
+	// SANEmailAddresses lists the accepted e-mail addresses in the certificate Subject Alternative Name extension.
+	SANEmailAddresses []string `json:"sanEmailAddresses,omitempty"`
+	// SANURIs lists the accepted URIs in the certificate Subject Alternative Name extension.
+	SANURIs []string `json:"sanURIs,omitempty"`
+	// ExtendedKeyUsages lists the accepted extended key usages of the certificate ("codeSigning", "emailProtection",
+	// "serverAuth", "clientAuth", or "any" to accept certificates issued for any purpose); the certificate must
+	// include one of them. If empty, only "codeSigning" is accepted.
+	ExtendedKeyUsages []string `json:"extendedKeyUsages,omitempty"`
 }
 
 // sbKeyType are the allowed values for prSignedBy.KeyType
@@ -92,18 +163,83 @@ const (
 	// SBKeyTypeX509Certificates refers to keys in a set of X.509 certificates
 	// FIXME: PEM, DER?
 	SBKeyTypeX509Certificates sbKeyType = "X509Certificates"
-	// SBKeyTypeSignedByX509CAs refers to keys signed by one of the X.509 CAs
-	// FIXME: PEM, DER?
+	// SBKeyTypeSignedByX509CAs refers to keys signed by one of the X.509 CAs, in a set of PEM-encoded CA certificates.
+	// Signatures are CMS (PKCS #7) SignedData objects created by the X.509 signing mechanism.
 	SBKeyTypeSignedByX509CAs sbKeyType = "signedByX509CAs"
 )
 
 // prSignedBaseLayer is a PolicyRequirement with type = prSignedBaseLayer: the image has a specified, correctly signed, base image.
 type prSignedBaseLayer struct {
 	prCommon
-	// BaseLayerIdentity specifies the base image to look for. "match-exact" is rejected, "match-repository" is unlikely to be useful.
+	// BaseLayerIdentity specifies the base image to look for; it must be an exactReference or an exactRepository
+	// (which refers to the "latest" tag of the repository).
 	BaseLayerIdentity PolicyReferenceMatch `json:"baseLayerIdentity"`
 }
 
+// prSigstoreSigned is a PolicyRequirement with type = prTypeSigstoreSigned: the image is signed by a trusted
+// sigstore (cosign) public key for a specified identity
+type prSigstoreSigned struct {
+	prCommon
+
+	// KeyPath is a pathname to a local file containing the trusted key. Exactly one of KeyPath and KeyData must be specified.
+	KeyPath string `json:"keyPath,omitempty"`
+	// KeyData contains the trusted key, base64-encoded. Exactly one of KeyPath and KeyData must be specified.
+	KeyData []byte `json:"keyData,omitempty"`
+	// FIXME: Multiple public keys?
+
+	// SignedIdentity specifies what image identity the signature must be claiming about the image.
+	// Defaults to "matchRepoDigestOrExact" if not specified.
+	SignedIdentity PolicyReferenceMatch `json:"signedIdentity"`
+}
+
+// prSignedByThreshold is a PolicyRequirement with type = prTypeSignedByThreshold: the image is signed, for the same identity,
+// by trusted keys of at least Threshold distinct key groups (or at least Threshold distinct keys).
+type prSignedByThreshold struct {
+	prCommon
+
+	// Threshold is the number of distinct key groups (or keys, depending on CountDistinct) which must have signed the image.
+	Threshold int `json:"threshold"`
+	// CountDistinct specifies what is counted towards Threshold.
+	// Defaults to SBTCountKeyGroups if not specified.
+	CountDistinct sbtCountDistinct `json:"countDistinct,omitempty"`
+	// KeyGroups are the groups of trusted keys. Must not be empty.
+	KeyGroups []SBKeyGroup `json:"keyGroups"`
+
+	// SignedIdentity specifies what image identity the signatures must be claiming about the image.
+	// Defaults to "matchRepoDigestOrExact" if not specified.
+	SignedIdentity PolicyReferenceMatch `json:"signedIdentity"`
+}
+
+// SBKeyGroup is a group of trusted keys, used by prSignedByThreshold.
+// The fields other than Name have the same semantics as the corresponding fields of prSignedBy.
+type SBKeyGroup struct {
+	// Name is an optional name of the group, used in error messages.
+	Name string `json:"name,omitempty"`
+	// KeyType specifies what kind of key reference KeyPath/KeyData is; only SBKeyTypeGPGKeys and SBKeyTypeSignedByX509CAs are supported.
+	KeyType sbKeyType `json:"keyType"`
+	// KeyPath is a pathname to a local file containing the trusted key(s). Exactly one of KeyPath and KeyData must be specified.
+	KeyPath string `json:"keyPath,omitempty"`
+	// KeyData contains the trusted key(s), base64-encoded. Exactly one of KeyPath and KeyData must be specified.
+	KeyData []byte `json:"keyData,omitempty"`
+	// X509Identity, if not nil, restricts the identity of the signing certificate.
+	// It can only be used with KeyType == SBKeyTypeSignedByX509CAs.
+	X509Identity *SBX509Identity `json:"x509Identity,omitempty"`
+	// RevokedFingerprints lists identities of keys (e.g. GPG key fingerprints) which must not be trusted,
+	// even if they are included in KeyPath/KeyData.
+	RevokedFingerprints []string `json:"revokedFingerprints,omitempty"`
+}
+
+// sbtCountDistinct are the allowed values for prSignedByThreshold.CountDistinct
+type sbtCountDistinct string
+
+const (
+	// SBTCountKeyGroups counts the key groups with at least one trusted signature.
+	// A key which is a member of several groups only counts for one of them.
+	SBTCountKeyGroups sbtCountDistinct = "keyGroups"
+	// SBTCountKeys counts the distinct keys which made trusted signatures, regardless of their groups.
+	SBTCountKeys sbtCountDistinct = "keys"
+)
+
 // PolicyReferenceMatch specifies a set of image identities accepted in PolicyRequirement.
 // The type is public, but its implementation is private.
 
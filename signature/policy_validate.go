@@ -0,0 +1,374 @@
+// Static validation of policies, to find mistakes before the policy is used.
+
+package signature
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/transports"
+)
+
+// PolicyIssueSeverity is the severity of a PolicyIssue.
+type PolicyIssueSeverity string
+
+const (
+	// PolicyIssueError is a problem which makes a part of the policy unusable, typically causing images to be rejected.
+	PolicyIssueError PolicyIssueSeverity = "error"
+	// PolicyIssueWarning is a likely mistake, which does not prevent the policy from being used.
+	PolicyIssueWarning PolicyIssueSeverity = "warning"
+)
+
+// PolicyIssue is a problem in a policy, found by ValidatePolicy.
+type PolicyIssue struct {
+	Severity PolicyIssueSeverity `json:"severity"`
+	// Path is a JSON path of the problematic element of the policy, e.g. `$.transports["docker"]["example.com"][0].keyPath`.
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (issue PolicyIssue) String() string {
+	return fmt.Sprintf("%s: %s: %s", issue.Severity, issue.Path, issue.Message)
+}
+
+// ValidatePolicy checks policy for semantic problems which are not detected when parsing it, e.g. unreadable
+// or unusable keys, "remapIdentity" prefixes which can never match images in the scope they are used in,
+// requirements which are weakened by a more specific scope, or "signedBaseLayer" requirements which can't be satisfied.
+// It returns the problems found, ordered by their location in the policy; an empty result means that no problems were found
+// (not that the policy is correct or secure).
+// NOTE: The policy is validated against the current state of the system (e.g. the contents of key files);
+// key files are read, and GPG keys are imported into temporary GPG home directories.
+func ValidatePolicy(policy *Policy) []PolicyIssue {
+	v := policyValidator{policy: policy}
+	if len(policy.Default) == 0 {
+		v.addError("$.default", "Default policy is missing or empty")
+	}
+	v.validateRequirements("$.default", "", "", policy.Default)
+
+	transportNames := make([]string, 0, len(policy.Transports))
+	for transportName := range policy.Transports {
+		transportNames = append(transportNames, transportName)
+	}
+	sort.Strings(transportNames)
+	for _, transportName := range transportNames {
+		transportPath := fmt.Sprintf("$.transports[%q]", transportName)
+		transport := transports.Get(transportName)
+		if transport == nil {
+			v.addWarning(transportPath, fmt.Sprintf("Unknown transport %q, the scopes are not validated", transportName))
+		}
+		transportScopes := policy.Transports[transportName]
+		scopes := make([]string, 0, len(transportScopes))
+		for scope := range transportScopes {
+			scopes = append(scopes, scope)
+		}
+		sort.Strings(scopes)
+		for _, scope := range scopes {
+			scopePath := fmt.Sprintf("%s[%q]", transportPath, scope)
+			if scope != "" && transport != nil {
+				if err := transport.ValidatePolicyConfigurationScope(scope); err != nil {
+					v.addError(scopePath, fmt.Sprintf("Invalid scope: %v", err))
+				}
+			}
+			reqs := transportScopes[scope]
+			if len(reqs) == 0 {
+				v.addError(scopePath, "List of verification policy requirements must not be empty")
+			}
+			v.validateRequirements(scopePath, transportName, scope, reqs)
+			if transportName == "docker" {
+				v.validateDockerScopeShadowing(scopePath, scope, scopes, transportScopes)
+			}
+		}
+	}
+	return v.issues
+}
+
+// policyValidator collects the results of ValidatePolicy.
+type policyValidator struct {
+	policy *Policy
+	issues []PolicyIssue
+}
+
+// addError records an error at path.
+func (v *policyValidator) addError(path, message string) {
+	v.issues = append(v.issues, PolicyIssue{Severity: PolicyIssueError, Path: path, Message: message})
+}
+
+// addWarning records a warning at path.
+func (v *policyValidator) addWarning(path, message string) {
+	v.issues = append(v.issues, PolicyIssue{Severity: PolicyIssueWarning, Path: path, Message: message})
+}
+
+// validateRequirements validates reqs at path, used for scope of transportName.
+// transportName is "" for the default policy.
+func (v *policyValidator) validateRequirements(path, transportName, scope string, reqs PolicyRequirements) {
+	for i, req := range reqs {
+		reqPath := fmt.Sprintf("%s[%d]", path, i)
+		switch pr := req.(type) {
+		case *prSignedBy:
+			v.validateSignedBy(reqPath, pr)
+			v.validateSignedIdentity(reqPath+".signedIdentity", transportName, scope, pr.SignedIdentity)
+		case *prSigstoreSigned:
+			v.validateSigstoreSigned(reqPath, pr)
+			v.validateSignedIdentity(reqPath+".signedIdentity", transportName, scope, pr.SignedIdentity)
+		case *prSignedByThreshold:
+			v.validateSignedByThreshold(reqPath, pr)
+			v.validateSignedIdentity(reqPath+".signedIdentity", transportName, scope, pr.SignedIdentity)
+		case *prSignedBaseLayer:
+			v.validateSignedBaseLayer(reqPath, pr)
+		}
+	}
+}
+
+// readKey returns the key data specified by keyPath or keyData, or records an error at reqPath and returns nil.
+func (v *policyValidator) readKey(reqPath, keyPath string, keyData []byte) []byte {
+	if keyPath != "" && keyData != nil {
+		v.addError(reqPath, `Both "keyPath" and "keyData" specified`)
+		return nil
+	}
+	if keyData != nil {
+		return keyData
+	}
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		v.addError(reqPath+".keyPath", fmt.Sprintf("Error reading key: %v", err))
+		return nil
+	}
+	return data
+}
+
+// keyLocation returns the JSON path of the key specified in a requirement at reqPath.
+func keyLocation(reqPath, keyPath string) string {
+	if keyPath != "" {
+		return reqPath + ".keyPath"
+	}
+	return reqPath + ".keyData"
+}
+
+// validateSignedBy validates the keys of pr at reqPath.
+func (v *policyValidator) validateSignedBy(reqPath string, pr *prSignedBy) {
+	switch pr.KeyType {
+	case SBKeyTypeGPGKeys, SBKeyTypeSignedByX509CAs:
+	default:
+		v.addError(reqPath+".keyType", fmt.Sprintf("keyType %q is not implemented, all signatures will be rejected", string(pr.KeyType)))
+		return
+	}
+	data := v.readKey(reqPath, pr.KeyPath, pr.KeyData)
+	if data == nil {
+		return
+	}
+	keyPath := keyLocation(reqPath, pr.KeyPath)
+	if pr.KeyType == SBKeyTypeSignedByX509CAs {
+		mech, err := newX509SigningMechanism(data, nil, nil)
+		if err != nil {
+			v.addError(keyPath, fmt.Sprintf("Error importing CA certificates: %v", err))
+			return
+		}
+		defer mech.Close()
+		if mech.trustedCAs == nil {
+			v.addError(keyPath, "No CA certificates found")
+		}
+		return
+	}
+	mech, trustedIdentities, err := NewEphemeralGPGSigningMechanism(data)
+	if err != nil {
+		v.addError(keyPath, fmt.Sprintf("Error importing keys: %v", err))
+		return
+	}
+	defer mech.Close()
+	if len(trustedIdentities) == 0 {
+		v.addError(keyPath, "No public keys found")
+		return
+	}
+	// revokedFingerprints entries for X.509 certificates can't be checked here, only CA certificates are included in the policy.
+	revoked := 0
+	for _, identity := range trustedIdentities {
+		if pr.isKeyRevoked(identity) {
+			revoked++
+		}
+	}
+	if revoked == len(trustedIdentities) {
+		v.addError(reqPath+".revokedFingerprints", "All trusted keys are revoked, all signatures will be rejected")
+	}
+	for i, fingerprint := range pr.RevokedFingerprints {
+		found := false
+		for _, identity := range trustedIdentities {
+			if normalizeKeyFingerprint(fingerprint) == normalizeKeyFingerprint(identity) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.addWarning(fmt.Sprintf("%s.revokedFingerprints[%d]", reqPath, i), fmt.Sprintf("Key %s is not one of the trusted keys, so revoking it has no effect", fingerprint))
+		}
+	}
+}
+
+// validateSignedByThreshold validates the key groups of pr at reqPath.
+func (v *policyValidator) validateSignedByThreshold(reqPath string, pr *prSignedByThreshold) {
+	for i := range pr.KeyGroups {
+		groupPath := fmt.Sprintf("%s.keyGroups[%d]", reqPath, i)
+		group, err := pr.KeyGroups[i].signedBy(pr.SignedIdentity)
+		if err != nil {
+			v.addError(groupPath, err.Error())
+			continue
+		}
+		v.validateSignedBy(groupPath, group)
+		for j := 0; j < i; j++ {
+			other := &pr.KeyGroups[j]
+			if (group.KeyPath != "" && group.KeyPath == other.KeyPath) || (len(group.KeyData) != 0 && bytes.Equal(group.KeyData, other.KeyData)) {
+				v.addWarning(groupPath, fmt.Sprintf("Key group %d uses the same keys as key group %d, so the groups do not require signatures by different parties", i, j))
+			}
+		}
+	}
+}
+
+// validateSigstoreSigned validates the key of pr at reqPath.
+func (v *policyValidator) validateSigstoreSigned(reqPath string, pr *prSigstoreSigned) {
+	data := v.readKey(reqPath, pr.KeyPath, pr.KeyData)
+	if data == nil {
+		return
+	}
+	if _, err := sigstorePublicKeyFromPEM(data); err != nil {
+		v.addError(keyLocation(reqPath, pr.KeyPath), fmt.Sprintf("Error parsing public key: %v", err))
+	}
+}
+
+// validateSignedIdentity validates prm at path, used for scope of transportName.
+func (v *policyValidator) validateSignedIdentity(path, transportName, scope string, prm PolicyReferenceMatch) {
+	remap, ok := prm.(*prmRemapIdentity)
+	if !ok || transportName != "docker" || scope == "" {
+		return
+	}
+	if !dockerScopeMayContainNamespace(scope, remap.Prefix) {
+		v.addWarning(path+".prefix", fmt.Sprintf("Prefix %q can never match images in scope %q, so no identities are remapped", remap.Prefix, scope))
+	}
+}
+
+// validateSignedBaseLayer validates that the base image of pr at reqPath can be evaluated.
+func (v *policyValidator) validateSignedBaseLayer(reqPath string, pr *prSignedBaseLayer) {
+	path := reqPath + ".baseLayerIdentity"
+	baseRef, err := pr.baseImageReference()
+	if err != nil {
+		v.addError(path, fmt.Sprintf("Invalid base image identity: %v", err))
+		return
+	}
+	transport := transports.Get("docker")
+	if transport == nil {
+		v.addError(path, `Base images can't be evaluated, the "docker" transport is not available`)
+		return
+	}
+	pc := &PolicyContext{Policy: v.policy}
+	seen := []string{}
+	for {
+		baseName := reference.FamiliarString(baseRef)
+		for _, s := range seen {
+			if s == baseRef.String() {
+				v.addError(path, fmt.Sprintf("Base image %s is required to be built on top of itself", baseName))
+				return
+			}
+		}
+		seen = append(seen, baseRef.String())
+		if len(seen) > maxBaseLayerNesting {
+			v.addError(path, fmt.Sprintf("Base images nested more than %d levels deep", maxBaseLayerNesting))
+			return
+		}
+
+		imgRef, err := transport.ParseReference("//" + baseRef.String())
+		if err != nil {
+			v.addError(path, fmt.Sprintf("Invalid base image reference %s: %v", baseName, err))
+			return
+		}
+		var nested *prSignedBaseLayer
+		for _, req := range pc.requirementsForImageRef(imgRef) {
+			switch baseReq := req.(type) {
+			case *prReject:
+				v.addError(path, fmt.Sprintf("Base image %s is rejected by policy", baseName))
+				return
+			case *prSignedBaseLayer:
+				if nested == nil {
+					nested = baseReq
+				}
+			}
+		}
+		if nested == nil {
+			return
+		}
+		baseRef, err = nested.baseImageReference()
+		if err != nil { // The nested requirement is validated on its own, don't report the problem again.
+			return
+		}
+	}
+}
+
+// validateDockerScopeShadowing warns if scope, at scopePath, shadows the requirements of a less specific scope
+// in transportScopes (with sorted keys scopes) which requires signatures, and does not require signatures itself.
+func (v *policyValidator) validateDockerScopeShadowing(scopePath, scope string, scopes []string, transportScopes PolicyTransportScopes) {
+	if !requirementsAcceptUnsigned(transportScopes[scope]) {
+		return
+	}
+	for _, outer := range scopes {
+		if outer == "" || !dockerScopeContains(outer, scope) || requirementsAcceptUnsigned(transportScopes[outer]) {
+			continue
+		}
+		v.addWarning(scopePath, fmt.Sprintf("Scope %q accepts unsigned images, overriding the less specific scope %q which requires signatures", scope, outer))
+	}
+}
+
+// requirementsAcceptUnsigned returns true if reqs accept any image, regardless of its signatures.
+func requirementsAcceptUnsigned(reqs PolicyRequirements) bool {
+	for _, req := range reqs {
+		if _, ok := req.(*prInsecureAcceptAnything); !ok {
+			return false
+		}
+	}
+	return len(reqs) != 0
+}
+
+// dockerScopeRepository returns the repository (or namespace) part of a "docker" transport scope,
+// i.e. scope without a tag or digest.
+func dockerScopeRepository(scope string) string {
+	if i := strings.IndexByte(scope, '@'); i != -1 {
+		scope = scope[:i]
+	}
+	// A ':' may also separate a port in the host name; a tag is only possible after a '/'.
+	if lastSlash := strings.LastIndexByte(scope, '/'); lastSlash != -1 {
+		if colon := strings.LastIndexByte(scope, ':'); colon > lastSlash {
+			scope = scope[:colon]
+		}
+	}
+	return scope
+}
+
+// namespaceContains returns true if name is namespace, or within it.
+func namespaceContains(namespace, name string) bool {
+	return name == namespace || strings.HasPrefix(name, namespace+"/")
+}
+
+// dockerScopeContains returns true if all images matching the "docker" transport scope inner also match the different scope outer.
+func dockerScopeContains(outer, inner string) bool {
+	if outer == inner {
+		return false
+	}
+	if strings.HasPrefix(outer, "*.") {
+		innerHost := strings.TrimPrefix(strings.SplitN(inner, "/", 2)[0], "*.")
+		return strings.HasSuffix(innerHost, outer[1:])
+	}
+	if strings.HasPrefix(inner, "*.") || dockerScopeRepository(outer) != outer {
+		return false
+	}
+	return namespaceContains(outer, dockerScopeRepository(inner))
+}
+
+// dockerScopeMayContainNamespace returns true if some images matching the "docker" transport scope may be within namespace.
+func dockerScopeMayContainNamespace(scope, namespace string) bool {
+	if strings.HasPrefix(scope, "*.") {
+		host := strings.SplitN(namespace, "/", 2)[0]
+		return strings.HasSuffix(host, scope[1:])
+	}
+	repo := dockerScopeRepository(scope)
+	return namespaceContains(namespace, repo) || namespaceContains(repo, namespace)
+}
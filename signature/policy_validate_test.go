@@ -0,0 +1,228 @@
+package signature
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// policyIssueLocations returns the severities and paths of issues, for easy comparison.
+func policyIssueLocations(issues []PolicyIssue) []string {
+	res := []string{}
+	for _, issue := range issues {
+		res = append(res, string(issue.Severity)+" "+issue.Path)
+	}
+	return res
+}
+
+func TestValidatePolicy(t *testing.T) {
+	// A valid policy
+	policy := &Policy{
+		Default: PolicyRequirements{NewPRReject()},
+		Transports: map[string]PolicyTransportScopes{
+			"docker": {
+				"": {NewPRReject()},
+				"docker.io/testing/manifest": {
+					xNewPRSignedByKeyPath(SBKeyTypeGPGKeys, "fixtures/public-key.gpg", xNewPRMRemapIdentity("docker.io/testing", "example.com/testing")),
+				},
+				"docker.io/testing/manifest:derived": {
+					xNewPRSignedBaseLayer(xNewPRMExactReference("docker.io/testing/manifest:latest")),
+				},
+				"docker.io/insecure": {NewPRInsecureAcceptAnything()},
+			},
+		},
+	}
+	issues := ValidatePolicy(policy)
+	assert.Empty(t, issues)
+
+	// Fixture policy
+	policy, err := NewPolicyFromFile("./fixtures/policy.json")
+	require.NoError(t, err)
+	issues = ValidatePolicy(policy)
+	for _, issue := range issues {
+		assert.NotEmpty(t, issue.Path)
+		assert.NotEmpty(t, issue.Message)
+	}
+
+	// Key problems
+	invalidPEMPath := filepath.Join(t.TempDir(), "invalid.pub")
+	err = os.WriteFile(invalidPEMPath, []byte("this is not a key"), 0644)
+	require.NoError(t, err)
+	ca, _ := x509TestCA(t, "root", nil, nil)
+	policy = &Policy{
+		Default: PolicyRequirements{
+			xNewPRSignedByKeyPath(SBKeyTypeGPGKeys, "/this/does/not/exist", NewPRMMatchRepoDigestOrExact()),
+			xNewPRSignedByKeyData(SBKeyTypeGPGKeys, []byte{}, NewPRMMatchRepoDigestOrExact()),
+			xNewPRSignedByKeyPath(SBKeyTypeSignedByGPGKeys, "fixtures/public-key.gpg", NewPRMMatchRepoDigestOrExact()),
+			xNewPRSignedByKeyData(SBKeyTypeSignedByX509CAs, x509TestCertificatesPEM(ca), NewPRMMatchRepoDigestOrExact()),
+			xNewPRSignedByKeyData(SBKeyTypeSignedByX509CAs, []byte("this is not a certificate"), NewPRMMatchRepoDigestOrExact()),
+			xNewPRSigstoreSignedKeyPath(invalidPEMPath, NewPRMMatchRepoDigestOrExact()),
+			xNewPRSigstoreSignedKeyPath("/this/does/not/exist", NewPRMMatchRepoDigestOrExact()),
+		},
+	}
+	issues = ValidatePolicy(policy)
+	assert.Equal(t, []string{
+		"error $.default[0].keyPath",
+		"error $.default[1].keyData",
+		"error $.default[2].keyType",
+		"error $.default[4].keyData",
+		"error $.default[5].keyPath",
+		"error $.default[6].keyPath",
+	}, policyIssueLocations(issues))
+	for _, issue := range issues {
+		assert.NotEmpty(t, issue.Message)
+		assert.Contains(t, issue.String(), issue.Path)
+	}
+
+	// revokedFingerprints
+	policy = &Policy{
+		Default: PolicyRequirements{
+			xNewPRSignedByWithOptions(SBKeyTypeGPGKeys, SBOptions{KeyPath: "fixtures/public-key.gpg", RevokedFingerprints: []string{TestRevokedKeyFingerprint}}, NewPRMMatchRepoDigestOrExact()),
+			xNewPRSignedByWithOptions(SBKeyTypeGPGKeys, SBOptions{KeyPath: "fixtures/public-key.gpg", RevokedFingerprints: []string{strings.ToLower(TestKeyFingerprint)}}, NewPRMMatchRepoDigestOrExact()),
+			xNewPRSignedByWithOptions(SBKeyTypeGPGKeys, SBOptions{KeyPath: "fixtures/revoked-key.gpg", RevokedFingerprints: []string{}}, NewPRMMatchRepoDigestOrExact()),
+		},
+	}
+	issues = ValidatePolicy(policy)
+	assert.Equal(t, []string{
+		"warning $.default[0].revokedFingerprints[0]",
+		"error $.default[1].revokedFingerprints",
+	}, policyIssueLocations(issues))
+
+	// signedByThreshold key groups
+	policy = &Policy{
+		Default: PolicyRequirements{
+			xNewPRSignedByThreshold(1, SBTCountKeys, []SBKeyGroup{
+				{KeyType: SBKeyTypeGPGKeys, KeyPath: "fixtures/public-key.gpg"},
+				{KeyType: SBKeyTypeGPGKeys, KeyPath: "/this/does/not/exist"},
+				{KeyType: SBKeyTypeSignedByX509CAs, KeyData: x509TestCertificatesPEM(ca)},
+				{KeyType: SBKeyTypeGPGKeys, KeyPath: "fixtures/public-key.gpg"},
+				{KeyType: SBKeyTypeGPGKeys, KeyPath: "fixtures/revoked-key.gpg", RevokedFingerprints: []string{TestRevokedKeyFingerprint}},
+			}, NewPRMMatchRepoDigestOrExact()),
+		},
+	}
+	issues = ValidatePolicy(policy)
+	assert.Equal(t, []string{
+		"error $.default[0].keyGroups[1].keyPath",
+		"warning $.default[0].keyGroups[3]",
+		"error $.default[0].keyGroups[4].revokedFingerprints",
+	}, policyIssueLocations(issues))
+
+	// Scope problems
+	policy = &Policy{
+		Default: PolicyRequirements{NewPRReject()},
+		Transports: map[string]PolicyTransportScopes{
+			"docker": {
+				"docker.io/testing": {
+					xNewPRSignedByKeyPath(SBKeyTypeGPGKeys, "fixtures/public-key.gpg", NewPRMMatchRepository()),
+				},
+				"docker.io/testing/manifest:latest": {NewPRInsecureAcceptAnything()},
+				"*.example.com": {
+					xNewPRSignedByKeyPath(SBKeyTypeGPGKeys, "fixtures/public-key.gpg", xNewPRMRemapIdentity("example.net/ns", "example.com/ns")),
+				},
+				"a.example.com/ns": {NewPRInsecureAcceptAnything()},
+				"b.example.com/ns": {
+					xNewPRSignedByKeyPath(SBKeyTypeGPGKeys, "fixtures/public-key.gpg", xNewPRMRemapIdentity("b.example.com/other", "example.com/ns")),
+				},
+				"example.com": {NewPRInsecureAcceptAnything()},
+			},
+			"this-transport-does-not-exist": {
+				"":      {NewPRReject()},
+				"scope": {},
+			},
+		},
+	}
+	issues = ValidatePolicy(policy)
+	assert.Equal(t, []string{
+		`warning $.transports["docker"]["*.example.com"][0].signedIdentity.prefix`,
+		`warning $.transports["docker"]["a.example.com/ns"]`,
+		`warning $.transports["docker"]["b.example.com/ns"][0].signedIdentity.prefix`,
+		`warning $.transports["docker"]["docker.io/testing/manifest:latest"]`,
+		`warning $.transports["this-transport-does-not-exist"]`,
+		`error $.transports["this-transport-does-not-exist"]["scope"]`,
+	}, policyIssueLocations(issues))
+
+	// signedBaseLayer problems
+	policy = &Policy{
+		Default: PolicyRequirements{NewPRReject()},
+		Transports: map[string]PolicyTransportScopes{
+			"docker": {
+				"example.com/rejected-base": {
+					xNewPRSignedBaseLayer(xNewPRMExactRepository("example.com/rejected")),
+				},
+				"example.com/cycle1": {
+					xNewPRSignedBaseLayer(xNewPRMExactReference("example.com/cycle2:latest")),
+				},
+				"example.com/cycle2": {
+					xNewPRSignedBaseLayer(xNewPRMExactReference("example.com/cycle1:latest")),
+				},
+				"example.com/valid": {
+					xNewPRSignedBaseLayer(xNewPRMExactReference("example.com/base:latest")),
+				},
+				"example.com/base": {NewPRInsecureAcceptAnything()},
+				"example.com/relative": {
+					xNewPRSignedBaseLayer(NewPRMMatchRepository()),
+				},
+			},
+		},
+	}
+	issues = ValidatePolicy(policy)
+	assert.Equal(t, []string{
+		`error $.transports["docker"]["example.com/cycle1"][0].baseLayerIdentity`,
+		`error $.transports["docker"]["example.com/cycle2"][0].baseLayerIdentity`,
+		`error $.transports["docker"]["example.com/rejected-base"][0].baseLayerIdentity`,
+		`error $.transports["docker"]["example.com/relative"][0].baseLayerIdentity`,
+	}, policyIssueLocations(issues))
+
+	// An empty default policy
+	issues = ValidatePolicy(&Policy{})
+	assert.Equal(t, []string{"error $.default"}, policyIssueLocations(issues))
+}
+
+func TestDockerScopeContains(t *testing.T) {
+	for _, c := range []struct {
+		outer, inner string
+		expected     bool
+	}{
+		{"example.com", "example.com", false},
+		{"example.com", "example.com/ns", true},
+		{"example.com", "example.com/ns/repo:tag", true},
+		{"example.com/ns", "example.com/ns2", false},
+		{"example.com/ns/repo", "example.com/ns/repo:tag", true},
+		{"example.com/ns/repo", "example.com/ns/repo@sha256:0000000000000000000000000000000000000000000000000000000000000000", true},
+		{"example.com/ns/repo:tag", "example.com/ns/repo:tag2", false},
+		{"example.com:5000", "example.com:5000/ns", true},
+		{"example.com:5000", "example.com/ns", false},
+		{"*.example.com", "a.example.com/ns", true},
+		{"*.example.com", "*.a.example.com", true},
+		{"*.example.com", "example.com/ns", false},
+		{"*.a.example.com", "*.example.com", false},
+		{"example.com", "*.example.com", false},
+	} {
+		res := dockerScopeContains(c.outer, c.inner)
+		assert.Equal(t, c.expected, res, "%s contains %s", c.outer, c.inner)
+	}
+}
+
+func TestDockerScopeMayContainNamespace(t *testing.T) {
+	for _, c := range []struct {
+		scope, namespace string
+		expected         bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "example.com/ns", true},
+		{"example.com/ns", "example.com", true},
+		{"example.com/ns/repo:tag", "example.com/ns", true},
+		{"example.com/ns/repo:tag", "example.com/ns/repo2", false},
+		{"example.com/ns", "example.com/ns2", false},
+		{"example.com", "example.net", false},
+		{"*.example.com", "a.example.com/ns", true},
+		{"*.example.com", "example.net/ns", false},
+	} {
+		res := dockerScopeMayContainNamespace(c.scope, c.namespace)
+		assert.Equal(t, c.expected, res, "%s may contain %s", c.scope, c.namespace)
+	}
+}
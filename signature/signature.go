@@ -27,6 +27,27 @@ func (err InvalidSignatureError) Error() string {
 	return err.msg
 }
 
+// RevokedKeyError is returned when a signature was made using a revoked key, either revoked by the key owner
+// (e.g. using an OpenPGP revocation signature), or listed as revoked in the policy.
+type RevokedKeyError struct {
+	// KeyIdentity is the identity of the revoked key.
+	KeyIdentity string
+	msg         string
+}
+
+func (err RevokedKeyError) Error() string {
+	return err.msg
+}
+
+// newRevokedKeyError returns a RevokedKeyError for keyIdentity, with an optional detail appended to the message.
+func newRevokedKeyError(keyIdentity, detail string) RevokedKeyError {
+	msg := fmt.Sprintf("Signing key %s has been revoked", keyIdentity)
+	if detail != "" {
+		msg = fmt.Sprintf("%s: %s", msg, detail)
+	}
+	return RevokedKeyError{KeyIdentity: keyIdentity, msg: msg}
+}
+
 // Signature is a parsed content of a signature.
 // The only way to get this structure from a blob should be as a return value from a successful call to verifyAndExtractSignature below.
 type Signature struct {
@@ -82,6 +103,11 @@ var _ json.Marshaler = (*untrustedSignature)(nil)
 
 // MarshalJSON implements the json.Marshaler interface.
 func (s untrustedSignature) MarshalJSON() ([]byte, error) {
+	return s.marshalJSONWithType(signatureType)
+}
+
+// marshalJSONWithType is MarshalJSON, using the specified critical.type value.
+func (s untrustedSignature) marshalJSONWithType(signatureType string) ([]byte, error) {
 	if s.UntrustedDockerManifestDigest == "" || s.UntrustedDockerReference == "" {
 		return nil, errors.New("Unexpected empty signature content")
 	}
@@ -109,7 +135,7 @@ var _ json.Unmarshaler = (*untrustedSignature)(nil)
 
 // UnmarshalJSON implements the json.Unmarshaler interface
 func (s *untrustedSignature) UnmarshalJSON(data []byte) error {
-	err := s.strictUnmarshalJSON(data)
+	err := s.strictUnmarshalJSON(data, signatureType, false)
 	if err != nil {
 		if formatErr, ok := err.(jsonFormatError); ok {
 			err = InvalidSignatureError{msg: formatErr.Error()}
@@ -118,9 +144,11 @@ func (s *untrustedSignature) UnmarshalJSON(data []byte) error {
 	return err
 }
 
-// strictUnmarshalJSON is UnmarshalJSON, except that it may return the internal jsonFormatError error type.
+// strictUnmarshalJSON is UnmarshalJSON, except that it may return the internal jsonFormatError error type,
+// and that it requires critical.type to be expectedType.
+// If allowNullOptional, "optional": null is accepted and treated as an empty object.
 // Splitting it into a separate function allows us to do the jsonFormatError → InvalidSignatureError in a single place, the caller.
-func (s *untrustedSignature) strictUnmarshalJSON(data []byte) error {
+func (s *untrustedSignature) strictUnmarshalJSON(data []byte, expectedType string, allowNullOptional bool) error {
 	var critical, optional json.RawMessage
 	if err := paranoidUnmarshalJSONObjectExactFields(data, map[string]interface{}{
 		"critical": &critical,
@@ -128,6 +156,9 @@ func (s *untrustedSignature) strictUnmarshalJSON(data []byte) error {
 	}); err != nil {
 		return err
 	}
+	if allowNullOptional && string(optional) == "null" {
+		optional = json.RawMessage("{}")
+	}
 
 	var creatorID string
 	var timestamp float64
@@ -167,7 +198,7 @@ func (s *untrustedSignature) strictUnmarshalJSON(data []byte) error {
 	}); err != nil {
 		return err
 	}
-	if t != signatureType {
+	if t != expectedType {
 		return InvalidSignatureError{msg: fmt.Sprintf("Unrecognized signature type %s", t)}
 	}
 
@@ -216,10 +247,15 @@ type signatureAcceptanceRules struct {
 	validateKeyIdentity                func(string) error
 	validateSignedDockerReference      func(string) error
 	validateSignedDockerManifestDigest func(digest.Digest) error
+	// validateSignatureTimestamp, if not nil, is called with the key identity and the creation time recorded in the signature,
+	// or nil if the signature does not record a creation time.
+	validateSignatureTimestamp func(keyIdentity string, timestamp *time.Time) error
 }
 
 // verifyAndExtractSignature verifies that unverifiedSignature has been signed, and that its principal components
-// match expected values, both as specified by rules, and returns it
+// match expected values, both as specified by rules, and returns it.
+// If the signing key has been revoked by its owner, or rejected as revoked by rules.validateKeyIdentity or rules.validateSignatureTimestamp,
+// a RevokedKeyError is returned.
 func verifyAndExtractSignature(mech SigningMechanism, unverifiedSignature []byte, rules signatureAcceptanceRules) (*Signature, error) {
 	signed, keyIdentity, err := mech.Verify(unverifiedSignature)
 	if err != nil {
@@ -239,6 +275,16 @@ func verifyAndExtractSignature(mech SigningMechanism, unverifiedSignature []byte
 	if err := rules.validateSignedDockerReference(unmatchedSignature.UntrustedDockerReference); err != nil {
 		return nil, err
 	}
+	if rules.validateSignatureTimestamp != nil {
+		var timestamp *time.Time
+		if unmatchedSignature.UntrustedTimestamp != nil {
+			ts := time.Unix(*unmatchedSignature.UntrustedTimestamp, 0)
+			timestamp = &ts
+		}
+		if err := rules.validateSignatureTimestamp(keyIdentity, timestamp); err != nil {
+			return nil, err
+		}
+	}
 	// signatureAcceptanceRules have accepted this value.
 	return &Signature{
 		DockerManifestDigest: unmatchedSignature.UntrustedDockerManifestDigest,
@@ -370,6 +370,71 @@ func TestVerifyAndExtractSignature(t *testing.T) {
 	assert.Error(t, err)
 	assert.Nil(t, sig)
 	assert.Equal(t, signatureData, recorded)
+
+	// validateSignatureTimestamp is called with the key identity and the recorded timestamp
+	var recordedTimestampKeyIdentity string
+	var recordedTimestamp *time.Time
+	var timestampErr error
+	timestampRules := recordingRules
+	timestampRules.validateSignatureTimestamp = func(keyIdentity string, timestamp *time.Time) error {
+		recordedTimestampKeyIdentity = keyIdentity
+		recordedTimestamp = timestamp
+		return timestampErr
+	}
+	wanted = signatureData
+	timestampErr = nil
+	sig, err = verifyAndExtractSignature(mech, signature, timestampRules)
+	require.NoError(t, err)
+	assert.Equal(t, TestImageSignatureReference, sig.DockerReference)
+	assert.Equal(t, TestKeyFingerprint, recordedTimestampKeyIdentity)
+	require.NotNil(t, recordedTimestamp)
+	assert.Equal(t, time.Unix(1458239713, 0), *recordedTimestamp)
+
+	timestampErr = errors.New("timestamp rejected")
+	sig, err = verifyAndExtractSignature(mech, signature, timestampRules)
+	assert.Error(t, err)
+	assert.Nil(t, sig)
+
+	// A signature by a key revoked by its owner
+	revokedKey, err := os.ReadFile("./fixtures/revoked-key.gpg")
+	require.NoError(t, err)
+	revokedKeyMech, _, err := NewEphemeralGPGSigningMechanism(revokedKey)
+	require.NoError(t, err)
+	defer revokedKeyMech.Close()
+	revokedKeySignature, err := os.ReadFile("./fixtures/revoked-key.signature")
+	require.NoError(t, err)
+	wanted = triple{
+		keyIdentity:                TestRevokedKeyFingerprint,
+		signedDockerReference:      "testing/manifest:latest",
+		signedDockerManifestDigest: TestImageManifestDigest,
+	}
+	recorded = triple{}
+	sig, err = verifyAndExtractSignature(revokedKeyMech, revokedKeySignature, recordingRules)
+	assert.IsType(t, RevokedKeyError{}, err)
+	assert.Nil(t, sig)
+	assert.Equal(t, triple{}, recorded)
+
+	// A signature without a timestamp
+	noOptionalFieldsSignature, err := os.ReadFile("./fixtures/no-optional-fields.signature")
+	require.NoError(t, err)
+	wanted = signatureData
+	timestampErr = nil
+	recordedTimestamp = &time.Time{}
+	sig, err = verifyAndExtractSignature(mech, noOptionalFieldsSignature, timestampRules)
+	require.NoError(t, err)
+	assert.Equal(t, TestImageSignatureReference, sig.DockerReference)
+	assert.Nil(t, recordedTimestamp)
+}
+
+func TestRevokedKeyError(t *testing.T) {
+	err := newRevokedKeyError(TestKeyFingerprint, "")
+	assert.Equal(t, TestKeyFingerprint, err.KeyIdentity)
+	assert.Contains(t, err.Error(), TestKeyFingerprint)
+
+	err = newRevokedKeyError(TestKeyFingerprint, "key compromised")
+	assert.Equal(t, TestKeyFingerprint, err.KeyIdentity)
+	assert.Contains(t, err.Error(), TestKeyFingerprint)
+	assert.Contains(t, err.Error(), "key compromised")
 }
 
 func TestGetUntrustedSignatureInformationWithoutVerifying(t *testing.T) {
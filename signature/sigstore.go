@@ -0,0 +1,246 @@
+// Note: Consider the API unstable until the code supports at least three different image formats or transports.
+
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/containers/image/v5/internal/signature"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// sigstoreSignatureType is the critical.type value of sigstore (cosign) signature payloads.
+	sigstoreSignatureType = "cosign container image signature"
+
+	// PEM block types of sigstore private keys, as created by cosign.
+	sigstoreEncryptedPrivateKeyPEMType       = "ENCRYPTED SIGSTORE PRIVATE KEY"
+	sigstoreLegacyEncryptedPrivateKeyPEMType = "ENCRYPTED COSIGN PRIVATE KEY"
+)
+
+// untrustedSigstorePayload is a parsed content of a sigstore signature payload (not the full signature).
+// It uses the same format as untrustedSignature, with a different critical.type value.
+type untrustedSigstorePayload struct {
+	untrustedSignature
+}
+
+// Compile-time check that untrustedSigstorePayload implements json.Marshaler
+var _ json.Marshaler = (*untrustedSigstorePayload)(nil)
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s untrustedSigstorePayload) MarshalJSON() ([]byte, error) {
+	return s.marshalJSONWithType(sigstoreSignatureType)
+}
+
+// Compile-time check that untrustedSigstorePayload implements json.Unmarshaler
+var _ json.Unmarshaler = (*untrustedSigstorePayload)(nil)
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (s *untrustedSigstorePayload) UnmarshalJSON(data []byte) error {
+	// cosign creates "optional": null if the signature has no annotations.
+	err := s.strictUnmarshalJSON(data, sigstoreSignatureType, true)
+	if err != nil {
+		if formatErr, ok := err.(jsonFormatError); ok {
+			err = InvalidSignatureError{msg: formatErr.Error()}
+		}
+	}
+	return err
+}
+
+// sigstorePublicKeyFromPEM parses a single PEM-encoded public key usable for sigstore signatures.
+func sigstorePublicKeyFromPEM(data []byte) (crypto.PublicKey, error) {
+	block, rest := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found in public key")
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("unexpected data after the PEM-encoded public key")
+	}
+	if block.Type != "PUBLIC KEY" {
+		return nil, errors.Errorf("unexpected PEM block type %q, expected a public key", block.Type)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing public key")
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, errors.Errorf("unsupported public key type %T", key)
+	}
+}
+
+// verifySigstoreSignature verifies unverifiedBase64Signature, a signature of unverifiedPayload, using publicKey.
+func verifySigstoreSignature(publicKey crypto.PublicKey, unverifiedPayload []byte, unverifiedBase64Signature string) error {
+	unverifiedSignature, err := base64.StdEncoding.DecodeString(unverifiedBase64Signature)
+	if err != nil {
+		return InvalidSignatureError{msg: fmt.Sprintf("invalid signature encoding: %v", err)}
+	}
+	var verified bool
+	switch k := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		payloadDigest := sha256.Sum256(unverifiedPayload)
+		verified = ecdsa.VerifyASN1(k, payloadDigest[:], unverifiedSignature)
+	case ed25519.PublicKey:
+		verified = ed25519.Verify(k, unverifiedPayload, unverifiedSignature)
+	default: // Coverage: This should never happen, sigstorePublicKeyFromPEM only accepts the types above.
+		return errors.Errorf("Internal error: unsupported public key type %T", publicKey)
+	}
+	if !verified {
+		return PolicyRequirementError("cryptographic signature verification failed")
+	}
+	return nil
+}
+
+// sigstoreAcceptanceRules specifies how to decide whether an untrusted sigstore payload is acceptable.
+// This is an equivalent of signatureAcceptanceRules; there is no key identity to validate, the payload
+// is only accepted if its signature has been verified using a single trusted public key.
+type sigstoreAcceptanceRules struct {
+	validateSignedDockerReference      func(string) error
+	validateSignedDockerManifestDigest func(digest.Digest) error
+}
+
+// verifySigstorePayload verifies that unverifiedPayload has been signed by publicKey (as unverifiedBase64Signature),
+// and that its principal components match expected values, both as specified by rules, and returns it.
+func verifySigstorePayload(publicKey crypto.PublicKey, unverifiedPayload []byte, unverifiedBase64Signature string, rules sigstoreAcceptanceRules) (*Signature, error) {
+	if err := verifySigstoreSignature(publicKey, unverifiedPayload, unverifiedBase64Signature); err != nil {
+		return nil, err
+	}
+
+	var unmatchedPayload untrustedSigstorePayload
+	if err := json.Unmarshal(unverifiedPayload, &unmatchedPayload); err != nil {
+		return nil, InvalidSignatureError{msg: err.Error()}
+	}
+	if err := rules.validateSignedDockerManifestDigest(unmatchedPayload.UntrustedDockerManifestDigest); err != nil {
+		return nil, err
+	}
+	if err := rules.validateSignedDockerReference(unmatchedPayload.UntrustedDockerReference); err != nil {
+		return nil, err
+	}
+	// sigstoreAcceptanceRules have accepted this value.
+	return &Signature{
+		DockerManifestDigest: unmatchedPayload.UntrustedDockerManifestDigest,
+		DockerReference:      unmatchedPayload.UntrustedDockerReference,
+	}, nil
+}
+
+// sigstoreEncryptedPrivateKey is the format of encrypted sigstore private keys, as created by cosign
+// (originally defined in github.com/secure-systems-lab/go-securesystemslib/encrypted).
+type sigstoreEncryptedPrivateKey struct {
+	KDF struct {
+		Name   string `json:"name"`
+		Params struct {
+			N int `json:"N"`
+			R int `json:"r"`
+			P int `json:"p"`
+		} `json:"params"`
+		Salt []byte `json:"salt"`
+	} `json:"kdf"`
+	Cipher struct {
+		Name  string `json:"name"`
+		Nonce []byte `json:"nonce"`
+	} `json:"cipher"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// decryptSigstorePrivateKey decrypts an encrypted sigstore private key, returning the DER-encoded PKCS #8 private key.
+func decryptSigstorePrivateKey(data []byte, passphrase []byte) ([]byte, error) {
+	var k sigstoreEncryptedPrivateKey
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, errors.Wrap(err, "parsing encrypted private key")
+	}
+	if k.KDF.Name != "scrypt" {
+		return nil, errors.Errorf("unsupported private key derivation function %q", k.KDF.Name)
+	}
+	if k.Cipher.Name != "nacl/secretbox" {
+		return nil, errors.Errorf("unsupported private key cipher %q", k.Cipher.Name)
+	}
+	var nonce [24]byte
+	if len(k.Cipher.Nonce) != len(nonce) {
+		return nil, errors.Errorf("invalid private key nonce length %d", len(k.Cipher.Nonce))
+	}
+	copy(nonce[:], k.Cipher.Nonce)
+
+	derivedKey, err := scrypt.Key(passphrase, k.KDF.Salt, k.KDF.Params.N, k.KDF.Params.R, k.KDF.Params.P, 32)
+	if err != nil {
+		return nil, errors.Wrap(err, "deriving private key encryption key")
+	}
+	var secretKey [32]byte
+	copy(secretKey[:], derivedKey)
+	res, ok := secretbox.Open(nil, k.Ciphertext, &nonce, &secretKey)
+	if !ok {
+		return nil, errors.New("decrypting private key failed, incorrect passphrase?")
+	}
+	return res, nil
+}
+
+// sigstorePrivateKeyFromPEM parses a single PEM-encoded ECDSA P-256 private key usable for creating sigstore signatures,
+// decrypting it using passphrase if necessary.
+func sigstorePrivateKeyFromPEM(data []byte, passphrase []byte) (*ecdsa.PrivateKey, error) {
+	block, rest := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found in private key")
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("unexpected data after the PEM-encoded private key")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case sigstoreEncryptedPrivateKeyPEMType, sigstoreLegacyEncryptedPrivateKeyPEMType:
+		var der []byte
+		der, err = decryptSigstorePrivateKey(block.Bytes, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		key, err = x509.ParsePKCS8PrivateKey(der)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, errors.Errorf("unexpected PEM block type %q, expected a private key", block.Type)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing private key")
+	}
+	ecdsaKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.Errorf("unsupported private key type %T, only ECDSA keys are supported", key)
+	}
+	if ecdsaKey.Curve != elliptic.P256() {
+		return nil, errors.Errorf("unsupported ECDSA curve %s, only P-256 is supported", ecdsaKey.Curve.Params().Name)
+	}
+	return ecdsaKey, nil
+}
+
+// signSigstorePayload returns a sigstore signature of payload, using privateKey, in the representation used by
+// types.ImageSource.GetSignatures and types.ImageDestination.PutSignatures.
+func signSigstorePayload(privateKey *ecdsa.PrivateKey, payload untrustedSigstorePayload) ([]byte, error) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	payloadDigest := sha256.Sum256(payloadBytes)
+	sig, err := ecdsa.SignASN1(rand.Reader, privateKey, payloadDigest[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "creating sigstore signature")
+	}
+	return signature.Blob(signature.SigstoreFromComponents(signature.SigstoreSignatureMIMEType, payloadBytes, map[string]string{
+		signature.SigstoreSignatureAnnotationKey: base64.StdEncoding.EncodeToString(sig),
+	}))
+}
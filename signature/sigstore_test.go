@@ -0,0 +1,346 @@
+package signature
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/containers/image/v5/internal/signature"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// sigstoreTestECDSAKey returns a newly generated ECDSA P-256 private key, and its PEM-encoded public key.
+func sigstoreTestECDSAKey(t *testing.T) (*ecdsa.PrivateKey, []byte) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return privateKey, sigstoreTestPublicKeyPEM(t, privateKey.Public())
+}
+
+// sigstoreTestPublicKeyPEM returns publicKey as a PEM-encoded "PUBLIC KEY" block.
+func sigstoreTestPublicKeyPEM(t *testing.T, publicKey crypto.PublicKey) []byte {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// sigstoreTestPayload returns a sigstore payload for manifestDigest and dockerReference.
+func sigstoreTestPayload(t *testing.T, manifestDigest digest.Digest, dockerReference string) []byte {
+	payload, err := json.Marshal(untrustedSigstorePayload{untrustedSignature{
+		UntrustedDockerManifestDigest: manifestDigest,
+		UntrustedDockerReference:      dockerReference,
+	}})
+	require.NoError(t, err)
+	return payload
+}
+
+// sigstoreTestSignECDSA returns a base64-encoded signature of payload using privateKey.
+func sigstoreTestSignECDSA(t *testing.T, privateKey *ecdsa.PrivateKey, payload []byte) string {
+	payloadDigest := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, privateKey, payloadDigest[:])
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+// sigstoreTestSignatureBlob returns a signature blob (as returned by ImageSource.GetSignatures) for manifestDigest
+// and dockerReference, signed by privateKey.
+func sigstoreTestSignatureBlob(t *testing.T, privateKey *ecdsa.PrivateKey, manifestDigest digest.Digest, dockerReference string) []byte {
+	payload := sigstoreTestPayload(t, manifestDigest, dockerReference)
+	blob, err := signature.Blob(signature.SigstoreFromComponents(signature.SigstoreSignatureMIMEType, payload, map[string]string{
+		signature.SigstoreSignatureAnnotationKey: sigstoreTestSignECDSA(t, privateKey, payload),
+	}))
+	require.NoError(t, err)
+	return blob
+}
+
+func TestUntrustedSigstorePayloadJSON(t *testing.T) {
+	payload := sigstoreTestPayload(t, "digest!@#", "reference#@!")
+	assert.Equal(t, `{"critical":{"identity":{"docker-reference":"reference#@!"},"image":{"docker-manifest-digest":"digest!@#"},"type":"cosign container image signature"},"optional":{}}`,
+		string(payload))
+
+	var parsed untrustedSigstorePayload
+	err := json.Unmarshal(payload, &parsed)
+	require.NoError(t, err)
+	assert.Equal(t, digest.Digest("digest!@#"), parsed.UntrustedDockerManifestDigest)
+	assert.Equal(t, "reference#@!", parsed.UntrustedDockerReference)
+
+	// "optional": null, as created by cosign
+	parsed = untrustedSigstorePayload{}
+	err = json.Unmarshal([]byte(`{"critical":{"identity":{"docker-reference":"reference#@!"},"image":{"docker-manifest-digest":"digest!@#"},"type":"cosign container image signature"},"optional":null}`), &parsed)
+	require.NoError(t, err)
+	assert.Equal(t, digest.Digest("digest!@#"), parsed.UntrustedDockerManifestDigest)
+	assert.Equal(t, "reference#@!", parsed.UntrustedDockerReference)
+	assert.Nil(t, parsed.UntrustedCreatorID)
+	assert.Nil(t, parsed.UntrustedTimestamp)
+	// … but not in simple signing signatures.
+	var simpleWithNull untrustedSignature
+	err = json.Unmarshal([]byte(`{"critical":{"identity":{"docker-reference":"reference#@!"},"image":{"docker-manifest-digest":"digest!@#"},"type":"atomic container signature"},"optional":null}`), &simpleWithNull)
+	assert.Error(t, err)
+
+	// A simple signing payload is not accepted as a sigstore payload, and vice versa.
+	simplePayload, err := json.Marshal(newUntrustedSignature("digest!@#", "reference#@!"))
+	require.NoError(t, err)
+	err = json.Unmarshal(simplePayload, &parsed)
+	assert.Error(t, err)
+	assert.IsType(t, InvalidSignatureError{}, err)
+	var simple untrustedSignature
+	err = json.Unmarshal(payload, &simple)
+	assert.Error(t, err)
+
+	// Invalid JSON
+	err = json.Unmarshal([]byte("&"), &parsed)
+	assert.Error(t, err)
+}
+
+func TestSigstorePublicKeyFromPEM(t *testing.T) {
+	// ECDSA
+	ecdsaKey, ecdsaPEM := sigstoreTestECDSAKey(t)
+	key, err := sigstorePublicKeyFromPEM(ecdsaPEM)
+	require.NoError(t, err)
+	assert.Equal(t, ecdsaKey.Public(), key)
+
+	// Ed25519
+	ed25519Public, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err = sigstorePublicKeyFromPEM(sigstoreTestPublicKeyPEM(t, ed25519Public))
+	require.NoError(t, err)
+	assert.Equal(t, ed25519Public, key)
+
+	// Unsupported key type
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	_, err = sigstorePublicKeyFromPEM(sigstoreTestPublicKeyPEM(t, rsaKey.Public()))
+	assert.Error(t, err)
+
+	for _, c := range [][]byte{
+		[]byte("not PEM"), // No PEM data
+		append(append([]byte{}, ecdsaPEM...), ecdsaPEM...),                           // Trailing data
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("x")}),      // Unexpected block type
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("not DER")}), // Invalid key data
+	} {
+		_, err := sigstorePublicKeyFromPEM(c)
+		assert.Error(t, err, string(c))
+	}
+}
+
+func TestVerifySigstorePayload(t *testing.T) {
+	privateKey, _ := sigstoreTestECDSAKey(t)
+	otherKey, _ := sigstoreTestECDSAKey(t)
+	payload := sigstoreTestPayload(t, TestImageManifestDigest, TestImageSignatureReference)
+	base64Sig := sigstoreTestSignECDSA(t, privateKey, payload)
+
+	type recorded struct {
+		digest    digest.Digest
+		reference string
+	}
+	var rec recorded
+	recordingRules := sigstoreAcceptanceRules{
+		validateSignedDockerReference: func(ref string) error {
+			rec.reference = ref
+			return nil
+		},
+		validateSignedDockerManifestDigest: func(d digest.Digest) error {
+			rec.digest = d
+			return nil
+		},
+	}
+
+	// Successful verification
+	res, err := verifySigstorePayload(privateKey.Public(), payload, base64Sig, recordingRules)
+	require.NoError(t, err)
+	assert.Equal(t, &Signature{
+		DockerManifestDigest: TestImageManifestDigest,
+		DockerReference:      TestImageSignatureReference,
+	}, res)
+	assert.Equal(t, recorded{digest: TestImageManifestDigest, reference: TestImageSignatureReference}, rec)
+
+	// Ed25519
+	ed25519Public, ed25519Private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ed25519Sig := base64.StdEncoding.EncodeToString(ed25519.Sign(ed25519Private, payload))
+	res, err = verifySigstorePayload(ed25519Public, payload, ed25519Sig, recordingRules)
+	require.NoError(t, err)
+	assert.Equal(t, TestImageManifestDigest, res.DockerManifestDigest)
+
+	// Invalid signature encoding
+	res, err = verifySigstorePayload(privateKey.Public(), payload, "&", recordingRules)
+	assert.Error(t, err)
+	assert.Nil(t, res)
+
+	// Signature by a different key
+	res, err = verifySigstorePayload(otherKey.Public(), payload, base64Sig, recordingRules)
+	assert.Error(t, err)
+	assert.IsType(t, PolicyRequirementError(""), err)
+	assert.Nil(t, res)
+
+	// Modified payload
+	modifiedPayload := sigstoreTestPayload(t, TestImageManifestDigest, "testing/other")
+	res, err = verifySigstorePayload(privateKey.Public(), modifiedPayload, base64Sig, recordingRules)
+	assert.Error(t, err)
+	assert.Nil(t, res)
+
+	// A correctly signed payload which is not valid
+	invalidPayload := []byte(`{"critical":{"type":"atomic container signature"}}`)
+	res, err = verifySigstorePayload(privateKey.Public(), invalidPayload, sigstoreTestSignECDSA(t, privateKey, invalidPayload), recordingRules)
+	assert.Error(t, err)
+	assert.IsType(t, InvalidSignatureError{}, err)
+	assert.Nil(t, res)
+
+	// Rejected by the rules
+	for _, rules := range []sigstoreAcceptanceRules{
+		{
+			validateSignedDockerReference:      recordingRules.validateSignedDockerReference,
+			validateSignedDockerManifestDigest: func(digest.Digest) error { return errors.New("digest rejected") },
+		},
+		{
+			validateSignedDockerReference:      func(string) error { return errors.New("reference rejected") },
+			validateSignedDockerManifestDigest: recordingRules.validateSignedDockerManifestDigest,
+		},
+	} {
+		res, err = verifySigstorePayload(privateKey.Public(), payload, base64Sig, rules)
+		assert.Error(t, err)
+		assert.Nil(t, res)
+	}
+}
+
+func TestVerifySigstorePayloadCosignFixture(t *testing.T) {
+	publicKeyPEM, err := os.ReadFile("fixtures/cosign.pub")
+	require.NoError(t, err)
+	publicKey, err := sigstorePublicKeyFromPEM(publicKeyPEM)
+	require.NoError(t, err)
+	payload, err := os.ReadFile("fixtures/cosign.payload")
+	require.NoError(t, err)
+	base64Sig, err := os.ReadFile("fixtures/cosign.signature")
+	require.NoError(t, err)
+
+	res, err := verifySigstorePayload(publicKey, payload, string(base64Sig), sigstoreAcceptanceRules{
+		validateSignedDockerReference: func(ref string) error {
+			assert.Equal(t, TestCosignSignatureReference, ref)
+			return nil
+		},
+		validateSignedDockerManifestDigest: func(d digest.Digest) error {
+			assert.Equal(t, TestImageManifestDigest, d)
+			return nil
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &Signature{
+		DockerManifestDigest: TestImageManifestDigest,
+		DockerReference:      TestCosignSignatureReference,
+	}, res)
+}
+
+// sigstoreTestEncryptPrivateKey returns privateKey encrypted using passphrase, in the format created by cosign.
+func sigstoreTestEncryptPrivateKey(t *testing.T, privateKey crypto.PrivateKey, passphrase []byte) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+
+	var k sigstoreEncryptedPrivateKey
+	k.KDF.Name = "scrypt"
+	k.KDF.Params.N = 1024 // Much weaker than the cosign default, to keep the tests fast.
+	k.KDF.Params.R = 8
+	k.KDF.Params.P = 1
+	k.KDF.Salt = make([]byte, 32)
+	_, err = rand.Read(k.KDF.Salt)
+	require.NoError(t, err)
+	k.Cipher.Name = "nacl/secretbox"
+	var nonce [24]byte
+	_, err = rand.Read(nonce[:])
+	require.NoError(t, err)
+	k.Cipher.Nonce = nonce[:]
+
+	derivedKey, err := scrypt.Key(passphrase, k.KDF.Salt, k.KDF.Params.N, k.KDF.Params.R, k.KDF.Params.P, 32)
+	require.NoError(t, err)
+	var secretKey [32]byte
+	copy(secretKey[:], derivedKey)
+	k.Ciphertext = secretbox.Seal(nil, der, &nonce, &secretKey)
+
+	encrypted, err := json.Marshal(k)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: sigstoreEncryptedPrivateKeyPEMType, Bytes: encrypted})
+}
+
+func TestSigstorePrivateKeyFromPEM(t *testing.T) {
+	passphrase := []byte("a passphrase")
+	privateKey, _ := sigstoreTestECDSAKey(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+	ecDER, err := x509.MarshalECPrivateKey(privateKey)
+	require.NoError(t, err)
+	encrypted := sigstoreTestEncryptPrivateKey(t, privateKey, passphrase)
+
+	// Success
+	for _, c := range []struct {
+		name string
+		data []byte
+	}{
+		{"PKCS #8", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})},
+		{"SEC 1", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER})},
+		{"encrypted", encrypted},
+		{"encrypted, legacy PEM type", bytes.Replace(encrypted, []byte(sigstoreEncryptedPrivateKeyPEMType), []byte(sigstoreLegacyEncryptedPrivateKeyPEMType), 2)},
+	} {
+		res, err := sigstorePrivateKeyFromPEM(c.data, passphrase)
+		require.NoError(t, err, c.name)
+		assert.True(t, privateKey.Equal(res), c.name)
+	}
+
+	// Incorrect passphrase
+	_, err = sigstorePrivateKeyFromPEM(encrypted, []byte("this is not the passphrase"))
+	assert.Error(t, err)
+
+	// Unsupported key types and curves
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	for _, key := range []crypto.PrivateKey{p384Key, ed25519Key} {
+		_, err = sigstorePrivateKeyFromPEM(sigstoreTestEncryptPrivateKey(t, key, passphrase), passphrase)
+		assert.Error(t, err)
+	}
+
+	// Invalid encryption parameters
+	block, _ := pem.Decode(encrypted)
+	require.NotNil(t, block)
+	for _, fn := range []func(k *sigstoreEncryptedPrivateKey){
+		func(k *sigstoreEncryptedPrivateKey) { k.KDF.Name = "unknown" },
+		func(k *sigstoreEncryptedPrivateKey) { k.KDF.Params.N = 3 },
+		func(k *sigstoreEncryptedPrivateKey) { k.Cipher.Name = "unknown" },
+		func(k *sigstoreEncryptedPrivateKey) { k.Cipher.Nonce = k.Cipher.Nonce[1:] },
+		func(k *sigstoreEncryptedPrivateKey) { k.Ciphertext[0] ^= 1 },
+	} {
+		var k sigstoreEncryptedPrivateKey
+		err := json.Unmarshal(block.Bytes, &k)
+		require.NoError(t, err)
+		fn(&k)
+		modified, err := json.Marshal(k)
+		require.NoError(t, err)
+		_, err = sigstorePrivateKeyFromPEM(pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: modified}), passphrase)
+		assert.Error(t, err)
+	}
+
+	for _, c := range [][]byte{
+		[]byte("not PEM"), // No PEM data
+		append(append([]byte{}, encrypted...), encrypted...),                                                // Trailing data
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkcs8}),                                    // Unexpected block type
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("not DER")}),                       // Invalid key data
+		pem.EncodeToMemory(&pem.Block{Type: sigstoreEncryptedPrivateKeyPEMType, Bytes: []byte("not JSON")}), // Invalid encrypted data
+	} {
+		_, err := sigstorePrivateKeyFromPEM(c, passphrase)
+		assert.Error(t, err, string(c))
+	}
+}
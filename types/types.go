@@ -534,6 +534,62 @@ const (
 	ShortNameModeEnforcing
 )
 
+// RetryPolicy defines how idempotent registry requests (manifest and blob GET/HEAD requests)
+// which fail with a possibly transient error (an HTTP 5xx status, a connection reset,
+// a TLS handshake timeout or an unexpected EOF) are retried.
+type RetryPolicy struct {
+	// The maximum number of retries after the first attempt; 0 disables retrying.
+	MaxRetries int
+	// The delay before the first retry; it is doubled for every following retry, and a random jitter is applied.
+	InitialDelay time.Duration
+	// If > 0, the maximum delay between two attempts.
+	MaxDelay time.Duration
+	// If > 0, no retry is started later than this after the first attempt.
+	Timeout time.Duration
+}
+
+// DockerRegistryRequestKind is the kind of server contacted in a DockerRegistryRequestEvent.
+type DockerRegistryRequestKind string
+
+const (
+	// DockerRegistryRequestRegistry is a request to the registry API.
+	DockerRegistryRequestRegistry DockerRegistryRequestKind = "registry"
+	// DockerRegistryRequestTokenServer is a request to a token server, to obtain a bearer token.
+	DockerRegistryRequestTokenServer DockerRegistryRequestKind = "token"
+	// DockerRegistryRequestExternal is a request to a server not controlled by the registry,
+	// e.g. to fetch a blob from an external URL, or a signature from a lookaside server.
+	DockerRegistryRequestExternal DockerRegistryRequestKind = "external"
+)
+
+// DockerRegistryRequestEvent describes a single HTTP request made by the docker transport, see SystemContext.DockerRegistryRequestHook.
+type DockerRegistryRequestEvent struct {
+	Kind   DockerRegistryRequestKind
+	Method string
+	URL    string // With any password redacted
+	// The registry the request was made on behalf of, as host[:port].  This is the mirror or the remapped location, if one is used.
+	Registry string
+	// The registry of the image reference being accessed (e.g. "docker.io"), which differs from Registry if a mirror or a remapped location is used.
+	LogicalRegistry string
+	// The number of preceding attempts of the same request, which failed and were retried; 0 for the first attempt.
+	Retry int
+	// The lowercase auth-scheme (e.g. "basic" or "bearer") of the authentication challenge which applies to the request:
+	// the first challenge in the WWW-Authenticate header of the response, if any; otherwise, for requests to the registry
+	// or its token server, the challenge from the registry's /v2/ ping response used to authenticate.  "" if there is none.
+	AuthChallengeScheme string
+	// The HTTP status of the response, or 0 if no response was received.
+	StatusCode int
+	// If not nil, the error which caused the request to fail, or reading the response body to fail.
+	Err error
+	// The number of bytes of the request body which were sent, and of the response body which were read by the caller.
+	BytesSent     int64
+	BytesReceived int64
+	// The time the request was started, the time until the response headers were received,
+	// and the time until the request failed or the response body was closed.
+	Start          time.Time
+	HeaderDuration time.Duration
+	Duration       time.Duration
+}
+
 // SystemContext allows parameterizing access to implicitly-accessed resources,
 // like configuration files in /etc and users' login state in their home directory.
 // Various components can share the same field only if their semantics is exactly
@@ -631,6 +687,25 @@ type SystemContext struct {
 	// Note that this requires writing blobs to temporary files, and takes more time than the default behavior,
 	// when the digest for a blob is unknown.
 	DockerRegistryPushPrecomputeDigests bool
+	// If > 0, blobs are uploaded to registries in chunks of this size (or of the minimum chunk size required by the registry, if larger),
+	// using a separate request for each chunk; if uploading a chunk fails, the upload is resumed instead of restarted from the beginning.
+	// Each chunk is buffered in memory. If 0, each blob is uploaded in a single request.
+	DockerRegistryPushChunkSize int64
+	// If not nil, overrides the retry policy for registry requests configured in registries.conf.
+	DockerRegistryRetryPolicy *RetryPolicy
+	// If not "", bearer tokens obtained from registries are also stored in this file (created with permissions 0600),
+	// and reused by other processes using the same file until they expire.
+	DockerBearerTokenCachePath string
+	// Repositories (e.g. "registry.example.com/base/image") which may contain blobs pushed to a registry; if so, the blobs are mounted
+	// from them instead of uploaded.  Only repositories on the same registry as the destination are used.
+	DockerRegistryMountSourceRepositories []string
+	// If true, and a blob being pushed is not known to exist in another repository, the registry is asked to mount the blob from
+	// any repository it can find it in (using the "mount" parameter without "from", as defined by the OCI distribution spec).
+	// Registries which do not support this start an upload instead, which is canceled; that costs extra requests for every blob.
+	DockerRegistryMountWithoutSource bool
+	// If not nil, called with a description of every HTTP request made by the docker transport, when the request fails,
+	// or when the response body is closed.  It may be called concurrently from several goroutines, and should return quickly.
+	DockerRegistryRequestHook func(DockerRegistryRequestEvent)
 
 	// === docker/daemon.Transport overrides ===
 	// A directory containing a CA certificate (ending with ".crt"),